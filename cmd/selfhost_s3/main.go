@@ -1,38 +1,144 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/Notifuse/selfhost_s3/internal/cli"
 	"github.com/Notifuse/selfhost_s3/internal/config"
+	"github.com/Notifuse/selfhost_s3/internal/logging"
 	"github.com/Notifuse/selfhost_s3/internal/server"
 )
 
 func main() {
+	// Administration subcommands; without one, or with "serve", start the server
+	if len(os.Args) > 1 && os.Args[1] != "serve" {
+		os.Exit(cli.Run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+	}
+	serve()
+}
+
+// serve runs the S3 server until SIGINT or SIGTERM
+func serve() {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
-		log.Printf("Configuration error: %v", err)
+		logConfigErrors(err)
 		log.Println("Required environment variables:")
 		log.Println("  S3_BUCKET      - S3 bucket name")
 		log.Println("  S3_ACCESS_KEY  - Access key for authentication")
 		log.Println("  S3_SECRET_KEY  - Secret key for authentication")
 		log.Println("Optional environment variables:")
+		log.Println("  S3_CONFIG_FILE  - YAML or TOML config file; environment variables take precedence")
 		log.Println("  S3_PORT         - Port to listen on (default: 9000)")
 		log.Println("  S3_STORAGE_PATH - Local directory for storage (default: ./data)")
+		log.Println("  S3_STORAGE_BACKEND - filesystem or memory (default: filesystem)")
 		log.Println("  S3_REGION       - AWS region (default: us-east-1)")
 		log.Println("  S3_CORS_ORIGINS - Allowed CORS origins (default: *)")
 		log.Println("  S3_MAX_FILE_SIZE - Maximum upload size (default: 100MB)")
+		log.Println("  S3_ENCRYPTION_KEY     - Base64 32-byte master key; encrypts new objects at rest (SSE-S3)")
+		log.Println("  S3_COMPRESSION        - gzip to compress objects on disk (default: off)")
+		log.Println("  S3_COMPRESSION_TYPES  - Content types compressed (default: text/*, JSON, XML, JavaScript, SVG)")
+		log.Println("  S3_COMPRESSION_PREFIXES - Key prefixes compressed (default: all keys)")
+		log.Println("  S3_DEDUP - Store identical objects once with hard links (default: false)")
+		log.Println("  S3_DEDUP_GC_INTERVAL - Interval between removals of unreferenced deduplicated data (default: 1h, 0 disables)")
+		log.Println("  S3_QUOTA_BYTES - Maximum size of the objects in the bucket, e.g. 50GB")
+		log.Println("  S3_QUOTA_OBJECTS - Maximum number of objects in the bucket")
+		log.Println("  S3_QUOTA_PREFIX_BYTES - Maximum size under key prefixes, e.g. uploads/=10GB,logs/=1GB")
+		log.Println("  S3_QUOTA_PREFIX_OBJECTS - Maximum number of objects under key prefixes, e.g. tmp/=1000")
+		log.Println("  S3_MIN_FREE_SPACE - Free disk space below which writes are rejected, e.g. 5GB")
+		log.Println("  S3_METADATA_INDEX - List objects from a persistent index instead of walking the bucket (default: false)")
+		log.Println("  S3_SCRUB_INTERVAL - Interval between verifications of the stored data (default: 0, disabled)")
+		log.Println("  S3_SCRUB_RATE - Data read per second while scrubbing, e.g. 32MB (default: 32MB, 0 means no limit)")
+		log.Println("  S3_SCRUB_REPAIR - Remove orphaned files and record missing checksums while scrubbing (default: false)")
+		log.Println("  S3_SCRUB_QUARANTINE - Move corrupt objects aside while scrubbing (default: false)")
+		log.Println("  S3_WATCH - Pick up files added or removed directly in the bucket directory, Linux only (default: false)")
+		log.Println("  S3_UPSTREAM_ENDPOINT - S3-compatible endpoint objects missing locally are fetched from (default: off)")
+		log.Println("  S3_UPSTREAM_BUCKET, S3_UPSTREAM_REGION - Upstream bucket and region (default: S3_BUCKET, S3_REGION)")
+		log.Println("  S3_UPSTREAM_ACCESS_KEY, S3_UPSTREAM_SECRET_KEY - Credentials signing upstream requests")
+		log.Println("  S3_UPSTREAM_WRITES - Make writes and deletes upstream too (default: false)")
+		log.Println("  S3_CACHE_MAX_SIZE - Size of the fetched objects kept locally, e.g. 10GB (default: 0, no limit)")
+		log.Println("  S3_REPLICATION_ENDPOINT - S3-compatible endpoint writes and deletes are replicated to (default: off)")
+		log.Println("  S3_REPLICATION_BUCKET, S3_REPLICATION_REGION - Target bucket and region (default: S3_BUCKET, S3_REGION)")
+		log.Println("  S3_REPLICATION_ACCESS_KEY, S3_REPLICATION_SECRET_KEY - Credentials signing replication requests")
+		log.Println("  S3_REPLICATION_PREFIX - Only replicate keys with this prefix")
+		log.Println("  S3_SHUTDOWN_TIMEOUT   - Grace period for in-flight requests on shutdown (default: 30s)")
+		log.Println("  S3_LIFECYCLE_INTERVAL - Lifecycle rule interval (default: 1h, 0 disables)")
+		log.Println("  S3_LIFECYCLE_DRY_RUN  - Log lifecycle actions without deleting (default: false)")
+		log.Println("  S3_WEBHOOK_URL        - Webhook receiving event notifications")
+		log.Println("  S3_WEBHOOK_SECRET     - HMAC secret for signing webhook requests")
+		log.Println("  S3_LOG_FORMAT         - text or json (default: text)")
+		log.Println("  S3_LOG_LEVEL          - debug, info, warn or error (default: info)")
+		log.Println("  S3_ACCESS_LOG_FILE    - Write an S3 server access log to this file")
+		log.Println("  S3_ACCESS_LOG_PREFIX  - Deliver S3 server access logs into the bucket under this prefix")
+		log.Println("  S3_METRICS_TOKEN      - Bearer token required on /metrics")
+		log.Println("  S3_TLS_CERT, S3_TLS_KEY - Serve HTTPS with this certificate (reloaded on change)")
+		log.Println("  S3_TLS_CLIENT_CA      - Require client certificates signed by this CA")
+		log.Println("  S3_ACME_DOMAINS       - Obtain certificates for these domains via ACME")
+		log.Println("Run \"selfhost_s3 help\" for the administration commands.")
 		os.Exit(1)
 	}
 
+	if err := logging.Setup(os.Stderr, cfg.LogFormat, cfg.LogLevel); err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
 	// Create and start server
 	srv, err := server.NewServer(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
 
-	if err := srv.Start(); err != nil {
-		log.Fatalf("Server error: %v", err)
+	// Stop on SIGINT (Ctrl+C) and SIGTERM (docker stop)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Reload CORS origins, credentials and public access on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			reloaded, err := config.Load()
+			if err != nil {
+				logConfigErrors(err)
+				log.Printf("Reload failed, keeping the current configuration")
+				continue
+			}
+			if err := srv.Reload(reloaded); err != nil {
+				log.Printf("Reload failed, keeping the current configuration: %v", err)
+			}
+		}
+	}()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Start() }()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			log.Fatalf("Server error: %v", err)
+		}
+	case <-ctx.Done():
+		stop()
+		log.Printf("Shutting down, waiting up to %s for in-flight requests", cfg.ShutdownTimeout)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Shutdown error: %v", err)
+		}
+		log.Printf("Server stopped")
+	}
+}
+
+// logConfigErrors logs each invalid setting reported by config.Load on its own line
+func logConfigErrors(err error) {
+	for _, line := range strings.Split(err.Error(), "\n") {
+		log.Printf("Configuration error: %s", line)
 	}
 }
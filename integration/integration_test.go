@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
@@ -17,6 +18,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 const (
@@ -1160,7 +1162,7 @@ func TestCORS_PreflightRequest(t *testing.T) {
 		t.Error("missing Access-Control-Allow-Methods header")
 	}
 	// Verify expected methods are allowed
-	expectedMethods := []string{"GET", "HEAD", "PUT", "DELETE", "OPTIONS"}
+	expectedMethods := []string{"GET", "HEAD", "PUT", "POST", "DELETE", "OPTIONS"}
 	for _, method := range expectedMethods {
 		if !strings.Contains(allowMethods, method) {
 			t.Errorf("expected %s in Access-Control-Allow-Methods, got %q", method, allowMethods)
@@ -1786,6 +1788,131 @@ func TestFolderMarker_FilesInsideFolder(t *testing.T) {
 	}
 }
 
+func TestCopyObject(t *testing.T) {
+	ctx := context.Background()
+	srcKey := "integration-test/copy/source file.txt"
+	dstKey := "integration-test/copy/destination.txt"
+
+	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(srcKey),
+		Body:   strings.NewReader("copy me"),
+	})
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	copyOutput, err := s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(testBucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(testBucket + "/" + url.PathEscape(srcKey)),
+	})
+	if err != nil {
+		t.Fatalf("CopyObject failed: %v", err)
+	}
+	if copyOutput.CopyObjectResult == nil || copyOutput.CopyObjectResult.ETag == nil {
+		t.Error("expected CopyObjectResult with an ETag")
+	}
+
+	getOutput, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(dstKey),
+	})
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	defer func() { _ = getOutput.Body.Close() }()
+
+	body, _ := io.ReadAll(getOutput.Body)
+	if string(body) != "copy me" {
+		t.Errorf("expected copied content, got %q", body)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	key := "integration-test/multipart.bin"
+
+	createOutput, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+
+	// Two parts: the first at the 5MB minimum, the last one smaller
+	parts := [][]byte{bytes.Repeat([]byte("a"), 5*1024*1024), []byte("tail")}
+	var completed []types.CompletedPart
+	for i, data := range parts {
+		partOutput, err := s3Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(testBucket),
+			Key:        aws.String(key),
+			UploadId:   createOutput.UploadId,
+			PartNumber: aws.Int32(int32(i + 1)),
+			Body:       bytes.NewReader(data),
+		})
+		if err != nil {
+			t.Fatalf("UploadPart %d failed: %v", i+1, err)
+		}
+		completed = append(completed, types.CompletedPart{ETag: partOutput.ETag, PartNumber: aws.Int32(int32(i + 1))})
+	}
+
+	_, err = s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(testBucket),
+		Key:             aws.String(key),
+		UploadId:        createOutput.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+
+	headOutput, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if *headOutput.ContentLength != int64(5*1024*1024+4) {
+		t.Errorf("expected assembled size %d, got %d", 5*1024*1024+4, *headOutput.ContentLength)
+	}
+}
+
+func TestAbortMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	key := "integration-test/aborted.bin"
+
+	createOutput, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+
+	_, err = s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(testBucket),
+		Key:      aws.String(key),
+		UploadId: createOutput.UploadId,
+	})
+	if err != nil {
+		t.Fatalf("AbortMultipartUpload failed: %v", err)
+	}
+
+	listOutput, err := s3Client.ListMultipartUploads(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(testBucket),
+		Prefix: aws.String(key),
+	})
+	if err != nil {
+		t.Fatalf("ListMultipartUploads failed: %v", err)
+	}
+	if len(listOutput.Uploads) != 0 {
+		t.Errorf("expected no uploads after abort, got %d", len(listOutput.Uploads))
+	}
+}
+
 // Helper function
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
@@ -0,0 +1,127 @@
+// Package accesslog writes request records in the Amazon S3 server access log format,
+// to rotated files or as log objects delivered into the bucket.
+package accesslog
+
+import (
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry describes one request in the S3 server access log format.
+// Empty fields are written as "-".
+type Entry struct {
+	Bucket           string
+	Time             time.Time
+	RemoteIP         string
+	Requester        string // access key of a signed request
+	RequestID        string
+	Operation        string // e.g. REST.GET.OBJECT
+	Key              string // URL-encoded object key
+	RequestURI       string // e.g. "GET /bucket/key?versionId=1 HTTP/1.1"
+	Status           int
+	ErrorCode        string
+	BytesSent        int64
+	ObjectSize       int64 // -1 when unknown
+	TotalTime        time.Duration
+	Referer          string
+	UserAgent        string
+	VersionID        string
+	HostID           string // x-amz-id-2
+	SignatureVersion string // "SigV4" for signed requests
+	CipherSuite      string
+	AuthType         string // "AuthHeader" or "QueryString"
+	Host             string
+	TLSVersion       string
+}
+
+// Format renders e as a single S3 server access log line, including the trailing newline
+func Format(e *Entry) string {
+	var b strings.Builder
+
+	field := func(v string) {
+		if v == "" {
+			v = "-"
+		}
+		b.WriteString(v)
+		b.WriteByte(' ')
+	}
+	quoted := func(v string) {
+		if v == "" {
+			b.WriteString("- ")
+			return
+		}
+		b.WriteByte('"')
+		b.WriteString(strings.ReplaceAll(v, `"`, `\"`))
+		b.WriteString(`" `)
+	}
+	number := func(n int64) {
+		if n <= 0 {
+			field("")
+			return
+		}
+		field(strconv.FormatInt(n, 10))
+	}
+
+	field("-") // bucket owner
+	field(e.Bucket)
+	b.WriteString("[" + e.Time.UTC().Format("02/Jan/2006:15:04:05 -0700") + "] ")
+	field(e.RemoteIP)
+	field(e.Requester)
+	field(e.RequestID)
+	field(e.Operation)
+	field(e.Key)
+	quoted(e.RequestURI)
+	field(strconv.Itoa(e.Status))
+	field(e.ErrorCode)
+	number(e.BytesSent)
+	if e.ObjectSize >= 0 {
+		field(strconv.FormatInt(e.ObjectSize, 10))
+	} else {
+		field("")
+	}
+	field(strconv.FormatInt(e.TotalTime.Milliseconds(), 10))
+	field("") // turn-around time
+	quoted(e.Referer)
+	quoted(e.UserAgent)
+	field(e.VersionID)
+	field(e.HostID)
+	field(e.SignatureVersion)
+	field(e.CipherSuite)
+	field(e.AuthType)
+	field(e.Host)
+	field(e.TLSVersion)
+	field("")          // access point ARN
+	b.WriteString("-") // ACL required
+	b.WriteByte('\n')
+
+	return b.String()
+}
+
+// Logger writes formatted entries to one or more destinations
+type Logger struct {
+	mu      sync.Mutex
+	writers []io.Writer
+}
+
+// NewLogger creates a logger writing to every writer
+func NewLogger(writers ...io.Writer) *Logger {
+	return &Logger{writers: writers}
+}
+
+// Log writes the entry to every destination. Write errors are logged and otherwise ignored,
+// so that access logging never fails a request.
+func (l *Logger) Log(e *Entry) {
+	line := []byte(Format(e))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, w := range l.writers {
+		if _, err := w.Write(line); err != nil {
+			log.Printf("Access log: write failed: %v", err)
+		}
+	}
+}
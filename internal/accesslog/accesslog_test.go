@@ -0,0 +1,72 @@
+package accesslog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	entry := &Entry{
+		Bucket:           "test-bucket",
+		Time:             time.Date(2024, 2, 6, 0, 0, 38, 0, time.UTC),
+		RemoteIP:         "192.0.2.3",
+		Requester:        "AKIAEXAMPLE",
+		RequestID:        "3E57427F3EXAMPLE",
+		Operation:        "REST.GET.OBJECT",
+		Key:              "photos/my%20cat.jpg",
+		RequestURI:       "GET /test-bucket/photos/my%20cat.jpg HTTP/1.1",
+		Status:           200,
+		BytesSent:        2662992,
+		ObjectSize:       3462992,
+		TotalTime:        70 * time.Millisecond,
+		Referer:          "",
+		UserAgent:        `curl/8.0 "quoted"`,
+		HostID:           "aGVsbG8=",
+		SignatureVersion: "SigV4",
+		CipherSuite:      "TLS_AES_128_GCM_SHA256",
+		AuthType:         "AuthHeader",
+		Host:             "s3.example.com",
+		TLSVersion:       "TLSv1.3",
+	}
+
+	expected := `- test-bucket [06/Feb/2024:00:00:38 +0000] 192.0.2.3 AKIAEXAMPLE 3E57427F3EXAMPLE REST.GET.OBJECT photos/my%20cat.jpg ` +
+		`"GET /test-bucket/photos/my%20cat.jpg HTTP/1.1" 200 - 2662992 3462992 70 - - "curl/8.0 \"quoted\"" - aGVsbG8= SigV4 ` +
+		"TLS_AES_128_GCM_SHA256 AuthHeader s3.example.com TLSv1.3 - -\n"
+	if got := Format(entry); got != expected {
+		t.Errorf("unexpected line:\n got: %s\nwant: %s", got, expected)
+	}
+}
+
+func TestFormat_AnonymousError(t *testing.T) {
+	line := Format(&Entry{
+		Bucket:     "test-bucket",
+		Time:       time.Now(),
+		RequestURI: "GET /test-bucket/private.txt HTTP/1.1",
+		Status:     403,
+		ErrorCode:  "AccessDenied",
+		BytesSent:  243,
+		ObjectSize: -1,
+	})
+
+	fields := strings.Fields(line)
+	// The time and request URI fields contain spaces
+	if len(fields) != 29 {
+		t.Fatalf("expected 29 fields, got %d: %s", len(fields), line)
+	}
+	if fields[5] != "-" || fields[13] != "AccessDenied" || fields[15] != "-" {
+		t.Errorf("unexpected line: %s", line)
+	}
+}
+
+func TestLogger_WritesEveryDestination(t *testing.T) {
+	var a, b bytes.Buffer
+	logger := NewLogger(&a, &b)
+
+	logger.Log(&Entry{Bucket: "test-bucket", Status: 200, ObjectSize: -1})
+
+	if a.Len() == 0 || a.String() != b.String() {
+		t.Errorf("expected the same line in both destinations, got %q and %q", a.String(), b.String())
+	}
+}
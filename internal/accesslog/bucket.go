@@ -0,0 +1,127 @@
+package accesslog
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// maxBufferSize triggers an early delivery when that many bytes of log lines are pending
+const maxBufferSize = 4 * 1024 * 1024
+
+// BucketWriter buffers log lines and delivers them as objects under a prefix of the
+// bucket, like S3 log delivery. A new object is written every interval, so each
+// object holds the requests of one period. Pending lines are delivered on Stop.
+type BucketWriter struct {
+	backend  storage.Backend
+	prefix   string
+	interval time.Duration
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewBucketWriter creates a writer delivering log objects under prefix every interval
+func NewBucketWriter(backend storage.Backend, prefix string, interval time.Duration) *BucketWriter {
+	return &BucketWriter{
+		backend:  backend,
+		prefix:   prefix,
+		interval: interval,
+		kick:     make(chan struct{}, 1),
+	}
+}
+
+// Write buffers p until the next delivery
+func (b *BucketWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	n, _ := b.buf.Write(p)
+	full := b.buf.Len() >= maxBufferSize
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.kick <- struct{}{}:
+		default:
+		}
+	}
+	return n, nil
+}
+
+// Flush delivers the pending lines as a new log object
+func (b *BucketWriter) Flush() error {
+	b.mu.Lock()
+	if b.buf.Len() == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	data := bytes.Clone(b.buf.Bytes())
+	b.buf.Reset()
+	b.mu.Unlock()
+
+	key := b.prefix + time.Now().UTC().Format("2006-01-02-15-04-05") + "-" + randomSuffix()
+	_, err := b.backend.PutObjectWithOptions(key, bytes.NewReader(data), storage.PutOptions{ContentType: "text/plain"})
+	return err
+}
+
+// Start delivers log objects in the background until Stop is called
+func (b *BucketWriter) Start() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stop != nil || b.interval <= 0 {
+		return
+	}
+	b.stop = make(chan struct{})
+	b.done = make(chan struct{})
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			case <-b.kick:
+			}
+			if err := b.Flush(); err != nil {
+				log.Printf("Access log: delivery to the bucket failed: %v", err)
+			}
+		}
+	}(b.stop, b.done)
+}
+
+// Stop stops the background delivery and delivers the pending lines
+func (b *BucketWriter) Stop() {
+	b.mu.Lock()
+	stop, done := b.stop, b.done
+	b.stop, b.done = nil, nil
+	b.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	if err := b.Flush(); err != nil {
+		log.Printf("Access log: delivery to the bucket failed: %v", err)
+	}
+}
+
+// randomSuffix makes log object keys written in the same second unique
+func randomSuffix() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,85 @@
+package accesslog
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// logObjects returns the contents of the log objects under prefix
+func logObjects(t *testing.T, backend storage.Backend, prefix string) []string {
+	t.Helper()
+
+	objects, err := backend.ListObjects(prefix)
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	var contents []string
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		_, reader, err := backend.GetObject(obj.Key)
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		data, _ := io.ReadAll(reader)
+		_ = reader.Close()
+		contents = append(contents, string(data))
+	}
+	return contents
+}
+
+func TestBucketWriter_Flush(t *testing.T) {
+	backend := storage.NewMemory()
+	w := NewBucketWriter(backend, "logs/", time.Hour)
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if got := logObjects(t, backend, "logs/"); len(got) != 0 {
+		t.Fatalf("expected no object without pending lines, got %v", got)
+	}
+
+	_, _ = w.Write([]byte("line 1\n"))
+	_, _ = w.Write([]byte("line 2\n"))
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	_, _ = w.Write([]byte("line 3\n"))
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	got := logObjects(t, backend, "logs/")
+	if len(got) != 2 {
+		t.Fatalf("expected 2 log objects, got %d", len(got))
+	}
+	if strings.Join(got, "") != "line 1\nline 2\nline 3\n" && strings.Join(got, "") != "line 3\nline 1\nline 2\n" {
+		t.Errorf("unexpected log objects %q", got)
+	}
+}
+
+func TestBucketWriter_StartStop(t *testing.T) {
+	backend := storage.NewMemory()
+	w := NewBucketWriter(backend, "logs/", 10*time.Millisecond)
+	w.Start()
+
+	_, _ = w.Write([]byte("periodic\n"))
+	deadline := time.Now().Add(2 * time.Second)
+	for len(logObjects(t, backend, "logs/")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("log object was not delivered in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, _ = w.Write([]byte("on stop\n"))
+	w.Stop()
+	if got := logObjects(t, backend, "logs/"); len(got) != 2 {
+		t.Errorf("expected pending lines to be delivered on Stop, got %q", got)
+	}
+}
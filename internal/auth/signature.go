@@ -4,15 +4,48 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// maxPresignExpires is the longest validity S3 accepts for a presigned URL (7 days)
+const maxPresignExpires = 7 * 24 * 60 * 60
+
+// Authentication errors returned by ValidateRequest. Other validation errors describe
+// a malformed request.
+var (
+	ErrMissingAuthorization = fmt.Errorf("missing Authorization header")
+	ErrInvalidAccessKey     = fmt.Errorf("invalid access key")
+	ErrRequestTimeSkewed    = fmt.Errorf("request timestamp too old or too far in future")
+	ErrPresignExpired       = fmt.Errorf("presigned URL has expired")
+	ErrSignatureMismatch    = fmt.Errorf("signature mismatch")
+)
+
+// FailureReason classifies an error returned by ValidateRequest, e.g. for metrics
+func FailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrMissingAuthorization):
+		return "missing_credentials"
+	case errors.Is(err, ErrInvalidAccessKey):
+		return "invalid_access_key"
+	case errors.Is(err, ErrRequestTimeSkewed):
+		return "time_skewed"
+	case errors.Is(err, ErrPresignExpired):
+		return "expired"
+	case errors.Is(err, ErrSignatureMismatch):
+		return "signature_mismatch"
+	default:
+		return "malformed"
+	}
+}
+
 // Credentials holds AWS-style credentials
 type Credentials struct {
 	AccessKey string
@@ -22,7 +55,8 @@ type Credentials struct {
 
 // SignatureV4 handles AWS Signature Version 4 authentication
 type SignatureV4 struct {
-	creds Credentials
+	creds  Credentials
+	others map[string]string // secret keys of the other accepted access keys
 }
 
 // NewSignatureV4 creates a new signature validator
@@ -36,6 +70,26 @@ func NewSignatureV4(accessKey, secretKey, region string) *SignatureV4 {
 	}
 }
 
+// AddCredentials accepts requests signed with another access key as well.
+// It must be called before the validator is used.
+func (s *SignatureV4) AddCredentials(accessKey, secretKey string) {
+	if s.others == nil {
+		s.others = make(map[string]string)
+	}
+	s.others[accessKey] = secretKey
+}
+
+// signer returns the validator of the credentials of accessKey, nil for an unknown key
+func (s *SignatureV4) signer(accessKey string) *SignatureV4 {
+	if accessKey == s.creds.AccessKey {
+		return s
+	}
+	if secretKey, ok := s.others[accessKey]; ok {
+		return &SignatureV4{creds: Credentials{AccessKey: accessKey, SecretKey: secretKey, Region: s.creds.Region}}
+	}
+	return nil
+}
+
 // authHeader represents parsed Authorization header
 type authHeader struct {
 	Algorithm     string
@@ -50,11 +104,35 @@ type authHeader struct {
 
 var authHeaderRegex = regexp.MustCompile(`AWS4-HMAC-SHA256\s+Credential=([^,]+),\s*SignedHeaders=([^,]+),\s*Signature=([a-f0-9]+)`)
 
+// IsSigned reports whether the request carries AWS Signature V4 credentials,
+// either in the Authorization header or as presigned URL query parameters
+func IsSigned(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.URL.Query().Has("X-Amz-Signature")
+}
+
+// RequestAccessKey returns the access key the request claims to be signed with, from
+// the Authorization header or the X-Amz-Credential query parameter. It is only
+// trustworthy once ValidateRequest accepted the request.
+func RequestAccessKey(r *http.Request) string {
+	if credential := r.URL.Query().Get("X-Amz-Credential"); credential != "" {
+		accessKey, _, _ := strings.Cut(credential, "/")
+		return accessKey
+	}
+	if auth, err := parseAuthHeader(r.Header.Get("Authorization")); err == nil {
+		return auth.AccessKey
+	}
+	return ""
+}
+
 // ValidateRequest validates an incoming HTTP request's AWS Signature V4
 func (s *SignatureV4) ValidateRequest(r *http.Request) error {
+	if r.URL.Query().Has("X-Amz-Signature") {
+		return s.validatePresignedRequest(r)
+	}
+
 	authHeaderValue := r.Header.Get("Authorization")
 	if authHeaderValue == "" {
-		return fmt.Errorf("missing Authorization header")
+		return ErrMissingAuthorization
 	}
 
 	auth, err := parseAuthHeader(authHeaderValue)
@@ -63,8 +141,9 @@ func (s *SignatureV4) ValidateRequest(r *http.Request) error {
 	}
 
 	// Verify access key matches
-	if auth.AccessKey != s.creds.AccessKey {
-		return fmt.Errorf("invalid access key")
+	signer := s.signer(auth.AccessKey)
+	if signer == nil {
+		return ErrInvalidAccessKey
 	}
 
 	// Get the request date
@@ -84,15 +163,100 @@ func (s *SignatureV4) ValidateRequest(r *http.Request) error {
 		timeDiff = -timeDiff
 	}
 	if timeDiff > 15*time.Minute {
-		return fmt.Errorf("request timestamp too old or too far in future")
+		return ErrRequestTimeSkewed
+	}
+
+	// Hashed payload
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = "UNSIGNED-PAYLOAD"
 	}
 
 	// Calculate the expected signature
-	expectedSig := s.calculateSignature(r, auth, amzDate)
+	expectedSig := signer.calculateSignature(r, r.URL.Query(), payloadHash, auth, amzDate)
 
 	// Compare signatures
 	if !hmac.Equal([]byte(auth.Signature), []byte(expectedSig)) {
-		return fmt.Errorf("signature mismatch")
+		return ErrSignatureMismatch
+	}
+
+	return nil
+}
+
+// validatePresignedRequest validates a request authenticated with query string
+// parameters (a presigned URL) instead of the Authorization header
+func (s *SignatureV4) validatePresignedRequest(r *http.Request) error {
+	query := r.URL.Query()
+
+	if algorithm := query.Get("X-Amz-Algorithm"); algorithm != "AWS4-HMAC-SHA256" {
+		return fmt.Errorf("unsupported X-Amz-Algorithm: %q", algorithm)
+	}
+
+	credential := query.Get("X-Amz-Credential")
+	credParts := strings.Split(credential, "/")
+	if len(credParts) != 5 {
+		return fmt.Errorf("invalid X-Amz-Credential format")
+	}
+
+	// Verify access key matches
+	signer := s.signer(credParts[0])
+	if signer == nil {
+		return ErrInvalidAccessKey
+	}
+
+	amzDate := query.Get("X-Amz-Date")
+	if amzDate == "" {
+		return fmt.Errorf("missing X-Amz-Date query parameter")
+	}
+
+	requestTime, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return fmt.Errorf("invalid X-Amz-Date format: %w", err)
+	}
+
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 1 || expires > maxPresignExpires {
+		return fmt.Errorf("invalid X-Amz-Expires: must be between 1 and %d seconds", maxPresignExpires)
+	}
+
+	// Reject URLs signed in the future (allowing for clock skew) or past their expiry
+	now := time.Now()
+	if requestTime.Sub(now) > 15*time.Minute {
+		return ErrRequestTimeSkewed
+	}
+	if now.After(requestTime.Add(time.Duration(expires) * time.Second)) {
+		return ErrPresignExpired
+	}
+
+	signedHeaders := query.Get("X-Amz-SignedHeaders")
+	if signedHeaders == "" {
+		return fmt.Errorf("missing X-Amz-SignedHeaders query parameter")
+	}
+
+	auth := &authHeader{
+		Algorithm:     "AWS4-HMAC-SHA256",
+		Credential:    credential,
+		SignedHeaders: strings.Split(signedHeaders, ";"),
+		Signature:     query.Get("X-Amz-Signature"),
+		AccessKey:     credParts[0],
+		Date:          credParts[1],
+		Region:        credParts[2],
+		Service:       credParts[3],
+	}
+
+	// The signature itself is not part of the canonical query string
+	query.Del("X-Amz-Signature")
+
+	// Presigned URLs never sign the payload unless explicitly requested
+	payloadHash := query.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = "UNSIGNED-PAYLOAD"
+	}
+
+	expectedSig := signer.calculateSignature(r, query, payloadHash, auth, amzDate)
+
+	if !hmac.Equal([]byte(auth.Signature), []byte(expectedSig)) {
+		return ErrSignatureMismatch
 	}
 
 	return nil
@@ -128,9 +292,9 @@ func parseAuthHeader(header string) (*authHeader, error) {
 }
 
 // calculateSignature computes the expected AWS Signature V4
-func (s *SignatureV4) calculateSignature(r *http.Request, auth *authHeader, amzDate string) string {
+func (s *SignatureV4) calculateSignature(r *http.Request, query url.Values, payloadHash string, auth *authHeader, amzDate string) string {
 	// Step 1: Create canonical request
-	canonicalRequest := s.createCanonicalRequest(r, auth.SignedHeaders)
+	canonicalRequest := s.createCanonicalRequest(r, query, payloadHash, auth.SignedHeaders)
 
 	// Step 2: Create string to sign
 	dateStamp := amzDate[:8] // YYYYMMDD
@@ -149,7 +313,7 @@ func (s *SignatureV4) calculateSignature(r *http.Request, auth *authHeader, amzD
 }
 
 // createCanonicalRequest creates the canonical request string
-func (s *SignatureV4) createCanonicalRequest(r *http.Request, signedHeaders []string) string {
+func (s *SignatureV4) createCanonicalRequest(r *http.Request, query url.Values, payloadHash string, signedHeaders []string) string {
 	// HTTP method
 	method := r.Method
 
@@ -167,7 +331,7 @@ func (s *SignatureV4) createCanonicalRequest(r *http.Request, signedHeaders []st
 	}
 
 	// Canonical query string
-	canonicalQueryString := s.createCanonicalQueryString(r.URL.Query())
+	canonicalQueryString := s.createCanonicalQueryString(query)
 
 	// Canonical headers
 	canonicalHeaders := s.createCanonicalHeaders(r, signedHeaders)
@@ -175,19 +339,13 @@ func (s *SignatureV4) createCanonicalRequest(r *http.Request, signedHeaders []st
 	// Signed headers (lowercase, sorted, semicolon-separated)
 	signedHeadersStr := strings.Join(signedHeaders, ";")
 
-	// Hashed payload
-	hashedPayload := r.Header.Get("X-Amz-Content-Sha256")
-	if hashedPayload == "" {
-		hashedPayload = "UNSIGNED-PAYLOAD"
-	}
-
 	return fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s",
 		method,
 		canonicalURI,
 		canonicalQueryString,
 		canonicalHeaders,
 		signedHeadersStr,
-		hashedPayload,
+		payloadHash,
 	)
 }
 
@@ -211,8 +369,8 @@ func (s *SignatureV4) createCanonicalQueryString(query url.Values) string {
 		sort.Strings(values)
 		for _, v := range values {
 			pairs = append(pairs, fmt.Sprintf("%s=%s",
-				url.QueryEscape(k),
-				url.QueryEscape(v),
+				uriEncode(k),
+				uriEncode(v),
 			))
 		}
 	}
@@ -277,6 +435,12 @@ func uriEncodePath(path string) string {
 	return buf.String()
 }
 
+// uriEncode encodes a query string component according to AWS Signature V4 spec
+// Unlike url.QueryEscape, spaces are encoded as %20 rather than '+'
+func uriEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
 // isUnreserved returns true if the rune is an unreserved character per RFC 3986
 func isUnreserved(r rune) bool {
 	return (r >= 'A' && r <= 'Z') ||
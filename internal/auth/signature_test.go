@@ -1,14 +1,20 @@
 package auth
 
 import (
+	"context"
 	"crypto/hmac"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 )
 
 func TestNewSignatureV4(t *testing.T) {
@@ -279,7 +285,7 @@ func TestCreateCanonicalQueryString(t *testing.T) {
 		{
 			name:     "params needing encoding",
 			query:    map[string][]string{"key": {"hello world"}},
-			expected: "key=hello+world",
+			expected: "key=hello%20world",
 		},
 	}
 
@@ -438,3 +444,212 @@ func TestSignatureCalculation_RoundTrip(t *testing.T) {
 		t.Logf("Signature: %s", signature)
 	}
 }
+
+// presignRequest builds a presigned URL for req using the AWS SDK signer
+func presignRequest(t *testing.T, req *http.Request, accessKey, secretKey, region string, expires time.Duration, signTime time.Time) *http.Request {
+	t.Helper()
+
+	query := req.URL.Query()
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int(expires.Seconds())))
+	req.URL.RawQuery = query.Encode()
+
+	// S3 signs the already-escaped path, so disable the signer's second escaping pass
+	signer := v4.NewSigner(func(o *v4.SignerOptions) { o.DisableURIPathEscaping = true })
+	creds := aws.Credentials{AccessKeyID: accessKey, SecretAccessKey: secretKey}
+	signedURL, _, err := signer.PresignHTTP(context.Background(), creds, req, "UNSIGNED-PAYLOAD", "s3", region, signTime)
+	if err != nil {
+		t.Fatalf("failed to presign request: %v", err)
+	}
+
+	u, err := url.Parse(signedURL)
+	if err != nil {
+		t.Fatalf("failed to parse presigned URL: %v", err)
+	}
+
+	presigned := httptest.NewRequest(req.Method, u.String(), nil)
+	presigned.Host = req.Host
+	return presigned
+}
+
+func TestIsSigned(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/test-bucket/key", nil)
+	if IsSigned(req) {
+		t.Error("expected unsigned request")
+	}
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=x")
+	if !IsSigned(req) {
+		t.Error("expected request with Authorization header to be signed")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/test-bucket/key?X-Amz-Signature=abc", nil)
+	if !IsSigned(req) {
+		t.Error("expected request with X-Amz-Signature to be signed")
+	}
+}
+
+func TestValidateRequest_Presigned(t *testing.T) {
+	sig := NewSignatureV4("access-key", "secret-key", "us-east-1")
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:9000/test-bucket/docs/My%20Report.pdf", nil)
+	req.Host = "localhost:9000"
+	query := req.URL.Query()
+	query.Set("response-content-disposition", `attachment; filename="My Report.pdf"`)
+	query.Set("response-content-type", "application/pdf")
+	req.URL.RawQuery = query.Encode()
+
+	presigned := presignRequest(t, req, "access-key", "secret-key", "us-east-1", time.Hour, time.Now())
+
+	if err := sig.ValidateRequest(presigned); err != nil {
+		t.Errorf("expected presigned URL to validate, got error: %v", err)
+	}
+}
+
+func TestValidateRequest_AdditionalCredentials(t *testing.T) {
+	sig := NewSignatureV4("access-key", "secret-key", "us-east-1")
+	sig.AddCredentials("backup-key", "backup-secret")
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:9000/test-bucket/key", nil)
+	req.Host = "localhost:9000"
+
+	for _, creds := range [][2]string{{"access-key", "secret-key"}, {"backup-key", "backup-secret"}} {
+		presigned := presignRequest(t, req, creds[0], creds[1], "us-east-1", time.Hour, time.Now())
+		if err := sig.ValidateRequest(presigned); err != nil {
+			t.Errorf("expected %s to be accepted, got error: %v", creds[0], err)
+		}
+		if got := RequestAccessKey(presigned); got != creds[0] {
+			t.Errorf("expected request access key %q, got %q", creds[0], got)
+		}
+	}
+
+	// Each access key only validates with its own secret key
+	presigned := presignRequest(t, req, "backup-key", "secret-key", "us-east-1", time.Hour, time.Now())
+	if err := sig.ValidateRequest(presigned); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("expected signature mismatch, got %v", err)
+	}
+	presigned = presignRequest(t, req, "other-key", "backup-secret", "us-east-1", time.Hour, time.Now())
+	if err := sig.ValidateRequest(presigned); !errors.Is(err, ErrInvalidAccessKey) {
+		t.Errorf("expected invalid access key, got %v", err)
+	}
+}
+
+func TestValidateRequest_PresignedExpired(t *testing.T) {
+	sig := NewSignatureV4("access-key", "secret-key", "us-east-1")
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:9000/test-bucket/key", nil)
+	req.Host = "localhost:9000"
+
+	presigned := presignRequest(t, req, "access-key", "secret-key", "us-east-1", time.Minute, time.Now().Add(-time.Hour))
+
+	err := sig.ValidateRequest(presigned)
+	if err == nil {
+		t.Fatal("expected error for expired presigned URL")
+	}
+	if !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expected expiry error, got %v", err)
+	}
+}
+
+func TestValidateRequest_PresignedTampered(t *testing.T) {
+	sig := NewSignatureV4("access-key", "secret-key", "us-east-1")
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:9000/test-bucket/key?response-content-type=text/plain", nil)
+	req.Host = "localhost:9000"
+
+	presigned := presignRequest(t, req, "access-key", "secret-key", "us-east-1", time.Hour, time.Now())
+
+	// Changing a signed query parameter must invalidate the signature
+	query := presigned.URL.Query()
+	query.Set("response-content-type", "text/html")
+	presigned.URL.RawQuery = query.Encode()
+
+	err := sig.ValidateRequest(presigned)
+	if err == nil {
+		t.Fatal("expected error for tampered presigned URL")
+	}
+	if !strings.Contains(err.Error(), "signature mismatch") {
+		t.Errorf("expected signature mismatch error, got %v", err)
+	}
+}
+
+func TestValidateRequest_PresignedInvalidParams(t *testing.T) {
+	sig := NewSignatureV4("access-key", "secret-key", "us-east-1")
+	amzDate := time.Now().UTC().Format("20060102T150405Z")
+	credential := fmt.Sprintf("access-key/%s/us-east-1/s3/aws4_request", amzDate[:8])
+
+	tests := []struct {
+		name   string
+		params map[string]string
+		errMsg string
+	}{
+		{
+			name:   "wrong algorithm",
+			params: map[string]string{"X-Amz-Algorithm": "AWS4-HMAC-SHA1"},
+			errMsg: "X-Amz-Algorithm",
+		},
+		{
+			name:   "malformed credential",
+			params: map[string]string{"X-Amz-Credential": "access-key/only"},
+			errMsg: "X-Amz-Credential",
+		},
+		{
+			name:   "wrong access key",
+			params: map[string]string{"X-Amz-Credential": "other-key/20231215/us-east-1/s3/aws4_request"},
+			errMsg: "invalid access key",
+		},
+		{
+			name:   "expires too long",
+			params: map[string]string{"X-Amz-Expires": "604801"},
+			errMsg: "X-Amz-Expires",
+		},
+		{
+			name:   "missing date",
+			params: map[string]string{"X-Amz-Date": ""},
+			errMsg: "X-Amz-Date",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{}
+			query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+			query.Set("X-Amz-Credential", credential)
+			query.Set("X-Amz-Date", amzDate)
+			query.Set("X-Amz-Expires", "3600")
+			query.Set("X-Amz-SignedHeaders", "host")
+			query.Set("X-Amz-Signature", "abc123")
+			for k, v := range tt.params {
+				query.Set(k, v)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/test-bucket/key?"+query.Encode(), nil)
+
+			err := sig.ValidateRequest(req)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{ErrMissingAuthorization, "missing_credentials"},
+		{ErrInvalidAccessKey, "invalid_access_key"},
+		{ErrRequestTimeSkewed, "time_skewed"},
+		{ErrPresignExpired, "expired"},
+		{ErrSignatureMismatch, "signature_mismatch"},
+		{fmt.Errorf("invalid Authorization header: %w", fmt.Errorf("malformed authorization header")), "malformed"},
+	}
+	for _, tt := range tests {
+		if got := FailureReason(tt.err); got != tt.want {
+			t.Errorf("FailureReason(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package certs provides the TLS configuration of the server: certificate files
+// reloaded on change, optional client certificate authentication and ACME.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ReloadInterval is how often certificate files are checked for changes
+var ReloadInterval = 10 * time.Second
+
+// Options configures TLS. Either CertFile and KeyFile or ACMEDomains must be set.
+type Options struct {
+	CertFile string
+	KeyFile  string
+	ClientCA string // PEM bundle; when set, clients must present a certificate signed by it
+
+	ACMEDomains   []string
+	ACMEEmail     string
+	ACMEDirectory string // ACME directory URL, e.g. a local Pebble instance
+	ACMECARoot    string // PEM bundle trusted when talking to the ACME directory
+	ACMECacheDir  string // where account keys and certificates are stored
+}
+
+// TLS is a server TLS setup
+type TLS struct {
+	Config *tls.Config
+
+	reloader *Reloader
+	manager  *autocert.Manager
+}
+
+// New builds the TLS configuration described by opts
+func New(opts Options) (*TLS, error) {
+	t := &TLS{
+		Config: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			NextProtos: []string{"h2", "http/1.1"},
+		},
+	}
+
+	switch {
+	case len(opts.ACMEDomains) > 0:
+		manager, err := newManager(opts)
+		if err != nil {
+			return nil, err
+		}
+		t.manager = manager
+		t.Config.GetCertificate = manager.GetCertificate
+		// Allows the TLS-ALPN-01 challenge when the server listens on port 443
+		t.Config.NextProtos = append(t.Config.NextProtos, acme.ALPNProto)
+	case opts.CertFile != "" && opts.KeyFile != "":
+		reloader, err := NewReloader(opts.CertFile, opts.KeyFile, ReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		t.reloader = reloader
+		t.Config.GetCertificate = reloader.GetCertificate
+	default:
+		return nil, fmt.Errorf("a certificate and key or ACME domains are required")
+	}
+
+	if opts.ClientCA != "" {
+		pool, err := loadPool(opts.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client CA: %w", err)
+		}
+		t.Config.ClientCAs = pool
+		t.Config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return t, nil
+}
+
+// newManager creates the ACME certificate manager
+func newManager(opts Options) (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: opts.ACMEDirectory}
+	if opts.ACMECARoot != "" {
+		pool, err := loadPool(opts.ACMECARoot)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACME CA root: %w", err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	if err := os.MkdirAll(opts.ACMECacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create ACME cache directory: %w", err)
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(opts.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(opts.ACMEDomains...),
+		Email:      opts.ACMEEmail,
+		Client:     client,
+	}, nil
+}
+
+// HTTPHandler returns the handler answering ACME HTTP-01 challenges and redirecting
+// other requests to HTTPS, or nil when ACME is not used
+func (t *TLS) HTTPHandler() http.Handler {
+	if t.manager == nil {
+		return nil
+	}
+	return t.manager.HTTPHandler(nil)
+}
+
+// Start begins watching certificate files for changes
+func (t *TLS) Start() {
+	if t.reloader != nil {
+		t.reloader.Start()
+	}
+}
+
+// Stop stops watching certificate files
+func (t *TLS) Stop() {
+	if t.reloader != nil {
+		t.reloader.Stop()
+	}
+}
+
+// loadPool reads a PEM certificate bundle
+func loadPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
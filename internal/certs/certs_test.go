@@ -0,0 +1,122 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for localhost and its key to dir
+func writeCert(t *testing.T, dir string, serial int64) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestNew_RequiresCertificate(t *testing.T) {
+	if _, err := New(Options{}); err == nil {
+		t.Error("expected error without certificate or ACME domains")
+	}
+	if _, err := New(Options{CertFile: "/missing.crt", KeyFile: "/missing.key"}); err == nil {
+		t.Error("expected error for missing certificate files")
+	}
+}
+
+func TestNew_CertificateFilesWithClientCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, 1)
+
+	tlsSetup, err := New(Options{CertFile: certFile, KeyFile: keyFile, ClientCA: certFile})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	cfg := tlsSetup.Config
+	if !slices.Equal(cfg.NextProtos, []string{"h2", "http/1.1"}) {
+		t.Errorf("unexpected NextProtos %v", cfg.NextProtos)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2 minimum, got %x", cfg.MinVersion)
+	}
+	if cfg.ClientAuth != tls.RequireAndVerifyClientCert || cfg.ClientCAs == nil {
+		t.Error("expected client certificates to be required")
+	}
+	if tlsSetup.HTTPHandler() != nil {
+		t.Error("expected no ACME HTTP handler for certificate files")
+	}
+
+	if _, err := New(Options{CertFile: certFile, KeyFile: keyFile, ClientCA: keyFile}); err == nil {
+		t.Error("expected error for client CA without certificates")
+	}
+}
+
+func TestNew_ACME(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "acme")
+
+	tlsSetup, err := New(Options{
+		ACMEDomains:   []string{"s3.example.com"},
+		ACMEDirectory: "https://localhost:14000/dir",
+		ACMECacheDir:  cacheDir,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if _, err := os.Stat(cacheDir); err != nil {
+		t.Errorf("expected cache directory to be created: %v", err)
+	}
+	if !slices.Contains(tlsSetup.Config.NextProtos, "acme-tls/1") {
+		t.Errorf("expected TLS-ALPN-01 support, got %v", tlsSetup.Config.NextProtos)
+	}
+	if tlsSetup.HTTPHandler() == nil {
+		t.Error("expected an ACME HTTP handler")
+	}
+
+	// Hosts outside the configured domains are refused before contacting the directory
+	_, err = tlsSetup.Config.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	if err == nil {
+		t.Error("expected error for a host that is not configured")
+	}
+}
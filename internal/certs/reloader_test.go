@@ -0,0 +1,116 @@
+package certs
+
+import (
+	"crypto/x509"
+	"os"
+	"testing"
+	"time"
+)
+
+// currentSerial returns the serial number of the certificate served by r
+func currentSerial(t *testing.T, r *Reloader) int64 {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+// touch moves the modification time of the files forward so a change is detected
+// even on filesystems with coarse timestamps
+func touch(t *testing.T, files ...string) {
+	t.Helper()
+	future := time.Now().Add(time.Minute)
+	for _, f := range files {
+		if err := os.Chtimes(f, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReloader_ReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, 1)
+
+	r, err := NewReloader(certFile, keyFile, time.Minute)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+	if serial := currentSerial(t, r); serial != 1 {
+		t.Fatalf("expected serial 1, got %d", serial)
+	}
+
+	reloaded, err := r.Reload()
+	if err != nil || reloaded {
+		t.Errorf("expected no reload for unchanged files, got %v, %v", reloaded, err)
+	}
+
+	writeCert(t, dir, 2)
+	touch(t, certFile, keyFile)
+	reloaded, err = r.Reload()
+	if err != nil || !reloaded {
+		t.Fatalf("expected reload, got %v, %v", reloaded, err)
+	}
+	if serial := currentSerial(t, r); serial != 2 {
+		t.Errorf("expected serial 2 after reload, got %d", serial)
+	}
+}
+
+func TestReloader_KeepsCertificateOnInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, 1)
+
+	r, err := NewReloader(certFile, keyFile, time.Minute)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, certFile)
+	if _, err := r.Reload(); err == nil {
+		t.Error("expected error for invalid certificate")
+	}
+	if serial := currentSerial(t, r); serial != 1 {
+		t.Errorf("expected previous certificate to be kept, got serial %d", serial)
+	}
+
+	// The pair is retried on the next check once it is valid again
+	writeCert(t, dir, 3)
+	touch(t, certFile, keyFile)
+	if reloaded, err := r.Reload(); err != nil || !reloaded {
+		t.Fatalf("expected reload, got %v, %v", reloaded, err)
+	}
+	if serial := currentSerial(t, r); serial != 3 {
+		t.Errorf("expected serial 3, got %d", serial)
+	}
+}
+
+func TestReloader_StartStop(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, 1)
+
+	r, err := NewReloader(certFile, keyFile, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+	r.Start()
+	defer r.Stop()
+
+	writeCert(t, dir, 2)
+	touch(t, certFile, keyFile)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for currentSerial(t, r) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was not reloaded in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
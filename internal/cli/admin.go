@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+)
+
+// maxPresignExpires is the longest validity of a presigned URL accepted by S3
+const maxPresignExpires = 7 * 24 * time.Hour
+
+// runGenKeys prints a random access key and secret key in the environment variable format
+func runGenKeys(_ context.Context, e *env, _ *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	_, _ = fmt.Fprintf(e.stdout, "S3_ACCESS_KEY=%s\n", randomString(20, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"))
+	_, _ = fmt.Fprintf(e.stdout, "S3_SECRET_KEY=%s\n", randomString(40, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"))
+	return nil
+}
+
+// randomString returns n characters drawn uniformly from alphabet
+func randomString(n int, alphabet string) string {
+	b := make([]byte, n)
+	for i := range b {
+		// Rejection sampling keeps the distribution uniform
+		for {
+			var r [1]byte
+			_, _ = rand.Read(r[:])
+			if int(r[0]) < 256-256%len(alphabet) {
+				b[i] = alphabet[int(r[0])%len(alphabet)]
+				break
+			}
+		}
+	}
+	return string(b)
+}
+
+// presignFlags registers the flags of presign
+func presignFlags(fs *flag.FlagSet) {
+	fs.String("endpoint", "", "public URL of the server (default: http://localhost:S3_PORT, https with TLS)")
+	credentialFlags(fs)
+	fs.String("method", http.MethodGet, "GET to download or PUT to upload")
+	fs.Duration("expires", time.Hour, "validity of the URL, at most 168h")
+}
+
+// runPresign prints a presigned URL. It only needs the credentials, not a running server.
+func runPresign(ctx context.Context, e *env, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	key := args[0]
+
+	cfg, loadErr := loadConfig()
+	scheme := "http"
+	if cfg.TLSEnabled() {
+		scheme = "https"
+	}
+	opts := targetOptions{
+		endpoint:  flagValue(fs, "endpoint", fmt.Sprintf("%s://localhost:%d", scheme, cfg.Port)),
+		bucket:    flagValue(fs, "bucket", cfg.Bucket),
+		accessKey: flagValue(fs, "access-key", cfg.AccessKey),
+		secretKey: flagValue(fs, "secret-key", cfg.SecretKey),
+		region:    flagValue(fs, "region", cfg.Region),
+	}
+	if opts.bucket == "" || opts.accessKey == "" || opts.secretKey == "" {
+		err := fmt.Errorf("the bucket, access key and secret key are required")
+		if loadErr != nil {
+			err = fmt.Errorf("%w; the configuration could not be loaded:\n%v", err, loadErr)
+		}
+		return err
+	}
+
+	expires := fs.Lookup("expires").Value.(flag.Getter).Get().(time.Duration)
+	if expires < time.Second || expires > maxPresignExpires {
+		return fmt.Errorf("-expires must be between 1s and %s", maxPresignExpires)
+	}
+
+	presigner := s3.NewPresignClient(newS3Client(opts), func(o *s3.PresignOptions) {
+		o.Expires = expires
+	})
+
+	var url string
+	switch method := strings.ToUpper(flagValue(fs, "method", http.MethodGet)); method {
+	case http.MethodGet:
+		req, err := presigner.PresignGetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(opts.bucket), Key: aws.String(key)})
+		if err != nil {
+			return err
+		}
+		url = req.URL
+	case http.MethodPut:
+		req, err := presigner.PresignPutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(opts.bucket), Key: aws.String(key)})
+		if err != nil {
+			return err
+		}
+		url = req.URL
+	default:
+		return fmt.Errorf("-method must be GET or PUT, not %q", method)
+	}
+
+	_, _ = fmt.Fprintln(e.stdout, url)
+	return nil
+}
+
+// runConfig validates the configuration like the server does at startup
+func runConfig(_ context.Context, e *env, _ *flag.FlagSet, args []string) error {
+	if len(args) != 1 || args[0] != "check" {
+		return errUsage
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%v", err)
+	}
+
+	_, _ = fmt.Fprintln(e.stdout, "Configuration is valid")
+	if path := os.Getenv("S3_CONFIG_FILE"); path != "" {
+		_, _ = fmt.Fprintf(e.stdout, "  Config file:   %s\n", path)
+	}
+	_, _ = fmt.Fprintf(e.stdout, "  Bucket:        %s\n", cfg.Bucket)
+	if cfg.StorageBackend == config.StorageMemory {
+		_, _ = fmt.Fprintln(e.stdout, "  Storage:       in memory")
+	} else {
+		_, _ = fmt.Fprintf(e.stdout, "  Storage:       %s\n", cfg.StoragePath)
+	}
+	protocol := "HTTP"
+	if cfg.TLSEnabled() {
+		protocol = "HTTPS"
+	}
+	_, _ = fmt.Fprintf(e.stdout, "  Listen:        :%d (%s)\n", cfg.Port, protocol)
+	if cfg.PublicPrefix != "" {
+		_, _ = fmt.Fprintf(e.stdout, "  Public prefix: %s\n", cfg.PublicPrefix)
+	} else {
+		_, _ = fmt.Fprintln(e.stdout, "  Public prefix: disabled")
+	}
+	_, _ = fmt.Fprintf(e.stdout, "  Webhooks:      %d\n", len(cfg.Webhooks))
+	_, _ = fmt.Fprintf(e.stdout, "  Replication:   %d\n", len(cfg.Replication))
+	return nil
+}
@@ -0,0 +1,152 @@
+// Package cli implements the administration subcommands of the selfhost_s3 binary.
+// They work directly on the storage path, or against a running instance with -endpoint.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+)
+
+// errUsage reports invalid arguments; the usage of the command has already been printed
+var errUsage = errors.New("invalid arguments")
+
+// env is what a command reads and writes besides its arguments
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// command is a subcommand of the selfhost_s3 binary
+type command struct {
+	name    string
+	args    string // argument synopsis
+	summary string
+	run     func(ctx context.Context, e *env, fs *flag.FlagSet, args []string) error
+	flags   func(fs *flag.FlagSet) // registers command flags (optional)
+	target  bool                   // operates on a bucket, locally or with -endpoint
+}
+
+// commands lists the subcommands in the order of the usage text. "serve" is run by main.
+var commands = []*command{
+	{name: "serve", summary: "Start the S3 server (default when no command is given)"},
+	{name: "ls", args: "[prefix]", summary: "List objects", run: runList, target: true},
+	{name: "put", args: "<file|-> <key>", summary: "Upload a file, or standard input with -", run: runPut, flags: putFlags, target: true},
+	{name: "get", args: "<key> [file|-]", summary: "Download an object to a file or standard output", run: runGet, target: true},
+	{name: "rm", args: "<key>...", summary: "Delete objects", run: runRemove, target: true},
+	{name: "du", args: "[prefix]", summary: "Show the number of objects and their size", run: runUsage, target: true},
+	{name: "dedup", summary: "Show the space saved by deduplication", run: runDedup, flags: dedupFlags, target: true},
+	{name: "index", args: "rebuild", summary: "Rebuild the metadata index from the storage path", run: runIndex, target: true},
+	{name: "import", args: "<dir> [prefix]", summary: "Upload a directory tree, or describe files copied into the bucket directory", run: runImport, target: true},
+	{name: "replicate", args: "backfill [prefix]", summary: "Queue the existing objects for replication", run: runReplicate, target: true},
+	{name: "snapshot", args: "<file|->", summary: "Write a consistent snapshot archive of the bucket", run: runSnapshot, target: true},
+	{name: "restore", args: "<file|->", summary: "Load a snapshot archive into the storage path", run: runRestore, flags: restoreFlags},
+	{name: "fsck", summary: "Verify the stored data against its checksums", run: runFsck, flags: fsckFlags, target: true},
+	{name: "gen-keys", summary: "Generate a random access key and secret key", run: runGenKeys},
+	{name: "presign", args: "<key>", summary: "Print a presigned URL for an object", run: runPresign, flags: presignFlags},
+	{name: "config", args: "check", summary: "Validate the configuration and print a summary", run: runConfig},
+}
+
+// Run runs the subcommand named by args[0] and returns the process exit code
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	e := &env{stdin: stdin, stdout: stdout, stderr: stderr}
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(stdout)
+		return 0
+	}
+
+	var cmd *command
+	for _, c := range commands {
+		if c.name == args[0] && c.run != nil {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		_, _ = fmt.Fprintf(stderr, "Unknown command %q\n\n", args[0])
+		printUsage(stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "Usage: selfhost_s3 %s [flags] %s\n\n%s\n", cmd.name, cmd.args, cmd.summary)
+		if hasFlags(fs) {
+			_, _ = fmt.Fprintln(stderr, "\nFlags:")
+			fs.PrintDefaults()
+		}
+	}
+	if cmd.target {
+		targetFlags(fs)
+	}
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if err := cmd.run(context.Background(), e, fs, fs.Args()); err != nil {
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		_, _ = fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// printUsage lists the subcommands
+func printUsage(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "Usage: selfhost_s3 [command] [flags] [arguments]")
+	_, _ = fmt.Fprintln(w, "\nCommands:")
+	for _, c := range commands {
+		_, _ = fmt.Fprintf(w, "  %-30s %s\n", strings.TrimSpace(c.name+" "+c.args), c.summary)
+	}
+	_, _ = fmt.Fprintln(w, "\nCommands read the bucket, credentials and storage path from the same environment")
+	_, _ = fmt.Fprintln(w, "variables and config file as the server. Run \"selfhost_s3 <command> -h\" for its flags.")
+}
+
+// hasFlags reports whether any flag is registered
+func hasFlags(fs *flag.FlagSet) bool {
+	found := false
+	fs.VisitAll(func(*flag.Flag) { found = true })
+	return found
+}
+
+// loadConfig loads the server configuration. Commands also work without a complete
+// configuration, so when it is invalid the defaults and the environment are used instead
+// and the error is returned along with them.
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.Load()
+	if err == nil {
+		return cfg, nil
+	}
+
+	cfg = config.Default()
+	cfg.Bucket = os.Getenv("S3_BUCKET")
+	cfg.AccessKey = os.Getenv("S3_ACCESS_KEY")
+	cfg.SecretKey = os.Getenv("S3_SECRET_KEY")
+	if path := os.Getenv("S3_STORAGE_PATH"); path != "" {
+		cfg.StoragePath = path
+	}
+	if region := os.Getenv("S3_REGION"); region != "" {
+		cfg.Region = region
+	}
+	if key, keyErr := config.ParseEncryptionKey(os.Getenv("S3_ENCRYPTION_KEY")); keyErr == nil {
+		cfg.EncryptionKey = key
+	}
+	return cfg, err
+}
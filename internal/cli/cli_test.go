@@ -0,0 +1,570 @@
+package cli
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/auth"
+	"github.com/Notifuse/selfhost_s3/internal/config"
+	"github.com/Notifuse/selfhost_s3/internal/server"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// setupEnv clears the S3_* environment variables and sets the given ones for the test
+func setupEnv(t *testing.T, vars map[string]string) {
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, "S3_") {
+			t.Setenv(name, "")
+		}
+	}
+	for k, v := range vars {
+		t.Setenv(k, v)
+	}
+}
+
+// run runs a command and returns its exit code and output
+func run(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := Run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// createBucket creates the bucket directory like the server does on startup
+func createBucket(t *testing.T, dir, bucket string) {
+	store, err := storage.NewStorage(dir, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Close()
+}
+
+func TestRun_LocalObjects(t *testing.T) {
+	dir := t.TempDir()
+	createBucket(t, dir, "test-bucket")
+	setupEnv(t, map[string]string{"S3_BUCKET": "test-bucket", "S3_STORAGE_PATH": dir})
+
+	src := filepath.Join(t.TempDir(), "report.txt")
+	if err := os.WriteFile(src, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _, stderr := run(t, "", "put", src, "docs/report.txt"); code != 0 {
+		t.Fatalf("put exit code = %d: %s", code, stderr)
+	}
+	if code, _, stderr := run(t, "from stdin", "put", "-content-type", "text/plain", "-", "docs/stdin"); code != 0 {
+		t.Fatalf("put - exit code = %d: %s", code, stderr)
+	}
+
+	code, stdout, _ := run(t, "", "ls", "docs/")
+	if code != 0 || !strings.Contains(stdout, "docs/report.txt") || !strings.Contains(stdout, "docs/stdin") {
+		t.Errorf("ls = %d %q", code, stdout)
+	}
+
+	code, stdout, _ = run(t, "", "get", "docs/stdin")
+	if code != 0 || stdout != "from stdin" {
+		t.Errorf("get = %d %q", code, stdout)
+	}
+	dest := filepath.Join(t.TempDir(), "out")
+	if code, _, _ := run(t, "", "get", "docs/report.txt", dest); code != 0 {
+		t.Errorf("get to file exit code = %d", code)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "hello" {
+		t.Errorf("downloaded file = %q", data)
+	}
+
+	code, stdout, _ = run(t, "", "du")
+	if code != 0 || !strings.HasPrefix(stdout, "2 objects, 15 B\n") {
+		t.Errorf("du = %d %q", code, stdout)
+	}
+
+	if code, _, _ := run(t, "", "rm", "docs/report.txt", "docs/stdin"); code != 0 {
+		t.Errorf("rm exit code = %d", code)
+	}
+	code, stdout, _ = run(t, "", "ls")
+	if code != 0 || strings.Contains(stdout, "report.txt") || strings.Contains(stdout, "stdin") {
+		t.Errorf("ls after rm = %d %q", code, stdout)
+	}
+
+	code, _, stderr := run(t, "", "get", "missing")
+	if code != 1 || !strings.HasPrefix(stderr, "Error: ") {
+		t.Errorf("get missing = %d %q", code, stderr)
+	}
+}
+
+func TestRun_Dedup(t *testing.T) {
+	dir := t.TempDir()
+	createBucket(t, dir, "test-bucket")
+	setupEnv(t, map[string]string{
+		"S3_BUCKET":       "test-bucket",
+		"S3_ACCESS_KEY":   "access-key",
+		"S3_SECRET_KEY":   "secret-key",
+		"S3_STORAGE_PATH": dir,
+		"S3_DEDUP":        "true",
+	})
+
+	for _, key := range []string{"a/logo.png", "b/logo.png", "c/logo.png"} {
+		if code, _, stderr := run(t, "same logo", "put", "-", key); code != 0 {
+			t.Fatalf("put exit code = %d: %s", code, stderr)
+		}
+	}
+	code, stdout, _ := run(t, "", "dedup")
+	if code != 0 || !strings.HasPrefix(stdout, "1 blobs shared by 3 objects and versions, 9 B stored\n18 B saved") {
+		t.Errorf("dedup = %d %q", code, stdout)
+	}
+
+	if code, _, _ := run(t, "", "rm", "a/logo.png", "b/logo.png", "c/logo.png"); code != 0 {
+		t.Fatalf("rm exit code = %d", code)
+	}
+	code, stdout, _ = run(t, "", "dedup", "-gc")
+	if code != 0 || !strings.HasPrefix(stdout, "Removed 1 unreferenced blobs, 9 B freed\n0 blobs") {
+		t.Errorf("dedup -gc = %d %q", code, stdout)
+	}
+
+	if code, _, stderr := run(t, "", "dedup", "-endpoint", "http://localhost:9000"); code != 1 || !strings.Contains(stderr, "storage path") {
+		t.Errorf("dedup -endpoint = %d %q", code, stderr)
+	}
+}
+
+func TestRun_Index(t *testing.T) {
+	dir := t.TempDir()
+	createBucket(t, dir, "test-bucket")
+	setupEnv(t, map[string]string{"S3_BUCKET": "test-bucket", "S3_STORAGE_PATH": dir})
+
+	for _, key := range []string{"a.txt", "docs/b.txt"} {
+		if code, _, stderr := run(t, "data", "put", "-", key); code != 0 {
+			t.Fatalf("put exit code = %d: %s", code, stderr)
+		}
+	}
+	code, stdout, stderr := run(t, "", "index", "rebuild")
+	if code != 0 || stdout != "3 objects and folders indexed: 3 added, 0 updated, 0 removed\n" {
+		t.Errorf("index rebuild = %d %q %q", code, stdout, stderr)
+	}
+
+	// Writes without the index mark it stale, and the next rebuild catches up
+	if code, _, _ := run(t, "", "rm", "a.txt"); code != 0 {
+		t.Fatalf("rm exit code = %d", code)
+	}
+	code, stdout, _ = run(t, "", "index", "rebuild")
+	if code != 0 || stdout != "2 objects and folders indexed: 0 added, 0 updated, 1 removed\n" {
+		t.Errorf("index rebuild after rm = %d %q", code, stdout)
+	}
+
+	// While the server holds the index, it is left to the server
+	store, err := storage.Open(dir, "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.OpenIndex(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = store.CloseIndex() }()
+	code, stdout, _ = run(t, "", "index", "rebuild")
+	if code != 0 || !strings.Contains(stdout, "in use by the server") {
+		t.Errorf("index rebuild while in use = %d %q", code, stdout)
+	}
+
+	if code, _, stderr := run(t, "", "index", "check"); code != 2 {
+		t.Errorf("index check = %d %q", code, stderr)
+	}
+	if code, _, stderr := run(t, "", "index", "-endpoint", "http://localhost:9000", "-access-key", "a", "-secret-key", "s", "rebuild"); code != 1 || !strings.Contains(stderr, "storage path") {
+		t.Errorf("index -endpoint = %d %q", code, stderr)
+	}
+}
+
+func TestRun_Import(t *testing.T) {
+	dir := t.TempDir()
+	createBucket(t, dir, "test-bucket")
+	setupEnv(t, map[string]string{"S3_BUCKET": "test-bucket", "S3_STORAGE_PATH": dir})
+
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"a.txt": "hello", "sub/b.json": "{}"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	code, stdout, stderr := run(t, "", "import", src, "backup")
+	if code != 0 || stdout != "2 files imported, 7 B\n" {
+		t.Errorf("import = %d %q %q", code, stdout, stderr)
+	}
+	_, stdout, _ = run(t, "", "ls", "backup/")
+	if !strings.Contains(stdout, "backup/a.txt") || !strings.Contains(stdout, "backup/sub/b.json") {
+		t.Errorf("ls after import = %q", stdout)
+	}
+
+	// Files copied into the bucket directory are described in place
+	bucketDir := filepath.Join(dir, "test-bucket")
+	if err := os.MkdirAll(filepath.Join(bucketDir, "synced"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bucketDir, "synced", "c.txt"), []byte("rsynced"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	code, stdout, stderr = run(t, "", "import", bucketDir)
+	if code != 0 || stdout != "3 files checked in place, 1 imported\n" {
+		t.Errorf("import in place = %d %q %q", code, stdout, stderr)
+	}
+	if code, stdout, _ := run(t, "", "fsck"); code != 0 || !strings.HasPrefix(stdout, "3 objects") {
+		t.Errorf("fsck after import in place = %d %q", code, stdout)
+	}
+
+	if code, _, stderr := run(t, "", "import", bucketDir, "prefix"); code != 1 || !strings.Contains(stderr, "in place") {
+		t.Errorf("import in place with a prefix = %d %q", code, stderr)
+	}
+	if code, _, stderr := run(t, "", "import", filepath.Join(src, "a.txt")); code != 1 || !strings.Contains(stderr, "not a directory") {
+		t.Errorf("import of a file = %d %q", code, stderr)
+	}
+	if code, _, _ := run(t, "", "import"); code != 2 {
+		t.Errorf("import without a directory = %d", code)
+	}
+}
+
+func TestRun_Fsck(t *testing.T) {
+	dir := t.TempDir()
+	createBucket(t, dir, "test-bucket")
+	setupEnv(t, map[string]string{"S3_BUCKET": "test-bucket", "S3_STORAGE_PATH": dir})
+
+	if code, _, stderr := run(t, "hello", "put", "-", "a.txt"); code != 0 {
+		t.Fatalf("put exit code = %d: %s", code, stderr)
+	}
+	code, stdout, stderr := run(t, "", "fsck")
+	if code != 0 || stdout != "1 objects and 0 versions verified, 5 B read\n" {
+		t.Errorf("fsck = %d %q %q", code, stdout, stderr)
+	}
+
+	// Unresolved issues fail the check until they are fixed
+	if err := os.WriteFile(filepath.Join(dir, ".selfhost_s3", "test-bucket", "tmp", "upload-1"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	_ = os.Chtimes(filepath.Join(dir, ".selfhost_s3", "test-bucket", "tmp", "upload-1"), old, old)
+	code, stdout, stderr = run(t, "", "fsck", "-rate", "10MB")
+	if code != 1 || !strings.HasPrefix(stdout, "stale-temp: ") || !strings.Contains(stderr, "1 issues found, 1 unresolved") {
+		t.Errorf("fsck with a stale temp file = %d %q %q", code, stdout, stderr)
+	}
+	code, stdout, _ = run(t, "", "fsck", "-repair")
+	if code != 0 || !strings.Contains(stdout, "[removed]") || !strings.Contains(stdout, "1 issues found and resolved") {
+		t.Errorf("fsck -repair = %d %q", code, stdout)
+	}
+
+	if code, _, stderr := run(t, "", "fsck", "-rate", "fast"); code != 1 || !strings.Contains(stderr, "invalid -rate") {
+		t.Errorf("fsck -rate fast = %d %q", code, stderr)
+	}
+	if code, _, stderr := run(t, "", "fsck", "-endpoint", "http://localhost:9000", "-access-key", "a", "-secret-key", "s"); code != 1 || !strings.Contains(stderr, "storage path") {
+		t.Errorf("fsck -endpoint = %d %q", code, stderr)
+	}
+}
+
+func TestRun_Replicate(t *testing.T) {
+	dir := t.TempDir()
+	createBucket(t, dir, "test-bucket")
+	setupEnv(t, map[string]string{"S3_BUCKET": "test-bucket", "S3_STORAGE_PATH": dir, "S3_ACCESS_KEY": "access", "S3_SECRET_KEY": "secret"})
+
+	for _, key := range []string{"a.txt", "docs/b.txt", "docs/c.txt"} {
+		if code, _, stderr := run(t, "data", "put", "-", key); code != 0 {
+			t.Fatalf("put exit code = %d: %s", code, stderr)
+		}
+	}
+	if code, _, stderr := run(t, "", "replicate", "backfill"); code != 1 || !strings.Contains(stderr, "no replication rule") {
+		t.Errorf("replicate without rules = %d %q", code, stderr)
+	}
+
+	t.Setenv("S3_REPLICATION_ENDPOINT", "https://backup.example.com")
+	t.Setenv("S3_REPLICATION_BUCKET", "backup")
+	t.Setenv("S3_REPLICATION_ACCESS_KEY", "key")
+	t.Setenv("S3_REPLICATION_SECRET_KEY", "secret")
+	code, stdout, stderr := run(t, "", "replicate", "backfill", "docs/")
+	if code != 0 || stdout != "2 objects queued for replication\n" {
+		t.Errorf("replicate backfill docs/ = %d %q %q", code, stdout, stderr)
+	}
+	entries, _ := filepath.Glob(filepath.Join(dir, ".selfhost_s3", "test-bucket", "replication", "queue", "*.json"))
+	if len(entries) != 2 {
+		t.Errorf("expected 2 queued entries, got %d", len(entries))
+	}
+
+	if code, _, _ := run(t, "", "replicate"); code != 2 {
+		t.Errorf("replicate without a subcommand = %d", code)
+	}
+}
+
+func TestRun_SnapshotAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	createBucket(t, dir, "test-bucket")
+	setupEnv(t, map[string]string{"S3_BUCKET": "test-bucket", "S3_STORAGE_PATH": dir})
+
+	for _, key := range []string{"a.txt", "docs/b.txt"} {
+		if code, _, stderr := run(t, "data", "put", "-", key); code != 0 {
+			t.Fatalf("put exit code = %d: %s", code, stderr)
+		}
+	}
+	archive := filepath.Join(t.TempDir(), "snapshot.tar.zst")
+	code, stdout, stderr := run(t, "", "snapshot", archive)
+	if code != 0 || !strings.HasPrefix(stdout, "Snapshot written to "+archive) {
+		t.Fatalf("snapshot = %d %q %q", code, stdout, stderr)
+	}
+	if code, stdout, _ := run(t, "", "snapshot", "-"); code != 0 || !strings.HasPrefix(stdout, "\x28\xb5\x2f\xfd") {
+		t.Errorf("snapshot - = %d, expected a zstd stream", code)
+	}
+
+	other := t.TempDir()
+	code, stdout, stderr = run(t, "", "restore", "-storage-path", other, "-bucket", "restored", archive)
+	if code != 0 || !strings.HasPrefix(stdout, "2 objects and 0 versions restored from the snapshot of bucket test-bucket taken ") {
+		t.Errorf("restore = %d %q %q", code, stdout, stderr)
+	}
+	if code, stdout, _ := run(t, "", "get", "-storage-path", other, "-bucket", "restored", "docs/b.txt"); code != 0 || stdout != "data" {
+		t.Errorf("get restored object = %d %q", code, stdout)
+	}
+
+	// The bucket has objects, which are only replaced on request
+	if code, _, stderr := run(t, "", "restore", archive); code != 1 || !strings.Contains(stderr, "use -replace") {
+		t.Errorf("restore into the bucket = %d %q", code, stderr)
+	}
+	if code, _, stderr := run(t, "", "restore", "-replace", archive); code != 0 {
+		t.Errorf("restore -replace = %d %q", code, stderr)
+	}
+	if code, _, stderr := run(t, "not an archive", "restore", "-replace", "-"); code != 1 || !strings.Contains(stderr, "invalid snapshot archive") {
+		t.Errorf("restore of an invalid archive = %d %q", code, stderr)
+	}
+	if code, _, _ := run(t, "", "restore"); code != 2 {
+		t.Errorf("restore without an archive = %d", code)
+	}
+}
+
+func TestRun_FlagsOverrideConfig(t *testing.T) {
+	dir := t.TempDir()
+	createBucket(t, dir, "flagged")
+	setupEnv(t, map[string]string{"S3_BUCKET": "other", "S3_STORAGE_PATH": t.TempDir()})
+
+	if code, _, stderr := run(t, "data", "put", "-storage-path", dir, "-bucket", "flagged", "-", "key"); code != 0 {
+		t.Fatalf("put exit code = %d: %s", code, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "flagged", "key")); err != nil {
+		t.Errorf("bucket directory from flags not used: %v", err)
+	}
+}
+
+func TestRun_MissingBucketDirectory(t *testing.T) {
+	setupEnv(t, map[string]string{"S3_BUCKET": "test-bucket", "S3_STORAGE_PATH": t.TempDir()})
+
+	code, _, stderr := run(t, "", "ls")
+	if code != 1 || !strings.Contains(stderr, "failed to open bucket directory") {
+		t.Errorf("ls = %d %q", code, stderr)
+	}
+}
+
+func TestRun_Remote(t *testing.T) {
+	setupEnv(t, nil)
+	storagePath := t.TempDir()
+	srv, err := server.NewServer(&config.Config{
+		Bucket:      "test-bucket",
+		AccessKey:   "test-access-key",
+		SecretKey:   "test-secret-key",
+		StoragePath: storagePath,
+		Region:      "us-east-1",
+		CORSOrigins: []string{"*"},
+		MaxFileSize: 10 * 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	flags := []string{"-endpoint", ts.URL, "-bucket", "test-bucket", "-access-key", "test-access-key", "-secret-key", "test-secret-key", "-region", "us-east-1"}
+	cmd := func(name string, args ...string) []string {
+		return append(append([]string{name}, flags...), args...)
+	}
+
+	if code, _, stderr := run(t, "remote data", cmd("put", "-", "a/b.txt")...); code != 0 {
+		t.Fatalf("put exit code = %d: %s", code, stderr)
+	}
+	code, stdout, stderr := run(t, "", cmd("ls")...)
+	if code != 0 || !strings.Contains(stdout, "a/b.txt") {
+		t.Errorf("ls = %d %q %s", code, stdout, stderr)
+	}
+	code, stdout, _ = run(t, "", cmd("get", "a/b.txt")...)
+	if code != 0 || stdout != "remote data" {
+		t.Errorf("get = %d %q", code, stdout)
+	}
+	code, stdout, _ = run(t, "", cmd("du")...)
+	if code != 0 || stdout != "1 objects, 11 B\n" {
+		t.Errorf("du = %d %q", code, stdout)
+	}
+	archive := filepath.Join(t.TempDir(), "snapshot.tar.zst")
+	code, stdout, stderr = run(t, "", cmd("snapshot", archive)...)
+	if code != 0 || !strings.HasPrefix(stdout, "Snapshot written to "+archive) {
+		t.Errorf("snapshot = %d %q %s", code, stdout, stderr)
+	}
+
+	// The storage path of the running server is only snapshotted through it, and not restored
+	local := []string{"-storage-path", storagePath, "-bucket", "test-bucket"}
+	code, _, stderr = run(t, "", append(append([]string{"snapshot"}, local...), archive+".local")...)
+	if code != 1 || !strings.Contains(stderr, "take the snapshot through it with -endpoint") {
+		t.Errorf("local snapshot = %d %q", code, stderr)
+	}
+	code, _, stderr = run(t, "", append(append([]string{"restore", "-replace"}, local...), archive)...)
+	if code != 1 || !strings.Contains(stderr, "stop it before restoring") {
+		t.Errorf("restore = %d %q", code, stderr)
+	}
+
+	// Neither is it written to directly, which would bypass the server
+	code, _, stderr = run(t, "data", append(append([]string{"put"}, local...), "-", "c.txt")...)
+	if code != 1 || !strings.Contains(stderr, "write through it with -endpoint") {
+		t.Errorf("local put = %d %q", code, stderr)
+	}
+	code, _, stderr = run(t, "", append(append([]string{"rm"}, local...), "a/b.txt")...)
+	if code != 1 || !strings.Contains(stderr, "write through it with -endpoint") {
+		t.Errorf("local rm = %d %q", code, stderr)
+	}
+
+	if code, _, _ := run(t, "", cmd("rm", "a/b.txt")...); code != 0 {
+		t.Errorf("rm exit code = %d", code)
+	}
+	if code, stdout, _ := run(t, "", cmd("ls")...); code != 0 || strings.Contains(stdout, "a/b.txt") {
+		t.Errorf("ls after rm = %d %q", code, stdout)
+	}
+
+	// Wrong credentials fail
+	code, _, stderr = run(t, "", "ls", "-endpoint", ts.URL, "-bucket", "test-bucket", "-access-key", "wrong", "-secret-key", "wrong")
+	if code != 1 || !strings.Contains(stderr, "InvalidAccessKeyId") {
+		t.Errorf("ls with wrong key = %d %q", code, stderr)
+	}
+	code, _, stderr = run(t, "", "snapshot", "-endpoint", ts.URL, "-bucket", "test-bucket", "-access-key", "wrong", "-secret-key", "wrong", archive+".2")
+	if code != 1 || !strings.Contains(stderr, "InvalidAccessKeyId") {
+		t.Errorf("snapshot with wrong key = %d %q", code, stderr)
+	}
+	if _, err := os.Stat(archive + ".2"); !os.IsNotExist(err) {
+		t.Error("expected the failed snapshot to be removed")
+	}
+}
+
+func TestRun_MissingBucket(t *testing.T) {
+	setupEnv(t, nil)
+
+	code, _, stderr := run(t, "", "ls")
+	if code != 1 || !strings.Contains(stderr, "the bucket (-bucket or S3_BUCKET) is required") {
+		t.Errorf("ls = %d %q", code, stderr)
+	}
+}
+
+func TestRun_GenKeys(t *testing.T) {
+	code, stdout, _ := run(t, "", "gen-keys")
+	if code != 0 {
+		t.Fatalf("exit code = %d", code)
+	}
+	if !regexp.MustCompile(`^S3_ACCESS_KEY=[A-Z0-9]{20}\nS3_SECRET_KEY=[A-Za-z0-9]{40}\n$`).MatchString(stdout) {
+		t.Errorf("output = %q", stdout)
+	}
+
+	_, again, _ := run(t, "", "gen-keys")
+	if again == stdout {
+		t.Error("gen-keys returned the same keys twice")
+	}
+}
+
+func TestRun_Presign(t *testing.T) {
+	setupEnv(t, map[string]string{
+		"S3_BUCKET":     "test-bucket",
+		"S3_ACCESS_KEY": "test-access-key",
+		"S3_SECRET_KEY": "test-secret-key",
+		"S3_PORT":       "9100",
+	})
+
+	code, stdout, stderr := run(t, "", "presign", "-expires", "10m", "docs/report.pdf")
+	if code != 0 {
+		t.Fatalf("exit code = %d: %s", code, stderr)
+	}
+	url := strings.TrimSpace(stdout)
+	if !strings.HasPrefix(url, "http://localhost:9100/test-bucket/docs/report.pdf?") || !strings.Contains(url, "X-Amz-Expires=600") {
+		t.Fatalf("url = %q", url)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.Host = "localhost:9100"
+	if err := auth.NewSignatureV4("test-access-key", "test-secret-key", "us-east-1").ValidateRequest(req); err != nil {
+		t.Errorf("presigned URL rejected: %v", err)
+	}
+
+	code, stdout, _ = run(t, "", "presign", "-method", "put", "-endpoint", "https://s3.example.com", "upload.bin")
+	if code != 0 || !strings.HasPrefix(stdout, "https://s3.example.com/test-bucket/upload.bin?") {
+		t.Errorf("presign PUT = %d %q", code, stdout)
+	}
+
+	for _, args := range [][]string{
+		{"presign", "-expires", "200h", "key"},
+		{"presign", "-method", "DELETE", "key"},
+	} {
+		if code, _, _ := run(t, "", args...); code != 1 {
+			t.Errorf("%v exit code = %d, want 1", args, code)
+		}
+	}
+}
+
+func TestRun_ConfigCheck(t *testing.T) {
+	setupEnv(t, map[string]string{
+		"S3_BUCKET":     "test-bucket",
+		"S3_ACCESS_KEY": "test-access-key",
+		"S3_SECRET_KEY": "test-secret-key",
+	})
+
+	code, stdout, _ := run(t, "", "config", "check")
+	if code != 0 || !strings.HasPrefix(stdout, "Configuration is valid\n") || !strings.Contains(stdout, "test-bucket") {
+		t.Errorf("valid config = %d %q", code, stdout)
+	}
+
+	t.Setenv("S3_PORT", "invalid")
+	t.Setenv("S3_SECRET_KEY", "")
+	code, _, stderr := run(t, "", "config", "check")
+	if code != 1 || !strings.Contains(stderr, "S3_SECRET_KEY is required") || !strings.Contains(stderr, "S3_PORT") {
+		t.Errorf("invalid config = %d %q", code, stderr)
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	code, stdout, _ := run(t, "")
+	if code != 0 || !strings.Contains(stdout, "gen-keys") {
+		t.Errorf("no command = %d %q", code, stdout)
+	}
+
+	code, _, stderr := run(t, "", "unknown")
+	if code != 2 || !strings.Contains(stderr, `Unknown command "unknown"`) {
+		t.Errorf("unknown command = %d %q", code, stderr)
+	}
+
+	code, _, stderr = run(t, "", "put", "only-one-arg")
+	if code != 2 || !strings.Contains(stderr, "Usage: selfhost_s3 put") {
+		t.Errorf("invalid arguments = %d %q", code, stderr)
+	}
+
+	if code, _, _ := run(t, "", "ls", "-unknown-flag"); code != 2 {
+		t.Errorf("unknown flag exit code = %d", code)
+	}
+	if code, _, _ := run(t, "", "config", "validate"); code != 2 {
+		t.Errorf("config validate exit code = %d", code)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1024:            "1.0 KiB",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+	}
+	for n, want := range tests {
+		if got := formatSize(n); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,524 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+	"github.com/Notifuse/selfhost_s3/internal/replication"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// runList prints the objects under an optional prefix
+func runList(ctx context.Context, e *env, fs *flag.FlagSet, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	prefix := ""
+	if len(args) == 1 {
+		prefix = args[0]
+	}
+
+	t, err := openTarget(fs)
+	if err != nil {
+		return err
+	}
+	objects, err := t.list(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(e.stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, obj := range objects {
+		_, _ = fmt.Fprintf(w, "%s\t%d\t %s\n", obj.LastModified.UTC().Format("2006-01-02 15:04:05"), obj.Size, obj.Key)
+	}
+	return w.Flush()
+}
+
+// putFlags registers the flags of put
+func putFlags(fs *flag.FlagSet) {
+	fs.String("content-type", "", "content type of the object (default: guessed from the key)")
+}
+
+// runPut uploads a file, or standard input when the file is "-"
+func runPut(ctx context.Context, e *env, fs *flag.FlagSet, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	source, key := args[0], args[1]
+
+	contentType := flagValue(fs, "content-type", mime.TypeByExtension(filepath.Ext(key)))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	var body io.ReadSeeker
+	if source == "-" {
+		// Uploads need the size up front, so standard input is spooled to a temporary file
+		tmp, err := os.CreateTemp("", "selfhost_s3-put-")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}()
+		if _, err := io.Copy(tmp, e.stdin); err != nil {
+			return fmt.Errorf("failed to read standard input: %w", err)
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		body = tmp
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		body = f
+	}
+
+	t, err := openTarget(fs)
+	if err != nil {
+		return err
+	}
+	return t.put(ctx, key, body, contentType)
+}
+
+// runGet downloads an object to a file, or to standard output by default
+func runGet(ctx context.Context, e *env, fs *flag.FlagSet, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	key, dest := args[0], "-"
+	if len(args) == 2 {
+		dest = args[1]
+	}
+
+	t, err := openTarget(fs)
+	if err != nil {
+		return err
+	}
+	reader, err := t.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = reader.Close() }()
+
+	if dest == "-" {
+		_, err = io.Copy(e.stdout, reader)
+		return err
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, reader); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runRemove deletes objects. Like S3, deleting a missing key succeeds.
+func runRemove(ctx context.Context, e *env, fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	t, err := openTarget(fs)
+	if err != nil {
+		return err
+	}
+	for _, key := range args {
+		if err := t.remove(ctx, key); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// runUsage prints the number and total size of the objects under an optional prefix
+func runUsage(ctx context.Context, e *env, fs *flag.FlagSet, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	prefix := ""
+	if len(args) == 1 {
+		prefix = args[0]
+	}
+
+	t, err := openTarget(fs)
+	if err != nil {
+		return err
+	}
+	objects, err := t.list(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	var count, size int64
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, "/") {
+			continue // folder marker
+		}
+		count++
+		size += obj.Size
+	}
+	_, _ = fmt.Fprintf(e.stdout, "%d objects, %s\n", count, formatSize(size))
+
+	// The storage path also holds non-current versions, metadata and incomplete uploads
+	if usage, ok := t.usage(); ok && prefix == "" {
+		_, _ = fmt.Fprintf(e.stdout, "%s stored, including versions, metadata and incomplete uploads\n", formatSize(usage.Bytes))
+	}
+	return nil
+}
+
+// formatSize renders a size in bytes with a binary unit
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// dedupFlags registers the flags of dedup
+func dedupFlags(fs *flag.FlagSet) {
+	fs.Bool("gc", false, "remove the deduplicated data no longer referenced by any object first")
+}
+
+// runDedup reports the blobs shared by deduplicated objects, optionally collecting the unreferenced ones
+func runDedup(_ context.Context, e *env, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	t, err := openTarget(fs)
+	if err != nil {
+		return err
+	}
+	local, ok := t.(*localTarget)
+	if !ok {
+		return errors.New("dedup works on the storage path, not with -endpoint")
+	}
+
+	if flagValue(fs, "gc", "false") == "true" {
+		removed, freed, err := local.store.CollectBlobs()
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(e.stdout, "Removed %d unreferenced blobs, %s freed\n", removed, formatSize(freed))
+	}
+
+	stats, err := local.store.DedupStats()
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(e.stdout, "%d blobs shared by %d objects and versions, %s stored\n", stats.Blobs, stats.References, formatSize(stats.Bytes))
+	_, _ = fmt.Fprintf(e.stdout, "%s saved by deduplication\n", formatSize(stats.SavedBytes))
+	if stats.Unreferenced > 0 {
+		_, _ = fmt.Fprintf(e.stdout, "%d unreferenced blobs, %s (removed with -gc)\n", stats.Unreferenced, formatSize(stats.UnreferencedBytes))
+	}
+	return nil
+}
+
+// runIndex reconciles the metadata index with the storage path. While the server holds
+// the index, it is marked stale so that the server reconciles it instead.
+func runIndex(_ context.Context, e *env, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 || args[0] != "rebuild" {
+		return errUsage
+	}
+
+	t, err := openTarget(fs)
+	if err != nil {
+		return err
+	}
+	local, ok := t.(*localTarget)
+	if !ok {
+		return errors.New("index works on the storage path, not with -endpoint")
+	}
+
+	stats, err := local.store.OpenIndex()
+	if errors.Is(err, storage.ErrIndexInUse) {
+		if err := local.store.MarkIndexStale(); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(e.stdout, "The index is in use by the server; it is rebuilt before the next listing")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if stats == nil {
+		if stats, err = local.store.RebuildIndex(); err != nil {
+			_ = local.store.CloseIndex()
+			return err
+		}
+	}
+	if err := local.store.CloseIndex(); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(e.stdout, "%d objects and folders indexed: %d added, %d updated, %d removed\n", stats.Keys, stats.Added, stats.Updated, stats.Removed)
+	return nil
+}
+
+func fsckFlags(fs *flag.FlagSet) {
+	fs.Bool("repair", false, "remove orphaned files, record missing checksums and accept objects modified on disk")
+	fs.Bool("quarantine", false, "move corrupt objects and orphaned data to the quarantine directory")
+	fs.String("rate", "", "maximum data read per second, e.g. 50MB (default: no limit)")
+}
+
+// runReplicate queues the existing objects under an optional prefix for replication. The
+// running server replays the queue, within a minute.
+func runReplicate(_ context.Context, e *env, fs *flag.FlagSet, args []string) error {
+	if len(args) < 1 || len(args) > 2 || args[0] != "backfill" {
+		return errUsage
+	}
+	prefix := ""
+	if len(args) == 2 {
+		prefix = args[1]
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if len(cfg.Replication) == 0 {
+		return errors.New("no replication rule configured")
+	}
+
+	t, err := openTarget(fs)
+	if err != nil {
+		return err
+	}
+	local, ok := t.(*localTarget)
+	if !ok {
+		return errors.New("replicate works on the storage path, not with -endpoint")
+	}
+
+	rules := make([]replication.Rule, 0, len(cfg.Replication))
+	for _, rule := range cfg.Replication {
+		rules = append(rules, replication.Rule{Prefix: rule.Prefix, Target: replication.TargetName(rule.Endpoint, rule.Bucket)})
+	}
+	r, err := replication.New(local.store.InternalPath("replication"), local.store, rules)
+	if err != nil {
+		return err
+	}
+	queued, err := r.Backfill(prefix)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(e.stdout, "%d objects queued for replication\n", queued)
+	return nil
+}
+
+// runFsck verifies the objects and versions of the bucket and reports what is wrong with them
+func runFsck(ctx context.Context, e *env, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	var rate int64
+	if value := flagValue(fs, "rate", ""); value != "" {
+		n, err := config.ParseSize(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid -rate %q: must be a size such as 50MB", value)
+		}
+		rate = n
+	}
+
+	t, err := openTarget(fs)
+	if err != nil {
+		return err
+	}
+	local, ok := t.(*localTarget)
+	if !ok {
+		return errors.New("fsck works on the storage path, not with -endpoint")
+	}
+
+	report, err := local.store.Scrub(ctx, storage.ScrubOptions{
+		Repair:     flagValue(fs, "repair", "false") == "true",
+		Quarantine: flagValue(fs, "quarantine", "false") == "true",
+		Rate:       rate,
+	})
+	if report != nil {
+		for _, issue := range report.Issues {
+			subject := issue.Key
+			if issue.VersionID != "" {
+				subject += " (version " + issue.VersionID + ")"
+			}
+			if subject == "" {
+				subject = issue.Path
+			}
+			line := fmt.Sprintf("%s: %s: %s", issue.Problem, subject, issue.Detail)
+			if issue.Action != "" {
+				line += " [" + issue.Action + "]"
+			}
+			_, _ = fmt.Fprintln(e.stdout, line)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(e.stdout, "%d objects and %d versions verified, %s read\n", report.Objects, report.Versions, formatSize(report.Bytes))
+	if report.Recorded > 0 {
+		_, _ = fmt.Fprintf(e.stdout, "%d checksums recorded\n", report.Recorded)
+	}
+	if n := report.Unverified - report.Recorded; n > 0 {
+		_, _ = fmt.Fprintf(e.stdout, "%d objects and versions without a checksum (recorded with -repair)\n", n)
+	}
+	if n := report.Unresolved(); n > 0 {
+		return fmt.Errorf("%d issues found, %d unresolved", len(report.Issues), n)
+	}
+	if len(report.Issues) > 0 {
+		_, _ = fmt.Fprintf(e.stdout, "%d issues found and resolved\n", len(report.Issues))
+	}
+	return nil
+}
+
+// importBatch is the number of files copied into the bucket directory reconciled at once
+const importBatch = 1000
+
+// runImport uploads the files of a directory tree under an optional key prefix. Files
+// already copied into the bucket directory, by rsync for instance, are described in place.
+func runImport(ctx context.Context, e *env, fs *flag.FlagSet, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	dir, prefix := args[0], ""
+	if len(args) == 2 {
+		prefix = args[1]
+		if !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	t, err := openTarget(fs)
+	if err != nil {
+		return err
+	}
+	if local, ok := t.(*localTarget); ok {
+		if _, inBucket := local.store.KeyForPath(dir); inBucket {
+			if prefix != "" {
+				return errors.New("files in the bucket directory are imported in place, without a prefix")
+			}
+			if err := local.lock(); err != nil {
+				return err
+			}
+			defer local.store.Unlock()
+			return importInPlace(e, local.store, dir)
+		}
+	}
+
+	var files, skipped, size int64
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			skipped++
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key := prefix + filepath.ToSlash(rel)
+
+		contentType := mime.TypeByExtension(filepath.Ext(key))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		if err := t.put(ctx, key, f, contentType); err != nil {
+			return fmt.Errorf("failed to import %s: %w", path, err)
+		}
+		if info, err := f.Stat(); err == nil {
+			size += info.Size()
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(e.stdout, "%d files imported, %s\n", files, formatSize(size))
+	if skipped > 0 {
+		_, _ = fmt.Fprintf(e.stdout, "%d entries skipped: not regular files\n", skipped)
+	}
+	return nil
+}
+
+// importInPlace describes the files under dir, in the bucket directory, that have no
+// metadata or were changed since it was written
+func importInPlace(e *env, store *storage.Storage, dir string) error {
+	var files, imported int64
+	var keys []string
+	reconcile := func() error {
+		changes, err := store.Reconcile(keys)
+		for _, change := range changes {
+			if change.Object != nil {
+				imported++
+			}
+		}
+		keys = keys[:0]
+		return err
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		key, _ := store.KeyForPath(path)
+		keys = append(keys, key)
+		files++
+		if len(keys) == importBatch {
+			return reconcile()
+		}
+		return nil
+	})
+	if err == nil {
+		err = reconcile()
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(e.stdout, "%d files checked in place, %d imported\n", files, imported)
+	return nil
+}
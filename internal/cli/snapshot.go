@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// runSnapshot writes a snapshot archive of the bucket to a file, or to standard output
+// with -. With -endpoint the running instance takes the snapshot, holding writes back
+// only while it does; on the storage path, the server must be stopped.
+func runSnapshot(ctx context.Context, e *env, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	dest := args[0]
+
+	t, err := openTarget(fs)
+	if err != nil {
+		return err
+	}
+
+	if dest == "-" {
+		_, err := t.snapshot(ctx, e.stdout)
+		return err
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	n, err := t.snapshot(ctx, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// A partial archive would fail to restore anyway
+		_ = os.Remove(dest)
+		return err
+	}
+	_, _ = fmt.Fprintf(e.stdout, "Snapshot written to %s (%s)\n", dest, formatSize(n))
+	return nil
+}
+
+// restoreFlags registers the flags of the restore command
+func restoreFlags(fs *flag.FlagSet) {
+	fs.String("storage-path", "", "storage directory (default: S3_STORAGE_PATH)")
+	fs.String("bucket", "", "bucket name (default: S3_BUCKET)")
+	fs.Bool("replace", false, "replace the objects of a bucket that already has some")
+}
+
+// runRestore loads a snapshot archive, from a file or from standard input with -, into the
+// bucket on the storage path. The archive is verified before it replaces anything.
+func runRestore(ctx context.Context, e *env, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	cfg, loadErr := loadConfig()
+	storagePath := flagValue(fs, "storage-path", cfg.StoragePath)
+	bucket := flagValue(fs, "bucket", cfg.Bucket)
+	if bucket == "" {
+		err := errors.New("the bucket (-bucket or S3_BUCKET) is required")
+		if loadErr != nil {
+			err = fmt.Errorf("%w; the configuration could not be loaded:\n%v", err, loadErr)
+		}
+		return err
+	}
+	if cfg.StorageBackend == config.StorageMemory && flagValue(fs, "storage-path", "") == "" {
+		return errors.New("snapshots cannot be restored into the memory backend")
+	}
+
+	var r io.Reader = e.stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+
+	stats, err := storage.Restore(ctx, r, storagePath, bucket, storage.RestoreOptions{
+		Replace: flagValue(fs, "replace", "false") == "true",
+	})
+	if errors.Is(err, storage.ErrBucketNotEmpty) {
+		return fmt.Errorf("bucket %s already has objects; use -replace to replace them", bucket)
+	}
+	if errors.Is(err, storage.ErrLocked) {
+		return fmt.Errorf("bucket %s is in use by a running server; stop it before restoring", bucket)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(e.stdout, "%d objects and %d versions restored from the snapshot of bucket %s taken %s (%s verified)\n",
+		stats.Objects, stats.Versions, stats.Bucket, stats.Created.Format(time.RFC3339), formatSize(stats.Bytes))
+	return nil
+}
@@ -0,0 +1,319 @@
+package cli
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// object is an entry of a listing
+type object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// target is the bucket a command operates on: the storage path, or a running instance
+type target interface {
+	list(ctx context.Context, prefix string) ([]object, error)
+	put(ctx context.Context, key string, body io.ReadSeeker, contentType string) error
+	get(ctx context.Context, key string) (io.ReadCloser, error)
+	remove(ctx context.Context, key string) error
+	// usage reports the bytes stored including versions and metadata, when known
+	usage() (*storage.Usage, bool)
+	// snapshot writes a snapshot archive of the bucket to w and returns its size
+	snapshot(ctx context.Context, w io.Writer) (int64, error)
+}
+
+// targetOptions are the flags selecting the target of a command
+type targetOptions struct {
+	endpoint    string
+	storagePath string
+	bucket      string
+	accessKey   string
+	secretKey   string
+	region      string
+}
+
+// targetFlags registers the flags selecting the target. Their defaults come from the configuration.
+func targetFlags(fs *flag.FlagSet) {
+	fs.String("endpoint", "", "URL of a running instance, e.g. http://localhost:9000 (default: the storage path)")
+	fs.String("storage-path", "", "storage directory (default: S3_STORAGE_PATH)")
+	credentialFlags(fs)
+}
+
+// credentialFlags registers the bucket and credential flags
+func credentialFlags(fs *flag.FlagSet) {
+	fs.String("bucket", "", "bucket name (default: S3_BUCKET)")
+	fs.String("access-key", "", "access key used with -endpoint (default: S3_ACCESS_KEY)")
+	fs.String("secret-key", "", "secret key used with -endpoint (default: S3_SECRET_KEY)")
+	fs.String("region", "", "region used with -endpoint (default: S3_REGION)")
+}
+
+// openTarget opens the target selected by the flags of fs
+func openTarget(fs *flag.FlagSet) (target, error) {
+	cfg, loadErr := loadConfig()
+	opts := targetOptions{
+		endpoint:    flagValue(fs, "endpoint", ""),
+		storagePath: flagValue(fs, "storage-path", cfg.StoragePath),
+		bucket:      flagValue(fs, "bucket", cfg.Bucket),
+		accessKey:   flagValue(fs, "access-key", cfg.AccessKey),
+		secretKey:   flagValue(fs, "secret-key", cfg.SecretKey),
+		region:      flagValue(fs, "region", cfg.Region),
+	}
+
+	missing := func(what string) error {
+		err := fmt.Errorf("%s is required", what)
+		if loadErr != nil {
+			err = fmt.Errorf("%w; the configuration could not be loaded:\n%v", err, loadErr)
+		}
+		return err
+	}
+	if opts.bucket == "" {
+		return nil, missing("the bucket (-bucket or S3_BUCKET)")
+	}
+
+	if opts.endpoint != "" {
+		if opts.accessKey == "" || opts.secretKey == "" {
+			return nil, missing("the access key and secret key (-access-key and -secret-key, or S3_ACCESS_KEY and S3_SECRET_KEY)")
+		}
+		return newRemoteTarget(opts), nil
+	}
+
+	if cfg.StorageBackend == config.StorageMemory && flagValue(fs, "storage-path", "") == "" {
+		return nil, errors.New("the memory backend can only be reached through a running instance with -endpoint")
+	}
+	store, err := storage.Open(opts.storagePath, opts.bucket)
+	if err != nil {
+		return nil, err
+	}
+	// Objects are encrypted, compressed and deduplicated like the server does
+	if cfg.EncryptionKey != nil {
+		store.SetMasterKey(cfg.EncryptionKey)
+	}
+	if cfg.Compression != "" {
+		store.SetCompression(&storage.CompressionPolicy{
+			Algorithm:    cfg.Compression,
+			ContentTypes: cfg.CompressTypes,
+			Prefixes:     cfg.CompressPrefixes,
+		})
+	}
+	if err := store.SetDeduplication(cfg.Dedup); err != nil {
+		return nil, err
+	}
+	return &localTarget{store: store}, nil
+}
+
+// flagValue returns the value of a string flag, or fallback when it was not set
+func flagValue(fs *flag.FlagSet, name, fallback string) string {
+	if f := fs.Lookup(name); f != nil && f.Value.String() != "" {
+		return f.Value.String()
+	}
+	return fallback
+}
+
+// localTarget works directly on the storage path. The storage is never closed, since
+// that would remove the temporary files of a server running on the same directory.
+type localTarget struct {
+	store *storage.Storage
+}
+
+// lock takes the lock of the bucket before a write. Writes next to a running server would
+// bypass its locking, versioning, quotas, deduplication and index, so they go through it.
+func (t *localTarget) lock() error {
+	err := t.store.Lock()
+	if errors.Is(err, storage.ErrLocked) {
+		return errors.New("a server is running on the storage path; write through it with -endpoint")
+	}
+	return err
+}
+
+func (t *localTarget) list(_ context.Context, prefix string) ([]object, error) {
+	objects, err := t.store.ListObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]object, 0, len(objects))
+	for _, obj := range objects {
+		list = append(list, object{Key: obj.Key, Size: obj.Size, LastModified: obj.LastModified})
+	}
+	return list, nil
+}
+
+func (t *localTarget) put(_ context.Context, key string, body io.ReadSeeker, contentType string) error {
+	if err := t.lock(); err != nil {
+		return err
+	}
+	defer t.store.Unlock()
+
+	_, err := t.store.PutObjectWithOptions(key, body, storage.PutOptions{ContentType: contentType})
+	return err
+}
+
+func (t *localTarget) get(_ context.Context, key string) (io.ReadCloser, error) {
+	_, reader, err := t.store.GetObject(key)
+	return reader, err
+}
+
+func (t *localTarget) remove(_ context.Context, key string) error {
+	if err := t.lock(); err != nil {
+		return err
+	}
+	defer t.store.Unlock()
+
+	return t.store.DeleteObject(key)
+}
+
+func (t *localTarget) usage() (*storage.Usage, bool) {
+	usage, err := t.store.Usage()
+	return usage, err == nil
+}
+
+func (t *localTarget) snapshot(_ context.Context, w io.Writer) (int64, error) {
+	// Writes of a server are only held back within its own process
+	if err := t.store.Lock(); err != nil {
+		if errors.Is(err, storage.ErrLocked) {
+			return 0, errors.New("a server is running on the storage path; take the snapshot through it with -endpoint")
+		}
+		return 0, err
+	}
+	defer t.store.Unlock()
+
+	snap, err := t.store.Snapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = snap.Close() }()
+	return snap.WriteTo(w)
+}
+
+// remoteTarget works against a running instance through the S3 API
+type remoteTarget struct {
+	client *s3.Client
+	bucket string
+}
+
+// newS3Client creates a path-style client for a running instance
+func newS3Client(opts targetOptions) *s3.Client {
+	return s3.New(s3.Options{
+		Region:       opts.region,
+		Credentials:  credentials.NewStaticCredentialsProvider(opts.accessKey, opts.secretKey, ""),
+		BaseEndpoint: aws.String(opts.endpoint),
+		UsePathStyle: true,
+	})
+}
+
+func newRemoteTarget(opts targetOptions) *remoteTarget {
+	return &remoteTarget{client: newS3Client(opts), bucket: opts.bucket}
+}
+
+func (t *remoteTarget) list(ctx context.Context, prefix string) ([]object, error) {
+	var list []object
+	paginator := s3.NewListObjectsV2Paginator(t.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(t.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			list = append(list, object{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return list, nil
+}
+
+func (t *remoteTarget) put(ctx context.Context, key string, body io.ReadSeeker, contentType string) error {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	_, err := t.client.PutObject(ctx, input)
+	return err
+}
+
+func (t *remoteTarget) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	output, err := t.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+func (t *remoteTarget) remove(ctx context.Context, key string) error {
+	_, err := t.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (t *remoteTarget) usage() (*storage.Usage, bool) {
+	return nil, false
+}
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// snapshot asks the instance for a snapshot, which the SDK has no operation for
+func (t *remoteTarget) snapshot(ctx context.Context, w io.Writer) (int64, error) {
+	opts := t.client.Options()
+	url := strings.TrimSuffix(aws.ToString(opts.BaseEndpoint), "/") + "/" + t.bucket + "?snapshot"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	creds, err := opts.Credentials.Retrieve(ctx)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, emptyPayloadHash, "s3", opts.Region, time.Now()); err != nil {
+		return 0, err
+	}
+
+	resp, err := opts.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Code    string
+			Message string
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if xml.Unmarshal(body, &apiErr) == nil && apiErr.Code != "" {
+			return 0, fmt.Errorf("snapshot failed: %s: %s", apiErr.Code, apiErr.Message)
+		}
+		return 0, fmt.Errorf("snapshot failed: %s", resp.Status)
+	}
+	return io.Copy(w, resp.Body)
+}
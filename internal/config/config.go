@@ -1,10 +1,17 @@
 package config
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/logging"
 )
 
 // Config holds selfhost_s3 server configuration
@@ -12,77 +19,304 @@ type Config struct {
 	Bucket            string
 	AccessKey         string
 	SecretKey         string
+	Credentials       []Credential // other access keys accepted besides AccessKey (config file only)
 	Port              int
 	StoragePath       string
+	StorageBackend    string // "filesystem" (default) or "memory"
 	Region            string
 	CORSOrigins       []string
-	MaxFileSize       int64  // in bytes
-	PublicPrefix      string // prefix for publicly accessible files (default: "public/")
-	PublicCacheMaxAge int    // Cache-Control max-age in seconds (default: 31536000)
+	MaxFileSize       int64         // in bytes
+	EncryptionKey     []byte        // SSE-S3 master key (32 bytes); new objects are encrypted when set
+	Compression       string        // "gzip" to compress new objects on disk, "" (default) to store them as is
+	CompressTypes     []string      // content types compressed, "type/*" wildcards allowed
+	CompressPrefixes  []string      // key prefixes compressed (default: every key)
+	Dedup             bool          // store identical objects once
+	DedupGCInterval   time.Duration // how often unreferenced blobs are removed (default: 1h, 0 disables)
+	Quotas            []Quota       // limits on the objects of the bucket and of key prefixes
+	MinFreeSpace      int64         // free disk space in bytes below which writes are rejected, 0 disables
+	MetadataIndex     bool          // list objects from a persistent index instead of walking the bucket
+	ScrubInterval     time.Duration // how often stored data is verified against its checksums, 0 (default) disables
+	ScrubRate         int64         // bytes read per second while scrubbing (default: 32MB), 0 means no limit
+	ScrubRepair       bool          // remove orphaned files and record checksums while scrubbing
+	ScrubQuarantine   bool          // move corrupt objects aside while scrubbing
+	Watch             bool          // pick up files added or removed directly in the bucket directory (Linux only)
+	UpstreamEndpoint  string        // S3-compatible endpoint objects missing locally are fetched from, "" disables
+	UpstreamBucket    string        // upstream bucket (default: Bucket)
+	UpstreamRegion    string        // region requests to the upstream are signed for (default: Region)
+	UpstreamAccessKey string
+	UpstreamSecretKey string
+	UpstreamWrites    bool              // make writes and deletes upstream too
+	CacheMaxSize      int64             // size of the fetched objects kept locally, 0 (default) means no limit
+	PublicPrefix      string            // prefix for publicly accessible files (default: "public/")
+	PublicCacheMaxAge int               // Cache-Control max-age in seconds (default: 31536000)
+	ReadHeaderTimeout time.Duration     // time allowed to read request headers (default: 10s)
+	ReadTimeout       time.Duration     // time allowed to read a whole request, 0 means no limit
+	WriteTimeout      time.Duration     // time allowed to write a response, 0 means no limit
+	IdleTimeout       time.Duration     // keep-alive idle timeout (default: 2m)
+	ShutdownTimeout   time.Duration     // grace period for in-flight requests on shutdown (default: 30s)
+	LifecycleInterval time.Duration     // how often lifecycle rules are applied (default: 1h, 0 disables)
+	LifecycleDryRun   bool              // log lifecycle actions without deleting anything
+	Webhooks          []Webhook         // event notification endpoints
+	Replication       []ReplicationRule // buckets the writes and deletes are replicated to
+	TLSCert           string            // PEM certificate file; enables HTTPS together with TLSKey
+	TLSKey            string            // PEM private key file
+	TLSClientCA       string            // PEM CA bundle; when set, clients must present a certificate
+	ACMEDomains       []string          // domains to obtain certificates for via ACME
+	ACMEEmail         string            // contact address for the ACME account (optional)
+	ACMEDirectory     string            // ACME directory URL (default: Let's Encrypt)
+	ACMECacheDir      string            // where ACME accounts and certificates are stored
+	ACMEHTTPAddr      string            // address answering HTTP-01 challenges, e.g. ":80" (optional)
+	ACMECARoot        string            // PEM CA bundle trusted for the ACME directory (optional)
+	MetricsToken      string            // bearer token required on /metrics (optional)
+	LogFormat         string            // "text" (default) or "json"
+	LogLevel          string            // "debug", "info" (default), "warn" or "error"
+	AccessLogFile     string            // S3 server access log file (optional)
+	AccessLogMaxSize  int64             // size at which the access log file is rotated (default: 100MB)
+	AccessLogMaxFiles int               // rotated access log files kept (default: 5)
+	AccessLogPrefix   string            // bucket prefix receiving access log objects (optional)
+	AccessLogInterval time.Duration     // how often access log objects are delivered (default: 5m)
 }
 
-// Load reads configuration from environment variables
-func Load() (*Config, error) {
-	cfg := &Config{
+// DefaultACMEDirectory is the Let's Encrypt production directory
+const DefaultACMEDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+
+// CompressionGzip compresses objects on disk with gzip
+const CompressionGzip = "gzip"
+
+// DefaultCompressTypes are the content types compressed by default: text and
+// structured formats, which typically compress well
+var DefaultCompressTypes = []string{
+	"text/*",
+	"application/json",
+	"application/xml",
+	"application/javascript",
+	"application/x-ndjson",
+	"image/svg+xml",
+}
+
+// Storage backends
+const (
+	StorageFilesystem = "filesystem"
+	StorageMemory     = "memory"
+)
+
+// Quota limits the current objects stored under a key prefix
+type Quota struct {
+	Prefix     string // "" for the whole bucket
+	MaxBytes   int64  // 0 for no limit
+	MaxObjects int64  // 0 for no limit
+}
+
+// Credential is an access key and its secret key
+type Credential struct {
+	AccessKey string
+	SecretKey string
+}
+
+// Webhook configures an HTTP endpoint receiving S3 event notifications
+type Webhook struct {
+	ID     string // identifies the webhook in the event queue (optional)
+	URL    string
+	Secret string   // HMAC-SHA256 signing secret (optional)
+	Events []string // e.g. "s3:ObjectCreated:*"
+	Prefix string   // key prefix filter (optional)
+	Suffix string   // key suffix filter (optional)
+}
+
+// ReplicationRule replicates the writes and deletes under a key prefix to another
+// S3-compatible bucket
+type ReplicationRule struct {
+	Prefix    string // key prefix filter (optional)
+	Endpoint  string
+	Bucket    string // default: the bucket's name
+	Region    string // default: the bucket's region
+	AccessKey string
+	SecretKey string
+}
+
+// Default returns a configuration with every optional setting at its default value.
+// Bucket and credentials are left empty.
+func Default() *Config {
+	return &Config{
 		Port:              9000,
 		StoragePath:       "./data",
+		StorageBackend:    StorageFilesystem,
 		Region:            "us-east-1",
 		CORSOrigins:       []string{"*"},
 		MaxFileSize:       100 * 1024 * 1024, // 100MB default
 		PublicPrefix:      "public/",         // default public prefix
 		PublicCacheMaxAge: 31536000,          // 1 year default
+		CompressTypes:     DefaultCompressTypes,
+		DedupGCInterval:   time.Hour,
+		ScrubRate:         32 * 1024 * 1024,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		ShutdownTimeout:   30 * time.Second,
+		LifecycleInterval: time.Hour,
+		ACMEDirectory:     DefaultACMEDirectory,
+		LogFormat:         logging.FormatText,
+		LogLevel:          "info",
+		AccessLogMaxSize:  100 * 1024 * 1024,
+		AccessLogMaxFiles: 5,
+		AccessLogInterval: 5 * time.Minute,
+	}
+}
+
+// TLSEnabled reports whether the server serves HTTPS
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCert != "" || len(c.ACMEDomains) > 0
+}
+
+// Load reads configuration from environment variables and, when S3_CONFIG_FILE is set,
+// from that YAML or TOML file. Environment variables take precedence over the file.
+// Every invalid setting is reported in the returned error, one per line.
+func Load() (*Config, error) {
+	l := &loader{}
+	if path := os.Getenv("S3_CONFIG_FILE"); path != "" {
+		file, err := readFile(path)
+		if file == nil {
+			return nil, err
+		}
+		if err != nil {
+			l.errs = append(l.errs, err)
+		}
+		l.file = file
 	}
 
+	cfg := Default()
+
 	// Required fields
-	cfg.Bucket = os.Getenv("S3_BUCKET")
+	cfg.Bucket = l.get("S3_BUCKET")
 	if cfg.Bucket == "" {
-		return nil, fmt.Errorf("S3_BUCKET is required")
+		l.errorf("S3_BUCKET is required")
 	}
 
-	cfg.AccessKey = os.Getenv("S3_ACCESS_KEY")
+	cfg.AccessKey = l.get("S3_ACCESS_KEY")
 	if cfg.AccessKey == "" {
-		return nil, fmt.Errorf("S3_ACCESS_KEY is required")
+		l.errorf("S3_ACCESS_KEY is required")
 	}
 
-	cfg.SecretKey = os.Getenv("S3_SECRET_KEY")
+	cfg.SecretKey = l.get("S3_SECRET_KEY")
 	if cfg.SecretKey == "" {
-		return nil, fmt.Errorf("S3_SECRET_KEY is required")
+		l.errorf("S3_SECRET_KEY is required")
+	}
+
+	// Other credentials of the file, each with a distinct access key
+	accessKeys := map[string]bool{cfg.AccessKey: true}
+	for i, cred := range l.fileCredentials() {
+		if cred.AccessKey == "" || cred.SecretKey == "" {
+			l.errorf("invalid credentials[%d]: access_key and secret_key are required", i)
+			continue
+		}
+		if accessKeys[cred.AccessKey] {
+			l.errorf("invalid credentials[%d].access_key: %q is already used", i, cred.AccessKey)
+			continue
+		}
+		accessKeys[cred.AccessKey] = true
+		cfg.Credentials = append(cfg.Credentials, cred)
 	}
 
 	// Optional fields
-	if port := os.Getenv("S3_PORT"); port != "" {
+	if port := l.get("S3_PORT"); port != "" {
 		p, err := strconv.Atoi(port)
-		if err != nil {
-			return nil, fmt.Errorf("invalid S3_PORT: %w", err)
+		if err != nil || p < 0 || p > 65535 {
+			l.errorf("invalid S3_PORT: must be a port number")
 		}
 		cfg.Port = p
 	}
 
-	if storagePath := os.Getenv("S3_STORAGE_PATH"); storagePath != "" {
+	if storagePath := l.get("S3_STORAGE_PATH"); storagePath != "" {
 		cfg.StoragePath = storagePath
 	}
 
-	if region := os.Getenv("S3_REGION"); region != "" {
+	if backend := l.get("S3_STORAGE_BACKEND"); backend != "" {
+		backend = strings.ToLower(strings.TrimSpace(backend))
+		if backend != StorageFilesystem && backend != StorageMemory {
+			l.errorf("invalid S3_STORAGE_BACKEND: must be %q or %q", StorageFilesystem, StorageMemory)
+		}
+		cfg.StorageBackend = backend
+	}
+
+	if region := l.get("S3_REGION"); region != "" {
 		cfg.Region = region
 	}
 
-	if corsOrigins := os.Getenv("S3_CORS_ORIGINS"); corsOrigins != "" {
-		cfg.CORSOrigins = strings.Split(corsOrigins, ",")
-		for i, origin := range cfg.CORSOrigins {
-			cfg.CORSOrigins[i] = strings.TrimSpace(origin)
-		}
+	if corsOrigins := l.get("S3_CORS_ORIGINS"); corsOrigins != "" {
+		cfg.CORSOrigins = splitList(corsOrigins)
 	}
 
-	if maxSize := os.Getenv("S3_MAX_FILE_SIZE"); maxSize != "" {
-		size, err := parseSize(maxSize)
+	if maxSize := l.get("S3_MAX_FILE_SIZE"); maxSize != "" {
+		size, err := ParseSize(maxSize)
 		if err != nil {
-			return nil, fmt.Errorf("invalid S3_MAX_FILE_SIZE: %w", err)
+			l.errorf("invalid S3_MAX_FILE_SIZE: %w", err)
 		}
 		cfg.MaxFileSize = size
 	}
 
+	if encryptionKey := l.get("S3_ENCRYPTION_KEY"); encryptionKey != "" {
+		key, err := ParseEncryptionKey(encryptionKey)
+		if err != nil {
+			l.errorf("invalid S3_ENCRYPTION_KEY: %w", err)
+		}
+		cfg.EncryptionKey = key
+	}
+
+	// Transparent compression
+	if compression := l.get("S3_COMPRESSION"); compression != "" {
+		compression = strings.ToLower(strings.TrimSpace(compression))
+		if compression != CompressionGzip {
+			l.errorf("invalid S3_COMPRESSION: must be %q", CompressionGzip)
+		}
+		cfg.Compression = compression
+	}
+	if types := l.get("S3_COMPRESSION_TYPES"); types != "" {
+		cfg.CompressTypes = splitList(types)
+		for _, contentType := range cfg.CompressTypes {
+			if !strings.Contains(contentType, "/") {
+				l.errorf("invalid S3_COMPRESSION_TYPES: %q is not a content type", contentType)
+			}
+		}
+	}
+	if prefixes := l.get("S3_COMPRESSION_PREFIXES"); prefixes != "" {
+		cfg.CompressPrefixes = splitList(prefixes)
+	}
+
+	// Deduplication
+	if dedup := l.get("S3_DEDUP"); dedup != "" {
+		b, err := strconv.ParseBool(dedup)
+		if err != nil {
+			l.errorf("invalid S3_DEDUP: %w", err)
+		}
+		cfg.Dedup = b
+	}
+	l.duration("S3_DEDUP_GC_INTERVAL", &cfg.DedupGCInterval)
+
+	l.loadQuotas(cfg)
+
+	// Metadata index
+	if index := l.get("S3_METADATA_INDEX"); index != "" {
+		b, err := strconv.ParseBool(index)
+		if err != nil {
+			l.errorf("invalid S3_METADATA_INDEX: %w", err)
+		}
+		cfg.MetadataIndex = b
+	}
+
+	l.loadScrub(cfg)
+
+	// Out-of-band changes
+	if watch := l.get("S3_WATCH"); watch != "" {
+		b, err := strconv.ParseBool(watch)
+		if err != nil {
+			l.errorf("invalid S3_WATCH: %w", err)
+		}
+		cfg.Watch = b
+	}
+
+	l.loadUpstream(cfg)
+
 	// Public prefix configuration
-	if publicPrefix, exists := os.LookupEnv("S3_PUBLIC_PREFIX"); exists {
+	if publicPrefix, exists := l.lookup("S3_PUBLIC_PREFIX"); exists {
 		if publicPrefix == "" {
 			cfg.PublicPrefix = ""
 		} else {
@@ -94,17 +328,432 @@ func Load() (*Config, error) {
 	}
 
 	// Public cache max age configuration
-	if maxAge := os.Getenv("S3_PUBLIC_CACHE_MAX_AGE"); maxAge != "" {
-		if age, err := strconv.Atoi(maxAge); err == nil {
+	if maxAge := l.get("S3_PUBLIC_CACHE_MAX_AGE"); maxAge != "" {
+		age, err := strconv.Atoi(maxAge)
+		if err != nil || age < 0 {
+			l.errorf("invalid S3_PUBLIC_CACHE_MAX_AGE: must be a non-negative number of seconds")
+		} else {
 			cfg.PublicCacheMaxAge = age
 		}
 	}
 
+	// HTTP server timeouts
+	l.duration("S3_READ_HEADER_TIMEOUT", &cfg.ReadHeaderTimeout)
+	l.duration("S3_READ_TIMEOUT", &cfg.ReadTimeout)
+	l.duration("S3_WRITE_TIMEOUT", &cfg.WriteTimeout)
+	l.duration("S3_IDLE_TIMEOUT", &cfg.IdleTimeout)
+	l.duration("S3_SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
+
+	// Lifecycle configuration
+	l.duration("S3_LIFECYCLE_INTERVAL", &cfg.LifecycleInterval)
+
+	if dryRun := l.get("S3_LIFECYCLE_DRY_RUN"); dryRun != "" {
+		b, err := strconv.ParseBool(dryRun)
+		if err != nil {
+			l.errorf("invalid S3_LIFECYCLE_DRY_RUN: %w", err)
+		}
+		cfg.LifecycleDryRun = b
+	}
+
+	// Webhook event notifications: those of the file, then the one set by environment variables
+	ids := make(map[string]bool)
+	for i, webhook := range l.fileWebhooks() {
+		l.checkWebhook(fmt.Sprintf("webhooks[%d].url", i), fmt.Sprintf("webhooks[%d].events", i), webhook)
+		if webhook.ID != "" && ids[webhook.ID] {
+			l.errorf("invalid webhooks[%d].id: %q is already used by another webhook", i, webhook.ID)
+		}
+		ids[webhook.ID] = true
+		cfg.Webhooks = append(cfg.Webhooks, webhook)
+	}
+	if webhookURL := os.Getenv("S3_WEBHOOK_URL"); webhookURL != "" {
+		webhook := Webhook{
+			URL:    webhookURL,
+			Secret: os.Getenv("S3_WEBHOOK_SECRET"),
+			Events: []string{"s3:ObjectCreated:*", "s3:ObjectRemoved:*"},
+			Prefix: os.Getenv("S3_WEBHOOK_PREFIX"),
+			Suffix: os.Getenv("S3_WEBHOOK_SUFFIX"),
+		}
+		if events := os.Getenv("S3_WEBHOOK_EVENTS"); events != "" {
+			webhook.Events = splitList(events)
+		}
+		l.checkWebhook("S3_WEBHOOK_URL", "S3_WEBHOOK_EVENTS", webhook)
+		cfg.Webhooks = append(cfg.Webhooks, webhook)
+	}
+
+	// Replication: the rules of the file, then the one set by environment variables
+	for i, rule := range l.fileReplication() {
+		l.checkReplication(fmt.Sprintf("replication[%d].", i), cfg, &rule)
+		cfg.Replication = append(cfg.Replication, rule)
+	}
+	if endpoint := os.Getenv("S3_REPLICATION_ENDPOINT"); endpoint != "" {
+		rule := ReplicationRule{
+			Prefix:    os.Getenv("S3_REPLICATION_PREFIX"),
+			Endpoint:  endpoint,
+			Bucket:    os.Getenv("S3_REPLICATION_BUCKET"),
+			Region:    os.Getenv("S3_REPLICATION_REGION"),
+			AccessKey: os.Getenv("S3_REPLICATION_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_REPLICATION_SECRET_KEY"),
+		}
+		l.checkReplication("S3_REPLICATION_", cfg, &rule)
+		cfg.Replication = append(cfg.Replication, rule)
+	}
+
+	cfg.MetricsToken = l.get("S3_METRICS_TOKEN")
+
+	l.loadLogging(cfg)
+	l.loadTLS(cfg)
+
+	if len(l.errs) > 0 {
+		return nil, errors.Join(l.errs...)
+	}
 	return cfg, nil
 }
 
-// parseSize parses a size string like "100MB" into bytes
-func parseSize(s string) (int64, error) {
+// ParseEncryptionKey decodes a base64-encoded 32-byte encryption key, as generated by
+// "openssl rand -base64 32"
+func ParseEncryptionKey(value string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("must be 32 bytes encoded in base64")
+	}
+	return key, nil
+}
+
+// loader reads settings from the environment, falling back to the config file,
+// and collects every invalid setting instead of stopping at the first one
+type loader struct {
+	file *fileConfig // nil without a config file
+	errs []error
+}
+
+// get returns the value of a setting, empty when it is not set
+func (l *loader) get(name string) string {
+	value, _ := l.lookup(name)
+	return value
+}
+
+// lookup returns the value of a setting and whether it is set. An empty environment
+// variable only counts as set when the file does not set the setting either.
+func (l *loader) lookup(name string) (string, bool) {
+	value, inEnv := os.LookupEnv(name)
+	if value != "" {
+		return value, true
+	}
+	if l.file != nil {
+		if fileValue, inFile := l.file.values[name]; inFile {
+			return fileValue, true
+		}
+	}
+	return value, inEnv
+}
+
+// fileCredentials returns the other credentials of the config file
+func (l *loader) fileCredentials() []Credential {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.credentials
+}
+
+// fileWebhooks returns the webhooks of the config file
+func (l *loader) fileWebhooks() []Webhook {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.webhooks
+}
+
+// fileReplication returns the replication rules of the config file
+func (l *loader) fileReplication() []ReplicationRule {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.replication
+}
+
+// errorf records an invalid setting
+func (l *loader) errorf(format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf(format, args...))
+}
+
+// loadLogging reads the log and access log settings
+func (l *loader) loadLogging(cfg *Config) {
+	if format := l.get("S3_LOG_FORMAT"); format != "" {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format != logging.FormatText && format != logging.FormatJSON {
+			l.errorf("invalid S3_LOG_FORMAT: must be %q or %q", logging.FormatText, logging.FormatJSON)
+		}
+		cfg.LogFormat = format
+	}
+
+	if level := l.get("S3_LOG_LEVEL"); level != "" {
+		if _, err := logging.ParseLevel(level); err != nil {
+			l.errorf("invalid S3_LOG_LEVEL: %w", err)
+		}
+		cfg.LogLevel = level
+	}
+
+	cfg.AccessLogFile = l.get("S3_ACCESS_LOG_FILE")
+
+	if maxSize := l.get("S3_ACCESS_LOG_MAX_SIZE"); maxSize != "" {
+		size, err := ParseSize(maxSize)
+		if err != nil {
+			l.errorf("invalid S3_ACCESS_LOG_MAX_SIZE: %w", err)
+		}
+		cfg.AccessLogMaxSize = size
+	}
+
+	if maxFiles := l.get("S3_ACCESS_LOG_MAX_FILES"); maxFiles != "" {
+		n, err := strconv.Atoi(maxFiles)
+		if err != nil || n < 0 {
+			l.errorf("invalid S3_ACCESS_LOG_MAX_FILES: must be a non-negative number")
+		}
+		cfg.AccessLogMaxFiles = n
+	}
+
+	if prefix := l.get("S3_ACCESS_LOG_PREFIX"); prefix != "" {
+		if !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		cfg.AccessLogPrefix = prefix
+	}
+
+	l.duration("S3_ACCESS_LOG_INTERVAL", &cfg.AccessLogInterval)
+}
+
+// loadQuotas reads the bucket and prefix quotas and the free disk space floor
+func (l *loader) loadQuotas(cfg *Config) {
+	bucket := Quota{}
+	if maxBytes := l.get("S3_QUOTA_BYTES"); maxBytes != "" {
+		size, err := ParseSize(maxBytes)
+		if err != nil || size < 0 {
+			l.errorf("invalid S3_QUOTA_BYTES: must be a size such as 10GB")
+		}
+		bucket.MaxBytes = size
+	}
+	if maxObjects := l.get("S3_QUOTA_OBJECTS"); maxObjects != "" {
+		n, err := strconv.ParseInt(maxObjects, 10, 64)
+		if err != nil || n < 0 {
+			l.errorf("invalid S3_QUOTA_OBJECTS: must be a non-negative number")
+		}
+		bucket.MaxObjects = n
+	}
+	if bucket.MaxBytes > 0 || bucket.MaxObjects > 0 {
+		cfg.Quotas = append(cfg.Quotas, bucket)
+	}
+
+	// Prefix quotas are lists of prefix=limit, merged by prefix
+	prefixes := make(map[string]*Quota)
+	var order []string
+	parse := func(name string, parseLimit func(string) (int64, error), set func(*Quota, int64)) {
+		for _, item := range splitList(l.get(name)) {
+			prefix, limit, ok := strings.Cut(item, "=")
+			if !ok || prefix == "" {
+				l.errorf("invalid %s: %q is not prefix=limit", name, item)
+				continue
+			}
+			n, err := parseLimit(limit)
+			if err != nil || n < 0 {
+				l.errorf("invalid %s: invalid limit for %s", name, prefix)
+				continue
+			}
+			if prefixes[prefix] == nil {
+				prefixes[prefix] = &Quota{Prefix: prefix}
+				order = append(order, prefix)
+			}
+			set(prefixes[prefix], n)
+		}
+	}
+	parse("S3_QUOTA_PREFIX_BYTES", ParseSize, func(q *Quota, n int64) { q.MaxBytes = n })
+	parse("S3_QUOTA_PREFIX_OBJECTS", func(s string) (int64, error) {
+		return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	}, func(q *Quota, n int64) { q.MaxObjects = n })
+	for _, prefix := range order {
+		cfg.Quotas = append(cfg.Quotas, *prefixes[prefix])
+	}
+
+	if minFree := l.get("S3_MIN_FREE_SPACE"); minFree != "" {
+		size, err := ParseSize(minFree)
+		if err != nil || size < 0 {
+			l.errorf("invalid S3_MIN_FREE_SPACE: must be a size such as 5GB")
+		}
+		cfg.MinFreeSpace = size
+	}
+}
+
+// loadScrub reads the settings of the background scrubber
+func (l *loader) loadScrub(cfg *Config) {
+	l.duration("S3_SCRUB_INTERVAL", &cfg.ScrubInterval)
+	if rate := l.get("S3_SCRUB_RATE"); rate != "" {
+		size, err := ParseSize(rate)
+		if err != nil || size < 0 {
+			l.errorf("invalid S3_SCRUB_RATE: must be a size per second such as 32MB")
+		}
+		cfg.ScrubRate = size
+	}
+	if repair := l.get("S3_SCRUB_REPAIR"); repair != "" {
+		b, err := strconv.ParseBool(repair)
+		if err != nil {
+			l.errorf("invalid S3_SCRUB_REPAIR: %w", err)
+		}
+		cfg.ScrubRepair = b
+	}
+	if quarantine := l.get("S3_SCRUB_QUARANTINE"); quarantine != "" {
+		b, err := strconv.ParseBool(quarantine)
+		if err != nil {
+			l.errorf("invalid S3_SCRUB_QUARANTINE: %w", err)
+		}
+		cfg.ScrubQuarantine = b
+	}
+}
+
+// loadUpstream reads the settings of the pull-through cache mode
+func (l *loader) loadUpstream(cfg *Config) {
+	cfg.UpstreamEndpoint = l.get("S3_UPSTREAM_ENDPOINT")
+	if cfg.UpstreamEndpoint == "" {
+		return
+	}
+	if !isHTTPURL(cfg.UpstreamEndpoint) {
+		l.errorf("invalid S3_UPSTREAM_ENDPOINT: must be an http(s) URL")
+	}
+	cfg.UpstreamBucket = l.get("S3_UPSTREAM_BUCKET")
+	if cfg.UpstreamBucket == "" {
+		cfg.UpstreamBucket = cfg.Bucket
+	}
+	cfg.UpstreamRegion = l.get("S3_UPSTREAM_REGION")
+	if cfg.UpstreamRegion == "" {
+		cfg.UpstreamRegion = cfg.Region
+	}
+	cfg.UpstreamAccessKey = l.get("S3_UPSTREAM_ACCESS_KEY")
+	cfg.UpstreamSecretKey = l.get("S3_UPSTREAM_SECRET_KEY")
+	if cfg.UpstreamAccessKey == "" || cfg.UpstreamSecretKey == "" {
+		l.errorf("S3_UPSTREAM_ACCESS_KEY and S3_UPSTREAM_SECRET_KEY are required with S3_UPSTREAM_ENDPOINT")
+	}
+	if writes := l.get("S3_UPSTREAM_WRITES"); writes != "" {
+		b, err := strconv.ParseBool(writes)
+		if err != nil {
+			l.errorf("invalid S3_UPSTREAM_WRITES: %w", err)
+		}
+		cfg.UpstreamWrites = b
+	}
+	if maxSize := l.get("S3_CACHE_MAX_SIZE"); maxSize != "" {
+		size, err := ParseSize(maxSize)
+		if err != nil || size < 0 {
+			l.errorf("invalid S3_CACHE_MAX_SIZE: must be a size such as 10GB")
+		}
+		cfg.CacheMaxSize = size
+	}
+}
+
+// loadTLS reads the TLS and ACME settings
+func (l *loader) loadTLS(cfg *Config) {
+	cfg.TLSCert = l.get("S3_TLS_CERT")
+	cfg.TLSKey = l.get("S3_TLS_KEY")
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		l.errorf("S3_TLS_CERT and S3_TLS_KEY must be set together")
+	}
+	cfg.TLSClientCA = l.get("S3_TLS_CLIENT_CA")
+
+	if domains := l.get("S3_ACME_DOMAINS"); domains != "" {
+		cfg.ACMEDomains = splitList(domains)
+	}
+	cfg.ACMEEmail = l.get("S3_ACME_EMAIL")
+	if directory := l.get("S3_ACME_DIRECTORY"); directory != "" {
+		if !isHTTPURL(directory) {
+			l.errorf("invalid S3_ACME_DIRECTORY: must be an http(s) URL")
+		}
+		cfg.ACMEDirectory = directory
+	}
+	cfg.ACMECacheDir = l.get("S3_ACME_CACHE_DIR")
+	if cfg.ACMECacheDir == "" {
+		cfg.ACMECacheDir = filepath.Join(cfg.StoragePath, ".selfhost_s3", ".acme")
+	}
+	cfg.ACMEHTTPAddr = l.get("S3_ACME_HTTP_ADDR")
+	cfg.ACMECARoot = l.get("S3_ACME_CA_ROOT")
+
+	if cfg.TLSCert != "" && len(cfg.ACMEDomains) > 0 {
+		l.errorf("S3_TLS_CERT and S3_ACME_DOMAINS cannot be used together")
+	}
+	if cfg.TLSClientCA != "" && !cfg.TLSEnabled() {
+		l.errorf("S3_TLS_CLIENT_CA requires S3_TLS_CERT or S3_ACME_DOMAINS")
+	}
+}
+
+// checkReplication validates a replication rule and fills in its defaults, naming its
+// settings with prefix in errors: "S3_REPLICATION_" or "replication[0]." for instance
+func (l *loader) checkReplication(prefix string, cfg *Config, rule *ReplicationRule) {
+	name := func(setting string) string {
+		if strings.HasPrefix(prefix, "S3_") {
+			return prefix + strings.ToUpper(setting)
+		}
+		return prefix + setting
+	}
+
+	if !isHTTPURL(rule.Endpoint) {
+		l.errorf("invalid %s: must be an http(s) URL", name("endpoint"))
+	}
+	if rule.AccessKey == "" || rule.SecretKey == "" {
+		l.errorf("%s and %s are required", name("access_key"), name("secret_key"))
+	}
+	if rule.Bucket == "" {
+		rule.Bucket = cfg.Bucket
+	}
+	if rule.Region == "" {
+		rule.Region = cfg.Region
+	}
+}
+
+// checkWebhook validates a webhook, naming its URL and events settings in errors
+func (l *loader) checkWebhook(urlName, eventsName string, webhook Webhook) {
+	if !isHTTPURL(webhook.URL) {
+		l.errorf("invalid %s: must be an http(s) URL", urlName)
+	}
+	for _, event := range webhook.Events {
+		if !strings.HasPrefix(event, "s3:") {
+			l.errorf("invalid %s: %q is not an S3 event name", eventsName, event)
+		}
+	}
+}
+
+// duration reads a non-negative duration such as "30s".
+// dst is left unchanged when the setting is not set.
+func (l *loader) duration(name string, dst *time.Duration) {
+	value := l.get(name)
+	if value == "" {
+		return
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		l.errorf("invalid %s: %w", name, err)
+		return
+	}
+	if d < 0 {
+		l.errorf("invalid %s: must not be negative", name)
+		return
+	}
+	*dst = d
+}
+
+// splitList splits a comma-separated list, trimming spaces and dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// isHTTPURL reports whether s is an absolute http or https URL
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// ParseSize parses a size string like "100MB" into bytes
+func ParseSize(s string) (int64, error) {
 	s = strings.TrimSpace(strings.ToUpper(s))
 
 	multiplier := int64(1)
@@ -117,6 +766,9 @@ func parseSize(s string) (int64, error) {
 	} else if strings.HasSuffix(s, "GB") {
 		multiplier = 1024 * 1024 * 1024
 		s = strings.TrimSuffix(s, "GB")
+	} else if strings.HasSuffix(s, "TB") {
+		multiplier = 1024 * 1024 * 1024 * 1024
+		s = strings.TrimSuffix(s, "TB")
 	} else if strings.HasSuffix(s, "B") {
 		s = strings.TrimSuffix(s, "B")
 	}
@@ -1,8 +1,13 @@
 package config
 
 import (
+	"bytes"
+	"encoding/base64"
 	"os"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad_RequiredFields(t *testing.T) {
@@ -19,7 +24,7 @@ func TestLoad_RequiredFields(t *testing.T) {
 			name:        "missing all required fields",
 			envVars:     map[string]string{},
 			expectError: true,
-			errorMsg:    "S3_BUCKET is required",
+			errorMsg:    "S3_BUCKET is required\nS3_ACCESS_KEY is required\nS3_SECRET_KEY is required",
 		},
 		{
 			name: "missing access key",
@@ -27,7 +32,7 @@ func TestLoad_RequiredFields(t *testing.T) {
 				"S3_BUCKET": "test-bucket",
 			},
 			expectError: true,
-			errorMsg:    "S3_ACCESS_KEY is required",
+			errorMsg:    "S3_ACCESS_KEY is required\nS3_SECRET_KEY is required",
 		},
 		{
 			name: "missing secret key",
@@ -118,6 +123,7 @@ func TestLoad_OptionalFields(t *testing.T) {
 	_ = os.Setenv("S3_REGION", "eu-west-1")
 	_ = os.Setenv("S3_CORS_ORIGINS", "https://example.com, https://app.example.com")
 	_ = os.Setenv("S3_MAX_FILE_SIZE", "50MB")
+	_ = os.Setenv("S3_METRICS_TOKEN", "scrape-token")
 
 	cfg, err := Load()
 	if err != nil {
@@ -154,6 +160,9 @@ func TestLoad_OptionalFields(t *testing.T) {
 	if cfg.MaxFileSize != 50*1024*1024 {
 		t.Errorf("expected max file size 50MB (52428800), got %d", cfg.MaxFileSize)
 	}
+	if cfg.MetricsToken != "scrape-token" {
+		t.Errorf("expected metrics token 'scrape-token', got %q", cfg.MetricsToken)
+	}
 }
 
 func TestLoad_InvalidPort(t *testing.T) {
@@ -197,6 +206,7 @@ func TestParseSize(t *testing.T) {
 		{"10mb", 10 * 1024 * 1024, false},
 		{"2GB", 2 * 1024 * 1024 * 1024, false},
 		{"2gb", 2 * 1024 * 1024 * 1024, false},
+		{"3TB", 3 * 1024 * 1024 * 1024 * 1024, false},
 		{"  50MB  ", 50 * 1024 * 1024, false},
 		{"invalid", 0, true},
 		{"MB", 0, true},
@@ -205,7 +215,7 @@ func TestParseSize(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			result, err := parseSize(tt.input)
+			result, err := ParseSize(tt.input)
 
 			if tt.hasError {
 				if err == nil {
@@ -220,7 +230,7 @@ func TestParseSize(t *testing.T) {
 			}
 
 			if result != tt.expected {
-				t.Errorf("parseSize(%q) = %d, expected %d", tt.input, result, tt.expected)
+				t.Errorf("ParseSize(%q) = %d, expected %d", tt.input, result, tt.expected)
 			}
 		})
 	}
@@ -287,6 +297,7 @@ func TestLoad_PublicCacheMaxAge(t *testing.T) {
 		envValue    string
 		setEnv      bool
 		expectedAge int
+		expectError bool
 	}{
 		{
 			name:        "default value when not set",
@@ -306,10 +317,16 @@ func TestLoad_PublicCacheMaxAge(t *testing.T) {
 			expectedAge: 0,
 		},
 		{
-			name:        "invalid value uses default",
+			name:        "invalid value",
 			envValue:    "invalid",
 			setEnv:      true,
-			expectedAge: 31536000, // keeps default on invalid
+			expectError: true,
+		},
+		{
+			name:        "negative value",
+			envValue:    "-1",
+			setEnv:      true,
+			expectError: true,
 		},
 	}
 
@@ -325,6 +342,12 @@ func TestLoad_PublicCacheMaxAge(t *testing.T) {
 			}
 
 			cfg, err := Load()
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error for S3_PUBLIC_CACHE_MAX_AGE=%s", tt.envValue)
+				}
+				return
+			}
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -336,6 +359,679 @@ func TestLoad_PublicCacheMaxAge(t *testing.T) {
 	}
 }
 
+func TestLoad_Lifecycle(t *testing.T) {
+	tests := []struct {
+		name             string
+		interval         string
+		dryRun           string
+		expectError      bool
+		expectedInterval time.Duration
+		expectedDryRun   bool
+	}{
+		{
+			name:             "defaults",
+			expectedInterval: time.Hour,
+		},
+		{
+			name:             "custom interval and dry run",
+			interval:         "15m",
+			dryRun:           "true",
+			expectedInterval: 15 * time.Minute,
+			expectedDryRun:   true,
+		},
+		{
+			name:             "zero disables the scheduler",
+			interval:         "0",
+			expectedInterval: 0,
+		},
+		{
+			name:        "invalid interval",
+			interval:    "often",
+			expectError: true,
+		},
+		{
+			name:        "negative interval",
+			interval:    "-1h",
+			expectError: true,
+		},
+		{
+			name:        "invalid dry run",
+			dryRun:      "maybe",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnvVars()
+			_ = os.Setenv("S3_BUCKET", "test-bucket")
+			_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+			_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+			if tt.interval != "" {
+				_ = os.Setenv("S3_LIFECYCLE_INTERVAL", tt.interval)
+			}
+			if tt.dryRun != "" {
+				_ = os.Setenv("S3_LIFECYCLE_DRY_RUN", tt.dryRun)
+			}
+
+			cfg, err := Load()
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if cfg.LifecycleInterval != tt.expectedInterval {
+				t.Errorf("expected LifecycleInterval %v, got %v", tt.expectedInterval, cfg.LifecycleInterval)
+			}
+			if cfg.LifecycleDryRun != tt.expectedDryRun {
+				t.Errorf("expected LifecycleDryRun %v, got %v", tt.expectedDryRun, cfg.LifecycleDryRun)
+			}
+		})
+	}
+}
+
+func TestLoad_Webhook(t *testing.T) {
+	clearEnvVars()
+	_ = os.Setenv("S3_BUCKET", "test-bucket")
+	_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+	_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Webhooks) != 0 {
+		t.Errorf("expected no webhooks by default, got %v", cfg.Webhooks)
+	}
+
+	_ = os.Setenv("S3_WEBHOOK_URL", "https://app.example.com/hooks/s3")
+	_ = os.Setenv("S3_WEBHOOK_SECRET", "s3cret")
+	_ = os.Setenv("S3_WEBHOOK_EVENTS", "s3:ObjectCreated:Put, s3:ObjectRemoved:*")
+	_ = os.Setenv("S3_WEBHOOK_PREFIX", "uploads/")
+	_ = os.Setenv("S3_WEBHOOK_SUFFIX", ".png")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Webhooks) != 1 {
+		t.Fatalf("expected 1 webhook, got %d", len(cfg.Webhooks))
+	}
+	webhook := cfg.Webhooks[0]
+	if webhook.URL != "https://app.example.com/hooks/s3" || webhook.Secret != "s3cret" {
+		t.Errorf("unexpected webhook: %+v", webhook)
+	}
+	if len(webhook.Events) != 2 || webhook.Events[1] != "s3:ObjectRemoved:*" {
+		t.Errorf("unexpected webhook events: %v", webhook.Events)
+	}
+	if webhook.Prefix != "uploads/" || webhook.Suffix != ".png" {
+		t.Errorf("unexpected webhook filters: %+v", webhook)
+	}
+
+	_ = os.Setenv("S3_WEBHOOK_URL", "ftp://example.com")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for non-http webhook URL")
+	}
+
+	_ = os.Setenv("S3_WEBHOOK_URL", "https://app.example.com/hooks/s3")
+	_ = os.Setenv("S3_WEBHOOK_EVENTS", "ObjectCreated")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for invalid webhook event")
+	}
+}
+
+func TestLoad_StorageBackend(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+		wantErr  bool
+	}{
+		{"default", "", StorageFilesystem, false},
+		{"filesystem", "filesystem", StorageFilesystem, false},
+		{"memory", "Memory", StorageMemory, false},
+		{"invalid", "s3", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnvVars()
+			_ = os.Setenv("S3_BUCKET", "test-bucket")
+			_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+			_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+			if tt.value != "" {
+				_ = os.Setenv("S3_STORAGE_BACKEND", tt.value)
+			}
+
+			cfg, err := Load()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.StorageBackend != tt.expected {
+				t.Errorf("expected StorageBackend %q, got %q", tt.expected, cfg.StorageBackend)
+			}
+		})
+	}
+}
+
+func TestLoad_Compression(t *testing.T) {
+	setRequired := func() {
+		clearEnvVars()
+		_ = os.Setenv("S3_BUCKET", "test-bucket")
+		_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+		_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+	}
+
+	setRequired()
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Compression != "" || !slices.Equal(cfg.CompressTypes, DefaultCompressTypes) || cfg.CompressPrefixes != nil {
+		t.Errorf("unexpected defaults: %q %v %v", cfg.Compression, cfg.CompressTypes, cfg.CompressPrefixes)
+	}
+
+	setRequired()
+	_ = os.Setenv("S3_COMPRESSION", "GZIP")
+	_ = os.Setenv("S3_COMPRESSION_TYPES", "application/json, text/csv")
+	_ = os.Setenv("S3_COMPRESSION_PREFIXES", "exports/,logs/")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Compression != CompressionGzip {
+		t.Errorf("expected gzip, got %q", cfg.Compression)
+	}
+	if !slices.Equal(cfg.CompressTypes, []string{"application/json", "text/csv"}) {
+		t.Errorf("unexpected types: %v", cfg.CompressTypes)
+	}
+	if !slices.Equal(cfg.CompressPrefixes, []string{"exports/", "logs/"}) {
+		t.Errorf("unexpected prefixes: %v", cfg.CompressPrefixes)
+	}
+
+	setRequired()
+	_ = os.Setenv("S3_COMPRESSION", "brotli")
+	_ = os.Setenv("S3_COMPRESSION_TYPES", "json")
+	_, err = Load()
+	if err == nil || !strings.Contains(err.Error(), "invalid S3_COMPRESSION:") || !strings.Contains(err.Error(), "invalid S3_COMPRESSION_TYPES") {
+		t.Errorf("expected compression errors, got %v", err)
+	}
+}
+
+func TestLoad_Dedup(t *testing.T) {
+	clearEnvVars()
+	_ = os.Setenv("S3_BUCKET", "test-bucket")
+	_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+	_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Dedup || cfg.DedupGCInterval != time.Hour {
+		t.Errorf("unexpected defaults: %v %s", cfg.Dedup, cfg.DedupGCInterval)
+	}
+
+	_ = os.Setenv("S3_DEDUP", "true")
+	_ = os.Setenv("S3_DEDUP_GC_INTERVAL", "15m")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Dedup || cfg.DedupGCInterval != 15*time.Minute {
+		t.Errorf("unexpected settings: %v %s", cfg.Dedup, cfg.DedupGCInterval)
+	}
+
+	_ = os.Setenv("S3_DEDUP", "sometimes")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid S3_DEDUP") {
+		t.Errorf("expected S3_DEDUP error, got %v", err)
+	}
+}
+
+func TestLoad_MetadataIndex(t *testing.T) {
+	clearEnvVars()
+	_ = os.Setenv("S3_BUCKET", "test-bucket")
+	_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+	_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.MetadataIndex {
+		t.Error("expected the metadata index to be disabled by default")
+	}
+
+	_ = os.Setenv("S3_METADATA_INDEX", "true")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.MetadataIndex {
+		t.Error("expected the metadata index to be enabled")
+	}
+
+	_ = os.Setenv("S3_METADATA_INDEX", "maybe")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid S3_METADATA_INDEX") {
+		t.Errorf("expected S3_METADATA_INDEX error, got %v", err)
+	}
+}
+
+func TestLoad_Scrub(t *testing.T) {
+	clearEnvVars()
+	_ = os.Setenv("S3_BUCKET", "test-bucket")
+	_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+	_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ScrubInterval != 0 || cfg.ScrubRate != 32<<20 || cfg.ScrubRepair || cfg.ScrubQuarantine {
+		t.Errorf("unexpected defaults: %s %d %v %v", cfg.ScrubInterval, cfg.ScrubRate, cfg.ScrubRepair, cfg.ScrubQuarantine)
+	}
+
+	_ = os.Setenv("S3_SCRUB_INTERVAL", "24h")
+	_ = os.Setenv("S3_SCRUB_RATE", "0")
+	_ = os.Setenv("S3_SCRUB_REPAIR", "true")
+	_ = os.Setenv("S3_SCRUB_QUARANTINE", "1")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ScrubInterval != 24*time.Hour || cfg.ScrubRate != 0 || !cfg.ScrubRepair || !cfg.ScrubQuarantine {
+		t.Errorf("unexpected settings: %s %d %v %v", cfg.ScrubInterval, cfg.ScrubRate, cfg.ScrubRepair, cfg.ScrubQuarantine)
+	}
+
+	_ = os.Setenv("S3_SCRUB_RATE", "fast")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid S3_SCRUB_RATE") {
+		t.Errorf("expected S3_SCRUB_RATE error, got %v", err)
+	}
+}
+
+func TestLoad_Watch(t *testing.T) {
+	clearEnvVars()
+	_ = os.Setenv("S3_BUCKET", "test-bucket")
+	_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+	_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Watch {
+		t.Error("expected the watcher to be disabled by default")
+	}
+
+	_ = os.Setenv("S3_WATCH", "true")
+	if cfg, err = Load(); err != nil || !cfg.Watch {
+		t.Errorf("expected the watcher to be enabled, got %v, %v", cfg, err)
+	}
+
+	_ = os.Setenv("S3_WATCH", "sometimes")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid S3_WATCH") {
+		t.Errorf("expected S3_WATCH error, got %v", err)
+	}
+}
+
+func TestLoad_Upstream(t *testing.T) {
+	clearEnvVars()
+	_ = os.Setenv("S3_BUCKET", "test-bucket")
+	_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+	_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+	_ = os.Setenv("S3_REGION", "eu-west-1")
+	_ = os.Setenv("S3_UPSTREAM_ENDPOINT", "https://s3.example.com")
+	_ = os.Setenv("S3_UPSTREAM_ACCESS_KEY", "upstream-key")
+	_ = os.Setenv("S3_UPSTREAM_SECRET_KEY", "upstream-secret")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.UpstreamBucket != "test-bucket" || cfg.UpstreamRegion != "eu-west-1" || cfg.UpstreamWrites || cfg.CacheMaxSize != 0 {
+		t.Errorf("unexpected upstream defaults: %+v", cfg)
+	}
+
+	_ = os.Setenv("S3_UPSTREAM_BUCKET", "prod-bucket")
+	_ = os.Setenv("S3_UPSTREAM_REGION", "us-east-2")
+	_ = os.Setenv("S3_UPSTREAM_WRITES", "true")
+	_ = os.Setenv("S3_CACHE_MAX_SIZE", "2GB")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.UpstreamBucket != "prod-bucket" || cfg.UpstreamRegion != "us-east-2" || !cfg.UpstreamWrites || cfg.CacheMaxSize != 2*1024*1024*1024 {
+		t.Errorf("unexpected upstream settings: %+v", cfg)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		err   string
+	}{
+		{"S3_UPSTREAM_ENDPOINT", "s3.example.com", "invalid S3_UPSTREAM_ENDPOINT"},
+		{"S3_UPSTREAM_SECRET_KEY", "", "S3_UPSTREAM_ACCESS_KEY and S3_UPSTREAM_SECRET_KEY are required"},
+		{"S3_UPSTREAM_WRITES", "sometimes", "invalid S3_UPSTREAM_WRITES"},
+		{"S3_CACHE_MAX_SIZE", "lots", "invalid S3_CACHE_MAX_SIZE"},
+	}
+	for _, tt := range tests {
+		previous := os.Getenv(tt.name)
+		_ = os.Setenv(tt.name, tt.value)
+		if _, err := Load(); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s=%q: expected %q error, got %v", tt.name, tt.value, tt.err, err)
+		}
+		_ = os.Setenv(tt.name, previous)
+	}
+}
+
+func TestLoad_Replication(t *testing.T) {
+	clearEnvVars()
+	_ = os.Setenv("S3_BUCKET", "test-bucket")
+	_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+	_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Replication != nil {
+		t.Errorf("expected no replication by default, got %+v", cfg.Replication)
+	}
+
+	_ = os.Setenv("S3_REPLICATION_ENDPOINT", "https://backup.example.com")
+	_ = os.Setenv("S3_REPLICATION_ACCESS_KEY", "backup-key")
+	_ = os.Setenv("S3_REPLICATION_SECRET_KEY", "backup-secret")
+	_ = os.Setenv("S3_REPLICATION_PREFIX", "uploads/")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := ReplicationRule{
+		Prefix:    "uploads/",
+		Endpoint:  "https://backup.example.com",
+		Bucket:    "test-bucket",
+		Region:    "us-east-1",
+		AccessKey: "backup-key",
+		SecretKey: "backup-secret",
+	}
+	if len(cfg.Replication) != 1 || cfg.Replication[0] != expected {
+		t.Errorf("unexpected replication rules: %+v", cfg.Replication)
+	}
+
+	_ = os.Setenv("S3_REPLICATION_ENDPOINT", "backup.example.com")
+	_ = os.Setenv("S3_REPLICATION_SECRET_KEY", "")
+	_, err = Load()
+	for _, expected := range []string{"invalid S3_REPLICATION_ENDPOINT", "S3_REPLICATION_ACCESS_KEY and S3_REPLICATION_SECRET_KEY are required"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q error, got %v", expected, err)
+		}
+	}
+}
+
+func TestLoad_Quotas(t *testing.T) {
+	clearEnvVars()
+	_ = os.Setenv("S3_BUCKET", "test-bucket")
+	_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+	_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Quotas != nil || cfg.MinFreeSpace != 0 {
+		t.Errorf("unexpected defaults: %+v %d", cfg.Quotas, cfg.MinFreeSpace)
+	}
+
+	_ = os.Setenv("S3_QUOTA_BYTES", "10GB")
+	_ = os.Setenv("S3_QUOTA_PREFIX_BYTES", "uploads/=1GB, logs/=500MB")
+	_ = os.Setenv("S3_QUOTA_PREFIX_OBJECTS", "logs/=1000,tmp/=10")
+	_ = os.Setenv("S3_MIN_FREE_SPACE", "5GB")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Quota{
+		{Prefix: "", MaxBytes: 10 << 30},
+		{Prefix: "uploads/", MaxBytes: 1 << 30},
+		{Prefix: "logs/", MaxBytes: 500 << 20, MaxObjects: 1000},
+		{Prefix: "tmp/", MaxObjects: 10},
+	}
+	if !slices.Equal(cfg.Quotas, expected) {
+		t.Errorf("Quotas = %+v, expected %+v", cfg.Quotas, expected)
+	}
+	if cfg.MinFreeSpace != 5<<30 {
+		t.Errorf("MinFreeSpace = %d", cfg.MinFreeSpace)
+	}
+
+	_ = os.Setenv("S3_QUOTA_OBJECTS", "-1")
+	_ = os.Setenv("S3_QUOTA_PREFIX_BYTES", "uploads/")
+	_, err = Load()
+	if err == nil || !strings.Contains(err.Error(), "invalid S3_QUOTA_OBJECTS") || !strings.Contains(err.Error(), "invalid S3_QUOTA_PREFIX_BYTES") {
+		t.Errorf("expected quota errors, got %v", err)
+	}
+}
+
+func TestLoad_EncryptionKey(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	tests := []struct {
+		name     string
+		value    string
+		expected []byte
+		wantErr  bool
+	}{
+		{"unset", "", nil, false},
+		{"valid", base64.StdEncoding.EncodeToString(key), key, false},
+		{"too short", base64.StdEncoding.EncodeToString(key[:16]), nil, true},
+		{"not base64", "not a key!", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnvVars()
+			_ = os.Setenv("S3_BUCKET", "test-bucket")
+			_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+			_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+			if tt.value != "" {
+				_ = os.Setenv("S3_ENCRYPTION_KEY", tt.value)
+			}
+
+			cfg, err := Load()
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "invalid S3_ENCRYPTION_KEY") {
+					t.Errorf("expected S3_ENCRYPTION_KEY error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(cfg.EncryptionKey, tt.expected) {
+				t.Errorf("expected EncryptionKey %v, got %v", tt.expected, cfg.EncryptionKey)
+			}
+		})
+	}
+}
+
+func TestLoad_Timeouts(t *testing.T) {
+	clearEnvVars()
+	_ = os.Setenv("S3_BUCKET", "test-bucket")
+	_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+	_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ReadHeaderTimeout != 10*time.Second || cfg.ReadTimeout != 0 || cfg.WriteTimeout != 0 ||
+		cfg.IdleTimeout != 2*time.Minute || cfg.ShutdownTimeout != 30*time.Second {
+		t.Errorf("unexpected default timeouts: %+v", cfg)
+	}
+
+	_ = os.Setenv("S3_READ_HEADER_TIMEOUT", "5s")
+	_ = os.Setenv("S3_READ_TIMEOUT", "10m")
+	_ = os.Setenv("S3_WRITE_TIMEOUT", "15m")
+	_ = os.Setenv("S3_IDLE_TIMEOUT", "0")
+	_ = os.Setenv("S3_SHUTDOWN_TIMEOUT", "1m")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ReadHeaderTimeout != 5*time.Second || cfg.ReadTimeout != 10*time.Minute || cfg.WriteTimeout != 15*time.Minute ||
+		cfg.IdleTimeout != 0 || cfg.ShutdownTimeout != time.Minute {
+		t.Errorf("unexpected timeouts: %+v", cfg)
+	}
+
+	for _, value := range []string{"soon", "-1s"} {
+		_ = os.Setenv("S3_SHUTDOWN_TIMEOUT", value)
+		if _, err := Load(); err == nil {
+			t.Errorf("expected error for S3_SHUTDOWN_TIMEOUT=%q", value)
+		}
+	}
+}
+
+func TestLoad_TLS(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	_ = os.Setenv("S3_BUCKET", "test-bucket")
+	_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+	_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+	_ = os.Setenv("S3_STORAGE_PATH", "/data")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.TLSEnabled() {
+		t.Error("expected TLS to be disabled by default")
+	}
+	if cfg.ACMEDirectory != DefaultACMEDirectory {
+		t.Errorf("expected default ACME directory, got %q", cfg.ACMEDirectory)
+	}
+	if cfg.ACMECacheDir != "/data/.selfhost_s3/.acme" {
+		t.Errorf("unexpected default ACME cache dir %q", cfg.ACMECacheDir)
+	}
+
+	_ = os.Setenv("S3_TLS_CERT", "/certs/tls.crt")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for certificate without key")
+	}
+
+	_ = os.Setenv("S3_TLS_KEY", "/certs/tls.key")
+	_ = os.Setenv("S3_TLS_CLIENT_CA", "/certs/ca.crt")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.TLSEnabled() || cfg.TLSKey != "/certs/tls.key" || cfg.TLSClientCA != "/certs/ca.crt" {
+		t.Errorf("unexpected TLS config: %+v", cfg)
+	}
+
+	_ = os.Setenv("S3_ACME_DOMAINS", "s3.example.com")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for certificate files combined with ACME")
+	}
+
+	_ = os.Unsetenv("S3_TLS_CERT")
+	_ = os.Unsetenv("S3_TLS_KEY")
+	_ = os.Setenv("S3_ACME_DOMAINS", "s3.example.com, files.example.com")
+	_ = os.Setenv("S3_ACME_DIRECTORY", "https://localhost:14000/dir")
+	_ = os.Setenv("S3_ACME_CACHE_DIR", "/acme")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.ACMEDomains) != 2 || cfg.ACMEDomains[1] != "files.example.com" {
+		t.Errorf("unexpected ACME domains: %v", cfg.ACMEDomains)
+	}
+	if cfg.ACMEDirectory != "https://localhost:14000/dir" || cfg.ACMECacheDir != "/acme" {
+		t.Errorf("unexpected ACME config: %+v", cfg)
+	}
+
+	_ = os.Setenv("S3_ACME_DIRECTORY", "localhost:14000")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for invalid ACME directory")
+	}
+
+	_ = os.Unsetenv("S3_ACME_DIRECTORY")
+	_ = os.Unsetenv("S3_ACME_DOMAINS")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for client CA without TLS")
+	}
+}
+
+func TestLoad_Logging(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	_ = os.Setenv("S3_BUCKET", "test-bucket")
+	_ = os.Setenv("S3_ACCESS_KEY", "access-key")
+	_ = os.Setenv("S3_SECRET_KEY", "secret-key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.LogFormat != "text" || cfg.LogLevel != "info" {
+		t.Errorf("unexpected log defaults: %q, %q", cfg.LogFormat, cfg.LogLevel)
+	}
+	if cfg.AccessLogFile != "" || cfg.AccessLogPrefix != "" {
+		t.Error("expected access logging to be disabled by default")
+	}
+	if cfg.AccessLogMaxSize != 100*1024*1024 || cfg.AccessLogMaxFiles != 5 || cfg.AccessLogInterval != 5*time.Minute {
+		t.Errorf("unexpected access log defaults: %+v", cfg)
+	}
+
+	_ = os.Setenv("S3_LOG_FORMAT", "JSON")
+	_ = os.Setenv("S3_LOG_LEVEL", "debug")
+	_ = os.Setenv("S3_ACCESS_LOG_FILE", "/var/log/s3/access.log")
+	_ = os.Setenv("S3_ACCESS_LOG_MAX_SIZE", "10MB")
+	_ = os.Setenv("S3_ACCESS_LOG_MAX_FILES", "2")
+	_ = os.Setenv("S3_ACCESS_LOG_PREFIX", "logs")
+	_ = os.Setenv("S3_ACCESS_LOG_INTERVAL", "1m")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.LogFormat != "json" || cfg.LogLevel != "debug" {
+		t.Errorf("unexpected log settings: %q, %q", cfg.LogFormat, cfg.LogLevel)
+	}
+	if cfg.AccessLogFile != "/var/log/s3/access.log" || cfg.AccessLogMaxSize != 10*1024*1024 || cfg.AccessLogMaxFiles != 2 {
+		t.Errorf("unexpected access log file settings: %+v", cfg)
+	}
+	if cfg.AccessLogPrefix != "logs/" || cfg.AccessLogInterval != time.Minute {
+		t.Errorf("unexpected access log delivery settings: %q, %s", cfg.AccessLogPrefix, cfg.AccessLogInterval)
+	}
+
+	for name, value := range map[string]string{
+		"S3_LOG_FORMAT":           "xml",
+		"S3_LOG_LEVEL":            "verbose",
+		"S3_ACCESS_LOG_MAX_FILES": "-1",
+		"S3_ACCESS_LOG_MAX_SIZE":  "big",
+	} {
+		t.Run(name, func(t *testing.T) {
+			original := os.Getenv(name)
+			defer func() { _ = os.Setenv(name, original) }()
+
+			_ = os.Setenv(name, value)
+			if _, err := Load(); err == nil {
+				t.Errorf("expected error for %s=%s", name, value)
+			}
+		})
+	}
+}
+
 func clearEnvVars() {
 	envVars := []string{
 		"S3_BUCKET",
@@ -343,13 +1039,102 @@ func clearEnvVars() {
 		"S3_SECRET_KEY",
 		"S3_PORT",
 		"S3_STORAGE_PATH",
+		"S3_STORAGE_BACKEND",
 		"S3_REGION",
 		"S3_CORS_ORIGINS",
 		"S3_MAX_FILE_SIZE",
+		"S3_ENCRYPTION_KEY",
+		"S3_COMPRESSION",
+		"S3_COMPRESSION_TYPES",
+		"S3_COMPRESSION_PREFIXES",
+		"S3_DEDUP",
+		"S3_DEDUP_GC_INTERVAL",
+		"S3_QUOTA_BYTES",
+		"S3_QUOTA_OBJECTS",
+		"S3_QUOTA_PREFIX_BYTES",
+		"S3_QUOTA_PREFIX_OBJECTS",
+		"S3_MIN_FREE_SPACE",
+		"S3_METADATA_INDEX",
+		"S3_SCRUB_INTERVAL",
+		"S3_SCRUB_RATE",
+		"S3_SCRUB_REPAIR",
+		"S3_SCRUB_QUARANTINE",
+		"S3_WATCH",
+		"S3_UPSTREAM_ENDPOINT",
+		"S3_UPSTREAM_BUCKET",
+		"S3_UPSTREAM_REGION",
+		"S3_UPSTREAM_ACCESS_KEY",
+		"S3_UPSTREAM_SECRET_KEY",
+		"S3_UPSTREAM_WRITES",
+		"S3_CACHE_MAX_SIZE",
 		"S3_PUBLIC_PREFIX",
 		"S3_PUBLIC_CACHE_MAX_AGE",
+		"S3_READ_HEADER_TIMEOUT",
+		"S3_READ_TIMEOUT",
+		"S3_WRITE_TIMEOUT",
+		"S3_IDLE_TIMEOUT",
+		"S3_SHUTDOWN_TIMEOUT",
+		"S3_LIFECYCLE_INTERVAL",
+		"S3_LIFECYCLE_DRY_RUN",
+		"S3_WEBHOOK_URL",
+		"S3_WEBHOOK_SECRET",
+		"S3_WEBHOOK_EVENTS",
+		"S3_WEBHOOK_PREFIX",
+		"S3_WEBHOOK_SUFFIX",
+		"S3_REPLICATION_ENDPOINT",
+		"S3_REPLICATION_BUCKET",
+		"S3_REPLICATION_REGION",
+		"S3_REPLICATION_ACCESS_KEY",
+		"S3_REPLICATION_SECRET_KEY",
+		"S3_REPLICATION_PREFIX",
+		"S3_TLS_CERT",
+		"S3_TLS_KEY",
+		"S3_TLS_CLIENT_CA",
+		"S3_ACME_DOMAINS",
+		"S3_ACME_EMAIL",
+		"S3_ACME_DIRECTORY",
+		"S3_ACME_CACHE_DIR",
+		"S3_ACME_HTTP_ADDR",
+		"S3_ACME_CA_ROOT",
+		"S3_METRICS_TOKEN",
+		"S3_LOG_FORMAT",
+		"S3_LOG_LEVEL",
+		"S3_ACCESS_LOG_FILE",
+		"S3_ACCESS_LOG_MAX_SIZE",
+		"S3_ACCESS_LOG_MAX_FILES",
+		"S3_ACCESS_LOG_PREFIX",
+		"S3_ACCESS_LOG_INTERVAL",
+		"S3_CONFIG_FILE",
 	}
 	for _, v := range envVars {
 		_ = os.Unsetenv(v)
 	}
 }
+
+func TestLoad_ReportsAllErrors(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	_ = os.Setenv("S3_BUCKET", "test-bucket")
+	_ = os.Setenv("S3_PORT", "http")
+	_ = os.Setenv("S3_IDLE_TIMEOUT", "-1s")
+	_ = os.Setenv("S3_LOG_LEVEL", "verbose")
+	_ = os.Setenv("S3_TLS_CERT", "/certs/tls.crt")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, expected := range []string{
+		"S3_ACCESS_KEY is required",
+		"S3_SECRET_KEY is required",
+		"invalid S3_PORT",
+		"invalid S3_IDLE_TIMEOUT",
+		"invalid S3_LOG_LEVEL",
+		"S3_TLS_CERT and S3_TLS_KEY must be set together",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in error:\n%v", expected, err)
+		}
+	}
+}
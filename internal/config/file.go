@@ -0,0 +1,332 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// fileSettings are the settings that can be set in the config file, under the name of
+// their environment variable in lower case without the S3_ prefix, e.g. max_file_size
+// for S3_MAX_FILE_SIZE. Other credentials, webhooks and replication rules are configured
+// as lists under "credentials", "webhooks" and "replication" instead.
+var fileSettings = []string{
+	"S3_BUCKET",
+	"S3_ACCESS_KEY",
+	"S3_SECRET_KEY",
+	"S3_PORT",
+	"S3_STORAGE_PATH",
+	"S3_STORAGE_BACKEND",
+	"S3_REGION",
+	"S3_CORS_ORIGINS",
+	"S3_MAX_FILE_SIZE",
+	"S3_ENCRYPTION_KEY",
+	"S3_COMPRESSION",
+	"S3_COMPRESSION_TYPES",
+	"S3_COMPRESSION_PREFIXES",
+	"S3_DEDUP",
+	"S3_DEDUP_GC_INTERVAL",
+	"S3_QUOTA_BYTES",
+	"S3_QUOTA_OBJECTS",
+	"S3_QUOTA_PREFIX_BYTES",
+	"S3_QUOTA_PREFIX_OBJECTS",
+	"S3_MIN_FREE_SPACE",
+	"S3_METADATA_INDEX",
+	"S3_SCRUB_INTERVAL",
+	"S3_SCRUB_RATE",
+	"S3_SCRUB_REPAIR",
+	"S3_SCRUB_QUARANTINE",
+	"S3_WATCH",
+	"S3_UPSTREAM_ENDPOINT",
+	"S3_UPSTREAM_BUCKET",
+	"S3_UPSTREAM_REGION",
+	"S3_UPSTREAM_ACCESS_KEY",
+	"S3_UPSTREAM_SECRET_KEY",
+	"S3_UPSTREAM_WRITES",
+	"S3_CACHE_MAX_SIZE",
+	"S3_PUBLIC_PREFIX",
+	"S3_PUBLIC_CACHE_MAX_AGE",
+	"S3_READ_HEADER_TIMEOUT",
+	"S3_READ_TIMEOUT",
+	"S3_WRITE_TIMEOUT",
+	"S3_IDLE_TIMEOUT",
+	"S3_SHUTDOWN_TIMEOUT",
+	"S3_LIFECYCLE_INTERVAL",
+	"S3_LIFECYCLE_DRY_RUN",
+	"S3_TLS_CERT",
+	"S3_TLS_KEY",
+	"S3_TLS_CLIENT_CA",
+	"S3_ACME_DOMAINS",
+	"S3_ACME_EMAIL",
+	"S3_ACME_DIRECTORY",
+	"S3_ACME_CACHE_DIR",
+	"S3_ACME_HTTP_ADDR",
+	"S3_ACME_CA_ROOT",
+	"S3_METRICS_TOKEN",
+	"S3_LOG_FORMAT",
+	"S3_LOG_LEVEL",
+	"S3_ACCESS_LOG_FILE",
+	"S3_ACCESS_LOG_MAX_SIZE",
+	"S3_ACCESS_LOG_MAX_FILES",
+	"S3_ACCESS_LOG_PREFIX",
+	"S3_ACCESS_LOG_INTERVAL",
+}
+
+// fileConfig is the content of a config file
+type fileConfig struct {
+	values      map[string]string // by environment variable name; lists are comma-separated
+	credentials []Credential
+	webhooks    []Webhook
+	replication []ReplicationRule
+}
+
+// readFile reads a YAML (.yaml, .yml) or TOML (.toml) config file. It returns no file when
+// the file cannot be read or parsed; unknown settings and values of the wrong kind are
+// all reported in the returned error along with the settings that could be read.
+func readFile(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var raw map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		_, err = toml.Decode(string(data), &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file %s: must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	file := &fileConfig{values: make(map[string]string)}
+	var errs []error
+
+	for _, key := range slices.Sorted(maps.Keys(raw)) {
+		if key == "credentials" {
+			credentials, err := parseCredentials(raw[key])
+			errs = append(errs, err)
+			file.credentials = credentials
+			continue
+		}
+		if key == "webhooks" {
+			webhooks, err := parseWebhooks(raw[key])
+			errs = append(errs, err)
+			file.webhooks = webhooks
+			continue
+		}
+		if key == "replication" {
+			rules, err := parseReplication(raw[key])
+			errs = append(errs, err)
+			file.replication = rules
+			continue
+		}
+
+		name := "S3_" + strings.ToUpper(key)
+		if key != strings.ToLower(key) || !slices.Contains(fileSettings, name) {
+			errs = append(errs, fmt.Errorf("unknown setting %q in config file", key))
+			continue
+		}
+		value, err := fileValue(raw[key])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", key, err))
+			continue
+		}
+		file.values[name] = value
+	}
+
+	return file, errors.Join(errs...)
+}
+
+// fileTables returns the items of a list of tables of a config file
+func fileTables(name string, raw any) ([]any, error) {
+	switch v := raw.(type) {
+	case []any:
+		return v, nil
+	case []map[string]any: // TOML array of tables
+		items := make([]any, 0, len(v))
+		for _, item := range v {
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("invalid %s: must be a list", name)
+	}
+}
+
+// parseCredentials reads the list of other credentials of a config file
+func parseCredentials(raw any) ([]Credential, error) {
+	items, err := fileTables("credentials", raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var credentials []Credential
+	var errs []error
+	for i, item := range items {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid credentials[%d]: must be a table of settings", i))
+			continue
+		}
+
+		var cred Credential
+		for _, key := range slices.Sorted(maps.Keys(fields)) {
+			s, err := fileValue(fields[key])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid credentials[%d].%s: %w", i, key, err))
+				continue
+			}
+			switch key {
+			case "access_key":
+				cred.AccessKey = s
+			case "secret_key":
+				cred.SecretKey = s
+			default:
+				errs = append(errs, fmt.Errorf("unknown setting \"credentials[%d].%s\" in config file", i, key))
+			}
+		}
+		credentials = append(credentials, cred)
+	}
+	return credentials, errors.Join(errs...)
+}
+
+// parseWebhooks reads the list of webhooks of a config file
+func parseWebhooks(raw any) ([]Webhook, error) {
+	items, err := fileTables("webhooks", raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var webhooks []Webhook
+	var errs []error
+	for i, item := range items {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid webhooks[%d]: must be a table of settings", i))
+			continue
+		}
+
+		webhook := Webhook{Events: []string{"s3:ObjectCreated:*", "s3:ObjectRemoved:*"}}
+		for _, key := range slices.Sorted(maps.Keys(fields)) {
+			s, err := fileValue(fields[key])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid webhooks[%d].%s: %w", i, key, err))
+				continue
+			}
+			switch key {
+			case "id":
+				webhook.ID = s
+			case "url":
+				webhook.URL = s
+			case "secret":
+				webhook.Secret = s
+			case "events":
+				webhook.Events = splitList(s)
+			case "prefix":
+				webhook.Prefix = s
+			case "suffix":
+				webhook.Suffix = s
+			default:
+				errs = append(errs, fmt.Errorf("unknown setting \"webhooks[%d].%s\" in config file", i, key))
+			}
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, errors.Join(errs...)
+}
+
+// parseReplication reads the list of replication rules of a config file
+func parseReplication(raw any) ([]ReplicationRule, error) {
+	items, err := fileTables("replication", raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []ReplicationRule
+	var errs []error
+	for i, item := range items {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid replication[%d]: must be a table of settings", i))
+			continue
+		}
+
+		var rule ReplicationRule
+		for _, key := range slices.Sorted(maps.Keys(fields)) {
+			s, err := fileValue(fields[key])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid replication[%d].%s: %w", i, key, err))
+				continue
+			}
+			switch key {
+			case "prefix":
+				rule.Prefix = s
+			case "endpoint":
+				rule.Endpoint = s
+			case "bucket":
+				rule.Bucket = s
+			case "region":
+				rule.Region = s
+			case "access_key":
+				rule.AccessKey = s
+			case "secret_key":
+				rule.SecretKey = s
+			default:
+				errs = append(errs, fmt.Errorf("unknown setting \"replication[%d].%s\" in config file", i, key))
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, errors.Join(errs...)
+}
+
+// fileValue converts a value of the config file to the format of its environment
+// variable: scalars as text and lists as comma-separated items
+func fileValue(raw any) (string, error) {
+	if list, ok := raw.([]any); ok {
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			s, err := scalarValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return scalarValue(raw)
+}
+
+// scalarValue converts a string, number or boolean of the config file to text
+func scalarValue(raw any) (string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("must be a string, number, boolean or a list of them")
+	}
+}
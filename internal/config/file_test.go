@@ -0,0 +1,200 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes a config file named name and points S3_CONFIG_FILE to it
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	_ = os.Setenv("S3_CONFIG_FILE", path)
+	return path
+}
+
+func TestLoad_YAMLFile(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	writeConfigFile(t, "config.yaml", `
+bucket: file-bucket
+access_key: file-access-key
+secret_key: file-secret-key
+port: 9100
+cors_origins:
+  - https://app.example.com
+  - https://admin.example.com
+max_file_size: 1GB
+public_prefix: ""
+idle_timeout: 90s
+lifecycle_dry_run: true
+webhooks:
+  - id: app
+    url: https://app.example.com/hooks/s3
+    events: [s3:ObjectCreated:*]
+    prefix: uploads/
+`)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Bucket != "file-bucket" || cfg.AccessKey != "file-access-key" || cfg.SecretKey != "file-secret-key" {
+		t.Errorf("unexpected required settings: %+v", cfg)
+	}
+	if cfg.Port != 9100 || cfg.MaxFileSize != 1024*1024*1024 || cfg.IdleTimeout != 90*time.Second || !cfg.LifecycleDryRun {
+		t.Errorf("unexpected optional settings: %+v", cfg)
+	}
+	if len(cfg.CORSOrigins) != 2 || cfg.CORSOrigins[1] != "https://admin.example.com" {
+		t.Errorf("unexpected CORS origins: %v", cfg.CORSOrigins)
+	}
+	if cfg.PublicPrefix != "" {
+		t.Errorf("expected public access to be disabled, got prefix %q", cfg.PublicPrefix)
+	}
+	if len(cfg.Webhooks) != 1 || cfg.Webhooks[0].ID != "app" || cfg.Webhooks[0].Prefix != "uploads/" || len(cfg.Webhooks[0].Events) != 1 {
+		t.Errorf("unexpected webhooks: %+v", cfg.Webhooks)
+	}
+
+	// Environment variables take precedence over the file
+	_ = os.Setenv("S3_PORT", "9200")
+	_ = os.Setenv("S3_WEBHOOK_URL", "https://other.example.com/hooks")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Port != 9200 || cfg.Bucket != "file-bucket" {
+		t.Errorf("expected the environment to override the file, got port %d and bucket %q", cfg.Port, cfg.Bucket)
+	}
+	if len(cfg.Webhooks) != 2 || cfg.Webhooks[1].URL != "https://other.example.com/hooks" {
+		t.Errorf("expected the environment webhook after the file webhooks, got %+v", cfg.Webhooks)
+	}
+}
+
+func TestLoad_TOMLFile(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	writeConfigFile(t, "config.toml", `
+bucket = "file-bucket"
+access_key = "file-access-key"
+secret_key = "file-secret-key"
+public_cache_max_age = 600
+acme_domains = ["s3.example.com"]
+
+[[credentials]]
+access_key = "deploy-key"
+secret_key = "deploy-secret"
+
+[[credentials]]
+access_key = "backup-key"
+secret_key = "backup-secret"
+
+[[webhooks]]
+url = "https://app.example.com/hooks/s3"
+secret = "s3cret"
+
+[[replication]]
+endpoint = "https://backup.example.com"
+bucket = "backup-bucket"
+access_key = "backup-key"
+secret_key = "backup-secret"
+`)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Bucket != "file-bucket" || cfg.PublicCacheMaxAge != 600 {
+		t.Errorf("unexpected settings: %+v", cfg)
+	}
+	if len(cfg.ACMEDomains) != 1 || cfg.ACMEDomains[0] != "s3.example.com" {
+		t.Errorf("unexpected ACME domains: %v", cfg.ACMEDomains)
+	}
+	if len(cfg.Credentials) != 2 || cfg.Credentials[0].AccessKey != "deploy-key" || cfg.Credentials[1].SecretKey != "backup-secret" {
+		t.Errorf("unexpected credentials: %+v", cfg.Credentials)
+	}
+	if len(cfg.Webhooks) != 1 || cfg.Webhooks[0].Secret != "s3cret" || len(cfg.Webhooks[0].Events) != 2 {
+		t.Errorf("unexpected webhooks: %+v", cfg.Webhooks)
+	}
+	if len(cfg.Replication) != 1 || cfg.Replication[0].Bucket != "backup-bucket" || cfg.Replication[0].Region != "us-east-1" {
+		t.Errorf("unexpected replication rules: %+v", cfg.Replication)
+	}
+}
+
+func TestLoad_InvalidFile(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	writeConfigFile(t, "config.yaml", `
+bucket: file-bucket
+access_key: file-access-key
+secret_key: file-secret-key
+prot: 9000
+log_level: verbose
+cors_origins:
+  nested: true
+credentials:
+  - access_key: file-access-key
+    secret_key: other-secret
+  - access_key: deploy-key
+  - access_key: backup-key
+    secret_key: backup-secret
+    bucket: backups
+webhooks:
+  - url: ftp://example.com
+    event: s3:ObjectCreated:*
+  - id: app
+    url: https://app.example.com/hooks/uploads
+  - id: app
+    url: https://app.example.com/hooks/removals
+replication:
+  - endpoint: https://backup.example.com
+    access_key: backup-key
+    secret: backup-secret
+`)
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, expected := range []string{
+		`unknown setting "prot"`,
+		"invalid cors_origins",
+		`invalid credentials[0].access_key: "file-access-key" is already used`,
+		"invalid credentials[1]: access_key and secret_key are required",
+		`unknown setting "credentials[2].bucket"`,
+		`unknown setting "webhooks[0].event"`,
+		"invalid webhooks[0].url",
+		`invalid webhooks[2].id: "app" is already used`,
+		`unknown setting "replication[0].secret"`,
+		"replication[0].access_key and replication[0].secret_key are required",
+		"invalid S3_LOG_LEVEL",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in error:\n%v", expected, err)
+		}
+	}
+
+	writeConfigFile(t, "config.json", `{}`)
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "unsupported config file") {
+		t.Errorf("expected unsupported format error, got %v", err)
+	}
+
+	writeConfigFile(t, "config.toml", `bucket = `)
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid config file") {
+		t.Errorf("expected parse error, got %v", err)
+	}
+
+	_ = os.Setenv("S3_CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "failed to read config file") {
+		t.Errorf("expected read error, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+// Package cors implements S3 bucket CORS configurations and the evaluation of
+// cross-origin requests against their rules.
+package cors
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// maxRules is the maximum number of rules S3 accepts in a CORS configuration
+const maxRules = 100
+
+// allowedMethods are the methods a rule may allow
+var allowedMethods = []string{http.MethodGet, http.MethodPut, http.MethodHead, http.MethodPost, http.MethodDelete}
+
+// defaultExposeHeaders are the response headers exposed by the rule built from S3_CORS_ORIGINS
+var defaultExposeHeaders = []string{
+	"ETag",
+	"x-amz-request-id",
+	"x-amz-id-2",
+	"x-amz-version-id",
+	"x-amz-delete-marker",
+	"x-amz-tagging-count",
+}
+
+// defaultMaxAge is the preflight cache duration of the rule built from S3_CORS_ORIGINS
+const defaultMaxAge = 86400
+
+// Configuration is the S3 CORSConfiguration document
+type Configuration struct {
+	XMLName xml.Name `xml:"CORSConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Rules   []Rule   `xml:"CORSRule"`
+}
+
+// Rule allows cross-origin requests from some origins with some methods and headers
+type Rule struct {
+	ID             string   `xml:"ID,omitempty"`
+	AllowedHeaders []string `xml:"AllowedHeader"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	ExposeHeaders  []string `xml:"ExposeHeader"`
+	MaxAgeSeconds  *int     `xml:"MaxAgeSeconds,omitempty"`
+}
+
+// Parse decodes and validates a CORS configuration document
+func Parse(data []byte) (*Configuration, error) {
+	var cfg Configuration
+	if err := xml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// FromOrigins returns the configuration applied when a bucket has none: a single rule
+// allowing the origins of S3_CORS_ORIGINS with every method and header. It returns nil,
+// which disables CORS, when there are no origins.
+func FromOrigins(origins []string) *Configuration {
+	if len(origins) == 0 {
+		return nil
+	}
+	maxAge := defaultMaxAge
+	return &Configuration{Rules: []Rule{{
+		AllowedHeaders: []string{"*"},
+		AllowedMethods: allowedMethods,
+		AllowedOrigins: origins,
+		ExposeHeaders:  defaultExposeHeaders,
+		MaxAgeSeconds:  &maxAge,
+	}}}
+}
+
+// Validate checks the configuration against the S3 CORS rules
+func (c *Configuration) Validate() error {
+	if len(c.Rules) == 0 {
+		return invalidf("at least one CORSRule is required")
+	}
+	if len(c.Rules) > maxRules {
+		return invalidf("at most %d CORSRules are allowed", maxRules)
+	}
+	for i, rule := range c.Rules {
+		if err := rule.validate(); err != nil {
+			return invalidf("CORSRule %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// validate checks a single rule
+func (r *Rule) validate() error {
+	if len(r.ID) > 255 {
+		return fmt.Errorf("ID must be at most 255 characters")
+	}
+	if len(r.AllowedMethods) == 0 {
+		return fmt.Errorf("at least one AllowedMethod is required")
+	}
+	for _, method := range r.AllowedMethods {
+		if !slices.Contains(allowedMethods, method) {
+			return fmt.Errorf("unsupported AllowedMethod %q, must be GET, PUT, HEAD, POST or DELETE", method)
+		}
+	}
+	if len(r.AllowedOrigins) == 0 {
+		return fmt.Errorf("at least one AllowedOrigin is required")
+	}
+	for _, origin := range r.AllowedOrigins {
+		if strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("AllowedOrigin %q can not have more than one wildcard", origin)
+		}
+	}
+	for _, header := range r.AllowedHeaders {
+		if strings.Count(header, "*") > 1 {
+			return fmt.Errorf("AllowedHeader %q can not have more than one wildcard", header)
+		}
+	}
+	for _, header := range r.ExposeHeaders {
+		if strings.Contains(header, "*") {
+			return fmt.Errorf("ExposeHeader %q can not contain a wildcard", header)
+		}
+	}
+	if r.MaxAgeSeconds != nil && *r.MaxAgeSeconds < 0 {
+		return fmt.Errorf("MaxAgeSeconds must not be negative")
+	}
+	return nil
+}
+
+// Match returns the first rule allowing a request from origin with method and the
+// given request headers, or nil when no rule does. Like S3, rules are evaluated in order.
+func (c *Configuration) Match(origin, method string, headers []string) *Rule {
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if rule.allowsOrigin(origin) && slices.Contains(rule.AllowedMethods, method) && rule.allowsHeaders(headers) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// AllowsAnyOrigin reports whether the rule allows every origin with "*". Responses then
+// allow "*" instead of echoing the origin, and credentials are not allowed.
+func (r *Rule) AllowsAnyOrigin() bool {
+	return slices.Contains(r.AllowedOrigins, "*")
+}
+
+// allowsOrigin reports whether an AllowedOrigin matches origin
+func (r *Rule) allowsOrigin(origin string) bool {
+	for _, allowed := range r.AllowedOrigins {
+		if matchWildcard(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders reports whether every request header matches an AllowedHeader.
+// Header names are compared case-insensitively.
+func (r *Rule) allowsHeaders(headers []string) bool {
+	for _, header := range headers {
+		allowed := false
+		for _, pattern := range r.AllowedHeaders {
+			if matchWildcard(strings.ToLower(pattern), strings.ToLower(header)) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// matchWildcard reports whether s matches pattern, where a single "*" matches any characters
+func matchWildcard(pattern, s string) bool {
+	before, after, found := strings.Cut(pattern, "*")
+	if !found {
+		return pattern == s
+	}
+	return len(s) >= len(before)+len(after) && strings.HasPrefix(s, before) && strings.HasSuffix(s, after)
+}
+
+// Errors returned by Parse and Validate
+var (
+	ErrMalformed = fmt.Errorf("malformed CORS configuration")
+	ErrInvalid   = fmt.Errorf("invalid CORS configuration")
+)
+
+// invalidf wraps a validation message in ErrInvalid
+func invalidf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,133 @@
+package cors
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse_Valid(t *testing.T) {
+	data := []byte(`<CORSConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <CORSRule>
+    <ID>app</ID>
+    <AllowedOrigin>https://*.example.com</AllowedOrigin>
+    <AllowedMethod>GET</AllowedMethod>
+    <AllowedMethod>PUT</AllowedMethod>
+    <AllowedHeader>*</AllowedHeader>
+    <ExposeHeader>ETag</ExposeHeader>
+    <MaxAgeSeconds>3000</MaxAgeSeconds>
+  </CORSRule>
+  <CORSRule>
+    <AllowedOrigin>*</AllowedOrigin>
+    <AllowedMethod>GET</AllowedMethod>
+  </CORSRule>
+</CORSConfiguration>`)
+
+	cfg, err := Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(cfg.Rules))
+	}
+	rule := cfg.Rules[0]
+	if rule.ID != "app" || len(rule.AllowedMethods) != 2 || rule.ExposeHeaders[0] != "ETag" || *rule.MaxAgeSeconds != 3000 {
+		t.Errorf("unexpected first rule: %+v", rule)
+	}
+	if cfg.Rules[1].MaxAgeSeconds != nil {
+		t.Errorf("expected no max age on the second rule")
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		xml  string
+		want error
+	}{
+		{"malformed", `<CORSConfiguration><CORSRule>`, ErrMalformed},
+		{"no rules", `<CORSConfiguration></CORSConfiguration>`, ErrInvalid},
+		{"no method", `<CORSConfiguration><CORSRule><AllowedOrigin>*</AllowedOrigin></CORSRule></CORSConfiguration>`, ErrInvalid},
+		{"no origin", `<CORSConfiguration><CORSRule><AllowedMethod>GET</AllowedMethod></CORSRule></CORSConfiguration>`, ErrInvalid},
+		{"unsupported method", `<CORSConfiguration><CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>PATCH</AllowedMethod></CORSRule></CORSConfiguration>`, ErrInvalid},
+		{"two wildcards in origin", `<CORSConfiguration><CORSRule><AllowedOrigin>https://*.*.com</AllowedOrigin><AllowedMethod>GET</AllowedMethod></CORSRule></CORSConfiguration>`, ErrInvalid},
+		{"wildcard in expose header", `<CORSConfiguration><CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>GET</AllowedMethod><ExposeHeader>x-*</ExposeHeader></CORSRule></CORSConfiguration>`, ErrInvalid},
+		{"negative max age", `<CORSConfiguration><CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>GET</AllowedMethod><MaxAgeSeconds>-1</MaxAgeSeconds></CORSRule></CORSConfiguration>`, ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.xml))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cfg := &Configuration{Rules: []Rule{
+		{
+			ID:             "uploads",
+			AllowedOrigins: []string{"https://app.example.com"},
+			AllowedMethods: []string{"PUT", "POST"},
+			AllowedHeaders: []string{"Content-Type", "x-amz-*"},
+		},
+		{
+			ID:             "downloads",
+			AllowedOrigins: []string{"https://*.example.com", "http://localhost:3000"},
+			AllowedMethods: []string{"GET", "HEAD"},
+		},
+	}}
+
+	tests := []struct {
+		name    string
+		origin  string
+		method  string
+		headers []string
+		want    string // rule ID, "" for no match
+	}{
+		{"exact origin and allowed headers", "https://app.example.com", "PUT", []string{"content-type", "x-amz-date"}, "uploads"},
+		{"header not allowed", "https://app.example.com", "PUT", []string{"authorization"}, ""},
+		{"method not allowed", "https://app.example.com", "DELETE", nil, ""},
+		{"wildcard origin", "https://cdn.example.com", "GET", nil, "downloads"},
+		{"wildcard does not match another domain", "https://example.org", "GET", nil, ""},
+		{"wildcard needs a subdomain", "https://.example.com", "GET", nil, "downloads"},
+		{"headers need an AllowedHeader", "http://localhost:3000", "GET", []string{"range"}, ""},
+		{"second origin of a rule", "http://localhost:3000", "HEAD", nil, "downloads"},
+		{"origin is case-sensitive", "HTTPS://APP.EXAMPLE.COM", "PUT", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := cfg.Match(tt.origin, tt.method, tt.headers)
+			got := ""
+			if rule != nil {
+				got = rule.ID
+			}
+			if got != tt.want {
+				t.Errorf("expected rule %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFromOrigins(t *testing.T) {
+	if FromOrigins(nil) != nil {
+		t.Error("expected no configuration without origins")
+	}
+
+	cfg := FromOrigins([]string{"*"})
+	rule := cfg.Match("https://anything.example", "DELETE", []string{"authorization", "x-amz-content-sha256"})
+	if rule == nil || !rule.AllowsAnyOrigin() {
+		t.Fatalf("expected the wildcard rule to match, got %+v", rule)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected a valid configuration, got %v", err)
+	}
+
+	cfg = FromOrigins([]string{"https://app.example.com"})
+	if rule := cfg.Match("https://app.example.com", "GET", nil); rule == nil || rule.AllowsAnyOrigin() {
+		t.Errorf("expected a specific origin rule, got %+v", rule)
+	}
+	if cfg.Match("https://other.example.com", "GET", nil) != nil {
+		t.Error("expected other origins to be rejected")
+	}
+}
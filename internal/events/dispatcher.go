@@ -0,0 +1,180 @@
+package events
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/queue"
+)
+
+// deliveryTimeout bounds a webhook request
+const deliveryTimeout = 10 * time.Second
+
+// Webhook request headers
+const (
+	HeaderEvent     = "X-Selfhost-S3-Event"
+	HeaderDelivery  = "X-Selfhost-S3-Delivery"
+	HeaderSignature = "X-Selfhost-S3-Signature"
+)
+
+// delivery is a pending webhook delivery
+type delivery struct {
+	Webhook   string          `json:"webhook"` // see Webhook.Key
+	URL       string          `json:"url"`
+	EventName string          `json:"eventName"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// Dispatcher queues event notifications on disk and delivers them to webhooks.
+// Every matching webhook gets its own queue entry, which is only removed once
+// delivered, so events survive restarts and webhook outages.
+type Dispatcher struct {
+	region   string
+	bucket   string
+	webhooks []Webhook
+	client   *http.Client
+	queue    *queue.Queue[delivery]
+
+	now func() time.Time
+}
+
+// NewDispatcher creates a dispatcher persisting its queue in dir
+func NewDispatcher(dir, region, bucket string, webhooks []Webhook) (*Dispatcher, error) {
+	d := &Dispatcher{
+		region:   region,
+		bucket:   bucket,
+		webhooks: webhooks,
+		client:   &http.Client{Timeout: deliveryTimeout},
+		now:      time.Now,
+	}
+
+	var err error
+	d.queue, err = queue.New(dir, queue.Options[delivery]{
+		Name:    "Events",
+		Process: d.process,
+		Done:    d.done,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event queue: %w", err)
+	}
+	return d, nil
+}
+
+// Publish queues an event for every webhook subscribed to it
+func (d *Dispatcher) Publish(eventName string, obj Object) error {
+	now := d.now()
+
+	var payload []byte
+	for _, webhook := range d.webhooks {
+		if !webhook.Matches(eventName, obj.Key) {
+			continue
+		}
+
+		if payload == nil {
+			data, err := json.Marshal(Notification{
+				Records: []Record{newRecord(d.region, d.bucket, eventName, obj, now)},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to encode event: %w", err)
+			}
+			payload = data
+		}
+
+		if err := d.queue.Add(delivery{Webhook: webhook.Key(), URL: webhook.URL, EventName: eventName, Payload: payload}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start delivers queued events in the background until Stop is called
+func (d *Dispatcher) Start() {
+	d.queue.Start()
+}
+
+// Stop stops background delivery and waits for an in-flight delivery to finish.
+// Undelivered events stay queued on disk.
+func (d *Dispatcher) Stop() {
+	d.queue.Stop()
+}
+
+// Pending returns the number of events waiting for delivery
+func (d *Dispatcher) Pending() int {
+	return d.queue.Len()
+}
+
+// process delivers a queued event, dropping it when its webhook was removed
+func (d *Dispatcher) process(entry *queue.Entry[delivery]) error {
+	item := entry.Value
+	webhook := d.webhook(item.Webhook)
+	if webhook == nil {
+		log.Printf("Events: dropping %s for %s: webhook is no longer configured", item.EventName, item.URL)
+		return nil
+	}
+
+	if err := d.deliver(webhook, entry.ID, &item); err != nil {
+		log.Printf("Events: delivery of %s to %s failed (attempt %d): %v", item.EventName, item.URL, entry.Attempts+1, err)
+		return err
+	}
+	return nil
+}
+
+// done logs the events given up on
+func (d *Dispatcher) done(entry *queue.Entry[delivery], err error) {
+	if err != nil {
+		log.Printf("Events: giving up on %s for %s after %d attempts: %v", entry.Value.EventName, entry.Value.URL, entry.Attempts, err)
+	}
+}
+
+// deliver POSTs an event to its webhook
+func (d *Dispatcher) deliver(webhook *Webhook, id string, item *delivery) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(item.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "selfhost_s3")
+	req.Header.Set(HeaderEvent, item.EventName)
+	req.Header.Set(HeaderDelivery, id)
+	if webhook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(webhook.Secret, item.Payload))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// webhook returns the configured webhook identified by key, or nil
+func (d *Dispatcher) webhook(key string) *Webhook {
+	for i := range d.webhooks {
+		if d.webhooks[i].Key() == key {
+			return &d.webhooks[i]
+		}
+	}
+	return nil
+}
+
+// Sign returns the signature header value of a payload: "sha256=" followed by
+// the hex-encoded HMAC-SHA256 of the body
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
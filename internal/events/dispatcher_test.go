@@ -0,0 +1,258 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/queue"
+)
+
+// webhookReceiver records the requests sent to a test webhook
+type webhookReceiver struct {
+	mu       sync.Mutex
+	requests []*receivedRequest
+	failures int // number of requests to reject before accepting
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	if wr.failures > 0 {
+		wr.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	wr.requests = append(wr.requests, &receivedRequest{header: r.Header.Clone(), body: body})
+}
+
+func (wr *webhookReceiver) received() []*receivedRequest {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	return append([]*receivedRequest(nil), wr.requests...)
+}
+
+// waitFor polls cond until it returns true or the test times out
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDispatcher_DeliversSignedEvents(t *testing.T) {
+	receiver := &webhookReceiver{}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	d, err := NewDispatcher(t.TempDir(), "us-east-1", "test-bucket", []Webhook{{
+		URL:    ts.URL,
+		Secret: "s3cret",
+		Events: []string{"s3:ObjectCreated:*"},
+	}})
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	d.Start()
+	defer d.Stop()
+
+	if err := d.Publish(ObjectCreatedPut, Object{Key: "a.txt", Size: 3}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	// Not subscribed
+	if err := d.Publish(ObjectRemovedDelete, Object{Key: "a.txt"}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	waitFor(t, func() bool { return len(receiver.received()) == 1 && d.Pending() == 0 })
+
+	req := receiver.received()[0]
+	if req.header.Get(HeaderEvent) != ObjectCreatedPut {
+		t.Errorf("unexpected event header %q", req.header.Get(HeaderEvent))
+	}
+	if req.header.Get(HeaderSignature) != Sign("s3cret", req.body) {
+		t.Errorf("invalid signature %q", req.header.Get(HeaderSignature))
+	}
+
+	var notification Notification
+	if err := json.Unmarshal(req.body, &notification); err != nil {
+		t.Fatalf("failed to decode notification: %v", err)
+	}
+	if len(notification.Records) != 1 || notification.Records[0].S3.Object.Key != "a.txt" {
+		t.Errorf("unexpected notification: %+v", notification)
+	}
+}
+
+func TestDispatcher_RetriesWithBackoff(t *testing.T) {
+	receiver := &webhookReceiver{failures: 2}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	d, err := NewDispatcher(t.TempDir(), "us-east-1", "test-bucket", []Webhook{{
+		URL:    ts.URL,
+		Events: []string{"s3:ObjectCreated:*"},
+	}})
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	d.queue.SetBackoff(func(int) time.Duration { return 10 * time.Millisecond })
+	d.Start()
+	defer d.Stop()
+
+	_ = d.Publish(ObjectCreatedPut, Object{Key: "a.txt"})
+
+	waitFor(t, func() bool { return len(receiver.received()) == 1 && d.Pending() == 0 })
+
+	if req := receiver.received()[0]; req.header.Get(HeaderSignature) != "" {
+		t.Error("expected unsigned request without secret")
+	}
+}
+
+func TestDispatcher_GivesUpAfterMaxAttempts(t *testing.T) {
+	receiver := &webhookReceiver{failures: queue.MaxAttempts}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	d, err := NewDispatcher(dir, "us-east-1", "test-bucket", []Webhook{{
+		URL:    ts.URL,
+		Events: []string{"s3:ObjectCreated:*"},
+	}})
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	d.queue.SetBackoff(func(int) time.Duration { return time.Millisecond })
+	d.Start()
+	defer d.Stop()
+
+	_ = d.Publish(ObjectCreatedPut, Object{Key: "a.txt"})
+
+	waitFor(t, func() bool {
+		failed, _ := filepath.Glob(filepath.Join(dir, "failed", "*.json"))
+		return len(failed) == 1 && d.Pending() == 0
+	})
+	if len(receiver.received()) != 0 {
+		t.Error("expected no successful delivery")
+	}
+}
+
+func TestDispatcher_SurvivesRestart(t *testing.T) {
+	receiver := &webhookReceiver{}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	webhooks := []Webhook{{URL: ts.URL, Events: []string{"s3:ObjectRemoved:*"}}}
+
+	// Events published while the dispatcher is not running stay queued on disk
+	d, err := NewDispatcher(dir, "us-east-1", "test-bucket", webhooks)
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	_ = d.Publish(ObjectRemovedDelete, Object{Key: "a.txt"})
+	_ = d.Publish(ObjectRemovedDelete, Object{Key: "b.txt"})
+	if d.Pending() != 2 {
+		t.Fatalf("expected 2 pending events, got %d", d.Pending())
+	}
+
+	// A new dispatcher over the same directory delivers them in order
+	restarted, err := NewDispatcher(dir, "us-east-1", "test-bucket", webhooks)
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	restarted.Start()
+	defer restarted.Stop()
+
+	waitFor(t, func() bool { return len(receiver.received()) == 2 })
+
+	var first Notification
+	_ = json.Unmarshal(receiver.received()[0].body, &first)
+	if first.Records[0].S3.Object.Key != "a.txt" {
+		t.Errorf("expected events in publish order, got %q first", first.Records[0].S3.Object.Key)
+	}
+}
+
+func TestDispatcher_DropsEventsForRemovedWebhooks(t *testing.T) {
+	dir := t.TempDir()
+
+	d, err := NewDispatcher(dir, "us-east-1", "test-bucket", []Webhook{{
+		URL:    "http://127.0.0.1:1/hook",
+		Events: []string{"s3:ObjectCreated:*"},
+	}})
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	_ = d.Publish(ObjectCreatedPut, Object{Key: "a.txt"})
+
+	reconfigured, err := NewDispatcher(dir, "us-east-1", "test-bucket", nil)
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	reconfigured.queue.ProcessDue()
+
+	if reconfigured.Pending() != 0 {
+		t.Errorf("expected orphaned event to be dropped, got %d pending", reconfigured.Pending())
+	}
+}
+
+func TestDispatcher_WebhooksSharingAURL(t *testing.T) {
+	receiver := &webhookReceiver{}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	d, err := NewDispatcher(t.TempDir(), "us-east-1", "test-bucket", []Webhook{
+		{URL: ts.URL, Secret: "uploads", Events: []string{"s3:ObjectCreated:*"}},
+		{URL: ts.URL, Secret: "removals", Events: []string{"s3:ObjectRemoved:*"}},
+	})
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	_ = d.Publish(ObjectRemovedDelete, Object{Key: "a.txt"})
+	d.queue.ProcessDue()
+
+	// Delivered as the webhook subscribed to the event, not the first one with its URL
+	if received := receiver.received(); len(received) != 1 || received[0].header.Get(HeaderSignature) != Sign("removals", received[0].body) {
+		t.Fatalf("expected one request signed for the removals webhook, got %d", len(received))
+	}
+}
+
+func TestDispatcher_WebhookIDSurvivesChanges(t *testing.T) {
+	receiver := &webhookReceiver{}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	d, err := NewDispatcher(dir, "us-east-1", "test-bucket", []Webhook{{ID: "app", URL: ts.URL, Events: []string{"s3:ObjectCreated:*"}}})
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	_ = d.Publish(ObjectCreatedPut, Object{Key: "a.txt"})
+
+	// Queued events follow the webhook with the same ID when its settings change
+	reconfigured, err := NewDispatcher(dir, "us-east-1", "test-bucket", []Webhook{{ID: "app", URL: ts.URL, Secret: "new", Events: []string{"s3:*"}}})
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	reconfigured.queue.ProcessDue()
+	if received := receiver.received(); len(received) != 1 || received[0].header.Get(HeaderSignature) != Sign("new", received[0].body) {
+		t.Errorf("expected the queued event to be delivered with the new settings, got %d requests", len(received))
+	}
+}
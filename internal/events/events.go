@@ -0,0 +1,145 @@
+package events
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3 event names
+const (
+	ObjectCreatedPut                       = "s3:ObjectCreated:Put"
+	ObjectCreatedCopy                      = "s3:ObjectCreated:Copy"
+	ObjectCreatedCompleteMultipartUpload   = "s3:ObjectCreated:CompleteMultipartUpload"
+	ObjectRemovedDelete                    = "s3:ObjectRemoved:Delete"
+	ObjectRemovedDeleteMarkerCreated       = "s3:ObjectRemoved:DeleteMarkerCreated"
+	ObjectTaggingPut                       = "s3:ObjectTagging:Put"
+	ObjectTaggingDelete                    = "s3:ObjectTagging:Delete"
+	LifecycleExpirationDelete              = "s3:LifecycleExpiration:Delete"
+	LifecycleExpirationDeleteMarkerCreated = "s3:LifecycleExpiration:DeleteMarkerCreated"
+)
+
+// Object describes the object an event refers to
+type Object struct {
+	Key       string
+	Size      int64
+	ETag      string
+	VersionID string
+}
+
+// Webhook is an HTTP endpoint receiving event notifications
+type Webhook struct {
+	ID     string // identifies the webhook in the queue; derived from its settings when empty
+	URL    string
+	Secret string   // HMAC-SHA256 signing key; requests are unsigned when empty
+	Events []string // event names or wildcards such as "s3:ObjectCreated:*"
+	Prefix string   // only keys starting with Prefix
+	Suffix string   // only keys ending with Suffix
+}
+
+// Key returns the identifier of the webhook in the queue: its ID, or else a digest of
+// its settings, so that queued events stay with their webhook across restarts
+func (w *Webhook) Key() string {
+	if w.ID != "" {
+		return w.ID
+	}
+	settings, _ := json.Marshal(w)
+	sum := sha256.Sum256(settings)
+	return hex.EncodeToString(sum[:16])
+}
+
+// Matches reports whether the webhook subscribes to an event on key
+func (w *Webhook) Matches(eventName, key string) bool {
+	if !strings.HasPrefix(key, w.Prefix) || !strings.HasSuffix(key, w.Suffix) {
+		return false
+	}
+	for _, pattern := range w.Events {
+		if pattern == eventName {
+			return true
+		}
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(eventName, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidEventName reports whether name is a supported event name or wildcard
+func ValidEventName(name string) bool {
+	switch name {
+	case "s3:ObjectCreated:*", "s3:ObjectRemoved:*", "s3:ObjectTagging:*", "s3:LifecycleExpiration:*",
+		ObjectCreatedPut, ObjectCreatedCopy, ObjectCreatedCompleteMultipartUpload,
+		ObjectRemovedDelete, ObjectRemovedDeleteMarkerCreated,
+		ObjectTaggingPut, ObjectTaggingDelete,
+		LifecycleExpirationDelete, LifecycleExpirationDeleteMarkerCreated:
+		return true
+	}
+	return false
+}
+
+// Notification is the JSON document delivered to webhooks, in the S3 event message format
+type Notification struct {
+	Records []Record `json:"Records"`
+}
+
+// Record is a single S3 event record
+type Record struct {
+	EventVersion string   `json:"eventVersion"`
+	EventSource  string   `json:"eventSource"`
+	AWSRegion    string   `json:"awsRegion"`
+	EventTime    string   `json:"eventTime"`
+	EventName    string   `json:"eventName"`
+	S3           S3Entity `json:"s3"`
+}
+
+// S3Entity describes the bucket and object of an event record
+type S3Entity struct {
+	SchemaVersion string       `json:"s3SchemaVersion"`
+	Bucket        BucketEntity `json:"bucket"`
+	Object        ObjectEntity `json:"object"`
+}
+
+// BucketEntity identifies the bucket of an event record
+type BucketEntity struct {
+	Name string `json:"name"`
+	ARN  string `json:"arn"`
+}
+
+// ObjectEntity identifies the object of an event record
+type ObjectEntity struct {
+	Key       string `json:"key"`
+	Size      int64  `json:"size,omitempty"`
+	ETag      string `json:"eTag,omitempty"`
+	VersionID string `json:"versionId,omitempty"`
+	Sequencer string `json:"sequencer"`
+}
+
+// newRecord builds an S3 event record. Like S3, the event name omits the "s3:" prefix
+// and the key is URL encoded.
+func newRecord(region, bucket, eventName string, obj Object, t time.Time) Record {
+	return Record{
+		EventVersion: "2.1",
+		EventSource:  "aws:s3",
+		AWSRegion:    region,
+		EventTime:    t.UTC().Format("2006-01-02T15:04:05.000Z"),
+		EventName:    strings.TrimPrefix(eventName, "s3:"),
+		S3: S3Entity{
+			SchemaVersion: "1.0",
+			Bucket: BucketEntity{
+				Name: bucket,
+				ARN:  "arn:aws:s3:::" + bucket,
+			},
+			Object: ObjectEntity{
+				Key:       url.QueryEscape(obj.Key),
+				Size:      obj.Size,
+				ETag:      strings.Trim(obj.ETag, `"`),
+				VersionID: obj.VersionID,
+				Sequencer: fmt.Sprintf("%016X", t.UnixNano()),
+			},
+		},
+	}
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWebhookMatches(t *testing.T) {
+	webhook := Webhook{
+		Events: []string{"s3:ObjectCreated:*", ObjectRemovedDelete},
+		Prefix: "images/",
+		Suffix: ".png",
+	}
+
+	tests := []struct {
+		event string
+		key   string
+		match bool
+	}{
+		{ObjectCreatedPut, "images/logo.png", true},
+		{ObjectRemovedDelete, "images/logo.png", true},
+		{ObjectRemovedDeleteMarkerCreated, "images/logo.png", false},
+		{ObjectCreatedPut, "docs/logo.png", false},
+		{ObjectCreatedPut, "images/logo.jpg", false},
+		{ObjectTaggingPut, "images/logo.png", false},
+	}
+
+	for _, tt := range tests {
+		if got := webhook.Matches(tt.event, tt.key); got != tt.match {
+			t.Errorf("Matches(%q, %q) = %v, expected %v", tt.event, tt.key, got, tt.match)
+		}
+	}
+}
+
+func TestValidEventName(t *testing.T) {
+	for _, name := range []string{"s3:ObjectCreated:*", ObjectCreatedPut, ObjectRemovedDeleteMarkerCreated, "s3:LifecycleExpiration:*"} {
+		if !ValidEventName(name) {
+			t.Errorf("expected %q to be valid", name)
+		}
+	}
+	for _, name := range []string{"", "*", "s3:ObjectRestore:Post", "ObjectCreated:Put", "s3:*"} {
+		if ValidEventName(name) {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	at := time.Date(2025, 6, 1, 12, 30, 0, 0, time.UTC)
+	record := newRecord("eu-west-1", "my-bucket", ObjectCreatedPut, Object{
+		Key:       "uploads/hello world.txt",
+		Size:      42,
+		ETag:      `"abc"`,
+		VersionID: "v1",
+	}, at)
+
+	if record.EventName != "ObjectCreated:Put" {
+		t.Errorf("expected event name without s3: prefix, got %q", record.EventName)
+	}
+	if record.EventTime != "2025-06-01T12:30:00.000Z" {
+		t.Errorf("unexpected event time %q", record.EventTime)
+	}
+	if record.AWSRegion != "eu-west-1" || record.S3.Bucket.Name != "my-bucket" || record.S3.Bucket.ARN != "arn:aws:s3:::my-bucket" {
+		t.Errorf("unexpected bucket information: %+v", record)
+	}
+	obj := record.S3.Object
+	if obj.Key != "uploads%2Fhello+world.txt" {
+		t.Errorf("expected URL-encoded key, got %q", obj.Key)
+	}
+	if obj.Size != 42 || obj.ETag != "abc" || obj.VersionID != "v1" || obj.Sequencer == "" {
+		t.Errorf("unexpected object entity: %+v", obj)
+	}
+}
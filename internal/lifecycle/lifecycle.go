@@ -0,0 +1,286 @@
+package lifecycle
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rule statuses
+const (
+	StatusEnabled  = "Enabled"
+	StatusDisabled = "Disabled"
+)
+
+// maxRules is the maximum number of rules S3 accepts in a lifecycle configuration
+const maxRules = 1000
+
+// Configuration is the S3 LifecycleConfiguration document
+type Configuration struct {
+	XMLName xml.Name `xml:"LifecycleConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Rules   []Rule   `xml:"Rule"`
+}
+
+// Rule is a single lifecycle rule
+type Rule struct {
+	ID                             string                          `xml:"ID,omitempty"`
+	Prefix                         *string                         `xml:"Prefix,omitempty"` // deprecated top-level prefix
+	Filter                         *Filter                         `xml:"Filter,omitempty"`
+	Status                         string                          `xml:"Status"`
+	Expiration                     *Expiration                     `xml:"Expiration,omitempty"`
+	NoncurrentVersionExpiration    *NoncurrentVersionExpiration    `xml:"NoncurrentVersionExpiration,omitempty"`
+	AbortIncompleteMultipartUpload *AbortIncompleteMultipartUpload `xml:"AbortIncompleteMultipartUpload,omitempty"`
+}
+
+// Filter selects the objects a rule applies to
+type Filter struct {
+	Prefix                *string `xml:"Prefix,omitempty"`
+	Tag                   *Tag    `xml:"Tag,omitempty"`
+	ObjectSizeGreaterThan *int64  `xml:"ObjectSizeGreaterThan,omitempty"`
+	ObjectSizeLessThan    *int64  `xml:"ObjectSizeLessThan,omitempty"`
+	And                   *And    `xml:"And,omitempty"`
+}
+
+// And combines several filter conditions that must all match
+type And struct {
+	Prefix                string `xml:"Prefix,omitempty"`
+	Tags                  []Tag  `xml:"Tag"`
+	ObjectSizeGreaterThan *int64 `xml:"ObjectSizeGreaterThan,omitempty"`
+	ObjectSizeLessThan    *int64 `xml:"ObjectSizeLessThan,omitempty"`
+}
+
+// Tag is an object tag condition
+type Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// Expiration expires current object versions
+type Expiration struct {
+	Days                      int    `xml:"Days,omitempty"`
+	Date                      string `xml:"Date,omitempty"`
+	ExpiredObjectDeleteMarker bool   `xml:"ExpiredObjectDeleteMarker,omitempty"`
+}
+
+// NoncurrentVersionExpiration permanently removes non-current versions
+type NoncurrentVersionExpiration struct {
+	NoncurrentDays          int `xml:"NoncurrentDays"`
+	NewerNoncurrentVersions int `xml:"NewerNoncurrentVersions,omitempty"`
+}
+
+// AbortIncompleteMultipartUpload aborts multipart uploads left incomplete
+type AbortIncompleteMultipartUpload struct {
+	DaysAfterInitiation int `xml:"DaysAfterInitiation"`
+}
+
+// Parse decodes and validates a lifecycle configuration document
+func Parse(data []byte) (*Configuration, error) {
+	var cfg Configuration
+	if err := xml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks the configuration against the S3 lifecycle rules
+func (c *Configuration) Validate() error {
+	if len(c.Rules) == 0 {
+		return invalidf("at least one rule is required")
+	}
+	if len(c.Rules) > maxRules {
+		return invalidf("at most %d rules are allowed", maxRules)
+	}
+
+	ids := make(map[string]bool, len(c.Rules))
+	for i, rule := range c.Rules {
+		if len(rule.ID) > 255 {
+			return invalidf("rule %d: ID must be at most 255 characters", i+1)
+		}
+		if rule.ID != "" {
+			if ids[rule.ID] {
+				return invalidf("rule ID %q is not unique", rule.ID)
+			}
+			ids[rule.ID] = true
+		}
+		if err := rule.validate(); err != nil {
+			return invalidf("rule %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// validate checks a single rule
+func (r *Rule) validate() error {
+	if r.Status != StatusEnabled && r.Status != StatusDisabled {
+		return fmt.Errorf("status must be %q or %q", StatusEnabled, StatusDisabled)
+	}
+	if r.Prefix != nil && r.Filter != nil {
+		return fmt.Errorf("prefix and filter cannot both be specified")
+	}
+	if r.Expiration == nil && r.NoncurrentVersionExpiration == nil && r.AbortIncompleteMultipartUpload == nil {
+		return fmt.Errorf("at least one action is required")
+	}
+
+	if f := r.Filter; f != nil {
+		conditions := 0
+		for _, set := range []bool{f.Prefix != nil, f.Tag != nil, f.ObjectSizeGreaterThan != nil || f.ObjectSizeLessThan != nil, f.And != nil} {
+			if set {
+				conditions++
+			}
+		}
+		if conditions > 1 {
+			return fmt.Errorf("filter conditions must be combined with And")
+		}
+		if f.And != nil && f.And.Prefix == "" && len(f.And.Tags) == 0 &&
+			f.And.ObjectSizeGreaterThan == nil && f.And.ObjectSizeLessThan == nil {
+			return fmt.Errorf("And filter requires at least one condition")
+		}
+	}
+
+	if e := r.Expiration; e != nil {
+		set := 0
+		if e.Days != 0 {
+			set++
+		}
+		if e.Date != "" {
+			set++
+		}
+		if e.ExpiredObjectDeleteMarker {
+			set++
+		}
+		if set != 1 {
+			return fmt.Errorf("expiration requires exactly one of Days, Date or ExpiredObjectDeleteMarker")
+		}
+		if e.Days < 0 {
+			return fmt.Errorf("expiration days must be a positive integer")
+		}
+		if e.Date != "" {
+			date, err := time.Parse(time.RFC3339, e.Date)
+			if err != nil {
+				return fmt.Errorf("expiration date must be in ISO 8601 format")
+			}
+			if !date.UTC().Equal(date.UTC().Truncate(24 * time.Hour)) {
+				return fmt.Errorf("expiration date must be at midnight UTC")
+			}
+		}
+	}
+
+	if n := r.NoncurrentVersionExpiration; n != nil {
+		if n.NoncurrentDays <= 0 {
+			return fmt.Errorf("noncurrent days must be a positive integer")
+		}
+		if n.NewerNoncurrentVersions < 0 {
+			return fmt.Errorf("newer noncurrent versions must not be negative")
+		}
+	}
+
+	if a := r.AbortIncompleteMultipartUpload; a != nil {
+		if a.DaysAfterInitiation <= 0 {
+			return fmt.Errorf("days after initiation must be a positive integer")
+		}
+		// Incomplete uploads have no tags or size yet
+		if f := r.Filter; f != nil && (f.Tag != nil || f.ObjectSizeGreaterThan != nil || f.ObjectSizeLessThan != nil ||
+			(f.And != nil && (len(f.And.Tags) > 0 || f.And.ObjectSizeGreaterThan != nil || f.And.ObjectSizeLessThan != nil))) {
+			return fmt.Errorf("AbortIncompleteMultipartUpload cannot be specified with tag or size filters")
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether the rule's filter selects an object
+func (r *Rule) Matches(key string, size int64, tags map[string]string) bool {
+	if r.Prefix != nil {
+		return strings.HasPrefix(key, *r.Prefix)
+	}
+
+	f := r.Filter
+	if f == nil {
+		return true
+	}
+
+	if f.Prefix != nil && !strings.HasPrefix(key, *f.Prefix) {
+		return false
+	}
+	if f.Tag != nil && !hasTag(tags, *f.Tag) {
+		return false
+	}
+	if !matchesSize(size, f.ObjectSizeGreaterThan, f.ObjectSizeLessThan) {
+		return false
+	}
+
+	if a := f.And; a != nil {
+		if !strings.HasPrefix(key, a.Prefix) {
+			return false
+		}
+		for _, tag := range a.Tags {
+			if !hasTag(tags, tag) {
+				return false
+			}
+		}
+		if !matchesSize(size, a.ObjectSizeGreaterThan, a.ObjectSizeLessThan) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// KeyPrefix returns the prefix of every key the rule's filter can select
+func (r *Rule) KeyPrefix() string {
+	switch f := r.Filter; {
+	case r.Prefix != nil:
+		return *r.Prefix
+	case f == nil:
+		return ""
+	case f.Prefix != nil:
+		return *f.Prefix
+	case f.And != nil:
+		return f.And.Prefix
+	}
+	return ""
+}
+
+// hasTag reports whether tags contains the given key/value pair
+func hasTag(tags map[string]string, tag Tag) bool {
+	value, ok := tags[tag.Key]
+	return ok && value == tag.Value
+}
+
+// matchesSize checks optional exclusive size bounds
+func matchesSize(size int64, greaterThan, lessThan *int64) bool {
+	if greaterThan != nil && size <= *greaterThan {
+		return false
+	}
+	if lessThan != nil && size >= *lessThan {
+		return false
+	}
+	return true
+}
+
+// expiresAt returns when an object created at t expires after the given number of days.
+// Like S3, the result is rounded up to the next midnight UTC.
+func expiresAt(t time.Time, days int) time.Time {
+	expiry := t.UTC().Add(time.Duration(days) * 24 * time.Hour)
+	midnight := expiry.Truncate(24 * time.Hour)
+	if midnight.Equal(expiry) {
+		return expiry
+	}
+	return midnight.Add(24 * time.Hour)
+}
+
+// Errors
+var (
+	ErrMalformed = fmt.Errorf("malformed lifecycle configuration")
+	ErrInvalid   = fmt.Errorf("invalid lifecycle configuration")
+)
+
+// invalidf wraps a validation message in ErrInvalid
+func invalidf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
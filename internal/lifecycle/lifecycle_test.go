@@ -0,0 +1,169 @@
+package lifecycle
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse_Valid(t *testing.T) {
+	data := []byte(`<LifecycleConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Rule>
+    <ID>expire-exports</ID>
+    <Filter><Prefix>exports/</Prefix></Filter>
+    <Status>Enabled</Status>
+    <Expiration><Days>7</Days></Expiration>
+    <AbortIncompleteMultipartUpload><DaysAfterInitiation>2</DaysAfterInitiation></AbortIncompleteMultipartUpload>
+  </Rule>
+  <Rule>
+    <ID>old-versions</ID>
+    <Filter><And><Prefix>docs/</Prefix><Tag><Key>retention</Key><Value>short</Value></Tag></And></Filter>
+    <Status>Disabled</Status>
+    <NoncurrentVersionExpiration><NoncurrentDays>30</NoncurrentDays></NoncurrentVersionExpiration>
+  </Rule>
+</LifecycleConfiguration>`)
+
+	cfg, err := Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.Rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(cfg.Rules))
+	}
+	if cfg.Rules[0].Expiration.Days != 7 || *cfg.Rules[0].Filter.Prefix != "exports/" {
+		t.Errorf("unexpected first rule: %+v", cfg.Rules[0])
+	}
+	and := cfg.Rules[1].Filter.And
+	if and == nil || and.Prefix != "docs/" || len(and.Tags) != 1 || and.Tags[0].Key != "retention" {
+		t.Errorf("unexpected And filter: %+v", and)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		xml  string
+		err  error
+	}{
+		{
+			name: "malformed xml",
+			xml:  `<LifecycleConfiguration><Rule>`,
+			err:  ErrMalformed,
+		},
+		{
+			name: "no rules",
+			xml:  `<LifecycleConfiguration></LifecycleConfiguration>`,
+			err:  ErrInvalid,
+		},
+		{
+			name: "invalid status",
+			xml:  `<LifecycleConfiguration><Rule><Status>On</Status><Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`,
+			err:  ErrInvalid,
+		},
+		{
+			name: "no action",
+			xml:  `<LifecycleConfiguration><Rule><Status>Enabled</Status></Rule></LifecycleConfiguration>`,
+			err:  ErrInvalid,
+		},
+		{
+			name: "days and date",
+			xml:  `<LifecycleConfiguration><Rule><Status>Enabled</Status><Expiration><Days>1</Days><Date>2030-01-01T00:00:00Z</Date></Expiration></Rule></LifecycleConfiguration>`,
+			err:  ErrInvalid,
+		},
+		{
+			name: "date not at midnight",
+			xml:  `<LifecycleConfiguration><Rule><Status>Enabled</Status><Expiration><Date>2030-01-01T10:00:00Z</Date></Expiration></Rule></LifecycleConfiguration>`,
+			err:  ErrInvalid,
+		},
+		{
+			name: "duplicate ids",
+			xml: `<LifecycleConfiguration>
+				<Rule><ID>a</ID><Status>Enabled</Status><Expiration><Days>1</Days></Expiration></Rule>
+				<Rule><ID>a</ID><Status>Enabled</Status><Expiration><Days>2</Days></Expiration></Rule>
+			</LifecycleConfiguration>`,
+			err: ErrInvalid,
+		},
+		{
+			name: "filter conditions without And",
+			xml:  `<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter><Prefix>a/</Prefix><Tag><Key>k</Key><Value>v</Value></Tag></Filter><Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`,
+			err:  ErrInvalid,
+		},
+		{
+			name: "zero noncurrent days",
+			xml:  `<LifecycleConfiguration><Rule><Status>Enabled</Status><NoncurrentVersionExpiration><NoncurrentDays>0</NoncurrentDays></NoncurrentVersionExpiration></Rule></LifecycleConfiguration>`,
+			err:  ErrInvalid,
+		},
+		{
+			name: "abort upload with tag filter",
+			xml:  `<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter><Tag><Key>k</Key><Value>v</Value></Tag></Filter><AbortIncompleteMultipartUpload><DaysAfterInitiation>1</DaysAfterInitiation></AbortIncompleteMultipartUpload></Rule></LifecycleConfiguration>`,
+			err:  ErrInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.xml))
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	prefix := "tmp/"
+	minSize := int64(10)
+
+	tests := []struct {
+		name  string
+		rule  Rule
+		key   string
+		size  int64
+		tags  map[string]string
+		match bool
+	}{
+		{"no filter", Rule{}, "anything", 1, nil, true},
+		{"legacy prefix match", Rule{Prefix: &prefix}, "tmp/a", 1, nil, true},
+		{"legacy prefix mismatch", Rule{Prefix: &prefix}, "docs/a", 1, nil, false},
+		{"filter prefix", Rule{Filter: &Filter{Prefix: &prefix}}, "tmp/a", 1, nil, true},
+		{"tag match", Rule{Filter: &Filter{Tag: &Tag{"retention", "short"}}}, "a", 1, map[string]string{"retention": "short"}, true},
+		{"tag mismatch", Rule{Filter: &Filter{Tag: &Tag{"retention", "short"}}}, "a", 1, map[string]string{"retention": "long"}, false},
+		{"tag missing", Rule{Filter: &Filter{Tag: &Tag{"retention", "short"}}}, "a", 1, nil, false},
+		{"size greater than", Rule{Filter: &Filter{ObjectSizeGreaterThan: &minSize}}, "a", 11, nil, true},
+		{"size not greater than", Rule{Filter: &Filter{ObjectSizeGreaterThan: &minSize}}, "a", 10, nil, false},
+		{
+			"and all match",
+			Rule{Filter: &Filter{And: &And{Prefix: "tmp/", Tags: []Tag{{"a", "1"}, {"b", "2"}}}}},
+			"tmp/x", 1, map[string]string{"a": "1", "b": "2"}, true,
+		},
+		{
+			"and one tag missing",
+			Rule{Filter: &Filter{And: &And{Prefix: "tmp/", Tags: []Tag{{"a", "1"}, {"b", "2"}}}}},
+			"tmp/x", 1, map[string]string{"a": "1"}, false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Matches(tt.key, tt.size, tt.tags); got != tt.match {
+				t.Errorf("expected match=%v, got %v", tt.match, got)
+			}
+		})
+	}
+}
+
+func TestExpiresAt(t *testing.T) {
+	created := time.Date(2025, 3, 10, 15, 30, 0, 0, time.UTC)
+
+	got := expiresAt(created, 1)
+	want := time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	midnight := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	if got := expiresAt(midnight, 2); !got.Equal(midnight.Add(48 * time.Hour)) {
+		t.Errorf("expected exact midnight to be kept, got %v", got)
+	}
+}
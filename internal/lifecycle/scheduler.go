@@ -0,0 +1,318 @@
+package lifecycle
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// Action types reported by the scheduler
+const (
+	ActionExpire                  = "Expiration"
+	ActionExpireNoncurrent        = "NoncurrentVersionExpiration"
+	ActionRemoveExpiredDeleteMark = "ExpiredObjectDeleteMarker"
+	ActionAbortUpload             = "AbortIncompleteMultipartUpload"
+)
+
+// Action is a single deletion performed (or planned, in dry-run mode) by a lifecycle run
+type Action struct {
+	Rule      string
+	Type      string
+	Key       string
+	VersionID string
+	UploadID  string // set for AbortIncompleteMultipartUpload actions
+}
+
+// Report summarizes a lifecycle run
+type Report struct {
+	StartedAt time.Time
+	DryRun    bool
+	Actions   []Action
+	Errors    []error
+}
+
+// Scheduler periodically applies the bucket lifecycle configuration
+type Scheduler struct {
+	store    storage.Backend
+	interval time.Duration
+	dryRun   bool
+	now      func() time.Time
+
+	onDelete func(Action, *storage.DeleteResult)
+
+	mu         sync.Mutex
+	lastReport *Report
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewScheduler creates a lifecycle scheduler running every interval.
+// In dry-run mode the scheduler only reports what it would delete.
+func NewScheduler(store storage.Backend, interval time.Duration, dryRun bool) *Scheduler {
+	return &Scheduler{
+		store:    store,
+		interval: interval,
+		dryRun:   dryRun,
+		now:      time.Now,
+	}
+}
+
+// OnDelete registers a callback invoked after each object version the scheduler deletes.
+// It must be called before Start.
+func (s *Scheduler) OnDelete(fn func(Action, *storage.DeleteResult)) {
+	s.onDelete = fn
+}
+
+// Start runs the scheduler in the background until Stop is called
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil || s.interval <= 0 {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := s.Run(); err != nil {
+					log.Printf("Lifecycle: run failed: %v", err)
+				}
+			}
+		}
+	}(s.stop, s.done)
+}
+
+// Stop stops the background scheduler and waits for a running pass to finish
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// LastReport returns the report of the most recent run, or nil if none ran yet
+func (s *Scheduler) LastReport() *Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastReport
+}
+
+// Run applies the lifecycle configuration once and reports what was deleted
+func (s *Scheduler) Run() (*Report, error) {
+	now := s.now()
+	report := &Report{StartedAt: now, DryRun: s.dryRun}
+
+	data, err := s.store.GetBucketConfig("lifecycle")
+	if err != nil {
+		if err == storage.ErrNoSuchConfig {
+			return report, nil
+		}
+		return nil, err
+	}
+
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the keys under the prefix of a rule are walked
+	for _, prefix := range rulePrefixes(cfg) {
+		versions, err := s.store.ListObjectVersions(prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		// Versions are sorted by key, latest first: evaluate each key's history at once
+		for start := 0; start < len(versions); {
+			end := start + 1
+			for end < len(versions) && versions[end].Key == versions[start].Key {
+				end++
+			}
+			s.evaluateKey(cfg, versions[start:end], now, report)
+			start = end
+		}
+	}
+
+	if err := s.abortIncompleteUploads(cfg, now, report); err != nil {
+		report.Errors = append(report.Errors, err)
+	}
+
+	for _, action := range report.Actions {
+		prefix := "Lifecycle:"
+		if report.DryRun {
+			prefix = "Lifecycle (dry run):"
+		}
+		if action.UploadID != "" {
+			log.Printf("%s %s %s (upload %q, rule %q)", prefix, action.Type, action.Key, action.UploadID, action.Rule)
+			continue
+		}
+		log.Printf("%s %s %s (version %q, rule %q)", prefix, action.Type, action.Key, action.VersionID, action.Rule)
+	}
+	log.Printf("Lifecycle: run complete, %d action(s), %d error(s)", len(report.Actions), len(report.Errors))
+
+	s.mu.Lock()
+	s.lastReport = report
+	s.mu.Unlock()
+
+	return report, nil
+}
+
+// rulePrefixes returns the key prefixes of the enabled expiration rules, leaving out
+// prefixes covered by a shorter one so that no key is listed twice
+func rulePrefixes(cfg *Configuration) []string {
+	var prefixes []string
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if rule.Status == StatusEnabled && (rule.Expiration != nil || rule.NoncurrentVersionExpiration != nil) {
+			prefixes = append(prefixes, rule.KeyPrefix())
+		}
+	}
+	sort.Strings(prefixes)
+
+	var walked []string
+	for _, prefix := range prefixes {
+		if len(walked) == 0 || !strings.HasPrefix(prefix, walked[len(walked)-1]) {
+			walked = append(walked, prefix)
+		}
+	}
+	return walked
+}
+
+// evaluateKey applies every enabled rule to the versions of a single key (latest first)
+func (s *Scheduler) evaluateKey(cfg *Configuration, versions []storage.Object, now time.Time, report *Report) {
+	key := versions[0].Key
+
+	// Folder markers are directories, not objects with a lifecycle of their own
+	if strings.HasSuffix(key, "/") {
+		return
+	}
+
+	latest := versions[0]
+	hasCurrent := latest.IsLatest && !latest.IsDeleteMarker
+	removed := make(map[string]bool)
+
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if rule.Status != StatusEnabled {
+			continue
+		}
+
+		if exp := rule.Expiration; exp != nil && !removed[latest.VersionID] {
+			switch {
+			case hasCurrent && rule.Matches(key, latest.Size, latest.Tags) && expired(exp, latest.LastModified, now):
+				s.apply(report, rule, ActionExpire, key, "")
+				removed[latest.VersionID] = true
+			case exp.ExpiredObjectDeleteMarker && latest.IsDeleteMarker && len(versions) == 1 && rule.Matches(key, 0, nil):
+				// A delete marker with no versions behind it is no longer useful
+				s.apply(report, rule, ActionRemoveExpiredDeleteMark, key, latest.VersionID)
+				removed[latest.VersionID] = true
+			}
+		}
+
+		if nve := rule.NoncurrentVersionExpiration; nve != nil {
+			for j := 1; j < len(versions); j++ {
+				v := versions[j]
+				if removed[v.VersionID] || j <= nve.NewerNoncurrentVersions || !rule.Matches(key, v.Size, v.Tags) {
+					continue
+				}
+				// A version becomes non-current when its successor is created
+				if !now.Before(expiresAt(versions[j-1].LastModified, nve.NoncurrentDays)) {
+					s.apply(report, rule, ActionExpireNoncurrent, key, v.VersionID)
+					removed[v.VersionID] = true
+				}
+			}
+		}
+	}
+}
+
+// abortIncompleteUploads aborts multipart uploads initiated longer ago than
+// the first matching enabled AbortIncompleteMultipartUpload rule allows
+func (s *Scheduler) abortIncompleteUploads(cfg *Configuration, now time.Time, report *Report) error {
+	var rules []*Rule
+	for i := range cfg.Rules {
+		r := &cfg.Rules[i]
+		if r.Status == StatusEnabled && r.AbortIncompleteMultipartUpload != nil {
+			rules = append(rules, r)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	uploads, err := s.store.ListMultipartUploads("")
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range uploads {
+		for _, rule := range rules {
+			// Uploads have neither a size nor tags until they complete
+			if !rule.Matches(upload.Key, 0, nil) ||
+				now.Before(expiresAt(upload.Initiated, rule.AbortIncompleteMultipartUpload.DaysAfterInitiation)) {
+				continue
+			}
+
+			action := Action{Rule: rule.ID, Type: ActionAbortUpload, Key: upload.Key, UploadID: upload.ID}
+			if !s.dryRun {
+				if err := s.store.AbortMultipartUpload(upload.Key, upload.ID); err != nil && err != storage.ErrNoSuchUpload {
+					report.Errors = append(report.Errors, fmt.Errorf("%s %s: %w", ActionAbortUpload, upload.ID, err))
+					break
+				}
+			}
+			report.Actions = append(report.Actions, action)
+			break
+		}
+	}
+	return nil
+}
+
+// expired reports whether an Expiration action applies to an object last modified at t
+func expired(exp *Expiration, t, now time.Time) bool {
+	if exp.Days > 0 {
+		return !now.Before(expiresAt(t, exp.Days))
+	}
+	if exp.Date != "" {
+		date, err := time.Parse(time.RFC3339, exp.Date)
+		return err == nil && !now.Before(date)
+	}
+	return false
+}
+
+// apply performs (or, in dry-run mode, records) a lifecycle deletion
+func (s *Scheduler) apply(report *Report, rule *Rule, actionType, key, versionID string) {
+	action := Action{Rule: rule.ID, Type: actionType, Key: key, VersionID: versionID}
+
+	if !s.dryRun {
+		result, err := s.store.DeleteObjectVersion(key, versionID)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("%s %s: %w", actionType, key, err))
+			return
+		}
+		if s.onDelete != nil {
+			s.onDelete(action, result)
+		}
+	}
+
+	report.Actions = append(report.Actions, action)
+}
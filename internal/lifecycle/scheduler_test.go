@@ -0,0 +1,331 @@
+package lifecycle
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// newTestScheduler creates a scheduler over a fresh storage with the given lifecycle XML
+func newTestScheduler(t *testing.T, lifecycleXML string, dryRun bool) (*Scheduler, *storage.Storage) {
+	t.Helper()
+
+	store, err := storage.NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.PutBucketConfig("lifecycle", []byte(lifecycleXML)); err != nil {
+		t.Fatalf("failed to store lifecycle configuration: %v", err)
+	}
+
+	return NewScheduler(store, time.Hour, dryRun), store
+}
+
+// putObject stores a small object or fails the test
+func putObject(t *testing.T, store *storage.Storage, key string) *storage.Object {
+	t.Helper()
+
+	obj, err := store.PutObject(key, "", strings.NewReader("data"))
+	if err != nil {
+		t.Fatalf("PutObject(%q) failed: %v", key, err)
+	}
+	return obj
+}
+
+func TestScheduler_NoConfiguration(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	report, err := NewScheduler(store, time.Hour, false).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.Actions) != 0 {
+		t.Errorf("expected no actions, got %+v", report.Actions)
+	}
+}
+
+func TestScheduler_ExpiresMatchingObjects(t *testing.T) {
+	sched, store := newTestScheduler(t, `<LifecycleConfiguration><Rule>
+		<ID>tmp</ID><Filter><Prefix>exports/</Prefix></Filter><Status>Enabled</Status>
+		<Expiration><Days>3</Days></Expiration>
+	</Rule></LifecycleConfiguration>`, false)
+
+	putObject(t, store, "exports/a.csv")
+	putObject(t, store, "keep/b.csv")
+
+	// Nothing is old enough yet
+	report, err := sched.Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(report.Actions) != 0 {
+		t.Fatalf("expected no actions for fresh objects, got %+v", report.Actions)
+	}
+
+	sched.now = func() time.Time { return time.Now().Add(5 * 24 * time.Hour) }
+	report, err = sched.Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if len(report.Actions) != 1 || report.Actions[0].Key != "exports/a.csv" || report.Actions[0].Type != ActionExpire {
+		t.Fatalf("expected exports/a.csv to expire, got %+v", report.Actions)
+	}
+	if _, err := store.HeadObject("exports/a.csv"); err != storage.ErrNotFound {
+		t.Errorf("expected expired object to be deleted, got %v", err)
+	}
+	if _, err := store.HeadObject("keep/b.csv"); err != nil {
+		t.Errorf("expected non-matching object to be kept, got %v", err)
+	}
+	if sched.LastReport() != report {
+		t.Error("expected LastReport to return the latest report")
+	}
+}
+
+func TestScheduler_DryRun(t *testing.T) {
+	sched, store := newTestScheduler(t, `<LifecycleConfiguration><Rule>
+		<Status>Enabled</Status><Expiration><Days>1</Days></Expiration>
+	</Rule></LifecycleConfiguration>`, true)
+
+	putObject(t, store, "a.txt")
+	sched.now = func() time.Time { return time.Now().Add(3 * 24 * time.Hour) }
+
+	report, err := sched.Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !report.DryRun || len(report.Actions) != 1 {
+		t.Fatalf("expected one dry-run action, got %+v", report)
+	}
+	if _, err := store.HeadObject("a.txt"); err != nil {
+		t.Errorf("dry run must not delete objects, got %v", err)
+	}
+}
+
+func TestScheduler_DisabledRule(t *testing.T) {
+	sched, store := newTestScheduler(t, `<LifecycleConfiguration><Rule>
+		<Status>Disabled</Status><Expiration><Days>1</Days></Expiration>
+	</Rule></LifecycleConfiguration>`, false)
+
+	putObject(t, store, "a.txt")
+	sched.now = func() time.Time { return time.Now().Add(3 * 24 * time.Hour) }
+
+	report, _ := sched.Run()
+	if len(report.Actions) != 0 {
+		t.Errorf("expected disabled rule to do nothing, got %+v", report.Actions)
+	}
+}
+
+func TestScheduler_NoncurrentVersionExpiration(t *testing.T) {
+	sched, store := newTestScheduler(t, `<LifecycleConfiguration><Rule>
+		<Status>Enabled</Status>
+		<NoncurrentVersionExpiration><NoncurrentDays>1</NoncurrentDays><NewerNoncurrentVersions>1</NewerNoncurrentVersions></NoncurrentVersionExpiration>
+	</Rule></LifecycleConfiguration>`, false)
+	_ = store.SetVersioning(storage.VersioningEnabled)
+
+	v1 := putObject(t, store, "doc.txt")
+	v2 := putObject(t, store, "doc.txt")
+	v3 := putObject(t, store, "doc.txt")
+
+	sched.now = func() time.Time { return time.Now().Add(3 * 24 * time.Hour) }
+	report, err := sched.Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	// The newest non-current version (v2) is retained, older ones are removed
+	if len(report.Actions) != 1 || report.Actions[0].VersionID != v1.VersionID {
+		t.Fatalf("expected only v1 to be removed, got %+v", report.Actions)
+	}
+	if _, err := store.HeadObjectVersion("doc.txt", v1.VersionID); err != storage.ErrNoSuchVersion {
+		t.Errorf("expected v1 to be removed, got %v", err)
+	}
+	for _, id := range []string{v2.VersionID, v3.VersionID} {
+		if _, err := store.HeadObjectVersion("doc.txt", id); err != nil {
+			t.Errorf("expected version %s to be kept, got %v", id, err)
+		}
+	}
+}
+
+func TestScheduler_ExpiredObjectDeleteMarker(t *testing.T) {
+	sched, store := newTestScheduler(t, `<LifecycleConfiguration>
+		<Rule><ID>markers</ID><Status>Enabled</Status><Expiration><ExpiredObjectDeleteMarker>true</ExpiredObjectDeleteMarker></Expiration></Rule>
+		<Rule><ID>versions</ID><Status>Enabled</Status><NoncurrentVersionExpiration><NoncurrentDays>1</NoncurrentDays></NoncurrentVersionExpiration></Rule>
+	</LifecycleConfiguration>`, false)
+	_ = store.SetVersioning(storage.VersioningEnabled)
+
+	putObject(t, store, "gone.txt")
+	_, _ = store.DeleteObjectVersion("gone.txt", "")
+
+	sched.now = func() time.Time { return time.Now().Add(3 * 24 * time.Hour) }
+
+	// First pass removes the non-current version, leaving a lone delete marker
+	if _, err := sched.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// Second pass cleans up the expired delete marker
+	report, err := sched.Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(report.Actions) != 1 || report.Actions[0].Type != ActionRemoveExpiredDeleteMark {
+		t.Fatalf("expected delete marker removal, got %+v", report.Actions)
+	}
+
+	versions, _ := store.ListObjectVersions("gone.txt")
+	if len(versions) != 0 {
+		t.Errorf("expected no versions left, got %+v", versions)
+	}
+}
+
+func TestScheduler_StartStop(t *testing.T) {
+	sched, _ := newTestScheduler(t, `<LifecycleConfiguration><Rule>
+		<Status>Enabled</Status><Expiration><Days>1</Days></Expiration>
+	</Rule></LifecycleConfiguration>`, false)
+	sched.interval = 10 * time.Millisecond
+
+	sched.Start()
+	deadline := time.Now().Add(2 * time.Second)
+	for sched.LastReport() == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	sched.Stop()
+
+	if sched.LastReport() == nil {
+		t.Error("expected background run to produce a report")
+	}
+
+	// Stopping twice is harmless
+	sched.Stop()
+}
+
+func TestScheduler_AbortIncompleteUploads(t *testing.T) {
+	sched, store := newTestScheduler(t, `<LifecycleConfiguration><Rule>
+		<ID>uploads</ID><Filter><Prefix>tmp/</Prefix></Filter><Status>Enabled</Status>
+		<AbortIncompleteMultipartUpload><DaysAfterInitiation>1</DaysAfterInitiation></AbortIncompleteMultipartUpload>
+	</Rule></LifecycleConfiguration>`, false)
+
+	stale, err := store.CreateMultipartUpload("tmp/stale.bin", storage.PutOptions{})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	if _, err := store.UploadPart("tmp/stale.bin", stale.ID, 1, strings.NewReader("partial"), nil); err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+	other, _ := store.CreateMultipartUpload("keep/other.bin", storage.PutOptions{})
+	putObject(t, store, "tmp/complete.txt")
+
+	sched.now = func() time.Time { return time.Now().Add(3 * 24 * time.Hour) }
+	report, err := sched.Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if len(report.Actions) != 1 || report.Actions[0].Type != ActionAbortUpload ||
+		report.Actions[0].UploadID != stale.ID || report.Actions[0].Key != "tmp/stale.bin" {
+		t.Fatalf("expected the stale upload to be aborted, got %+v", report.Actions)
+	}
+	uploads, _ := store.ListMultipartUploads("")
+	if len(uploads) != 1 || uploads[0].ID != other.ID {
+		t.Errorf("expected only the upload outside the prefix to remain, got %+v", uploads)
+	}
+	if _, err := store.HeadObject("tmp/complete.txt"); err != nil {
+		t.Errorf("expected completed object to be kept, got %v", err)
+	}
+}
+
+func TestScheduler_MemoryBackend(t *testing.T) {
+	store := storage.NewMemory()
+	_ = store.PutBucketConfig("lifecycle", []byte(`<LifecycleConfiguration><Rule>
+		<Status>Enabled</Status><Expiration><Days>1</Days></Expiration>
+	</Rule></LifecycleConfiguration>`))
+	_, _ = store.PutObject("a.txt", "", strings.NewReader("data"))
+
+	sched := NewScheduler(store, time.Hour, false)
+	sched.now = func() time.Time { return time.Now().Add(3 * 24 * time.Hour) }
+	if _, err := sched.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if _, err := store.HeadObject("a.txt"); err != storage.ErrNotFound {
+		t.Errorf("expected object to be expired, got %v", err)
+	}
+}
+
+func TestScheduler_TagFilter(t *testing.T) {
+	sched, store := newTestScheduler(t, `<LifecycleConfiguration><Rule>
+		<Filter><Tag><Key>retention</Key><Value>short</Value></Tag></Filter>
+		<Status>Enabled</Status><Expiration><Days>1</Days></Expiration>
+	</Rule></LifecycleConfiguration>`, false)
+
+	if _, err := store.PutObjectWithOptions("short.txt", strings.NewReader("data"), storage.PutOptions{
+		Tags: map[string]string{"retention": "short"},
+	}); err != nil {
+		t.Fatalf("PutObjectWithOptions failed: %v", err)
+	}
+	putObject(t, store, "untagged.txt")
+
+	sched.now = func() time.Time { return time.Now().Add(3 * 24 * time.Hour) }
+	report, err := sched.Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if len(report.Actions) != 1 || report.Actions[0].Key != "short.txt" {
+		t.Fatalf("expected only the tagged object to expire, got %+v", report.Actions)
+	}
+	if _, err := store.HeadObject("untagged.txt"); err != nil {
+		t.Errorf("expected untagged object to be kept, got %v", err)
+	}
+}
+
+func TestScheduler_OnDelete(t *testing.T) {
+	sched, store := newTestScheduler(t, `<LifecycleConfiguration><Rule>
+		<Status>Enabled</Status><Expiration><Days>1</Days></Expiration>
+	</Rule></LifecycleConfiguration>`, false)
+	_ = store.SetVersioning(storage.VersioningEnabled)
+
+	var deleted []Action
+	var results []*storage.DeleteResult
+	sched.OnDelete(func(action Action, result *storage.DeleteResult) {
+		deleted = append(deleted, action)
+		results = append(results, result)
+	})
+
+	putObject(t, store, "a.txt")
+	sched.now = func() time.Time { return time.Now().Add(3 * 24 * time.Hour) }
+	if _, err := sched.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if len(deleted) != 1 || deleted[0].Key != "a.txt" {
+		t.Fatalf("expected callback for a.txt, got %+v", deleted)
+	}
+	if !results[0].DeleteMarker {
+		t.Errorf("expected expiration in a versioned bucket to create a delete marker, got %+v", results[0])
+	}
+}
+
+func TestRulePrefixes(t *testing.T) {
+	cfg, err := Parse([]byte(`<LifecycleConfiguration>
+		<Rule><Filter><Prefix>logs/app/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>1</Days></Expiration></Rule>
+		<Rule><Filter><Prefix>logs/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>7</Days></Expiration></Rule>
+		<Rule><Filter><And><Prefix>tmp/</Prefix><Tag><Key>a</Key><Value>b</Value></Tag></And></Filter><Status>Enabled</Status>
+			<NoncurrentVersionExpiration><NoncurrentDays>1</NoncurrentDays></NoncurrentVersionExpiration></Rule>
+		<Rule><Status>Disabled</Status><Expiration><Days>1</Days></Expiration></Rule>
+		<Rule><Status>Enabled</Status><AbortIncompleteMultipartUpload><DaysAfterInitiation>1</DaysAfterInitiation></AbortIncompleteMultipartUpload></Rule>
+	</LifecycleConfiguration>`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// Disabled rules and upload-only rules do not walk the whole bucket
+	if got := strings.Join(rulePrefixes(cfg), ","); got != "logs/,tmp/" {
+		t.Errorf("rulePrefixes = %q, expected logs/,tmp/", got)
+	}
+}
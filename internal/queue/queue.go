@@ -0,0 +1,331 @@
+// Package queue implements the durable on-disk queues of the background deliveries,
+// such as event notifications and replication, retried with exponential backoff
+package queue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// Delivery settings
+const (
+	MaxAttempts    = 10
+	initialBackoff = time.Second
+	maxBackoff     = 10 * time.Minute
+	failedDirName  = "failed"
+)
+
+// Entry is a queued value and the state of its delivery
+type Entry[T any] struct {
+	ID          string    `json:"id"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+	Value       T         `json:"value"`
+
+	name string // file name in the queue directory, without extension
+}
+
+// Options configure a queue
+type Options[T any] struct {
+	// Name prefixes the log messages of the queue
+	Name string
+	// Process delivers an entry. The entry is removed when it returns nil, retried
+	// when it returns an error, and given up on at once when the error is Permanent.
+	Process func(*Entry[T]) error
+	// Queued is called, with the queue locked, once an entry is written
+	Queued func(*Entry[T]) error
+	// Done is called, with the queue locked, once an entry is delivered or, with the
+	// last error, given up on
+	Done func(*Entry[T], error)
+}
+
+// Queue persists entries as JSON files in a directory until they are delivered.
+// Entries given up on are moved to its failed subdirectory, so deliveries survive
+// restarts and outages of their destination.
+type Queue[T any] struct {
+	dir  string
+	opts Options[T]
+
+	now     func() time.Time
+	backoff func(attempts int) time.Duration
+
+	mu     sync.Mutex // serializes the changes of entries
+	worker sync.Mutex // guards stop and done
+	wake   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// New creates a queue persisting its entries in dir
+func New[T any](dir string, opts Options[T]) (*Queue[T], error) {
+	if err := os.MkdirAll(filepath.Join(dir, failedDirName), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	return &Queue[T]{
+		dir:     dir,
+		opts:    opts,
+		now:     time.Now,
+		backoff: ExponentialBackoff,
+		wake:    make(chan struct{}, 1),
+	}, nil
+}
+
+// ExponentialBackoff returns the delay before the next delivery attempt
+func ExponentialBackoff(attempts int) time.Duration {
+	delay := initialBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// SetBackoff replaces the delay before the next delivery attempt.
+// It must be called before Start.
+func (q *Queue[T]) SetBackoff(fn func(attempts int) time.Duration) {
+	q.backoff = fn
+}
+
+// permanentError is an error not worth retrying
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a delivery error that gives up on the entry without further attempts
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Add queues value as a new entry. Entries are delivered in the order they are added.
+func (q *Queue[T]) Add(value T) error {
+	id := newEntryID(q.now())
+	return q.put(id, id, value)
+}
+
+// Put queues value under name, replacing the entry queued or given up on under that
+// name. An entry being delivered while it is replaced is left for the new one.
+func (q *Queue[T]) Put(name string, value T) error {
+	return q.put(name, newEntryID(q.now()), value)
+}
+
+// put writes a new entry and wakes the worker
+func (q *Queue[T]) put(name, id string, value T) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry := &Entry[T]{ID: id, NextAttempt: q.now(), Value: value, name: name}
+	if err := writeEntry(q.path(name), entry); err != nil {
+		return err
+	}
+	_ = os.Remove(q.failedPath(name))
+	if q.opts.Queued != nil {
+		if err := q.opts.Queued(entry); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Has reports whether an entry is queued under name
+func (q *Queue[T]) Has(name string) bool {
+	_, err := os.Stat(q.path(name))
+	return err == nil
+}
+
+// Len returns the number of entries waiting for delivery
+func (q *Queue[T]) Len() int {
+	files, _ := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	return len(files)
+}
+
+// Failed returns the number of entries given up on
+func (q *Queue[T]) Failed() int {
+	files, _ := filepath.Glob(filepath.Join(q.dir, failedDirName, "*.json"))
+	return len(files)
+}
+
+// Start delivers queued entries in the background until Stop is called
+func (q *Queue[T]) Start() {
+	q.worker.Lock()
+	defer q.worker.Unlock()
+
+	if q.stop != nil {
+		return
+	}
+	q.stop = make(chan struct{})
+	q.done = make(chan struct{})
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		for {
+			wait := q.ProcessDue()
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-q.wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+	}(q.stop, q.done)
+}
+
+// Stop stops background delivery and waits for an in-flight delivery to finish.
+// Undelivered entries stay queued on disk.
+func (q *Queue[T]) Stop() {
+	q.worker.Lock()
+	stop, done := q.stop, q.done
+	q.stop, q.done = nil, nil
+	q.worker.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// ProcessDue delivers every queued entry that is due and returns how long to wait
+// before the next one becomes due. Entries written by another process, such as the
+// command line, are picked up within a minute.
+func (q *Queue[T]) ProcessDue() time.Duration {
+	wait := time.Minute
+
+	files, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		log.Printf("%s: failed to list queue: %v", q.opts.Name, err)
+		return wait
+	}
+	sort.Strings(files) // entry IDs are time-ordered
+
+	for _, file := range files {
+		entry, err := readEntry[T](file)
+		if err != nil {
+			log.Printf("%s: dropping unreadable queue entry %s: %v", q.opts.Name, filepath.Base(file), err)
+			_ = os.Remove(file)
+			continue
+		}
+
+		now := q.now()
+		if now.Before(entry.NextAttempt) {
+			wait = min(wait, entry.NextAttempt.Sub(now))
+			continue
+		}
+
+		err = q.opts.Process(entry)
+		q.finish(entry, err)
+		if err != nil && !entry.NextAttempt.IsZero() {
+			wait = min(wait, entry.NextAttempt.Sub(now))
+		}
+	}
+
+	return max(wait, 0)
+}
+
+// finish removes a delivered entry, or schedules its retry. An entry replaced
+// meanwhile is left for the newer one to be delivered.
+func (q *Queue[T]) finish(entry *Entry[T], deliveryErr error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	file := q.path(entry.name)
+	if current, err := readEntry[T](file); err != nil || current.ID != entry.ID {
+		return
+	}
+
+	if deliveryErr == nil {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Printf("%s: failed to remove delivered entry %s: %v", q.opts.Name, entry.ID, err)
+		}
+		if q.opts.Done != nil {
+			q.opts.Done(entry, nil)
+		}
+		return
+	}
+
+	entry.Attempts++
+	entry.LastError = deliveryErr.Error()
+	var permanent *permanentError
+	if entry.Attempts >= MaxAttempts || errors.As(deliveryErr, &permanent) {
+		entry.NextAttempt = time.Time{}
+		if err := writeEntry(q.failedPath(entry.name), entry); err == nil {
+			_ = os.Remove(file)
+		}
+		if q.opts.Done != nil {
+			q.opts.Done(entry, deliveryErr)
+		}
+		return
+	}
+
+	entry.NextAttempt = q.now().Add(q.backoff(entry.Attempts))
+	if err := writeEntry(file, entry); err != nil {
+		log.Printf("%s: failed to update queue entry %s: %v", q.opts.Name, entry.ID, err)
+	}
+}
+
+// path returns the file of the entry queued under name
+func (q *Queue[T]) path(name string) string {
+	return filepath.Join(q.dir, name+".json")
+}
+
+// failedPath returns the file of the entry under name once given up on
+func (q *Queue[T]) failedPath(name string) string {
+	return filepath.Join(q.dir, failedDirName, name+".json")
+}
+
+// newEntryID returns a unique, time-ordered entry ID
+func newEntryID(t time.Time) string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return fmt.Sprintf("%016x%s", t.UnixNano(), hex.EncodeToString(b[:]))
+}
+
+// readEntry loads an entry
+func readEntry[T any](path string) (*Entry[T], error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry Entry[T]
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	entry.name = strings.TrimSuffix(filepath.Base(path), ".json")
+	return &entry, nil
+}
+
+// writeEntry persists an entry, so the queue never contains partially written entries
+func writeEntry[T any](path string, entry *Entry[T]) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode queue entry: %w", err)
+	}
+	if err := storage.WriteFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write queue entry: %w", err)
+	}
+	return nil
+}
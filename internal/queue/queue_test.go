@@ -0,0 +1,110 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestQueue creates a queue of strings delivered by process
+func newTestQueue(t *testing.T, process func(*Entry[string]) error) *Queue[string] {
+	t.Helper()
+
+	q, err := New(t.TempDir(), Options[string]{Name: "Test", Process: process})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	q.SetBackoff(func(int) time.Duration { return 0 })
+	return q
+}
+
+func TestQueue_DeliversInOrder(t *testing.T) {
+	var delivered []string
+	q := newTestQueue(t, func(entry *Entry[string]) error {
+		delivered = append(delivered, entry.Value)
+		return nil
+	})
+
+	for _, value := range []string{"a", "b", "c"} {
+		if err := q.Add(value); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	q.ProcessDue()
+
+	if len(delivered) != 3 || delivered[0] != "a" || delivered[2] != "c" || q.Len() != 0 {
+		t.Errorf("delivered %v, %d left", delivered, q.Len())
+	}
+}
+
+func TestQueue_RetriesThenGivesUp(t *testing.T) {
+	attempts := 0
+	q := newTestQueue(t, func(*Entry[string]) error {
+		attempts++
+		return errors.New("unavailable")
+	})
+	var given []string
+	q.opts.Done = func(entry *Entry[string], err error) {
+		if err != nil {
+			given = append(given, entry.Value)
+		}
+	}
+
+	_ = q.Put("a", "first")
+	for range MaxAttempts {
+		q.ProcessDue()
+	}
+	if attempts != MaxAttempts || q.Len() != 0 || q.Failed() != 1 || len(given) != 1 {
+		t.Fatalf("attempts = %d, pending = %d, failed = %d, given up = %v", attempts, q.Len(), q.Failed(), given)
+	}
+
+	// Putting the name again supersedes the failed entry
+	_ = q.Put("a", "second")
+	if q.Len() != 1 || q.Failed() != 0 {
+		t.Errorf("pending = %d, failed = %d", q.Len(), q.Failed())
+	}
+}
+
+func TestQueue_PermanentErrors(t *testing.T) {
+	q := newTestQueue(t, func(*Entry[string]) error {
+		return Permanent(errors.New("never works"))
+	})
+
+	_ = q.Add("a")
+	q.ProcessDue()
+	if q.Len() != 0 || q.Failed() != 1 {
+		t.Errorf("pending = %d, failed = %d", q.Len(), q.Failed())
+	}
+}
+
+func TestQueue_EntryReplacedWhileDelivered(t *testing.T) {
+	var q *Queue[string]
+	q = newTestQueue(t, func(entry *Entry[string]) error {
+		if entry.Value == "first" {
+			_ = q.Put("a", "second")
+		}
+		return nil
+	})
+
+	_ = q.Put("a", "first")
+	q.ProcessDue()
+	if q.Len() != 1 || !q.Has("a") {
+		t.Fatalf("expected the newer entry to be kept, %d pending", q.Len())
+	}
+	q.ProcessDue()
+	if q.Len() != 0 {
+		t.Errorf("expected the newer entry to be delivered, %d pending", q.Len())
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	if got := ExponentialBackoff(1); got != initialBackoff {
+		t.Errorf("expected %v after first attempt, got %v", initialBackoff, got)
+	}
+	if got := ExponentialBackoff(3); got != 4*initialBackoff {
+		t.Errorf("expected %v after third attempt, got %v", 4*initialBackoff, got)
+	}
+	if got := ExponentialBackoff(100); got != maxBackoff {
+		t.Errorf("expected backoff to be capped at %v, got %v", maxBackoff, got)
+	}
+}
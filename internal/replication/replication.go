@@ -0,0 +1,288 @@
+// Package replication copies the writes and deletes of the bucket to other
+// S3-compatible buckets in the background
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/queue"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// Replication settings
+const (
+	replayTimeout = 10 * time.Minute
+	queueDirName  = "queue"
+	statusDirName = "status"
+)
+
+// Replication statuses, as reported in the x-amz-replication-status header
+const (
+	StatusPending   = "PENDING"
+	StatusCompleted = "COMPLETED"
+	StatusFailed    = "FAILED"
+)
+
+// Operations replayed on targets
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// Rule replicates the objects under a key prefix to a target bucket
+type Rule struct {
+	Prefix string           // "" for every key
+	Target string           // identifies the target bucket in the queue, see TargetName
+	Client storage.Upstream // writes to the target bucket
+}
+
+// TargetName identifies the bucket at endpoint as a target
+func TargetName(endpoint, bucket string) string {
+	return strings.TrimSuffix(endpoint, "/") + "/" + bucket
+}
+
+// task is a pending replication. There is one per target and key: a newer write
+// or delete of the key replaces it.
+type task struct {
+	Target string `json:"target"`
+	Key    string `json:"key"`
+	Op     string `json:"op"`
+}
+
+// Replicator queues the writes and deletes matching its rules on disk and replays them
+// on the target buckets, reading the objects written from the local backend. Entries
+// are only removed once replayed, so replication survives restarts and target outages.
+type Replicator struct {
+	dir     string
+	store   storage.Backend
+	rules   []Rule
+	clients map[string]storage.Upstream // by target
+	queue   *queue.Queue[task]
+}
+
+// New creates a replicator persisting its queue in dir. Without clients, as when
+// backfilling from the command line, entries can be queued but not replayed.
+func New(dir string, store storage.Backend, rules []Rule) (*Replicator, error) {
+	if err := os.MkdirAll(filepath.Join(dir, statusDirName), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create replication status directory: %w", err)
+	}
+
+	clients := make(map[string]storage.Upstream)
+	for _, rule := range rules {
+		if _, ok := clients[rule.Target]; !ok && rule.Client != nil {
+			clients[rule.Target] = rule.Client
+		}
+	}
+	r := &Replicator{
+		dir:     dir,
+		store:   store,
+		rules:   rules,
+		clients: clients,
+	}
+
+	var err error
+	r.queue, err = queue.New(filepath.Join(dir, queueDirName), queue.Options[task]{
+		Name:    "Replication",
+		Process: r.process,
+		Queued:  r.queued,
+		Done:    r.done,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create replication queue: %w", err)
+	}
+	return r, nil
+}
+
+// QueuePut queues the replication of the current object at key to the targets of the
+// rules matching it
+func (r *Replicator) QueuePut(key string) error {
+	return r.enqueue(key, opPut)
+}
+
+// QueueDelete queues the deletion of key from the targets of the rules matching it
+func (r *Replicator) QueueDelete(key string) error {
+	return r.enqueue(key, opDelete)
+}
+
+// enqueue writes an entry for every target of the rules matching key
+func (r *Replicator) enqueue(key, op string) error {
+	for _, target := range r.targets(key) {
+		if err := r.queue.Put(entryName(target, key), task{Target: target, Key: key, Op: op}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Backfill queues the replication of the current objects under prefix that match a rule
+// and returns how many were queued. Objects already replicated are copied again.
+func (r *Replicator) Backfill(prefix string) (int, error) {
+	objects, err := r.store.ListObjects(prefix)
+	if err != nil {
+		return 0, err
+	}
+	queued := 0
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, "/") || len(r.targets(obj.Key)) == 0 {
+			continue // folders are not replicated
+		}
+		if err := r.QueuePut(obj.Key); err != nil {
+			return queued, err
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// Status returns the replication status of the object at key: StatusPending while it
+// is queued, StatusCompleted once replayed on every target, StatusFailed when a target
+// gave up, and "" when it was never queued
+func (r *Replicator) Status(key string) string {
+	data, err := os.ReadFile(r.statusPath(key))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// Pending returns the number of replications waiting to be replayed
+func (r *Replicator) Pending() int {
+	return r.queue.Len()
+}
+
+// Failed returns the number of replications given up on
+func (r *Replicator) Failed() int {
+	return r.queue.Failed()
+}
+
+// Start replays queued replications in the background until Stop is called
+func (r *Replicator) Start() {
+	r.queue.Start()
+}
+
+// Stop stops background replication and waits for an in-flight replay to finish.
+// Pending replications stay queued on disk.
+func (r *Replicator) Stop() {
+	r.queue.Stop()
+}
+
+// process replays a queued replication, dropping it when its target was removed
+func (r *Replicator) process(entry *queue.Entry[task]) error {
+	item := entry.Value
+	client := r.clients[item.Target]
+	if client == nil {
+		log.Printf("Replication: dropping %s of %s: target %s is no longer configured", item.Op, item.Key, item.Target)
+		return nil
+	}
+
+	err := r.replay(client, &item)
+	switch {
+	case errors.Is(err, storage.ErrCustomerKeyRequired):
+		return queue.Permanent(err)
+	case err != nil:
+		log.Printf("Replication: %s of %s to %s failed (attempt %d): %v", item.Op, item.Key, item.Target, entry.Attempts+1, err)
+	}
+	return err
+}
+
+// replay writes the current object at item.Key to the target, or deletes it there
+func (r *Replicator) replay(client storage.Upstream, item *task) error {
+	ctx, cancel := context.WithTimeout(context.Background(), replayTimeout)
+	defer cancel()
+
+	if item.Op == opDelete {
+		return client.DeleteObject(ctx, item.Key)
+	}
+
+	obj, reader, err := r.store.GetObject(item.Key)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrDeleteMarker) {
+		return nil // removed since, without a delete to replicate
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = reader.Close() }()
+	return client.PutObject(ctx, item.Key, reader, obj.Size, obj.ContentType)
+}
+
+// queued marks a queued key as pending; the queue is locked
+func (r *Replicator) queued(entry *queue.Entry[task]) error {
+	return r.setStatus(entry.Value.Key, StatusPending)
+}
+
+// done records the status of a key once one of its entries was replayed or given up
+// on; the queue is locked
+func (r *Replicator) done(entry *queue.Entry[task], err error) {
+	item := entry.Value
+	if err != nil {
+		log.Printf("Replication: giving up on %s of %s to %s after %d attempts: %v", item.Op, item.Key, item.Target, entry.Attempts, err)
+		if err := r.setStatus(item.Key, StatusFailed); err != nil {
+			log.Printf("Replication: %v", err)
+		}
+		return
+	}
+	r.updateStatus(&item)
+}
+
+// updateStatus records the status of a key once one of its entries was replayed
+func (r *Replicator) updateStatus(item *task) {
+	for _, target := range r.targets(item.Key) {
+		if r.queue.Has(entryName(target, item.Key)) {
+			return // still pending for another target
+		}
+	}
+
+	var err error
+	switch {
+	case item.Op == opDelete:
+		err = os.Remove(r.statusPath(item.Key))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	case r.Status(item.Key) != StatusFailed:
+		err = r.setStatus(item.Key, StatusCompleted)
+	}
+	if err != nil {
+		log.Printf("Replication: %v", err)
+	}
+}
+
+// setStatus records the status of key; the queue must be locked
+func (r *Replicator) setStatus(key, status string) error {
+	if err := storage.WriteFileAtomic(r.statusPath(key), []byte(status)); err != nil {
+		return fmt.Errorf("failed to record the replication status of %s: %w", key, err)
+	}
+	return nil
+}
+
+// targets returns the targets of the rules matching key
+func (r *Replicator) targets(key string) []string {
+	var targets []string
+	for _, rule := range r.rules {
+		if strings.HasPrefix(key, rule.Prefix) && !slices.Contains(targets, rule.Target) {
+			targets = append(targets, rule.Target)
+		}
+	}
+	return targets
+}
+
+// statusPath returns the file recording the replication status of key
+func (r *Replicator) statusPath(key string) string {
+	return filepath.Join(r.dir, statusDirName, entryName("", key))
+}
+
+// entryName returns a filesystem-safe name for the entries of key for target
+func entryName(target, key string) string {
+	sum := sha256.Sum256([]byte(target + "\x00" + key))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,242 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/queue"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// memoryTarget is a target bucket keeping its objects in memory
+type memoryTarget struct {
+	mu       sync.Mutex
+	objects  *storage.Memory
+	failures int // number of requests to reject before accepting
+}
+
+func newMemoryTarget() *memoryTarget {
+	return &memoryTarget{objects: storage.NewMemory()}
+}
+
+func (m *memoryTarget) fail() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("target unavailable")
+	}
+	return nil
+}
+
+func (m *memoryTarget) GetObject(_ context.Context, key string) (*storage.Object, io.ReadCloser, error) {
+	return m.objects.GetObject(key)
+}
+
+func (m *memoryTarget) PutObject(_ context.Context, key string, body io.Reader, _ int64, contentType string) error {
+	if err := m.fail(); err != nil {
+		return err
+	}
+	_, err := m.objects.PutObject(key, contentType, body)
+	return err
+}
+
+func (m *memoryTarget) DeleteObject(_ context.Context, key string) error {
+	if err := m.fail(); err != nil {
+		return err
+	}
+	return m.objects.DeleteObject(key)
+}
+
+// read returns the content of key in the target, or "" when it is missing
+func (m *memoryTarget) read(key string) string {
+	_, reader, err := m.objects.GetObject(key)
+	if err != nil {
+		return ""
+	}
+	defer func() { _ = reader.Close() }()
+	data, _ := io.ReadAll(reader)
+	return string(data)
+}
+
+// waitFor polls cond until it returns true or the test times out
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func put(t *testing.T, store storage.Backend, key, data string) {
+	t.Helper()
+	if _, err := store.PutObject(key, "", strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplicator_ReplaysPutsAndDeletes(t *testing.T) {
+	store := storage.NewMemory()
+	target := newMemoryTarget()
+	r, err := New(t.TempDir(), store, []Rule{{Prefix: "docs/", Target: "backup", Client: target}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	r.Start()
+	defer r.Stop()
+
+	put(t, store, "docs/a.txt", "replicated")
+	put(t, store, "tmp/b.txt", "not replicated")
+	_ = r.QueuePut("docs/a.txt")
+	_ = r.QueuePut("tmp/b.txt")
+	if got := r.Status("tmp/b.txt"); got != "" {
+		t.Errorf("status of a key matching no rule = %q", got)
+	}
+
+	waitFor(t, func() bool { return r.Status("docs/a.txt") == StatusCompleted })
+	if got := target.read("docs/a.txt"); got != "replicated" {
+		t.Errorf("target has %q", got)
+	}
+	if target.read("tmp/b.txt") != "" {
+		t.Error("key outside the rule prefix replicated")
+	}
+
+	if err := store.DeleteObject("docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	_ = r.QueueDelete("docs/a.txt")
+	waitFor(t, func() bool { return r.Pending() == 0 && target.read("docs/a.txt") == "" })
+	if got := r.Status("docs/a.txt"); got != "" {
+		t.Errorf("status of a deleted key = %q", got)
+	}
+}
+
+func TestReplicator_NewerWritesReplaceQueuedOnes(t *testing.T) {
+	store := storage.NewMemory()
+	target := newMemoryTarget()
+	r, err := New(t.TempDir(), store, []Rule{{Target: "backup", Client: target}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	put(t, store, "a.txt", "first")
+	_ = r.QueuePut("a.txt")
+	put(t, store, "a.txt", "second")
+	_ = r.QueuePut("a.txt")
+	put(t, store, "b.txt", "short-lived")
+	_ = r.QueuePut("b.txt")
+	_ = store.DeleteObject("b.txt")
+	_ = r.QueueDelete("b.txt")
+	if r.Pending() != 2 {
+		t.Fatalf("expected one entry per key, got %d", r.Pending())
+	}
+
+	r.queue.ProcessDue()
+	if got := target.read("a.txt"); got != "second" || target.read("b.txt") != "" {
+		t.Errorf("target has a.txt = %q, b.txt = %q", got, target.read("b.txt"))
+	}
+	if r.Pending() != 0 || r.Status("a.txt") != StatusCompleted {
+		t.Errorf("pending = %d, status = %q", r.Pending(), r.Status("a.txt"))
+	}
+}
+
+func TestReplicator_RetriesThenGivesUp(t *testing.T) {
+	store := storage.NewMemory()
+	target := newMemoryTarget()
+	target.failures = 2
+	r, err := New(t.TempDir(), store, []Rule{{Target: "backup", Client: target}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	r.queue.SetBackoff(func(int) time.Duration { return time.Millisecond })
+	r.Start()
+	defer r.Stop()
+
+	put(t, store, "a.txt", "data")
+	_ = r.QueuePut("a.txt")
+	waitFor(t, func() bool { return r.Status("a.txt") == StatusCompleted })
+	if target.read("a.txt") != "data" {
+		t.Error("expected the object to be replicated after retries")
+	}
+
+	target.mu.Lock()
+	target.failures = queue.MaxAttempts
+	target.mu.Unlock()
+	put(t, store, "b.txt", "data")
+	_ = r.QueuePut("b.txt")
+	waitFor(t, func() bool { return r.Status("b.txt") == StatusFailed })
+	if r.Pending() != 0 || r.Failed() != 1 {
+		t.Errorf("pending = %d, failed = %d", r.Pending(), r.Failed())
+	}
+
+	// A new write queues the key again
+	_ = r.QueuePut("b.txt")
+	waitFor(t, func() bool { return r.Status("b.txt") == StatusCompleted })
+	if r.Failed() != 0 {
+		t.Errorf("expected the failed entry to be superseded, got %d", r.Failed())
+	}
+}
+
+func TestReplicator_BackfillAndRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewMemory()
+	put(t, store, "docs/a.txt", "a")
+	put(t, store, "docs/sub/b.txt", "b")
+	put(t, store, "other.txt", "other")
+
+	// Queued without clients, as the command line does
+	queuer, err := New(dir, store, []Rule{{Prefix: "docs/", Target: "backup"}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	queued, err := queuer.Backfill("")
+	if err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+	if queued != 2 || queuer.Status("docs/a.txt") != StatusPending {
+		t.Fatalf("queued %d, status %q", queued, queuer.Status("docs/a.txt"))
+	}
+
+	target := newMemoryTarget()
+	r, err := New(dir, store, []Rule{{Prefix: "docs/", Target: "backup", Client: target}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	r.queue.ProcessDue()
+	if target.read("docs/a.txt") != "a" || target.read("docs/sub/b.txt") != "b" || target.read("other.txt") != "" {
+		t.Error("expected the objects under the rule prefix to be replicated")
+	}
+	if r.Status("docs/sub/b.txt") != StatusCompleted {
+		t.Errorf("status = %q", r.Status("docs/sub/b.txt"))
+	}
+}
+
+func TestReplicator_DropsEntriesOfRemovedTargets(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewMemory()
+	put(t, store, "a.txt", "data")
+
+	r, err := New(dir, store, []Rule{{Target: "old"}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	_ = r.QueuePut("a.txt")
+
+	reconfigured, err := New(dir, store, []Rule{{Target: "new", Client: newMemoryTarget()}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	reconfigured.queue.ProcessDue()
+	if reconfigured.Pending() != 0 {
+		t.Errorf("expected the orphaned entry to be dropped, got %d pending", reconfigured.Pending())
+	}
+}
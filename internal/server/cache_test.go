@@ -0,0 +1,111 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newUpstreamServer serves another instance of the server over HTTP
+func newUpstreamServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+
+	cfg := testConfig(t)
+	cfg.Bucket = "prod-bucket"
+	cfg.AccessKey = "upstream-access-key"
+	cfg.SecretKey = "upstream-secret-key"
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create upstream server: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+// newCachingServer returns a server reading through to the upstream served at endpoint
+func newCachingServer(t *testing.T, endpoint string, writes bool) *Server {
+	t.Helper()
+
+	cfg := testConfig(t)
+	cfg.UpstreamEndpoint = endpoint
+	cfg.UpstreamBucket = "prod-bucket"
+	cfg.UpstreamRegion = cfg.Region
+	cfg.UpstreamAccessKey = "upstream-access-key"
+	cfg.UpstreamSecretKey = "upstream-secret-key"
+	cfg.UpstreamWrites = writes
+	cfg.CacheMaxSize = 1024
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCache_ReadsThroughUpstream(t *testing.T) {
+	upstream, ts := newUpstreamServer(t)
+	doRequest(t, upstream, http.MethodPut, "/prod-bucket/images/logo.svg", "<svg/>")
+	srv := newCachingServer(t, ts.URL, false)
+
+	resp := doRequest(t, srv, http.MethodHead, "/test-bucket/images/logo.svg", "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Length") != "6" {
+		t.Fatalf("HEAD = %d, length %s", resp.StatusCode, resp.Header.Get("Content-Length"))
+	}
+	resp = doRequest(t, srv, http.MethodGet, "/test-bucket/images/logo.svg", "")
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "<svg/>" {
+		t.Errorf("GET = %d %q", resp.StatusCode, body)
+	}
+	if resp := doRequest(t, srv, http.MethodGet, "/test-bucket/missing.txt", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an object missing upstream, got %d", resp.StatusCode)
+	}
+
+	// Served locally once fetched
+	doRequest(t, upstream, http.MethodDelete, "/prod-bucket/images/logo.svg", "")
+	if resp := doRequest(t, srv, http.MethodGet, "/test-bucket/images/logo.svg", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the fetched object to be served locally, got %d", resp.StatusCode)
+	}
+
+	// Writes stay local without S3_UPSTREAM_WRITES
+	doRequest(t, srv, http.MethodPut, "/test-bucket/local.txt", "local")
+	if resp := doRequest(t, upstream, http.MethodHead, "/prod-bucket/local.txt", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the write to stay local, got %d upstream", resp.StatusCode)
+	}
+
+	// Removing cached objects needs an unversioned bucket
+	resp = doRequest(t, srv, http.MethodPut, "/test-bucket?versioning",
+		`<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`)
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusConflict || !strings.Contains(string(body), "InvalidBucketState") {
+		t.Errorf("PutBucketVersioning = %d %s", resp.StatusCode, body)
+	}
+
+	metrics := scrapeMetrics(t, srv)
+	for _, line := range []string{"selfhost_s3_cache_objects 1", "selfhost_s3_cache_bytes 6"} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("metrics missing %q", line)
+		}
+	}
+}
+
+func TestCache_WritesUpstream(t *testing.T) {
+	upstream, ts := newUpstreamServer(t)
+	doRequest(t, upstream, http.MethodPut, "/prod-bucket/old.txt", "old")
+	srv := newCachingServer(t, ts.URL, true)
+
+	if resp := doRequest(t, srv, http.MethodPut, "/test-bucket/reports/new.csv", "a,b,c"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT = %d", resp.StatusCode)
+	}
+	resp := doRequest(t, upstream, http.MethodGet, "/prod-bucket/reports/new.csv", "")
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "a,b,c" {
+		t.Errorf("upstream GET = %d %q", resp.StatusCode, body)
+	}
+
+	if resp := doRequest(t, srv, http.MethodDelete, "/test-bucket/old.txt", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE = %d", resp.StatusCode)
+	}
+	if resp := doRequest(t, upstream, http.MethodHead, "/prod-bucket/old.txt", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the delete to be made upstream, got %d", resp.StatusCode)
+	}
+}
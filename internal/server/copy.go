@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/events"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// handleCopyObject handles CopyObject requests (PUT with x-amz-copy-source)
+func (s *Server) handleCopyObject(w http.ResponseWriter, r *http.Request, key string) {
+	bucket, srcKey, srcVersionID, ok := parseCopySource(r.Header.Get("x-amz-copy-source"))
+	if !ok {
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey")
+		return
+	}
+	if bucket != s.config.Bucket {
+		s.sendError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	metadataDirective := r.Header.Get("x-amz-metadata-directive")
+	taggingDirective := r.Header.Get("x-amz-tagging-directive")
+	for _, directive := range []string{metadataDirective, taggingDirective} {
+		if directive != "" && directive != "COPY" && directive != "REPLACE" {
+			s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "Unknown directive: "+directive)
+			return
+		}
+	}
+
+	srcCustomerKey, err := parseCustomerKey(r.Header, copySSECustomerPrefix)
+	if err != nil {
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	encryption, err := parseEncryptionHeaders(r.Header)
+	if err != nil {
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+
+	// Content types are derived from the key, so only the tagging directive has an effect.
+	// A nil opts copies the source tags unchanged and encrypts the copy with the default mode.
+	var opts *storage.PutOptions
+	if taggingDirective == "REPLACE" {
+		tags, err := parseTaggingHeader(r.Header.Get("x-amz-tagging"))
+		if err != nil {
+			s.sendStorageError(w, r, err)
+			return
+		}
+		encryption.Tags = tags
+		opts = &encryption
+	} else if encryption.Encryption != "" || encryption.CustomerKey != nil {
+		// Encrypting the copy needs explicit options, which then carry the source tags
+		src, err := s.storage.HeadObjectVersion(srcKey, srcVersionID)
+		if err != nil {
+			s.sendStorageError(w, r, err)
+			return
+		}
+		encryption.Tags = src.Tags
+		opts = &encryption
+	}
+
+	src := storage.GetOptions{VersionID: srcVersionID, CustomerKey: srcCustomerKey}
+	obj, err := s.storage.CopyObject(srcKey, src, key, opts)
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	s.publish(events.ObjectCreatedCopy, events.Object{
+		Key:       obj.Key,
+		Size:      obj.Size,
+		ETag:      obj.ETag,
+		VersionID: obj.VersionID,
+	})
+	s.replicate(obj)
+
+	if srcVersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", srcVersionID)
+	}
+	if obj.VersionID != "" {
+		w.Header().Set("x-amz-version-id", obj.VersionID)
+	}
+	setEncryptionHeaders(w, obj.Encryption, obj.CustomerKeyMD5)
+	s.sendXML(w, http.StatusOK, CopyObjectResult{
+		Xmlns:        "http://s3.amazonaws.com/doc/2006-03-01/",
+		LastModified: obj.LastModified.UTC().Format(time.RFC3339),
+		ETag:         obj.ETag,
+	})
+}
+
+// parseCopySource parses an x-amz-copy-source header of the form
+// "/bucket/key?versionId=id", where the bucket and key are URL-encoded
+func parseCopySource(header string) (bucket, key, versionID string, ok bool) {
+	source, query, _ := strings.Cut(header, "?")
+	if query != "" {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return "", "", "", false
+		}
+		versionID = values.Get("versionId")
+	}
+
+	source, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	if err != nil {
+		return "", "", "", false
+	}
+	bucket, key, found := strings.Cut(source, "/")
+	if !found || bucket == "" || key == "" {
+		return "", "", "", false
+	}
+	return bucket, key, versionID, true
+}
+
+// CopyObjectResult is the response for CopyObject
+type CopyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
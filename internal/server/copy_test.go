@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// doCopyRequest sends a signed CopyObject request
+func doCopyRequest(t *testing.T, srv *Server, target, source string, headers map[string]string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPut, target, nil)
+	req.Host = "localhost:9000"
+	req.Header.Set("x-amz-copy-source", source)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	signRequest(req, srv.config.AccessKey, srv.config.SecretKey, srv.config.Region)
+
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	return w.Result()
+}
+
+func TestCopyObject(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	resp := doRequest(t, srv, http.MethodPut, "/test-bucket/reports/2024%20Q1.csv", "a,b,c")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PutObject failed with status %d", resp.StatusCode)
+	}
+
+	resp = doCopyRequest(t, srv, "/test-bucket/archive/q1.csv", "/test-bucket/reports/2024%20Q1.csv", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CopyObject failed with status %d", resp.StatusCode)
+	}
+	var result CopyObjectResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	if result.ETag == "" || result.LastModified == "" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	resp = doRequest(t, srv, http.MethodGet, "/test-bucket/archive/q1.csv", "")
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "a,b,c" {
+		t.Errorf("expected copied content, got %q", body)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("expected source content type to be kept, got %q", got)
+	}
+}
+
+func TestCopyObject_ReplaceTags(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	doRequest(t, srv, http.MethodPut, "/test-bucket/src.txt", "data")
+	doRequest(t, srv, http.MethodPut, "/test-bucket/src.txt?tagging",
+		`<Tagging><TagSet><Tag><Key>team</Key><Value>a</Value></Tag></TagSet></Tagging>`)
+
+	resp := doCopyRequest(t, srv, "/test-bucket/dst.txt", "test-bucket/src.txt", map[string]string{
+		"x-amz-metadata-directive": "REPLACE",
+		"x-amz-tagging-directive":  "REPLACE",
+		"x-amz-tagging":            "team=b",
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CopyObject failed with status %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, srv, http.MethodGet, "/test-bucket/dst.txt?tagging", "")
+	var tagging Tagging
+	if err := xml.NewDecoder(resp.Body).Decode(&tagging); err != nil {
+		t.Fatalf("failed to decode tagging: %v", err)
+	}
+	if len(tagging.TagSet) != 1 || tagging.TagSet[0].Value != "b" {
+		t.Errorf("expected replaced tags, got %+v", tagging.TagSet)
+	}
+}
+
+func TestCopyObject_Errors(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		source   string
+		headers  map[string]string
+		expected int
+	}{
+		{"missing source", "/test-bucket/missing.txt", nil, http.StatusNotFound},
+		{"other bucket", "/other-bucket/file.txt", nil, http.StatusNotFound},
+		{"no key", "/test-bucket", nil, http.StatusBadRequest},
+		{"invalid directive", "/test-bucket/file.txt", map[string]string{"x-amz-metadata-directive": "MERGE"}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doCopyRequest(t, srv, "/test-bucket/dst.txt", tt.source, tt.headers)
+			if resp.StatusCode != tt.expected {
+				t.Errorf("expected status %d, got %d", tt.expected, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestCopyObject_SourceVersion(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	enableVersioning(t, srv)
+
+	resp := doRequest(t, srv, http.MethodPut, "/test-bucket/doc.txt", "v1")
+	v1 := resp.Header.Get("x-amz-version-id")
+	doRequest(t, srv, http.MethodPut, "/test-bucket/doc.txt", "v2")
+
+	resp = doCopyRequest(t, srv, "/test-bucket/doc.txt", "/test-bucket/doc.txt?versionId="+v1, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CopyObject failed with status %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("x-amz-copy-source-version-id"); got != v1 {
+		t.Errorf("expected source version %q, got %q", v1, got)
+	}
+	if resp.Header.Get("x-amz-version-id") == "" {
+		t.Error("expected a new version ID for the copy")
+	}
+
+	resp = doRequest(t, srv, http.MethodGet, "/test-bucket/doc.txt", "")
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "v1" {
+		t.Errorf("expected v1 to be restored as the latest version, got %q", body)
+	}
+}
+
+func TestParseCopySource(t *testing.T) {
+	tests := []struct {
+		header    string
+		bucket    string
+		key       string
+		versionID string
+		ok        bool
+	}{
+		{"bucket/key.txt", "bucket", "key.txt", "", true},
+		{"/bucket/dir/key%20name.txt", "bucket", "dir/key name.txt", "", true},
+		{"/bucket/key.txt?versionId=abc", "bucket", "key.txt", "abc", true},
+		{"/bucket", "", "", "", false},
+		{"/bucket/", "", "", "", false},
+		{"/bucket/%zz", "", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			bucket, key, versionID, ok := parseCopySource(tt.header)
+			if ok != tt.ok || bucket != tt.bucket || key != tt.key || versionID != tt.versionID {
+				t.Errorf("parseCopySource(%q) = %q, %q, %q, %v", tt.header, bucket, key, versionID, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Notifuse/selfhost_s3/internal/cors"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// corsConfigName is the bucket configuration document holding the CORS rules
+const corsConfigName = "cors"
+
+// loadBucketCORS reads the stored CORS configuration of the bucket, if any
+func (s *Server) loadBucketCORS() error {
+	data, err := s.storage.GetBucketConfig(corsConfigName)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoSuchConfig) {
+			return nil
+		}
+		return err
+	}
+
+	cfg, err := cors.Parse(data)
+	if err != nil {
+		// The document was validated when stored; fall back to S3_CORS_ORIGINS rather than refusing to start
+		log.Printf("Ignoring the stored CORS configuration: %v", err)
+		return nil
+	}
+	s.bucketCORS.Store(cfg)
+	return nil
+}
+
+// corsConfiguration returns the CORS rules in effect: those of the bucket, or the rule
+// built from S3_CORS_ORIGINS when the bucket has none. It returns nil when CORS is disabled.
+func (s *Server) corsConfiguration() *cors.Configuration {
+	if cfg := s.bucketCORS.Load(); cfg != nil {
+		return cfg
+	}
+	return s.settings.Load().cors
+}
+
+// corsMiddleware answers preflight requests and adds CORS headers to responses
+// for origins allowed by a CORS rule
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := s.corsConfiguration()
+
+		// Handle preflight requests
+		if r.Method == http.MethodOptions {
+			s.handlePreflight(w, r, cfg)
+			return
+		}
+
+		if cfg != nil {
+			// The headers depend on the origin, so caches must not share responses between origins
+			w.Header().Add("Vary", "Origin")
+			if origin := r.Header.Get("Origin"); origin != "" {
+				if rule := cfg.Match(origin, r.Method, nil); rule != nil {
+					setCORSHeaders(w.Header(), rule, origin)
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlePreflight answers a CORS preflight request. Like S3, it is rejected with 403
+// when no rule allows the origin, the requested method and all the requested headers.
+func (s *Server) handlePreflight(w http.ResponseWriter, r *http.Request, cfg *cors.Configuration) {
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	if origin == "" {
+		s.sendError(w, r, http.StatusBadRequest, "BadRequest", "Insufficient information. Origin request header needed.")
+		return
+	}
+	if method == "" {
+		s.sendError(w, r, http.StatusBadRequest, "BadRequest", "Insufficient information. Access-Control-Request-Method request header needed.")
+		return
+	}
+	if cfg == nil {
+		s.sendError(w, r, http.StatusForbidden, "AccessForbidden", "CORSResponse: CORS is not enabled for this bucket.")
+		return
+	}
+
+	var headers []string
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, header)
+		}
+	}
+
+	rule := cfg.Match(origin, method, headers)
+	if rule == nil {
+		s.sendError(w, r, http.StatusForbidden, "AccessForbidden",
+			"CORSResponse: This CORS request is not allowed. This is usually because the evalution of Origin, request method / Access-Control-Request-Method or Access-Control-Request-Headers are not whitelisted by the resource's CORS spec.")
+		return
+	}
+
+	h := w.Header()
+	h.Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+	setCORSHeaders(h, rule, origin)
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if rule.MaxAgeSeconds != nil {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(*rule.MaxAgeSeconds))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// setCORSHeaders adds the headers of a matching rule. Rules allowing any origin answer
+// with "*"; others echo the origin and allow credentials, as S3 does.
+func setCORSHeaders(h http.Header, rule *cors.Rule, origin string) {
+	if rule.AllowsAnyOrigin() {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+	if len(rule.ExposeHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+	}
+}
+
+// handleGetBucketCors handles GetBucketCors requests
+func (s *Server) handleGetBucketCors(w http.ResponseWriter, r *http.Request) {
+	data, err := s.storage.GetBucketConfig(corsConfigName)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoSuchConfig) {
+			s.sendError(w, r, http.StatusNotFound, "NoSuchCORSConfiguration", "The CORS configuration does not exist")
+			return
+		}
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(data)
+}
+
+// handlePutBucketCors handles PutBucketCors requests
+func (s *Server) handlePutBucketCors(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxConfigBodySize))
+	if err != nil {
+		s.sendError(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema")
+		return
+	}
+
+	cfg, err := cors.Parse(body)
+	if err != nil {
+		if errors.Is(err, cors.ErrMalformed) {
+			s.sendError(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema")
+			return
+		}
+		s.sendError(w, r, http.StatusBadRequest, "InvalidRequest", err.Error())
+		return
+	}
+
+	// Store a normalized document so GET returns a clean configuration
+	cfg.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	data, err := xml.Marshal(cfg)
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	if err := s.storage.PutBucketConfig(corsConfigName, data); err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+	s.bucketCORS.Store(cfg)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleDeleteBucketCors handles DeleteBucketCors requests. S3_CORS_ORIGINS applies again afterwards.
+func (s *Server) handleDeleteBucketCors(w http.ResponseWriter, r *http.Request) {
+	if err := s.storage.DeleteBucketConfig(corsConfigName); err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+	s.bucketCORS.Store(nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Notifuse/selfhost_s3/internal/cors"
+)
+
+// testCORSConfiguration allows uploads from one origin and downloads from its subdomains
+const testCORSConfiguration = `<CORSConfiguration>
+	<CORSRule>
+		<AllowedOrigin>https://app.example.com</AllowedOrigin>
+		<AllowedMethod>PUT</AllowedMethod>
+		<AllowedMethod>GET</AllowedMethod>
+		<AllowedHeader>content-type</AllowedHeader>
+		<AllowedHeader>x-amz-*</AllowedHeader>
+		<ExposeHeader>ETag</ExposeHeader>
+		<MaxAgeSeconds>600</MaxAgeSeconds>
+	</CORSRule>
+	<CORSRule>
+		<AllowedOrigin>https://*.example.com</AllowedOrigin>
+		<AllowedMethod>GET</AllowedMethod>
+	</CORSRule>
+</CORSConfiguration>`
+
+// preflight sends a CORS preflight request
+func preflight(srv *Server, origin, method, headers string) *http.Response {
+	req := httptest.NewRequest(http.MethodOptions, "/test-bucket/uploads/file.txt", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if method != "" {
+		req.Header.Set("Access-Control-Request-Method", method)
+	}
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	return w.Result()
+}
+
+func TestBucketCors_PutGetDelete(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	// No configuration yet
+	resp := serveSigned(t, srv, http.MethodGet, "/test-bucket?cors", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "NoSuchCORSConfiguration") {
+		t.Errorf("expected NoSuchCORSConfiguration error, got %s", body)
+	}
+
+	resp = serveSigned(t, srv, http.MethodPut, "/test-bucket?cors", testCORSConfiguration)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	resp = serveSigned(t, srv, http.MethodGet, "/test-bucket?cors", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	var cfg cors.Configuration
+	if err := xml.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		t.Fatalf("failed to decode CORS configuration: %v", err)
+	}
+	if len(cfg.Rules) != 2 || cfg.Rules[0].AllowedOrigins[0] != "https://app.example.com" || *cfg.Rules[0].MaxAgeSeconds != 600 {
+		t.Errorf("unexpected CORS configuration: %+v", cfg)
+	}
+
+	// The configuration survives a restart
+	srv.Close()
+	restarted, err := NewServer(srv.config)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if resp := preflight(restarted, "https://other.example.org", http.MethodGet, ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the stored rules after a restart, got %d", resp.StatusCode)
+	}
+
+	resp = serveSigned(t, srv, http.MethodDelete, "/test-bucket?cors", "")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", resp.StatusCode)
+	}
+	if resp := serveSigned(t, srv, http.MethodGet, "/test-bucket?cors", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 after delete, got %d", resp.StatusCode)
+	}
+
+	// S3_CORS_ORIGINS applies again
+	if resp := preflight(srv, "https://other.example.org", http.MethodGet, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the S3_CORS_ORIGINS rule after delete, got %d", resp.StatusCode)
+	}
+}
+
+func TestBucketCors_PutInvalid(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"malformed", "<CORSConfiguration>", "MalformedXML"},
+		{"no origin", "<CORSConfiguration><CORSRule><AllowedMethod>GET</AllowedMethod></CORSRule></CORSConfiguration>", "InvalidRequest"},
+		{"unsupported method", "<CORSConfiguration><CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>PATCH</AllowedMethod></CORSRule></CORSConfiguration>", "InvalidRequest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := serveSigned(t, srv, http.MethodPut, "/test-bucket?cors", tt.body)
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), tt.code) {
+				t.Errorf("expected 400 %s, got %d: %s", tt.code, resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if resp := serveSigned(t, srv, http.MethodPut, "/test-bucket?cors", testCORSConfiguration); resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to put CORS configuration: %d", resp.StatusCode)
+	}
+
+	resp := preflight(srv, "https://app.example.com", http.MethodPut, "content-type, x-amz-date")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "PUT, GET",
+		"Access-Control-Allow-Headers":     "content-type, x-amz-date",
+		"Access-Control-Expose-Headers":    "ETag",
+		"Access-Control-Max-Age":           "600",
+	}
+	for header, want := range expected {
+		if got := resp.Header.Get(header); got != want {
+			t.Errorf("expected %s %q, got %q", header, want, got)
+		}
+	}
+	if vary := strings.Join(resp.Header.Values("Vary"), ", "); !strings.Contains(vary, "Origin") || !strings.Contains(vary, "Access-Control-Request-Headers") {
+		t.Errorf("expected Vary on the request headers, got %q", vary)
+	}
+
+	// The second rule matches subdomains for GET, without a max age
+	resp = preflight(srv, "https://cdn.example.com", http.MethodGet, "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Access-Control-Allow-Origin") != "https://cdn.example.com" || resp.Header.Get("Access-Control-Max-Age") != "" {
+		t.Errorf("unexpected preflight response for the second rule: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	rejected := []struct {
+		name, origin, method, headers string
+		status                        int
+	}{
+		{"origin not allowed", "https://evil.example.org", http.MethodGet, "", http.StatusForbidden},
+		{"method not allowed", "https://cdn.example.com", http.MethodPut, "", http.StatusForbidden},
+		{"header not allowed", "https://app.example.com", http.MethodPut, "authorization", http.StatusForbidden},
+		{"missing origin", "", http.MethodGet, "", http.StatusBadRequest},
+		{"missing method", "https://app.example.com", "", "", http.StatusBadRequest},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := preflight(srv, tt.origin, tt.method, tt.headers)
+			if resp.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if resp.Header.Get("Access-Control-Allow-Origin") != "" {
+				t.Errorf("expected no Access-Control-Allow-Origin on a rejected preflight")
+			}
+		})
+	}
+}
+
+func TestCORS_PreflightDisabled(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.CORSOrigins = nil
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	resp := preflight(srv, "https://app.example.com", http.MethodGet, "")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "CORS is not enabled") {
+		t.Errorf("expected 403 with CORS disabled, got %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestCORS_ActualRequest(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	// S3_CORS_ORIGINS=* allows any origin without credentials
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("expected a wildcard origin without credentials, got %v", w.Header())
+	}
+	if !strings.Contains(w.Header().Get("Access-Control-Expose-Headers"), "x-amz-version-id") {
+		t.Errorf("expected the S3 response headers to be exposed, got %q", w.Header().Get("Access-Control-Expose-Headers"))
+	}
+
+	if resp := serveSigned(t, srv, http.MethodPut, "/test-bucket?cors", testCORSConfiguration); resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to put CORS configuration: %d", resp.StatusCode)
+	}
+
+	tests := []struct {
+		origin string
+		want   string
+	}{
+		{"https://app.example.com", "https://app.example.com"},
+		{"https://cdn.example.com", "https://cdn.example.com"},
+		{"https://evil.example.org", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.Header.Set("Origin", tt.origin)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: requests are served whatever the origin, got %d", tt.origin, w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("%s: expected Access-Control-Allow-Origin %q, got %q", tt.origin, tt.want, got)
+		}
+		if w.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: expected Vary: Origin, got %q", tt.origin, w.Header().Get("Vary"))
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// blobCollector periodically removes the deduplicated data no longer referenced by any
+// object, left behind by deletes and overwrites
+type blobCollector struct {
+	store    *storage.Storage
+	interval time.Duration
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// newBlobCollector creates a collector running every interval
+func newBlobCollector(store *storage.Storage, interval time.Duration) *blobCollector {
+	return &blobCollector{store: store, interval: interval}
+}
+
+// start runs the collector in the background until stop is called
+func (c *blobCollector) start() {
+	c.quit = make(chan struct{})
+	c.done = make(chan struct{})
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.quit:
+				return
+			case <-ticker.C:
+				c.collect()
+			}
+		}
+	}()
+}
+
+// stop stops the collector and waits for a running collection to finish
+func (c *blobCollector) stop() {
+	if c.quit == nil {
+		return
+	}
+	close(c.quit)
+	<-c.done
+	c.quit = nil
+}
+
+// collect removes the unreferenced blobs once
+func (c *blobCollector) collect() {
+	removed, freed, err := c.store.CollectBlobs()
+	if err != nil {
+		log.Printf("Dedup: collection failed: %v", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("Dedup: removed %d unreferenced blob(s), %d bytes freed", removed, freed)
+	}
+}
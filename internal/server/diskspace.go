@@ -0,0 +1,91 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// diskCheckInterval is how often the free disk space is checked
+const diskCheckInterval = 10 * time.Second
+
+// diskMonitor puts the server in read-only mode while the free disk space of the
+// storage is below S3_MIN_FREE_SPACE
+type diskMonitor struct {
+	store    *storage.Storage
+	minFree  int64
+	readOnly atomic.Bool
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// newDiskMonitor creates a monitor keeping minFree bytes free
+func newDiskMonitor(store *storage.Storage, minFree int64) *diskMonitor {
+	return &diskMonitor{store: store, minFree: minFree}
+}
+
+// start checks the free disk space now, then in the background until stop is called
+func (m *diskMonitor) start() {
+	m.check()
+
+	m.quit = make(chan struct{})
+	m.done = make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+
+		ticker := time.NewTicker(diskCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.quit:
+				return
+			case <-ticker.C:
+				m.check()
+			}
+		}
+	}()
+}
+
+// stop stops the monitor
+func (m *diskMonitor) stop() {
+	if m.quit == nil {
+		return
+	}
+	close(m.quit)
+	<-m.done
+	m.quit = nil
+}
+
+// check switches read-only mode on or off from the current free disk space. The mode
+// is kept when the free space cannot be read.
+func (m *diskMonitor) check() {
+	free, err := m.store.FreeSpace()
+	if err != nil {
+		log.Printf("Disk: %v", err)
+		return
+	}
+
+	low := free < m.minFree
+	if m.readOnly.Swap(low) == low {
+		return
+	}
+	if low {
+		log.Printf("Disk: %d bytes free, below S3_MIN_FREE_SPACE (%d bytes); rejecting writes until space is freed", free, m.minFree)
+	} else {
+		log.Printf("Disk: %d bytes free, accepting writes again", free)
+	}
+}
+
+// rejectWrite reports whether r must be rejected because the server is in read-only
+// mode. Reads are served and deletes are allowed, since they free space.
+func (m *diskMonitor) rejectWrite(r *http.Request) bool {
+	if m == nil || !m.readOnly.Load() {
+		return false
+	}
+	return r.Method == http.MethodPut || r.Method == http.MethodPost
+}
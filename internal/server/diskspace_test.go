@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDiskMonitor_ReadOnly(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MinFreeSpace = 1 << 62 // more than any disk has
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	doRequest(t, srv, http.MethodPut, "/test-bucket/kept.txt", "kept")
+
+	srv.disk.check()
+
+	resp := doRequest(t, srv, http.MethodPut, "/test-bucket/new.txt", "new")
+	if resp.StatusCode != http.StatusServiceUnavailable || errorCode(t, resp) != "ServiceUnavailable" {
+		t.Errorf("expected PUT to be rejected, got %d", resp.StatusCode)
+	}
+	resp = doRequest(t, srv, http.MethodPost, "/test-bucket/new.txt?uploads", "")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected CreateMultipartUpload to be rejected, got %d", resp.StatusCode)
+	}
+	resp = doRequest(t, srv, http.MethodPut, "/test-bucket?versioning", "")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected bucket settings to be read-only, got %d", resp.StatusCode)
+	}
+
+	// Reads are served and deletes free space
+	if resp := doRequest(t, srv, http.MethodGet, "/test-bucket/kept.txt", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected GET to succeed, got %d", resp.StatusCode)
+	}
+	if resp := doRequest(t, srv, http.MethodDelete, "/test-bucket/kept.txt", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected DELETE to succeed, got %d", resp.StatusCode)
+	}
+
+	rec := scrapeMetrics(t, srv)
+	if !strings.Contains(rec, "selfhost_s3_read_only 1") {
+		t.Errorf("read-only gauge not set:\n%s", rec)
+	}
+
+	// Writes are accepted again once enough space is free
+	srv.disk.minFree = 1
+	srv.disk.check()
+	if resp := doRequest(t, srv, http.MethodPut, "/test-bucket/new.txt", "new"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected PUT to succeed, got %d", resp.StatusCode)
+	}
+}
+
+func TestDiskMonitor_StartBackground(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MinFreeSpace = 1 << 62
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	// The free disk space is checked on startup, before the first interval elapses
+	srv.StartBackground()
+	defer srv.Close()
+	if resp := doRequest(t, srv, http.MethodPut, "/test-bucket/new.txt", "new"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected PUT to be rejected, got %d", resp.StatusCode)
+	}
+}
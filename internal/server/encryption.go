@@ -0,0 +1,82 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// Server-side encryption headers
+const (
+	sseHeader             = "x-amz-server-side-encryption"
+	sseCustomerPrefix     = "x-amz-server-side-encryption-customer-"
+	copySSECustomerPrefix = "x-amz-copy-source-server-side-encryption-customer-"
+	sseAlgorithm          = "AES256" // the only algorithm supported, for both SSE-S3 and SSE-C
+)
+
+// parseEncryptionHeaders returns the encryption requested for a new object: SSE-S3 with
+// "x-amz-server-side-encryption: AES256", or SSE-C with the customer key headers.
+// Errors carry the message of the InvalidArgument response.
+func parseEncryptionHeaders(h http.Header) (storage.PutOptions, error) {
+	var opts storage.PutOptions
+
+	customerKey, err := parseCustomerKey(h, sseCustomerPrefix)
+	if err != nil {
+		return opts, err
+	}
+
+	if value := h.Get(sseHeader); value != "" {
+		if value != sseAlgorithm {
+			return opts, errors.New("The encryption method specified is not supported")
+		}
+		if customerKey != nil {
+			return opts, errors.New("Server Side Encryption with Customer provided key is incompatible with the encryption method specified")
+		}
+		opts.Encryption = storage.SSES3
+	}
+	opts.CustomerKey = customerKey
+	return opts, nil
+}
+
+// parseCustomerKey returns the SSE-C key sent with the headers starting with prefix,
+// or nil when there is none
+func parseCustomerKey(h http.Header, prefix string) ([]byte, error) {
+	algorithm := h.Get(prefix + "algorithm")
+	encodedKey := h.Get(prefix + "key")
+	keyMD5 := h.Get(prefix + "key-MD5")
+	if algorithm == "" && encodedKey == "" && keyMD5 == "" {
+		return nil, nil
+	}
+
+	if algorithm != sseAlgorithm {
+		return nil, errors.New("Requests specifying Server Side Encryption with Customer provided keys must provide a valid encryption algorithm.")
+	}
+	if encodedKey == "" {
+		return nil, errors.New("Requests specifying Server Side Encryption with Customer provided keys must provide an appropriate secret key.")
+	}
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) != storage.KeySize {
+		return nil, errors.New("The secret key was invalid for the specified algorithm.")
+	}
+	if keyMD5 == "" {
+		return nil, errors.New("Requests specifying Server Side Encryption with Customer provided keys must provide the client calculated MD5 of the secret key.")
+	}
+	if subtle.ConstantTimeCompare([]byte(keyMD5), []byte(storage.CustomerKeyMD5(key))) != 1 {
+		return nil, errors.New("The calculated MD5 hash of the key did not match the hash that was provided.")
+	}
+	return key, nil
+}
+
+// setEncryptionHeaders describes how an object or upload is encrypted in a response
+func setEncryptionHeaders(w http.ResponseWriter, mode, customerKeyMD5 string) {
+	switch mode {
+	case storage.SSES3:
+		w.Header().Set(sseHeader, sseAlgorithm)
+	case storage.SSEC:
+		w.Header().Set(sseCustomerPrefix+"algorithm", sseAlgorithm)
+		w.Header().Set(sseCustomerPrefix+"key-MD5", customerKeyMD5)
+	}
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// doRequestWithHeaders sends a signed request with additional headers
+func doRequestWithHeaders(t *testing.T, srv *Server, method, target, body string, headers map[string]string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Host = "localhost:9000"
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	signRequest(req, srv.config.AccessKey, srv.config.SecretKey, srv.config.Region)
+
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	return w.Result()
+}
+
+// customerKeyHeaders returns a random SSE-C key and the headers sending it with the given prefix
+func customerKeyHeaders(t *testing.T, prefix string) ([]byte, map[string]string) {
+	t.Helper()
+
+	key := make([]byte, storage.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum(key)
+	return key, map[string]string{
+		prefix + "algorithm": "AES256",
+		prefix + "key":       base64.StdEncoding.EncodeToString(key),
+		prefix + "key-MD5":   base64.StdEncoding.EncodeToString(sum[:]),
+	}
+}
+
+// errorCode returns the code of an S3 error response
+func errorCode(t *testing.T, resp *http.Response) string {
+	t.Helper()
+
+	var errResp ErrorResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	return errResp.Code
+}
+
+func TestEncryption_SSES3(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.EncryptionKey = make([]byte, storage.KeySize)
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	// Objects are encrypted by default once a master key is configured
+	resp := doRequest(t, srv, http.MethodPut, "/test-bucket/report.txt", "confidential report")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("x-amz-server-side-encryption") != "AES256" {
+		t.Fatalf("PUT = %d, encryption %q", resp.StatusCode, resp.Header.Get("x-amz-server-side-encryption"))
+	}
+
+	resp = doRequest(t, srv, http.MethodGet, "/test-bucket/report.txt", "")
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "confidential report" || resp.Header.Get("x-amz-server-side-encryption") != "AES256" {
+		t.Errorf("GET = %q, encryption %q", body, resp.Header.Get("x-amz-server-side-encryption"))
+	}
+	if resp.Header.Get("Content-Length") != "19" {
+		t.Errorf("expected the plaintext length, got %s", resp.Header.Get("Content-Length"))
+	}
+
+	resp = doRequestWithHeaders(t, srv, http.MethodGet, "/test-bucket/report.txt", "", map[string]string{"Range": "bytes=13-"})
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "report" {
+		t.Errorf("range GET = %d %q", resp.StatusCode, body)
+	}
+
+	resp = doRequest(t, srv, http.MethodHead, "/test-bucket/report.txt", "")
+	if resp.Header.Get("x-amz-server-side-encryption") != "AES256" {
+		t.Errorf("HEAD encryption = %q", resp.Header.Get("x-amz-server-side-encryption"))
+	}
+
+	resp = doRequestWithHeaders(t, srv, http.MethodPut, "/test-bucket/kms.txt", "data", map[string]string{
+		"x-amz-server-side-encryption": "aws:kms",
+	})
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, resp) != "InvalidArgument" {
+		t.Errorf("unsupported encryption = %d", resp.StatusCode)
+	}
+}
+
+func TestEncryption_NotConfigured(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	resp := doRequestWithHeaders(t, srv, http.MethodPut, "/test-bucket/file.txt", "data", map[string]string{
+		"x-amz-server-side-encryption": "AES256",
+	})
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, resp) != "InvalidArgument" {
+		t.Errorf("SSE-S3 without master key = %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, srv, http.MethodPut, "/test-bucket/plain.txt", "data")
+	if resp.Header.Get("x-amz-server-side-encryption") != "" {
+		t.Error("plaintext object reported as encrypted")
+	}
+}
+
+func TestEncryption_SSEC(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	key, headers := customerKeyHeaders(t, "x-amz-server-side-encryption-customer-")
+	_, otherHeaders := customerKeyHeaders(t, "x-amz-server-side-encryption-customer-")
+
+	resp := doRequestWithHeaders(t, srv, http.MethodPut, "/test-bucket/private.txt", "customer data", headers)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT failed with status %d", resp.StatusCode)
+	}
+	if resp.Header.Get("x-amz-server-side-encryption-customer-key-MD5") != storage.CustomerKeyMD5(key) {
+		t.Errorf("PUT key MD5 = %q", resp.Header.Get("x-amz-server-side-encryption-customer-key-MD5"))
+	}
+
+	resp = doRequest(t, srv, http.MethodGet, "/test-bucket/private.txt", "")
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, resp) != "InvalidRequest" {
+		t.Errorf("GET without key = %d", resp.StatusCode)
+	}
+	resp = doRequestWithHeaders(t, srv, http.MethodGet, "/test-bucket/private.txt", "", otherHeaders)
+	if resp.StatusCode != http.StatusForbidden || errorCode(t, resp) != "AccessDenied" {
+		t.Errorf("GET with another key = %d", resp.StatusCode)
+	}
+	resp = doRequest(t, srv, http.MethodHead, "/test-bucket/private.txt", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("HEAD without key = %d", resp.StatusCode)
+	}
+
+	resp = doRequestWithHeaders(t, srv, http.MethodGet, "/test-bucket/private.txt", "", headers)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "customer data" {
+		t.Errorf("GET with key = %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("x-amz-server-side-encryption-customer-algorithm") != "AES256" {
+		t.Error("missing SSE-C algorithm header")
+	}
+
+	// The key MD5 must match the key
+	invalid := map[string]string{}
+	for k, v := range headers {
+		invalid[k] = v
+	}
+	invalid["x-amz-server-side-encryption-customer-key-MD5"] = otherHeaders["x-amz-server-side-encryption-customer-key-MD5"]
+	resp = doRequestWithHeaders(t, srv, http.MethodPut, "/test-bucket/invalid.txt", "data", invalid)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, resp) != "InvalidArgument" {
+		t.Errorf("PUT with mismatched MD5 = %d", resp.StatusCode)
+	}
+}
+
+func TestEncryption_CopySSEC(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	_, srcHeaders := customerKeyHeaders(t, "x-amz-server-side-encryption-customer-")
+	doRequestWithHeaders(t, srv, http.MethodPut, "/test-bucket/src.txt", "secret", srcHeaders)
+
+	resp := doCopyRequest(t, srv, "/test-bucket/dst.txt", "test-bucket/src.txt", nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("copy without source key = %d", resp.StatusCode)
+	}
+
+	// Re-encrypt the copy with another customer key
+	_, copyHeaders := customerKeyHeaders(t, "x-amz-copy-source-server-side-encryption-customer-")
+	for k, v := range srcHeaders {
+		copyHeaders["x-amz-copy-source-"+strings.TrimPrefix(k, "x-amz-")] = v
+	}
+	_, dstHeaders := customerKeyHeaders(t, "x-amz-server-side-encryption-customer-")
+	for k, v := range dstHeaders {
+		copyHeaders[k] = v
+	}
+	resp = doCopyRequest(t, srv, "/test-bucket/dst.txt", "test-bucket/src.txt", copyHeaders)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("copy = %d", resp.StatusCode)
+	}
+
+	resp = doRequestWithHeaders(t, srv, http.MethodGet, "/test-bucket/dst.txt", "", dstHeaders)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "secret" {
+		t.Errorf("GET copy = %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestEncryption_MultipartSSEC(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	_, headers := customerKeyHeaders(t, "x-amz-server-side-encryption-customer-")
+
+	resp := doRequestWithHeaders(t, srv, http.MethodPost, "/test-bucket/big.bin?uploads", "", headers)
+	var initiated InitiateMultipartUploadResult
+	if err := xml.NewDecoder(resp.Body).Decode(&initiated); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	if resp.Header.Get("x-amz-server-side-encryption-customer-algorithm") != "AES256" {
+		t.Error("missing SSE-C header on CreateMultipartUpload")
+	}
+
+	target := fmt.Sprintf("/test-bucket/big.bin?partNumber=1&uploadId=%s", initiated.UploadID)
+	if resp := doRequest(t, srv, http.MethodPut, target, "part"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("UploadPart without key = %d", resp.StatusCode)
+	}
+	resp = doRequestWithHeaders(t, srv, http.MethodPut, target, "part", headers)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("UploadPart = %d", resp.StatusCode)
+	}
+
+	complete := fmt.Sprintf(`<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part></CompleteMultipartUpload>`, resp.Header.Get("ETag"))
+	resp = doRequest(t, srv, http.MethodPost, "/test-bucket/big.bin?uploadId="+initiated.UploadID, complete)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CompleteMultipartUpload = %d", resp.StatusCode)
+	}
+
+	resp = doRequestWithHeaders(t, srv, http.MethodGet, "/test-bucket/big.bin", "", headers)
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "part" {
+		t.Errorf("GET = %q", body)
+	}
+}
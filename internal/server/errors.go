@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Notifuse/selfhost_s3/internal/auth"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// ErrorResponse is an S3 error response
+type ErrorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestId string   `xml:"RequestId"`
+	HostId    string   `xml:"HostId"`
+}
+
+// sendError sends an S3-style error response. As with S3, errors to HEAD requests
+// have no body: clients only see the status code and the request ID headers.
+func (s *Server) sendError(w http.ResponseWriter, r *http.Request, statusCode int, code, message string) {
+	setErrorCode(w, code)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	resp := ErrorResponse{
+		XMLName:  xml.Name{Local: "Error"},
+		Code:     code,
+		Message:  message,
+		Resource: r.URL.Path,
+	}
+	if info := requestInfoFrom(r.Context()); info != nil {
+		resp.RequestId = info.id
+		resp.HostId = info.hostID
+	}
+	s.sendXML(w, statusCode, resp)
+}
+
+// sendStorageError maps a storage error to the matching S3 error response.
+// Unexpected errors are logged with the request ID rather than returned to the client.
+func (s *Server) sendStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		s.sendError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+	case errors.Is(err, storage.ErrNoSuchVersion):
+		s.sendError(w, r, http.StatusNotFound, "NoSuchVersion", "The specified version does not exist")
+	case errors.Is(err, storage.ErrInvalidVersionID):
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid version id specified")
+	case errors.Is(err, storage.ErrDeleteMarker):
+		w.Header().Set("x-amz-delete-marker", "true")
+		s.sendError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource")
+	case errors.Is(err, storage.ErrInvalidPath):
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid key")
+	case errors.Is(err, storage.ErrInvalidTag):
+		s.sendError(w, r, http.StatusBadRequest, "InvalidTag", err.Error())
+	case errors.Is(err, storage.ErrNoSuchUpload):
+		s.sendError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist")
+	case errors.Is(err, storage.ErrInvalidPart):
+		s.sendError(w, r, http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found")
+	case errors.Is(err, storage.ErrInvalidPartOrder):
+		s.sendError(w, r, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order")
+	case errors.Is(err, storage.ErrEntityTooSmall):
+		s.sendError(w, r, http.StatusBadRequest, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed object size")
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		s.sendError(w, r, http.StatusForbidden, "QuotaExceeded", err.Error())
+	case errors.Is(err, storage.ErrCustomerKeyRequired):
+		s.sendError(w, r, http.StatusBadRequest, "InvalidRequest",
+			"The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.")
+	case errors.Is(err, storage.ErrCustomerKeyMismatch):
+		s.sendError(w, r, http.StatusForbidden, "AccessDenied",
+			"The calculated MD5 hash of the key did not match the hash that was provided.")
+	case errors.Is(err, storage.ErrCustomerKeyNotApplicable):
+		s.sendError(w, r, http.StatusBadRequest, "InvalidRequest", "The encryption parameters are not applicable to this object.")
+	case errors.Is(err, storage.ErrEncryptionNotConfigured):
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "Server-side encryption is not enabled on this server")
+	case errors.Is(err, storage.ErrCacheVersioning):
+		s.sendError(w, r, http.StatusConflict, "InvalidBucketState", "Versioning cannot be enabled on a bucket in front of an upstream bucket")
+	case errors.Is(err, storage.ErrInvalidEncryption):
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "The encryption request you specified is not valid.")
+	default:
+		s.logError(r, slog.LevelError, "Storage error", err)
+		s.sendError(w, r, http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again.")
+	}
+}
+
+// sendAuthError maps a signature validation error to the matching S3 error response
+func (s *Server) sendAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, auth.ErrMissingAuthorization):
+		s.sendError(w, r, http.StatusForbidden, "AccessDenied", "Access Denied")
+	case errors.Is(err, auth.ErrInvalidAccessKey):
+		s.sendError(w, r, http.StatusForbidden, "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records.")
+	case errors.Is(err, auth.ErrRequestTimeSkewed):
+		s.sendError(w, r, http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the current time is too large.")
+	case errors.Is(err, auth.ErrPresignExpired):
+		s.sendError(w, r, http.StatusForbidden, "AccessDenied", "Request has expired")
+	case errors.Is(err, auth.ErrSignatureMismatch):
+		s.sendError(w, r, http.StatusForbidden, "SignatureDoesNotMatch",
+			"The request signature we calculated does not match the signature you provided. Check your key and signing method.")
+	case r.URL.Query().Has("X-Amz-Signature"):
+		s.sendError(w, r, http.StatusBadRequest, "AuthorizationQueryParametersError", err.Error())
+	default:
+		s.sendError(w, r, http.StatusBadRequest, "AuthorizationHeaderMalformed", err.Error())
+	}
+}
+
+// logError logs an error at level with the ID of the request that caused it
+func (s *Server) logError(r *http.Request, level slog.Level, msg string, err error) {
+	attrs := []slog.Attr{slog.String("error", err.Error())}
+	if info := requestInfoFrom(r.Context()); info != nil {
+		attrs = append(attrs, slog.String("request_id", info.id))
+	}
+	slog.LogAttrs(r.Context(), level, msg, attrs...)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSendError_CompleteResponse(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	resp := serveSigned(t, srv, http.MethodGet, "/test-bucket/dir/missing.txt", "")
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", resp.StatusCode)
+	}
+	requestID, hostID := resp.Header.Get("x-amz-request-id"), resp.Header.Get("x-amz-id-2")
+	if requestID == "" || hostID == "" {
+		t.Fatalf("expected request ID headers, got %v", resp.Header)
+	}
+
+	var errResp ErrorResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if errResp.Code != "NoSuchKey" || errResp.Resource != "/test-bucket/dir/missing.txt" {
+		t.Errorf("unexpected error response %+v", errResp)
+	}
+	if errResp.RequestId != requestID || errResp.HostId != hostID {
+		t.Errorf("expected request IDs %q and %q in the body, got %+v", requestID, hostID, errResp)
+	}
+}
+
+func TestSendError_HeadWithoutBody(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	resp := serveSigned(t, srv, http.MethodHead, "/test-bucket/missing.txt", "")
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("x-amz-request-id") == "" {
+		t.Error("expected x-amz-request-id header")
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if len(body) != 0 {
+		t.Errorf("expected no body, got %q", body)
+	}
+}
+
+func TestSendAuthError(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	cfg := srv.config
+
+	tests := []struct {
+		name   string
+		setup  func(req *http.Request)
+		status int
+		code   string
+	}{
+		{"missing credentials", func(req *http.Request) {}, http.StatusForbidden, "AccessDenied"},
+		{"unknown access key", func(req *http.Request) {
+			signRequest(req, "wrong-key", cfg.SecretKey, cfg.Region)
+		}, http.StatusForbidden, "InvalidAccessKeyId"},
+		{"wrong secret", func(req *http.Request) {
+			signRequest(req, cfg.AccessKey, "wrong-secret", cfg.Region)
+		}, http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"skewed time", func(req *http.Request) {
+			signRequest(req, cfg.AccessKey, cfg.SecretKey, cfg.Region)
+			req.Header.Set("X-Amz-Date", time.Now().UTC().Add(-time.Hour).Format("20060102T150405Z"))
+		}, http.StatusForbidden, "RequestTimeTooSkewed"},
+		{"malformed header", func(req *http.Request) {
+			req.Header.Set("Authorization", "AWS4-HMAC-SHA256 garbage")
+		}, http.StatusBadRequest, "AuthorizationHeaderMalformed"},
+		{"expired presigned URL", func(req *http.Request) {
+			signed := time.Now().UTC().Add(-2 * time.Hour)
+			query := req.URL.Query()
+			query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+			query.Set("X-Amz-Credential", cfg.AccessKey+"/"+signed.Format("20060102")+"/"+cfg.Region+"/s3/aws4_request")
+			query.Set("X-Amz-Date", signed.Format("20060102T150405Z"))
+			query.Set("X-Amz-Expires", "60")
+			query.Set("X-Amz-SignedHeaders", "host")
+			query.Set("X-Amz-Signature", "0000")
+			req.URL.RawQuery = query.Encode()
+		}, http.StatusForbidden, "AccessDenied"},
+		{"malformed presigned URL", func(req *http.Request) {
+			req.URL.RawQuery = "X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Signature=0000"
+		}, http.StatusBadRequest, "AuthorizationQueryParametersError"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test-bucket/file.txt", nil)
+			req.Host = "localhost:9000"
+			tt.setup(req)
+
+			w := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(w, req)
+
+			var errResp ErrorResponse
+			if err := xml.NewDecoder(w.Body).Decode(&errResp); err != nil {
+				t.Fatalf("failed to decode error response: %v", err)
+			}
+			if w.Code != tt.status || errResp.Code != tt.code {
+				t.Errorf("expected %d %s, got %d %s (%s)", tt.status, tt.code, w.Code, errResp.Code, errResp.Message)
+			}
+			if errResp.RequestId != w.Header().Get("x-amz-request-id") {
+				t.Errorf("expected request ID %q in the body, got %q", w.Header().Get("x-amz-request-id"), errResp.RequestId)
+			}
+		})
+	}
+}
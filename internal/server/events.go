@@ -0,0 +1,29 @@
+package server
+
+import (
+	"log"
+
+	"github.com/Notifuse/selfhost_s3/internal/events"
+	"github.com/Notifuse/selfhost_s3/internal/lifecycle"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// publish queues an event notification for the configured webhooks.
+// Failing to queue an event is logged but never fails the request that caused it.
+func (s *Server) publish(eventName string, obj events.Object) {
+	if s.notifier == nil {
+		return
+	}
+	if err := s.notifier.Publish(eventName, obj); err != nil {
+		log.Printf("Failed to queue %s event for %s: %v", eventName, obj.Key, err)
+	}
+}
+
+// publishLifecycleDelete publishes the event for a deletion made by the lifecycle scheduler
+func (s *Server) publishLifecycleDelete(action lifecycle.Action, result *storage.DeleteResult) {
+	eventName := events.LifecycleExpirationDelete
+	if result.DeleteMarker && action.VersionID == "" {
+		eventName = events.LifecycleExpirationDeleteMarkerCreated
+	}
+	s.publish(eventName, events.Object{Key: action.Key, VersionID: result.VersionID})
+}
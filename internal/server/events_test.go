@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+	"github.com/Notifuse/selfhost_s3/internal/events"
+)
+
+func TestEventNotifications(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(events.HeaderSignature) != events.Sign("hook-secret", body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var notification events.Notification
+		_ = json.Unmarshal(body, &notification)
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, record := range notification.Records {
+			received = append(received, record.EventName+" "+record.S3.Object.Key)
+		}
+	}))
+	defer hook.Close()
+
+	cfg := testConfig(t)
+	cfg.Webhooks = []config.Webhook{{
+		URL:    hook.URL,
+		Secret: "hook-secret",
+		Events: []string{"s3:ObjectCreated:*", "s3:ObjectRemoved:*"},
+		Prefix: "uploads/",
+	}}
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	srv.notifier.Start()
+	defer srv.notifier.Stop()
+
+	doRequest(t, srv, http.MethodPut, "/test-bucket/uploads/a.txt", "data")
+	doRequest(t, srv, http.MethodPut, "/test-bucket/other/b.txt", "data")
+	doRequest(t, srv, http.MethodDelete, "/test-bucket/uploads/a.txt", "")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(received)
+		mu.Unlock()
+		if n >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"ObjectCreated:Put uploads%2Fa.txt", "ObjectRemoved:Delete uploads%2Fa.txt"}
+	if len(received) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, received)
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Errorf("event %d: expected %q, got %q", i, expected[i], received[i])
+		}
+	}
+}
+
+func TestEventNotifications_InvalidEventName(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Webhooks = []config.Webhook{{URL: "http://localhost/hook", Events: []string{"s3:ObjectRestore:Post"}}}
+
+	if _, err := NewServer(cfg); err == nil {
+		t.Error("expected error for unsupported event name")
+	}
+}
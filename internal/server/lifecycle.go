@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/Notifuse/selfhost_s3/internal/lifecycle"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// lifecycleConfigName is the bucket configuration document holding the lifecycle rules
+const lifecycleConfigName = "lifecycle"
+
+// handleGetBucketLifecycle handles GetBucketLifecycleConfiguration requests
+func (s *Server) handleGetBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	data, err := s.storage.GetBucketConfig(lifecycleConfigName)
+	if err != nil {
+		if err == storage.ErrNoSuchConfig {
+			s.sendError(w, r, http.StatusNotFound, "NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist")
+			return
+		}
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(data)
+}
+
+// handlePutBucketLifecycle handles PutBucketLifecycleConfiguration requests
+func (s *Server) handlePutBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxConfigBodySize))
+	if err != nil {
+		s.sendError(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema")
+		return
+	}
+
+	cfg, err := lifecycle.Parse(body)
+	if err != nil {
+		if errors.Is(err, lifecycle.ErrMalformed) {
+			s.sendError(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema")
+			return
+		}
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+
+	// Store a normalized document so GET returns a clean configuration
+	cfg.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	data, err := xml.Marshal(cfg)
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	if err := s.storage.PutBucketConfig(lifecycleConfigName, data); err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleDeleteBucketLifecycle handles DeleteBucketLifecycle requests
+func (s *Server) handleDeleteBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	if err := s.storage.DeleteBucketConfig(lifecycleConfigName); err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
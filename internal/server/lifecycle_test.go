@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/lifecycle"
+)
+
+func TestBucketLifecycle_PutGetDelete(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	// No configuration yet
+	resp := doRequest(t, srv, http.MethodGet, "/test-bucket?lifecycle", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "NoSuchLifecycleConfiguration") {
+		t.Errorf("expected NoSuchLifecycleConfiguration error, got %s", body)
+	}
+
+	resp = doRequest(t, srv, http.MethodPut, "/test-bucket?lifecycle", `<LifecycleConfiguration>
+		<Rule>
+			<ID>expire-exports</ID>
+			<Filter><Prefix>exports/</Prefix></Filter>
+			<Status>Enabled</Status>
+			<Expiration><Days>7</Days></Expiration>
+		</Rule>
+	</LifecycleConfiguration>`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, srv, http.MethodGet, "/test-bucket?lifecycle", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	var cfg lifecycle.Configuration
+	if err := xml.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		t.Fatalf("failed to decode lifecycle configuration: %v", err)
+	}
+	if len(cfg.Rules) != 1 || cfg.Rules[0].ID != "expire-exports" || cfg.Rules[0].Expiration.Days != 7 {
+		t.Errorf("unexpected lifecycle configuration: %+v", cfg)
+	}
+
+	resp = doRequest(t, srv, http.MethodDelete, "/test-bucket?lifecycle", "")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, srv, http.MethodGet, "/test-bucket?lifecycle", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 after delete, got %d", resp.StatusCode)
+	}
+}
+
+func TestBucketLifecycle_InvalidConfiguration(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"malformed", `<LifecycleConfiguration><Rule>`, "MalformedXML"},
+		{"no action", `<LifecycleConfiguration><Rule><Status>Enabled</Status></Rule></LifecycleConfiguration>`, "InvalidArgument"},
+		{"bad status", `<LifecycleConfiguration><Rule><Status>yes</Status><Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`, "InvalidArgument"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(t, srv, http.MethodPut, "/test-bucket?lifecycle", tt.body)
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("expected status 400, got %d", resp.StatusCode)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if !strings.Contains(string(body), tt.code) {
+				t.Errorf("expected %s error, got %s", tt.code, body)
+			}
+		})
+	}
+}
+
+func TestBucketLifecycle_Metrics(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.LifecycleInterval = time.Hour
+	cfg.LifecycleDryRun = true
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	if out := scrapeMetrics(t, srv); strings.Contains(out, "selfhost_s3_lifecycle_") {
+		t.Errorf("expected no lifecycle metrics before the first run:\n%s", out)
+	}
+
+	doRequest(t, srv, http.MethodPut, "/test-bucket?lifecycle", `<LifecycleConfiguration><Rule>
+		<ID>expire-exports</ID><Filter><Prefix>exports/</Prefix></Filter><Status>Enabled</Status>
+		<Expiration><Date>2020-01-01T00:00:00Z</Date></Expiration>
+	</Rule></LifecycleConfiguration>`)
+	doRequest(t, srv, http.MethodPut, "/test-bucket/exports/a.csv", "a")
+	doRequest(t, srv, http.MethodPut, "/test-bucket/keep/b.csv", "b")
+	if _, err := srv.lifecycle.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	out := scrapeMetrics(t, srv)
+	for _, expected := range []string{
+		`selfhost_s3_lifecycle_last_run_actions{action="Expiration"} 1`,
+		`selfhost_s3_lifecycle_last_run_actions{action="AbortIncompleteMultipartUpload"} 0`,
+		`selfhost_s3_lifecycle_last_run_errors 0`,
+		`selfhost_s3_lifecycle_last_run_timestamp_seconds `,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in metrics output:\n%s", expected, out)
+		}
+	}
+}
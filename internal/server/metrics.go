@@ -0,0 +1,266 @@
+package server
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/lifecycle"
+	"github.com/Notifuse/selfhost_s3/internal/replication"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// usageTTL is how long the bucket usage reported on /metrics is cached,
+// since computing it walks the whole bucket
+const usageTTL = 30 * time.Second
+
+// latencyBuckets are the request latency histogram buckets in seconds, from 5ms to 1 minute
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// serverMetrics are the metrics exposed on /metrics
+type serverMetrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	duration        *prometheus.HistogramVec
+	bytesReceived   *prometheus.CounterVec
+	bytesSent       *prometheus.CounterVec
+	authFailures    *prometheus.CounterVec
+	uploadsInFlight prometheus.Gauge
+}
+
+// newServerMetrics registers the server metrics for bucket, along with the Go runtime
+// and process metrics
+func newServerMetrics(bucket string, store storage.Backend) *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "selfhost_s3_requests_total",
+			Help: "S3 API requests by operation and HTTP status.",
+		}, []string{"operation", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "selfhost_s3_request_duration_seconds",
+			Help:    "S3 API request latency by operation and HTTP status.",
+			Buckets: latencyBuckets,
+		}, []string{"operation", "status"}),
+		bytesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "selfhost_s3_received_bytes_total",
+			Help: "Request body bytes received by operation.",
+		}, []string{"operation"}),
+		bytesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "selfhost_s3_sent_bytes_total",
+			Help: "Response body bytes sent by operation.",
+		}, []string{"operation"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "selfhost_s3_auth_failures_total",
+			Help: "Rejected request signatures by reason.",
+		}, []string{"reason"}),
+		uploadsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "selfhost_s3_uploads_in_flight",
+			Help: "PutObject and UploadPart requests in progress.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.duration, m.bytesReceived, m.bytesSent, m.authFailures, m.uploadsInFlight,
+		newUsageCollector(bucket, store),
+	)
+	return m
+}
+
+// gaugeFunc registers a gauge computed by fn when the metrics are scraped
+func (m *serverMetrics) gaugeFunc(name, help string, fn func() float64) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, fn))
+}
+
+// registerReadOnly exposes whether disk has put the server in read-only mode
+func (m *serverMetrics) registerReadOnly(disk *diskMonitor) {
+	m.gaugeFunc("selfhost_s3_read_only",
+		"1 while writes are rejected because free disk space is below S3_MIN_FREE_SPACE.", func() float64 {
+			if disk.readOnly.Load() {
+				return 1
+			}
+			return 0
+		})
+}
+
+// registerScrub exposes the issues left by the last background scrub
+func (m *serverMetrics) registerScrub(scrubber *scrubScheduler) {
+	m.gaugeFunc("selfhost_s3_scrub_issues",
+		"Issues found and left unresolved by the last scrub of the stored data.", func() float64 {
+			return float64(scrubber.unresolved.Load())
+		})
+}
+
+// registerCache exposes the objects fetched from the upstream bucket and kept locally
+func (m *serverMetrics) registerCache(cache *storage.Cache) {
+	m.gaugeFunc("selfhost_s3_cache_bytes",
+		"Size of the objects kept locally that can be fetched again from the upstream bucket.", func() float64 {
+			return float64(cache.Stats().Bytes)
+		})
+	m.gaugeFunc("selfhost_s3_cache_objects",
+		"Number of objects kept locally that can be fetched again from the upstream bucket.", func() float64 {
+			return float64(cache.Stats().Objects)
+		})
+}
+
+// registerReplication exposes the replications waiting to be replayed and given up on
+func (m *serverMetrics) registerReplication(replicator *replication.Replicator) {
+	m.gaugeFunc("selfhost_s3_replication_pending",
+		"Writes and deletes waiting to be replicated.", func() float64 {
+			return float64(replicator.Pending())
+		})
+	m.gaugeFunc("selfhost_s3_replication_failed",
+		"Writes and deletes whose replication was given up on.", func() float64 {
+			return float64(replicator.Failed())
+		})
+}
+
+// registerLifecycle exposes the outcome of the last lifecycle run
+func (m *serverMetrics) registerLifecycle(scheduler *lifecycle.Scheduler) {
+	m.registry.MustRegister(&lifecycleCollector{
+		scheduler: scheduler,
+		timestamp: prometheus.NewDesc("selfhost_s3_lifecycle_last_run_timestamp_seconds",
+			"Time the last lifecycle run started.", nil, nil),
+		actions: prometheus.NewDesc("selfhost_s3_lifecycle_last_run_actions",
+			"Deletions and aborted uploads of the last lifecycle run by action, planned ones in dry-run mode.", []string{"action"}, nil),
+		errors: prometheus.NewDesc("selfhost_s3_lifecycle_last_run_errors",
+			"Actions of the last lifecycle run that failed.", nil, nil),
+	})
+}
+
+// observe records a completed S3 API request
+func (m *serverMetrics) observe(operation string, status int, duration time.Duration, received, sent int64) {
+	code := strconv.Itoa(status)
+	m.requests.WithLabelValues(operation, code).Inc()
+	m.duration.WithLabelValues(operation, code).Observe(duration.Seconds())
+	m.bytesReceived.WithLabelValues(operation).Add(float64(received))
+	m.bytesSent.WithLabelValues(operation).Add(float64(sent))
+}
+
+// handler serves the metrics, requiring token as bearer token when it is set
+func (m *serverMetrics) handler(token string) http.HandlerFunc {
+	serve := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		serve.ServeHTTP(w, r)
+	}
+}
+
+// lifecycleCollector exposes the last lifecycle report, once the scheduler has run
+type lifecycleCollector struct {
+	scheduler *lifecycle.Scheduler
+	timestamp *prometheus.Desc
+	actions   *prometheus.Desc
+	errors    *prometheus.Desc
+}
+
+// Describe implements prometheus.Collector
+func (c *lifecycleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.timestamp
+	ch <- c.actions
+	ch <- c.errors
+}
+
+// Collect implements prometheus.Collector
+func (c *lifecycleCollector) Collect(ch chan<- prometheus.Metric) {
+	report := c.scheduler.LastReport()
+	if report == nil {
+		return
+	}
+
+	actions := map[string]int{
+		lifecycle.ActionExpire:                  0,
+		lifecycle.ActionExpireNoncurrent:        0,
+		lifecycle.ActionRemoveExpiredDeleteMark: 0,
+		lifecycle.ActionAbortUpload:             0,
+	}
+	for _, action := range report.Actions {
+		actions[action.Type]++
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.timestamp, prometheus.GaugeValue, float64(report.StartedAt.Unix()))
+	for action, n := range actions {
+		ch <- prometheus.MustNewConstMetric(c.actions, prometheus.GaugeValue, float64(n), action)
+	}
+	ch <- prometheus.MustNewConstMetric(c.errors, prometheus.GaugeValue, float64(len(report.Errors)))
+}
+
+// usageCollector exposes the bucket usage, leaving the gauges out of a scrape when
+// it cannot be computed
+type usageCollector struct {
+	usage   *usageCache
+	objects *prometheus.Desc
+	bytes   *prometheus.Desc
+}
+
+// newUsageCollector creates the collector of the usage of bucket
+func newUsageCollector(bucket string, store storage.Backend) *usageCollector {
+	labels := prometheus.Labels{"bucket": bucket}
+	return &usageCollector{
+		usage: &usageCache{backend: store},
+		objects: prometheus.NewDesc("selfhost_s3_bucket_objects",
+			"Objects in the bucket.", nil, labels),
+		bytes: prometheus.NewDesc("selfhost_s3_bucket_size_bytes",
+			"Bytes stored for the bucket, including non-current versions and incomplete uploads.", nil, labels),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *usageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.objects
+	ch <- c.bytes
+}
+
+// Collect implements prometheus.Collector
+func (c *usageCollector) Collect(ch chan<- prometheus.Metric) {
+	usage, ok := c.usage.get()
+	if !ok {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.objects, prometheus.GaugeValue, float64(usage.Objects))
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(usage.Bytes))
+}
+
+// usageCache computes the bucket usage at most once per usageTTL
+type usageCache struct {
+	backend storage.Backend
+
+	mu      sync.Mutex
+	usage   *storage.Usage
+	updated time.Time
+}
+
+// get returns the cached usage, refreshing it when it is stale
+func (c *usageCache) get() (*storage.Usage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.usage != nil && time.Since(c.updated) < usageTTL {
+		return c.usage, true
+	}
+
+	usage, err := c.backend.Usage()
+	if err != nil {
+		log.Printf("Metrics: failed to compute bucket usage: %v", err)
+		return nil, false
+	}
+	c.usage = usage
+	c.updated = time.Now()
+	return usage, true
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// scrapeMetrics returns the /metrics output of srv
+func scrapeMetrics(t *testing.T, srv *Server) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 from /metrics, got %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+// serveSigned sends a signed request through the full handler chain
+func serveSigned(t *testing.T, srv *Server, method, target, body string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Host = "localhost:9000"
+	signRequest(req, srv.config.AccessKey, srv.config.SecretKey, srv.config.Region)
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	return w.Result()
+}
+
+func TestMetrics_RequestsAndBytes(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	serveSigned(t, srv, http.MethodPut, "/test-bucket/hello.txt", "hello world")
+	resp := serveSigned(t, srv, http.MethodGet, "/test-bucket/hello.txt", "")
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hello world" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	serveSigned(t, srv, http.MethodGet, "/test-bucket/missing.txt", "")
+
+	out := scrapeMetrics(t, srv)
+	for _, expected := range []string{
+		`selfhost_s3_requests_total{operation="PutObject",status="200"} 1`,
+		`selfhost_s3_requests_total{operation="GetObject",status="200"} 1`,
+		`selfhost_s3_requests_total{operation="GetObject",status="404"} 1`,
+		`selfhost_s3_request_duration_seconds_count{operation="PutObject",status="200"} 1`,
+		`selfhost_s3_received_bytes_total{operation="PutObject"} 11`,
+		`selfhost_s3_sent_bytes_total{operation="GetObject"} `,
+		`selfhost_s3_uploads_in_flight 0`,
+		`selfhost_s3_bucket_objects{bucket="test-bucket"} 1`, // folder markers such as public/ are not counted
+		`selfhost_s3_bucket_size_bytes{bucket="test-bucket"} `,
+		`go_goroutines `, // Go runtime metrics
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in metrics output:\n%s", expected, out)
+		}
+	}
+
+	if v := testutil.ToFloat64(srv.metrics.bytesSent.WithLabelValues("GetObject")); v < 11 {
+		t.Errorf("expected at least 11 bytes sent for GetObject, got %v", v)
+	}
+}
+
+func TestMetrics_AuthFailures(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test-bucket/file.txt", nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/test-bucket/file.txt", nil)
+	signRequest(req, "wrong-key", srv.config.SecretKey, srv.config.Region)
+	srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/test-bucket/file.txt", nil)
+	signRequest(req, srv.config.AccessKey, "wrong-secret", srv.config.Region)
+	srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	out := scrapeMetrics(t, srv)
+	for _, expected := range []string{
+		`selfhost_s3_auth_failures_total{reason="missing_credentials"} 1`,
+		`selfhost_s3_auth_failures_total{reason="invalid_access_key"} 1`,
+		`selfhost_s3_auth_failures_total{reason="signature_mismatch"} 1`,
+		`selfhost_s3_requests_total{operation="GetObject",status="403"} 3`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in metrics output:\n%s", expected, out)
+		}
+	}
+}
+
+func TestMetrics_Token(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MetricsToken = "scrape-token"
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without token, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scrape-token")
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 with token, got %d", w.Code)
+	}
+}
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/accesslog"
+)
+
+// accessLogOperations maps S3 operations to their name in S3 server access logs
+var accessLogOperations = map[string]string{
+	"ListObjects":                     "REST.GET.BUCKET",
+	"ListObjectsV2":                   "REST.GET.BUCKET",
+	"ListObjectVersions":              "REST.GET.BUCKETVERSIONS",
+	"ListMultipartUploads":            "REST.GET.UPLOADS",
+	"HeadBucket":                      "REST.HEAD.BUCKET",
+	"GetBucketVersioning":             "REST.GET.VERSIONING",
+	"PutBucketVersioning":             "REST.PUT.VERSIONING",
+	"GetBucketLifecycleConfiguration": "REST.GET.LIFECYCLE",
+	"PutBucketLifecycleConfiguration": "REST.PUT.LIFECYCLE",
+	"DeleteBucketLifecycle":           "REST.DELETE.LIFECYCLE",
+	"GetBucketCors":                   "REST.GET.CORS",
+	"PutBucketCors":                   "REST.PUT.CORS",
+	"DeleteBucketCors":                "REST.DELETE.CORS",
+	"GetBucketSnapshot":               "REST.GET.SNAPSHOT",
+	"GetObject":                       "REST.GET.OBJECT",
+	"HeadObject":                      "REST.HEAD.OBJECT",
+	"PutObject":                       "REST.PUT.OBJECT",
+	"CopyObject":                      "REST.COPY.OBJECT",
+	"DeleteObject":                    "REST.DELETE.OBJECT",
+	"GetObjectTagging":                "REST.GET.OBJECT_TAGGING",
+	"PutObjectTagging":                "REST.PUT.OBJECT_TAGGING",
+	"DeleteObjectTagging":             "REST.DELETE.OBJECT_TAGGING",
+	"CreateMultipartUpload":           "REST.POST.UPLOADS",
+	"UploadPart":                      "REST.PUT.PART",
+	"ListParts":                       "REST.GET.UPLOAD",
+	"CompleteMultipartUpload":         "REST.POST.UPLOAD",
+	"AbortMultipartUpload":            "REST.DELETE.UPLOAD",
+}
+
+// s3Operation names the S3 API operation of a request, following the routing in handleRequest
+func s3Operation(r *http.Request) string {
+	query := r.URL.Query()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	_, key, _ := strings.Cut(path, "/")
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodGet:
+			switch {
+			case query.Has("versioning"):
+				return "GetBucketVersioning"
+			case query.Has("versions"):
+				return "ListObjectVersions"
+			case query.Has("lifecycle"):
+				return "GetBucketLifecycleConfiguration"
+			case query.Has("cors"):
+				return "GetBucketCors"
+			case query.Has("uploads"):
+				return "ListMultipartUploads"
+			case query.Has("snapshot"):
+				return "GetBucketSnapshot"
+			case query.Get("list-type") == "2":
+				return "ListObjectsV2"
+			default:
+				return "ListObjects"
+			}
+		case http.MethodHead:
+			return "HeadBucket"
+		case http.MethodPut:
+			switch {
+			case query.Has("versioning"):
+				return "PutBucketVersioning"
+			case query.Has("lifecycle"):
+				return "PutBucketLifecycleConfiguration"
+			case query.Has("cors"):
+				return "PutBucketCors"
+			}
+		case http.MethodDelete:
+			switch {
+			case query.Has("lifecycle"):
+				return "DeleteBucketLifecycle"
+			case query.Has("cors"):
+				return "DeleteBucketCors"
+			}
+		}
+		return "Unknown"
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		switch {
+		case query.Has("list-type"):
+			return "ListObjectsV2"
+		case query.Has("tagging"):
+			return "GetObjectTagging"
+		case query.Has("uploadId"):
+			return "ListParts"
+		default:
+			return "GetObject"
+		}
+	case http.MethodHead:
+		return "HeadObject"
+	case http.MethodPut:
+		switch {
+		case query.Has("tagging"):
+			return "PutObjectTagging"
+		case query.Has("uploadId"):
+			return "UploadPart"
+		case r.Header.Get("x-amz-copy-source") != "":
+			return "CopyObject"
+		default:
+			return "PutObject"
+		}
+	case http.MethodPost:
+		switch {
+		case query.Has("uploads"):
+			return "CreateMultipartUpload"
+		case query.Has("uploadId"):
+			return "CompleteMultipartUpload"
+		}
+	case http.MethodDelete:
+		switch {
+		case query.Has("tagging"):
+			return "DeleteObjectTagging"
+		case query.Has("uploadId"):
+			return "AbortMultipartUpload"
+		default:
+			return "DeleteObject"
+		}
+	}
+	return "Unknown"
+}
+
+// requestInfo is what handlers learn about a request that the logs record
+type requestInfo struct {
+	id        string // x-amz-request-id
+	hostID    string // x-amz-id-2
+	accessKey string // set once the signature is verified
+}
+
+type requestInfoKey struct{}
+
+// requestInfoFrom returns the request information attached by instrument, if any
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// newRequestID returns a request ID in the format used by S3
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return strings.ToUpper(hex.EncodeToString(b[:]))
+}
+
+// newHostID returns an extended request ID in the format used by S3
+func newHostID() string {
+	var b [48]byte
+	_, _ = rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// instrument assigns request IDs and records metrics, a structured log record
+// and an access log entry for every S3 API request
+func (s *Server) instrument(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		operation := s3Operation(r)
+
+		info := &requestInfo{id: newRequestID(), hostID: newHostID()}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		w.Header().Set("x-amz-request-id", info.id)
+		w.Header().Set("x-amz-id-2", info.hostID)
+
+		if operation == "PutObject" || operation == "UploadPart" {
+			s.metrics.uploadsInFlight.Inc()
+			defer s.metrics.uploadsInFlight.Dec()
+		}
+
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next(rec, r)
+
+		duration := time.Since(start)
+		s.metrics.observe(operation, rec.status, duration, body.n, rec.written)
+		s.logRequest(r, info, operation, rec, body.n, duration)
+		if s.accessLog != nil {
+			s.accessLog.Log(s.accessLogEntry(r, info, operation, rec, body.n, start, duration))
+		}
+	}
+}
+
+// logRequest writes the structured log record of a request. Server errors are
+// logged at error level, everything else at info level.
+func (s *Server) logRequest(r *http.Request, info *requestInfo, operation string, rec *statusRecorder, received int64, duration time.Duration) {
+	level := slog.LevelInfo
+	if rec.status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+
+	attrs := []slog.Attr{
+		slog.String("request_id", info.id),
+		slog.String("operation", operation),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", rec.status),
+		slog.Int64("bytes_in", received),
+		slog.Int64("bytes_out", rec.written),
+		slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+		slog.String("client_ip", clientIP(r)),
+	}
+	if rec.errorCode != "" {
+		attrs = append(attrs, slog.String("error_code", rec.errorCode))
+	}
+	if info.accessKey != "" {
+		attrs = append(attrs, slog.String("access_key", info.accessKey))
+	}
+	if ua := r.UserAgent(); ua != "" {
+		attrs = append(attrs, slog.String("user_agent", ua))
+	}
+
+	slog.LogAttrs(r.Context(), level, "request", attrs...)
+}
+
+// accessLogEntry describes a request in the S3 server access log format
+func (s *Server) accessLogEntry(r *http.Request, info *requestInfo, operation string, rec *statusRecorder, received int64, start time.Time, duration time.Duration) *accesslog.Entry {
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+
+	entry := &accesslog.Entry{
+		Bucket:     s.config.Bucket,
+		Time:       start,
+		RemoteIP:   clientIP(r),
+		Requester:  info.accessKey,
+		RequestID:  info.id,
+		Operation:  accessLogOperations[operation],
+		Key:        key,
+		RequestURI: r.Method + " " + r.URL.RequestURI() + " " + r.Proto,
+		Status:     rec.status,
+		ErrorCode:  rec.errorCode,
+		BytesSent:  rec.written,
+		ObjectSize: -1,
+		TotalTime:  duration,
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+		VersionID:  r.URL.Query().Get("versionId"),
+		HostID:     info.hostID,
+		Host:       r.Host,
+	}
+	if entry.Operation == "" {
+		entry.Operation = "REST." + r.Method + ".UNKNOWN"
+	}
+
+	switch operation {
+	case "GetObject", "HeadObject":
+		if size, err := strconv.ParseInt(rec.Header().Get("Content-Length"), 10, 64); err == nil {
+			entry.ObjectSize = size
+		}
+	case "PutObject", "UploadPart":
+		entry.ObjectSize = received
+	}
+
+	if r.URL.Query().Has("X-Amz-Signature") {
+		entry.SignatureVersion, entry.AuthType = "SigV4", "QueryString"
+	} else if r.Header.Get("Authorization") != "" {
+		entry.SignatureVersion, entry.AuthType = "SigV4", "AuthHeader"
+	}
+
+	if r.TLS != nil {
+		entry.CipherSuite = tls.CipherSuiteName(r.TLS.CipherSuite)
+		entry.TLSVersion = strings.Replace(tls.VersionName(r.TLS.Version), "TLS 1", "TLSv1", 1)
+	}
+
+	return entry
+}
+
+// clientIP returns the IP address of the client connection
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusRecorder captures the status code, body size and S3 error code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	written     int64
+	errorCode   string
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(p)
+	rec.written += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the sendfile optimization of the underlying writer for object downloads
+func (rec *statusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	rec.wroteHeader = true
+	var n int64
+	var err error
+	if rf, ok := rec.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(rec.ResponseWriter, src)
+	}
+	rec.written += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// setErrorCode records the S3 error code of a response for the logs
+func setErrorCode(w http.ResponseWriter, code string) {
+	if rec, ok := w.(*statusRecorder); ok {
+		rec.errorCode = code
+	}
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
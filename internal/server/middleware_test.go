@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// captureLogs sends the default slog records to a buffer as JSON for the duration of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+// requestRecords returns the "request" records in captured JSON logs
+func requestRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			continue
+		}
+		if record["msg"] == "request" {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestInstrument_RequestIDAndLogRecord(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	logs := captureLogs(t)
+
+	resp := serveSigned(t, srv, http.MethodPut, "/test-bucket/logged.txt", "hello")
+	requestID := resp.Header.Get("x-amz-request-id")
+	if len(requestID) != 16 {
+		t.Errorf("expected a 16 character request ID, got %q", requestID)
+	}
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test-bucket/secret.txt", nil))
+
+	records := requestRecords(t, logs)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 request records, got %d:\n%s", len(records), logs.String())
+	}
+
+	put := records[0]
+	if put["request_id"] != requestID || put["operation"] != "PutObject" || put["status"] != float64(200) {
+		t.Errorf("unexpected record %v", put)
+	}
+	if put["bytes_in"] != float64(5) || put["access_key"] != "test-access-key" || put["client_ip"] != "192.0.2.1" {
+		t.Errorf("unexpected record %v", put)
+	}
+
+	denied := records[1]
+	if denied["status"] != float64(403) || denied["error_code"] != "AccessDenied" || denied["access_key"] != nil {
+		t.Errorf("unexpected record %v", denied)
+	}
+}
+
+func TestInstrument_AccessLogFile(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.AccessLogFile = filepath.Join(t.TempDir(), "logs", "access.log")
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer srv.Close()
+
+	serveSigned(t, srv, http.MethodPut, "/test-bucket/dir/my%20file.txt", "hello")
+	serveSigned(t, srv, http.MethodGet, "/test-bucket/dir/my%20file.txt?versionId=null", "")
+
+	data, err := os.ReadFile(cfg.AccessLogFile)
+	if err != nil {
+		t.Fatalf("failed to read access log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 access log lines, got %d:\n%s", len(lines), data)
+	}
+
+	put := strings.Fields(lines[0])
+	if put[1] != "test-bucket" || put[5] != "test-access-key" || put[7] != "REST.PUT.OBJECT" || put[8] != "dir/my%20file.txt" {
+		t.Errorf("unexpected PUT line: %s", lines[0])
+	}
+	if !strings.Contains(lines[0], `"PUT /test-bucket/dir/my%20file.txt HTTP/1.1" 200 - - 5 `) {
+		t.Errorf("unexpected PUT line: %s", lines[0])
+	}
+	if !strings.Contains(lines[0], " SigV4 - AuthHeader localhost:9000 ") {
+		t.Errorf("expected signature and host fields in: %s", lines[0])
+	}
+
+	if !strings.Contains(lines[1], "REST.GET.OBJECT") || !strings.Contains(lines[1], " 200 - 5 5 ") || !strings.Contains(lines[1], " null ") {
+		t.Errorf("unexpected GET line: %s", lines[1])
+	}
+	if get := strings.Fields(lines[1]); get[21] == "-" || get[22] != "SigV4" {
+		t.Errorf("expected host ID and signature fields in: %s", lines[1])
+	}
+}
+
+func TestInstrument_AccessLogDeliveredToBucket(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.StorageBackend = "memory"
+	cfg.AccessLogPrefix = "logs/"
+	cfg.AccessLogInterval = time.Hour
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	srv.StartBackground()
+
+	serveSigned(t, srv, http.MethodPut, "/test-bucket/a.txt", "a")
+	srv.Close()
+
+	objects, err := srv.storage.ListObjects("logs/")
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	var logObjects []storage.Object
+	for _, obj := range objects {
+		if !strings.HasSuffix(obj.Key, "/") {
+			logObjects = append(logObjects, obj)
+		}
+	}
+	if len(logObjects) != 1 {
+		t.Fatalf("expected 1 log object delivered on close, got %+v", objects)
+	}
+
+	_, reader, err := srv.storage.GetObject(logObjects[0].Key)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+	var content bytes.Buffer
+	_, _ = content.ReadFrom(reader)
+	if !strings.Contains(content.String(), "REST.PUT.OBJECT a.txt") {
+		t.Errorf("unexpected log object content: %s", content.String())
+	}
+}
+
+func TestS3Operation(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		header string
+		want   string
+	}{
+		{http.MethodGet, "/test-bucket?list-type=2", "", "ListObjectsV2"},
+		{http.MethodGet, "/test-bucket", "", "ListObjects"},
+		{http.MethodGet, "/test-bucket?versions", "", "ListObjectVersions"},
+		{http.MethodPut, "/test-bucket?versioning", "", "PutBucketVersioning"},
+		{http.MethodHead, "/test-bucket", "", "HeadBucket"},
+		{http.MethodGet, "/test-bucket/a.txt", "", "GetObject"},
+		{http.MethodGet, "/test-bucket/a.txt?tagging", "", "GetObjectTagging"},
+		{http.MethodPut, "/test-bucket/a.txt", "", "PutObject"},
+		{http.MethodPut, "/test-bucket/a.txt", "test-bucket/b.txt", "CopyObject"},
+		{http.MethodPut, "/test-bucket/a.txt?partNumber=1&uploadId=x", "", "UploadPart"},
+		{http.MethodPost, "/test-bucket/a.txt?uploads", "", "CreateMultipartUpload"},
+		{http.MethodPost, "/test-bucket/a.txt?uploadId=x", "", "CompleteMultipartUpload"},
+		{http.MethodDelete, "/test-bucket/a.txt?uploadId=x", "", "AbortMultipartUpload"},
+		{http.MethodDelete, "/test-bucket/a.txt", "", "DeleteObject"},
+		{http.MethodPatch, "/test-bucket/a.txt", "", "Unknown"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.header != "" {
+			req.Header.Set("x-amz-copy-source", tt.header)
+		}
+		if got := s3Operation(req); got != tt.want {
+			t.Errorf("%s %s: expected %s, got %s", tt.method, tt.target, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/events"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// handleCreateMultipartUpload handles CreateMultipartUpload requests (POST ?uploads)
+func (s *Server) handleCreateMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	tags, err := parseTaggingHeader(r.Header.Get("x-amz-tagging"))
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	opts, err := parseEncryptionHeaders(r.Header)
+	if err != nil {
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	opts.ContentType = contentType
+	opts.Tags = tags
+
+	upload, err := s.storage.CreateMultipartUpload(key, opts)
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	setEncryptionHeaders(w, upload.Encryption, upload.CustomerKeyMD5)
+	s.sendXML(w, http.StatusOK, InitiateMultipartUploadResult{
+		Xmlns:    "http://s3.amazonaws.com/doc/2006-03-01/",
+		Bucket:   s.config.Bucket,
+		Key:      upload.Key,
+		UploadID: upload.ID,
+	})
+}
+
+// handleUploadPart handles UploadPart requests (PUT ?partNumber&uploadId)
+func (s *Server) handleUploadPart(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > storage.MaxPartNumber {
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument",
+			fmt.Sprintf("Part number must be an integer between 1 and %d, inclusive", storage.MaxPartNumber))
+		return
+	}
+
+	if r.ContentLength > s.config.MaxFileSize {
+		s.sendError(w, r, http.StatusBadRequest, "EntityTooLarge",
+			fmt.Sprintf("Your proposed upload exceeds the maximum allowed size of %d bytes", s.config.MaxFileSize))
+		return
+	}
+
+	customerKey, err := parseCustomerKey(r.Header, sseCustomerPrefix)
+	if err != nil {
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+
+	part, err := s.storage.UploadPart(key, query.Get("uploadId"), partNumber, io.LimitReader(r.Body, s.config.MaxFileSize+1), customerKey)
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", part.ETag)
+	if customerKey != nil {
+		setEncryptionHeaders(w, storage.SSEC, storage.CustomerKeyMD5(customerKey))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleCompleteMultipartUpload handles CompleteMultipartUpload requests (POST ?uploadId)
+func (s *Server) handleCompleteMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	uploadID := r.URL.Query().Get("uploadId")
+
+	var request CompleteMultipartUpload
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxConfigBodySize))
+	if err != nil || xml.Unmarshal(body, &request) != nil {
+		s.sendError(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema")
+		return
+	}
+
+	// The assembled object is subject to the same size limit as a single PUT
+	uploaded, err := s.storage.ListParts(key, uploadID)
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+	sizes := make(map[int]int64, len(uploaded))
+	for _, part := range uploaded {
+		sizes[part.PartNumber] = part.Size
+	}
+
+	parts := make([]storage.CompletedPart, 0, len(request.Parts))
+	var total int64
+	for _, part := range request.Parts {
+		parts = append(parts, storage.CompletedPart{PartNumber: part.PartNumber, ETag: part.ETag})
+		total += sizes[part.PartNumber]
+	}
+	if total > s.config.MaxFileSize {
+		s.sendError(w, r, http.StatusBadRequest, "EntityTooLarge",
+			fmt.Sprintf("Your proposed upload exceeds the maximum allowed size of %d bytes", s.config.MaxFileSize))
+		return
+	}
+
+	obj, err := s.storage.CompleteMultipartUpload(key, uploadID, parts)
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	s.publish(events.ObjectCreatedCompleteMultipartUpload, events.Object{
+		Key:       obj.Key,
+		Size:      obj.Size,
+		ETag:      obj.ETag,
+		VersionID: obj.VersionID,
+	})
+	s.replicate(obj)
+
+	if obj.VersionID != "" {
+		w.Header().Set("x-amz-version-id", obj.VersionID)
+	}
+	setEncryptionHeaders(w, obj.Encryption, obj.CustomerKeyMD5)
+	s.sendXML(w, http.StatusOK, CompleteMultipartUploadResult{
+		Xmlns:    "http://s3.amazonaws.com/doc/2006-03-01/",
+		Location: fmt.Sprintf("/%s/%s", s.config.Bucket, obj.Key),
+		Bucket:   s.config.Bucket,
+		Key:      obj.Key,
+		ETag:     obj.ETag,
+	})
+}
+
+// handleAbortMultipartUpload handles AbortMultipartUpload requests (DELETE ?uploadId)
+func (s *Server) handleAbortMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	if err := s.storage.AbortMultipartUpload(key, r.URL.Query().Get("uploadId")); err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListParts handles ListParts requests (GET ?uploadId)
+func (s *Server) handleListParts(w http.ResponseWriter, r *http.Request, key string) {
+	uploadID := r.URL.Query().Get("uploadId")
+
+	parts, err := s.storage.ListParts(key, uploadID)
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	response := ListPartsResult{
+		Xmlns:    "http://s3.amazonaws.com/doc/2006-03-01/",
+		Bucket:   s.config.Bucket,
+		Key:      key,
+		UploadID: uploadID,
+		MaxParts: storage.MaxPartNumber,
+		Parts:    make([]PartEntry, 0, len(parts)),
+	}
+	for _, part := range parts {
+		response.Parts = append(response.Parts, PartEntry{
+			PartNumber:   part.PartNumber,
+			LastModified: part.LastModified.UTC().Format(time.RFC3339),
+			ETag:         part.ETag,
+			Size:         part.Size,
+		})
+	}
+
+	s.sendXML(w, http.StatusOK, response)
+}
+
+// handleListMultipartUploads handles ListMultipartUploads requests (GET /bucket?uploads)
+func (s *Server) handleListMultipartUploads(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+
+	uploads, err := s.storage.ListMultipartUploads(prefix)
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	response := ListMultipartUploadsResult{
+		Xmlns:      "http://s3.amazonaws.com/doc/2006-03-01/",
+		Bucket:     s.config.Bucket,
+		Prefix:     prefix,
+		MaxUploads: 1000,
+		Uploads:    make([]UploadEntry, 0, len(uploads)),
+	}
+	for _, upload := range uploads {
+		response.Uploads = append(response.Uploads, UploadEntry{
+			Key:          upload.Key,
+			UploadID:     upload.ID,
+			Initiated:    upload.Initiated.UTC().Format(time.RFC3339),
+			StorageClass: "STANDARD",
+		})
+	}
+
+	s.sendXML(w, http.StatusOK, response)
+}
+
+// InitiateMultipartUploadResult is the response for CreateMultipartUpload
+type InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+// CompleteMultipartUpload is the request body of CompleteMultipartUpload
+type CompleteMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []CompletedPart `xml:"Part"`
+}
+
+// CompletedPart identifies an uploaded part in CompleteMultipartUpload
+type CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// CompleteMultipartUploadResult is the response for CompleteMultipartUpload
+type CompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// ListPartsResult is the response for ListParts
+type ListPartsResult struct {
+	XMLName     xml.Name    `xml:"ListPartsResult"`
+	Xmlns       string      `xml:"xmlns,attr"`
+	Bucket      string      `xml:"Bucket"`
+	Key         string      `xml:"Key"`
+	UploadID    string      `xml:"UploadId"`
+	MaxParts    int         `xml:"MaxParts"`
+	IsTruncated bool        `xml:"IsTruncated"`
+	Parts       []PartEntry `xml:"Part"`
+}
+
+// PartEntry is a part in the ListParts response
+type PartEntry struct {
+	PartNumber   int    `xml:"PartNumber"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+// ListMultipartUploadsResult is the response for ListMultipartUploads
+type ListMultipartUploadsResult struct {
+	XMLName     xml.Name      `xml:"ListMultipartUploadsResult"`
+	Xmlns       string        `xml:"xmlns,attr"`
+	Bucket      string        `xml:"Bucket"`
+	Prefix      string        `xml:"Prefix"`
+	MaxUploads  int           `xml:"MaxUploads"`
+	IsTruncated bool          `xml:"IsTruncated"`
+	Uploads     []UploadEntry `xml:"Upload"`
+}
+
+// UploadEntry is an upload in the ListMultipartUploads response
+type UploadEntry struct {
+	Key          string `xml:"Key"`
+	UploadID     string `xml:"UploadId"`
+	Initiated    string `xml:"Initiated"`
+	StorageClass string `xml:"StorageClass"`
+}
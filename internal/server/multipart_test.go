@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+)
+
+// createUpload starts a multipart upload and returns its ID
+func createUpload(t *testing.T, srv *Server, key string) string {
+	t.Helper()
+
+	resp := doRequest(t, srv, http.MethodPost, "/test-bucket/"+key+"?uploads", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CreateMultipartUpload failed with status %d", resp.StatusCode)
+	}
+	var result InitiateMultipartUploadResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	if result.Key != key || result.UploadID == "" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	return result.UploadID
+}
+
+// uploadPart uploads a part and returns its ETag
+func uploadPart(t *testing.T, srv *Server, key, uploadID string, partNumber int, body string) string {
+	t.Helper()
+
+	resp := doRequest(t, srv, http.MethodPut, fmt.Sprintf("/test-bucket/%s?partNumber=%d&uploadId=%s", key, partNumber, uploadID), body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("UploadPart failed with status %d", resp.StatusCode)
+	}
+	return resp.Header.Get("ETag")
+}
+
+// completeBody builds a CompleteMultipartUpload request body from part ETags
+func completeBody(etags ...string) string {
+	var b strings.Builder
+	b.WriteString("<CompleteMultipartUpload>")
+	for i, etag := range etags {
+		fmt.Fprintf(&b, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, etag)
+	}
+	b.WriteString("</CompleteMultipartUpload>")
+	return b.String()
+}
+
+func TestMultipartUpload(t *testing.T) {
+	for _, backend := range []string{config.StorageFilesystem, config.StorageMemory} {
+		t.Run(backend, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.StorageBackend = backend
+			srv, err := NewServer(cfg)
+			if err != nil {
+				t.Fatalf("failed to create server: %v", err)
+			}
+
+			uploadID := createUpload(t, srv, "videos/clip.mp4")
+
+			resp := doRequest(t, srv, http.MethodGet, "/test-bucket?uploads", "")
+			var uploads ListMultipartUploadsResult
+			if err := xml.NewDecoder(resp.Body).Decode(&uploads); err != nil {
+				t.Fatalf("failed to decode uploads: %v", err)
+			}
+			if len(uploads.Uploads) != 1 || uploads.Uploads[0].UploadID != uploadID || uploads.Uploads[0].Key != "videos/clip.mp4" {
+				t.Errorf("unexpected uploads: %+v", uploads.Uploads)
+			}
+
+			// A single part may be smaller than the minimum part size
+			etag := uploadPart(t, srv, "videos/clip.mp4", uploadID, 1, "frame data")
+
+			resp = doRequest(t, srv, http.MethodGet, "/test-bucket/videos/clip.mp4?uploadId="+uploadID, "")
+			var parts ListPartsResult
+			if err := xml.NewDecoder(resp.Body).Decode(&parts); err != nil {
+				t.Fatalf("failed to decode parts: %v", err)
+			}
+			if len(parts.Parts) != 1 || parts.Parts[0].ETag != etag || parts.Parts[0].Size != 10 {
+				t.Errorf("unexpected parts: %+v", parts.Parts)
+			}
+
+			resp = doRequest(t, srv, http.MethodPost, "/test-bucket/videos/clip.mp4?uploadId="+uploadID, completeBody(etag))
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("CompleteMultipartUpload failed with status %d", resp.StatusCode)
+			}
+			var result CompleteMultipartUploadResult
+			if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatalf("failed to decode result: %v", err)
+			}
+			if result.Key != "videos/clip.mp4" || result.ETag == "" {
+				t.Errorf("unexpected result: %+v", result)
+			}
+
+			resp = doRequest(t, srv, http.MethodGet, "/test-bucket/videos/clip.mp4", "")
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != "frame data" {
+				t.Errorf("unexpected content %q", body)
+			}
+
+			resp = doRequest(t, srv, http.MethodGet, "/test-bucket/videos/clip.mp4?uploadId="+uploadID, "")
+			if resp.StatusCode != http.StatusNotFound {
+				t.Errorf("expected NoSuchUpload after completion, got status %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestMultipartUpload_Abort(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	uploadID := createUpload(t, srv, "file.bin")
+	uploadPart(t, srv, "file.bin", uploadID, 1, "data")
+
+	resp := doRequest(t, srv, http.MethodDelete, "/test-bucket/file.bin?uploadId="+uploadID, "")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("AbortMultipartUpload failed with status %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, srv, http.MethodDelete, "/test-bucket/file.bin?uploadId="+uploadID, "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected NoSuchUpload, got status %d", resp.StatusCode)
+	}
+	resp = doRequest(t, srv, http.MethodHead, "/test-bucket/file.bin", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected no object after abort, got status %d", resp.StatusCode)
+	}
+}
+
+func TestMultipartUpload_Errors(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MaxFileSize = 20
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	uploadID := createUpload(t, srv, "file.bin")
+	etag1 := uploadPart(t, srv, "file.bin", uploadID, 1, "0123456789")
+	etag2 := uploadPart(t, srv, "file.bin", uploadID, 2, "0123456789")
+	etag3 := uploadPart(t, srv, "file.bin", uploadID, 3, "0123456789")
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		expected int
+		code     string
+	}{
+		{"invalid part number", http.MethodPut, "/test-bucket/file.bin?partNumber=0&uploadId=" + uploadID, "x", http.StatusBadRequest, "InvalidArgument"},
+		{"unknown upload", http.MethodPut, "/test-bucket/file.bin?partNumber=1&uploadId=unknown", "x", http.StatusNotFound, "NoSuchUpload"},
+		{"malformed xml", http.MethodPost, "/test-bucket/file.bin?uploadId=" + uploadID, "<Complete", http.StatusBadRequest, "MalformedXML"},
+		{"wrong etag", http.MethodPost, "/test-bucket/file.bin?uploadId=" + uploadID, completeBody(`"bogus"`), http.StatusBadRequest, "InvalidPart"},
+		{"parts too small", http.MethodPost, "/test-bucket/file.bin?uploadId=" + uploadID, completeBody(etag1, etag2), http.StatusBadRequest, "EntityTooSmall"},
+		{"too large", http.MethodPost, "/test-bucket/file.bin?uploadId=" + uploadID, completeBody(etag1, etag2, etag3), http.StatusBadRequest, "EntityTooLarge"},
+		{
+			"parts out of order", http.MethodPost, "/test-bucket/file.bin?uploadId=" + uploadID,
+			"<CompleteMultipartUpload><Part><PartNumber>2</PartNumber><ETag>" + etag2 + "</ETag></Part><Part><PartNumber>1</PartNumber><ETag>" + etag1 + "</ETag></Part></CompleteMultipartUpload>",
+			http.StatusBadRequest, "InvalidPartOrder",
+		},
+		{"post without subresource", http.MethodPost, "/test-bucket/file.bin", "", http.StatusMethodNotAllowed, "MethodNotAllowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(t, srv, tt.method, tt.target, tt.body)
+			if resp.StatusCode != tt.expected {
+				t.Fatalf("expected status %d, got %d", tt.expected, resp.StatusCode)
+			}
+			var errResp ErrorResponse
+			if err := xml.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+				t.Fatalf("failed to decode error: %v", err)
+			}
+			if errResp.Code != tt.code {
+				t.Errorf("expected code %s, got %s", tt.code, errResp.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		size   int64
+		want   *byteRange
+		err    error
+	}{
+		{"", 10, nil, nil},
+		{"bytes=0-4", 10, &byteRange{0, 5}, nil},
+		{"bytes=5-", 10, &byteRange{5, 5}, nil},
+		{"bytes=5-100", 10, &byteRange{5, 5}, nil},
+		{"bytes=-3", 10, &byteRange{7, 3}, nil},
+		{"bytes=-20", 10, &byteRange{0, 10}, nil},
+		{"bytes=9-9", 10, &byteRange{9, 1}, nil},
+		{"bytes=10-", 10, nil, errUnsatisfiableRange},
+		{"bytes=-0", 10, nil, errUnsatisfiableRange},
+		{"bytes=0-", 0, nil, errUnsatisfiableRange},
+		{"bytes=5-2", 10, nil, nil},     // invalid, ignored
+		{"bytes=0-1,3-4", 10, nil, nil}, // multiple ranges are not supported
+		{"items=0-1", 10, nil, nil},     // unknown unit
+		{"bytes=a-b", 10, nil, nil},     // malformed
+	}
+	for _, tt := range tests {
+		got, err := parseRange(tt.header, tt.size)
+		if err != tt.err {
+			t.Errorf("parseRange(%q, %d) error = %v, want %v", tt.header, tt.size, err, tt.err)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("parseRange(%q, %d) = %+v, want %+v", tt.header, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestGetObject_Range(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	doRequest(t, srv, http.MethodPut, "/test-bucket/digits.txt", "0123456789")
+
+	resp := doRequestWithHeaders(t, srv, http.MethodGet, "/test-bucket/digits.txt", "", map[string]string{"Range": "bytes=2-5"})
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "2345" {
+		t.Errorf("range GET = %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Content-Range") != "bytes 2-5/10" || resp.Header.Get("Content-Length") != "4" {
+		t.Errorf("Content-Range %q, Content-Length %q", resp.Header.Get("Content-Range"), resp.Header.Get("Content-Length"))
+	}
+
+	resp = doRequestWithHeaders(t, srv, http.MethodGet, "/test-bucket/digits.txt", "", map[string]string{"Range": "bytes=20-"})
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || resp.Header.Get("Content-Range") != "bytes */10" {
+		t.Errorf("unsatisfiable range = %d %q", resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+	if code := errorCode(t, resp); code != "InvalidRange" {
+		t.Errorf("error code = %q", code)
+	}
+
+	resp = doRequest(t, srv, http.MethodGet, "/test-bucket/digits.txt", "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Errorf("full GET = %d, Accept-Ranges %q", resp.StatusCode, resp.Header.Get("Accept-Ranges"))
+	}
+}
+
+func TestGetObject_RangeCompressed(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Compression = config.CompressionGzip
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	data := strings.Repeat(`{"event":"click"}`+"\n", 1000)
+	put := doRequest(t, srv, http.MethodPut, "/test-bucket/events.json", data)
+
+	resp := doRequest(t, srv, http.MethodHead, "/test-bucket/events.json", "")
+	if resp.Header.Get("Content-Length") != strconv.Itoa(len(data)) || resp.Header.Get("ETag") != put.Header.Get("ETag") {
+		t.Errorf("HEAD Content-Length %q, ETag %q", resp.Header.Get("Content-Length"), resp.Header.Get("ETag"))
+	}
+
+	resp = doRequestWithHeaders(t, srv, http.MethodGet, "/test-bucket/events.json", "", map[string]string{"Range": "bytes=18-34"})
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != `{"event":"click"}` {
+		t.Errorf("range GET = %d %q", resp.StatusCode, body)
+	}
+}
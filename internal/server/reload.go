@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+
+	"github.com/Notifuse/selfhost_s3/internal/auth"
+	"github.com/Notifuse/selfhost_s3/internal/config"
+	"github.com/Notifuse/selfhost_s3/internal/cors"
+)
+
+// settings are the parts of the configuration that can change while serving.
+// Requests read them once from Server.settings, so a reload never mixes old and new values.
+type settings struct {
+	auth              *auth.SignatureV4
+	cors              *cors.Configuration // from S3_CORS_ORIGINS, nil when CORS is disabled
+	publicPrefix      string
+	publicCacheMaxAge int
+}
+
+// applySettings makes the reloadable settings of cfg current
+func (s *Server) applySettings(cfg *config.Config) error {
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return fmt.Errorf("access key and secret key are required")
+	}
+
+	// Create public directory if configured
+	previous := s.settings.Load()
+	if cfg.PublicPrefix != "" && (previous == nil || previous.publicPrefix != cfg.PublicPrefix) {
+		if err := s.storage.EnsurePublicDir(cfg.PublicPrefix); err != nil {
+			return fmt.Errorf("failed to create public directory: %w", err)
+		}
+		log.Printf("Public access enabled for prefix: %s", cfg.PublicPrefix)
+	}
+
+	signature := auth.NewSignatureV4(cfg.AccessKey, cfg.SecretKey, s.config.Region)
+	for _, cred := range cfg.Credentials {
+		signature.AddCredentials(cred.AccessKey, cred.SecretKey)
+	}
+
+	s.settings.Store(&settings{
+		auth:              signature,
+		cors:              cors.FromOrigins(slices.Clone(cfg.CORSOrigins)),
+		publicPrefix:      cfg.PublicPrefix,
+		publicCacheMaxAge: cfg.PublicCacheMaxAge,
+	})
+	return nil
+}
+
+// Reload applies the CORS origins, credentials and public access settings of cfg
+// without interrupting requests. Other settings only take effect after a restart,
+// which is logged when they differ from the running configuration.
+func (s *Server) Reload(cfg *config.Config) error {
+	if err := s.applySettings(cfg); err != nil {
+		return err
+	}
+
+	// Compare the rest of the configuration with the reloadable settings left out
+	running, reloaded := *s.config, *cfg
+	for _, c := range []*config.Config{&running, &reloaded} {
+		c.AccessKey, c.SecretKey, c.Credentials = "", "", nil
+		c.CORSOrigins, c.PublicPrefix, c.PublicCacheMaxAge = nil, "", 0
+	}
+	if !reflect.DeepEqual(running, reloaded) {
+		log.Printf("Configuration reloaded; other settings than CORS origins, credentials and public access changed and take effect after a restart")
+	} else {
+		log.Printf("Configuration reloaded")
+	}
+	return nil
+}
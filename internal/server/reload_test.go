@@ -0,0 +1,129 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+)
+
+func TestServer_Reload(t *testing.T) {
+	cfg := testConfig(t)
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	if resp := serveSigned(t, srv, http.MethodPut, "/test-bucket/assets/logo.png", "png"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	reloaded := *cfg
+	reloaded.AccessKey = "new-access-key"
+	reloaded.SecretKey = "new-secret-key"
+	reloaded.CORSOrigins = []string{"https://app.example.com"}
+	reloaded.PublicPrefix = "assets/"
+	reloaded.PublicCacheMaxAge = 60
+	if err := srv.Reload(&reloaded); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+
+	// The previous credentials are rejected, the new ones accepted
+	if resp := serveSigned(t, srv, http.MethodGet, "/test-bucket/assets/logo.png?tagging", ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the old credentials to be rejected, got %d", resp.StatusCode)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/test-bucket/assets/logo.png?tagging", nil)
+	req.Host = "localhost:9000"
+	signRequest(req, reloaded.AccessKey, reloaded.SecretKey, reloaded.Region)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the new credentials to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The new public prefix is readable anonymously, with the new cache max age
+	req = httptest.NewRequest(http.MethodGet, "/test-bucket/assets/logo.png", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "public, max-age=60" {
+		t.Errorf("expected a public response, got %d with Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "https://app.example.com" {
+		t.Errorf("expected the reloaded CORS origin, got %q", origin)
+	}
+	req.Header.Set("Origin", "https://evil.example.com")
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("expected no CORS headers for other origins, got %q", origin)
+	}
+
+	// The previous public prefix is not
+	req = httptest.NewRequest(http.MethodGet, "/test-bucket/public/file.txt", nil)
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected the old public prefix to require authentication, got %d", w.Code)
+	}
+}
+
+func TestServer_ReloadRejectsMissingCredentials(t *testing.T) {
+	cfg := testConfig(t)
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	reloaded := *cfg
+	reloaded.SecretKey = ""
+	if err := srv.Reload(&reloaded); err == nil {
+		t.Fatal("expected error for missing secret key")
+	}
+
+	// The running settings are kept
+	if resp := serveSigned(t, srv, http.MethodPut, "/test-bucket/file.txt", "data"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the previous credentials to remain valid, got %d", resp.StatusCode)
+	}
+}
+
+func TestServer_ReloadCredentials(t *testing.T) {
+	cfg := testConfig(t)
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	status := func(accessKey, secretKey string) int {
+		req := httptest.NewRequest(http.MethodGet, "/test-bucket?list-type=2", nil)
+		req.Host = "localhost:9000"
+		signRequest(req, accessKey, secretKey, cfg.Region)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	reloaded := *cfg
+	reloaded.Credentials = []config.Credential{{AccessKey: "backup-key", SecretKey: "backup-secret"}}
+	if err := srv.Reload(&reloaded); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if code := status(cfg.AccessKey, cfg.SecretKey); code != http.StatusOK {
+		t.Errorf("expected the main credentials to be accepted, got %d", code)
+	}
+	if code := status("backup-key", "backup-secret"); code != http.StatusOK {
+		t.Errorf("expected the added credentials to be accepted, got %d", code)
+	}
+	if code := status("backup-key", cfg.SecretKey); code != http.StatusForbidden {
+		t.Errorf("expected another secret key to be rejected, got %d", code)
+	}
+
+	// Removing the credentials from the file revokes them
+	if err := srv.Reload(cfg); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if code := status("backup-key", "backup-secret"); code != http.StatusForbidden {
+		t.Errorf("expected the removed credentials to be rejected, got %d", code)
+	}
+}
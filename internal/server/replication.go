@@ -0,0 +1,61 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+	"github.com/Notifuse/selfhost_s3/internal/replication"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+	"github.com/Notifuse/selfhost_s3/internal/upstream"
+)
+
+// replicationRules returns the replication rules of cfg, with a client for each target
+func replicationRules(cfg *config.Config) []replication.Rule {
+	rules := make([]replication.Rule, 0, len(cfg.Replication))
+	for _, rule := range cfg.Replication {
+		rules = append(rules, replication.Rule{
+			Prefix: rule.Prefix,
+			Target: replication.TargetName(rule.Endpoint, rule.Bucket),
+			Client: upstream.New(upstream.Options{
+				Endpoint:  rule.Endpoint,
+				Bucket:    rule.Bucket,
+				Region:    rule.Region,
+				AccessKey: rule.AccessKey,
+				SecretKey: rule.SecretKey,
+			}),
+		})
+	}
+	return rules
+}
+
+// replicate queues the replication of an object written. Objects encrypted with a
+// customer key cannot be read back to be replicated.
+func (s *Server) replicate(obj *storage.Object) {
+	if s.replicator == nil || obj.CustomerKeyMD5 != "" {
+		return
+	}
+	if err := s.replicator.QueuePut(obj.Key); err != nil {
+		log.Printf("Failed to queue the replication of %s: %v", obj.Key, err)
+	}
+}
+
+// replicateDelete queues the replication of the deletion of key
+func (s *Server) replicateDelete(key string) {
+	if s.replicator == nil {
+		return
+	}
+	if err := s.replicator.QueueDelete(key); err != nil {
+		log.Printf("Failed to queue the replication of the deletion of %s: %v", key, err)
+	}
+}
+
+// setReplicationStatus sets the x-amz-replication-status header of the current object at key
+func (s *Server) setReplicationStatus(w http.ResponseWriter, key string) {
+	if s.replicator == nil {
+		return
+	}
+	if status := s.replicator.Status(key); status != "" {
+		w.Header().Set("x-amz-replication-status", status)
+	}
+}
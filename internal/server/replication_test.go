@@ -0,0 +1,105 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+)
+
+// waitForStatus polls the replication status of key until it is expected
+func waitForStatus(t *testing.T, srv *Server, key, expected string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp := doRequest(t, srv, http.MethodHead, "/test-bucket/"+key, "")
+		status := resp.Header.Get("x-amz-replication-status")
+		if status == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replication status of %s = %q, expected %q", key, status, expected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplication_ReplaysWritesOnTarget(t *testing.T) {
+	target, ts := newUpstreamServer(t)
+
+	cfg := testConfig(t)
+	cfg.Replication = []config.ReplicationRule{{
+		Prefix:    "docs/",
+		Endpoint:  ts.URL,
+		Bucket:    "prod-bucket",
+		Region:    cfg.Region,
+		AccessKey: "upstream-access-key",
+		SecretKey: "upstream-secret-key",
+	}}
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	srv.StartBackground()
+	defer srv.Close()
+
+	doRequest(t, srv, http.MethodPut, "/test-bucket/docs/report.txt", "quarterly report")
+	doRequest(t, srv, http.MethodPut, "/test-bucket/tmp/scratch.txt", "scratch")
+	waitForStatus(t, srv, "docs/report.txt", "COMPLETED")
+
+	resp := doRequest(t, target, http.MethodGet, "/prod-bucket/docs/report.txt", "")
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "quarterly report" {
+		t.Errorf("target GET = %d %q", resp.StatusCode, body)
+	}
+	if resp := doRequest(t, srv, http.MethodHead, "/test-bucket/tmp/scratch.txt", ""); resp.Header.Get("x-amz-replication-status") != "" {
+		t.Errorf("unexpected status %q outside the rule prefix", resp.Header.Get("x-amz-replication-status"))
+	}
+	if resp := doRequest(t, target, http.MethodHead, "/prod-bucket/tmp/scratch.txt", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the key outside the rule prefix not to be replicated, got %d", resp.StatusCode)
+	}
+
+	// Copies are replicated, and deletes too
+	headers := map[string]string{"x-amz-copy-source": "/test-bucket/docs/report.txt"}
+	if resp := doRequestWithHeaders(t, srv, http.MethodPut, "/test-bucket/docs/copy.txt", "", headers); resp.StatusCode != http.StatusOK {
+		t.Fatalf("copy = %d", resp.StatusCode)
+	}
+	waitForStatus(t, srv, "docs/copy.txt", "COMPLETED")
+	doRequest(t, srv, http.MethodDelete, "/test-bucket/docs/report.txt", "")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.replicator.Pending() != 0 || doRequest(t, target, http.MethodHead, "/prod-bucket/docs/report.txt", "").StatusCode != http.StatusNotFound {
+		if time.Now().After(deadline) {
+			t.Fatal("delete not replicated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(scrapeMetrics(t, srv), "selfhost_s3_replication_pending 0") {
+		t.Error("expected the replication queue to be exposed as drained")
+	}
+}
+
+func TestReplication_PendingUntilReplayed(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Replication = []config.ReplicationRule{{
+		Endpoint:  "http://127.0.0.1:1",
+		Bucket:    "backup",
+		Region:    cfg.Region,
+		AccessKey: "key",
+		SecretKey: "secret",
+	}}
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer srv.Close()
+
+	doRequest(t, srv, http.MethodPut, "/test-bucket/a.txt", "data")
+	waitForStatus(t, srv, "a.txt", "PENDING")
+	if resp := doRequest(t, srv, http.MethodGet, "/test-bucket/a.txt", ""); resp.Header.Get("x-amz-replication-status") != "PENDING" {
+		t.Errorf("GET status = %q", resp.Header.Get("x-amz-replication-status"))
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// scrubScheduler periodically verifies the stored data against its checksums, with the
+// reads throttled so that requests are not starved
+type scrubScheduler struct {
+	store      *storage.Storage
+	interval   time.Duration
+	opts       storage.ScrubOptions
+	unresolved atomic.Int64 // issues left by the last scrub
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+// newScrubScheduler creates a scheduler scrubbing every interval
+func newScrubScheduler(store *storage.Storage, interval time.Duration, opts storage.ScrubOptions) *scrubScheduler {
+	return &scrubScheduler{store: store, interval: interval, opts: opts}
+}
+
+// start runs the scheduler in the background until stop is called
+func (s *scrubScheduler) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.scrub(ctx)
+			}
+		}
+	}()
+}
+
+// stop stops the scheduler, interrupting a running scrub
+func (s *scrubScheduler) stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+	s.cancel = nil
+}
+
+// scrub verifies the bucket once and logs what it found
+func (s *scrubScheduler) scrub(ctx context.Context) {
+	report, err := s.store.Scrub(ctx, s.opts)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	for _, issue := range report.Issues {
+		logScrubIssue(issue)
+	}
+	if err != nil {
+		log.Printf("Scrub: %v", err)
+		return
+	}
+
+	s.unresolved.Store(int64(report.Unresolved()))
+	if len(report.Issues) > 0 || report.Recorded > 0 {
+		log.Printf("Scrub: %d object(s) and %d version(s) verified, %d bytes read; %d issue(s), %d unresolved, %d checksum(s) recorded",
+			report.Objects, report.Versions, report.Bytes, len(report.Issues), report.Unresolved(), report.Recorded)
+	}
+}
+
+// logScrubIssue logs a problem found by a scrub and what was done about it
+func logScrubIssue(issue storage.ScrubIssue) {
+	subject := issue.Key
+	if issue.VersionID != "" {
+		subject += " (version " + issue.VersionID + ")"
+	}
+	if subject == "" {
+		subject = issue.Path
+	}
+	action := issue.Action
+	if action == "" {
+		action = "left as is"
+	}
+	log.Printf("Scrub: %s %s: %s; %s", issue.Problem, subject, issue.Detail, action)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flipByte corrupts the stored data of key without changing its modification time
+func flipByte(t *testing.T, srv *Server, key string) {
+	t.Helper()
+
+	path := filepath.Join(srv.config.StoragePath, srv.config.Bucket, key)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	data[0] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+func TestScrubScheduler_Quarantine(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.ScrubInterval = time.Hour
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	doRequest(t, srv, http.MethodPut, "/test-bucket/sound.txt", "sound data")
+	doRequest(t, srv, http.MethodPut, "/test-bucket/rotten.txt", "rotten data")
+	flipByte(t, srv, "rotten.txt")
+
+	srv.scrubber.scrub(context.Background())
+	if rec := scrapeMetrics(t, srv); !strings.Contains(rec, "selfhost_s3_scrub_issues 1") {
+		t.Errorf("scrub issues gauge not set:\n%s", rec)
+	}
+	if resp := doRequest(t, srv, http.MethodGet, "/test-bucket/rotten.txt", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the corrupt object to be left in place, got %d", resp.StatusCode)
+	}
+
+	// Quarantined objects are gone from the bucket and no longer counted
+	srv.scrubber.opts.Quarantine = true
+	srv.scrubber.scrub(context.Background())
+	if resp := doRequest(t, srv, http.MethodGet, "/test-bucket/rotten.txt", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the corrupt object to be quarantined, got %d", resp.StatusCode)
+	}
+	if resp := doRequest(t, srv, http.MethodGet, "/test-bucket/sound.txt", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the sound object to be kept, got %d", resp.StatusCode)
+	}
+	if rec := scrapeMetrics(t, srv); !strings.Contains(rec, "selfhost_s3_scrub_issues 0") {
+		t.Errorf("scrub issues gauge not reset:\n%s", rec)
+	}
+}
+
+func TestScrubScheduler_StartBackground(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.ScrubInterval = 10 * time.Millisecond
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	doRequest(t, srv, http.MethodPut, "/test-bucket/rotten.txt", "rotten data")
+	flipByte(t, srv, "rotten.txt")
+
+	srv.StartBackground()
+	defer srv.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.scrubber.unresolved.Load() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the background scrub did not find the corrupt object")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
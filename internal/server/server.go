@@ -1,18 +1,35 @@
 package server
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
+	"github.com/Notifuse/selfhost_s3/internal/accesslog"
 	"github.com/Notifuse/selfhost_s3/internal/auth"
+	"github.com/Notifuse/selfhost_s3/internal/certs"
 	"github.com/Notifuse/selfhost_s3/internal/config"
+	"github.com/Notifuse/selfhost_s3/internal/cors"
+	"github.com/Notifuse/selfhost_s3/internal/events"
+	"github.com/Notifuse/selfhost_s3/internal/lifecycle"
+	"github.com/Notifuse/selfhost_s3/internal/replication"
 	"github.com/Notifuse/selfhost_s3/internal/storage"
+	"github.com/Notifuse/selfhost_s3/internal/upstream"
 )
 
 // Version is the current version of selfhost_s3
@@ -20,84 +37,464 @@ const Version = "v1.3"
 
 // Server represents the SelfhostS3 HTTP server
 type Server struct {
-	config  *config.Config
-	storage *storage.Storage
-	auth    *auth.SignatureV4
+	config     *config.Config
+	settings   atomic.Pointer[settings]           // the settings Reload can change
+	bucketCORS atomic.Pointer[cors.Configuration] // nil when the bucket has no CORS configuration
+	storage    storage.Backend
+	lifecycle  *lifecycle.Scheduler
+	metrics    *serverMetrics
+	notifier   *events.Dispatcher      // nil when no webhooks are configured
+	blobGC     *blobCollector          // nil unless deduplication is enabled
+	disk       *diskMonitor            // nil unless S3_MIN_FREE_SPACE is set
+	scrubber   *scrubScheduler         // nil unless S3_SCRUB_INTERVAL is set
+	watcher    *storage.Watcher        // nil unless S3_WATCH is set and the bucket directory is watched
+	replicator *replication.Replicator // nil when no replication rules are configured
+	tempDir    string                  // temporary queues of backends without a data directory
+
+	accessLog       *accesslog.Logger       // nil when access logging is disabled
+	accessLogFile   *accesslog.RotatingFile // nil unless S3_ACCESS_LOG_FILE is set
+	accessLogBucket *accesslog.BucketWriter // nil unless S3_ACCESS_LOG_PREFIX is set
+
+	http     *http.Server
+	tls      *certs.TLS   // nil when serving plain HTTP
+	acmeHTTP *http.Server // answers ACME HTTP-01 challenges, nil when not configured
 }
 
 // NewServer creates a new SelfhostS3 server
 func NewServer(cfg *config.Config) (*Server, error) {
-	store, err := storage.NewStorage(cfg.StoragePath, cfg.Bucket)
+	if cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("bucket, access key and secret key are required")
+	}
+
+	store, err := newBackend(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize storage: %w", err)
 	}
 
-	// Create public directory if configured
-	if cfg.PublicPrefix != "" {
-		if err := store.EnsurePublicDir(cfg.PublicPrefix); err != nil {
-			return nil, fmt.Errorf("failed to create public directory: %w", err)
+	s := &Server{
+		config:    cfg,
+		storage:   store,
+		lifecycle: lifecycle.NewScheduler(store, cfg.LifecycleInterval, cfg.LifecycleDryRun),
+		metrics:   newServerMetrics(cfg.Bucket, store),
+	}
+	// A server that fails to start releases the bucket
+	created := false
+	defer func() {
+		if !created {
+			s.Close()
 		}
-		log.Printf("Public access enabled for prefix: %s", cfg.PublicPrefix)
+	}()
+	if cache, ok := store.(*storage.Cache); ok {
+		s.metrics.registerCache(cache)
+	}
+	if cfg.LifecycleInterval > 0 {
+		s.metrics.registerLifecycle(s.lifecycle)
+	}
+	if fs, ok := fileStorage(store); ok && cfg.Dedup && cfg.DedupGCInterval > 0 {
+		s.blobGC = newBlobCollector(fs, cfg.DedupGCInterval)
+	}
+	if fs, ok := fileStorage(store); ok && cfg.MinFreeSpace > 0 {
+		s.disk = newDiskMonitor(fs, cfg.MinFreeSpace)
+		s.metrics.registerReadOnly(s.disk)
+	}
+	if fs, ok := fileStorage(store); ok && cfg.ScrubInterval > 0 {
+		s.scrubber = newScrubScheduler(fs, cfg.ScrubInterval, storage.ScrubOptions{
+			Repair:     cfg.ScrubRepair,
+			Quarantine: cfg.ScrubQuarantine,
+			Rate:       cfg.ScrubRate,
+		})
+		s.metrics.registerScrub(s.scrubber)
+	}
+	if err := s.applySettings(cfg); err != nil {
+		return nil, err
+	}
+	if err := s.loadBucketCORS(); err != nil {
+		return nil, fmt.Errorf("failed to load the CORS configuration: %w", err)
+	}
+	s.http = &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
 	}
 
-	return &Server{
-		config:  cfg,
-		storage: store,
-		auth:    auth.NewSignatureV4(cfg.AccessKey, cfg.SecretKey, cfg.Region),
-	}, nil
+	if cfg.TLSEnabled() {
+		s.tls, err = certs.New(certs.Options{
+			CertFile:      cfg.TLSCert,
+			KeyFile:       cfg.TLSKey,
+			ClientCA:      cfg.TLSClientCA,
+			ACMEDomains:   cfg.ACMEDomains,
+			ACMEEmail:     cfg.ACMEEmail,
+			ACMEDirectory: cfg.ACMEDirectory,
+			ACMECARoot:    cfg.ACMECARoot,
+			ACMECacheDir:  cfg.ACMECacheDir,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize TLS: %w", err)
+		}
+		s.http.TLSConfig = s.tls.Config
+
+		if cfg.ACMEHTTPAddr != "" && s.tls.HTTPHandler() != nil {
+			s.acmeHTTP = &http.Server{
+				Addr:              cfg.ACMEHTTPAddr,
+				Handler:           s.tls.HTTPHandler(),
+				ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+				IdleTimeout:       cfg.IdleTimeout,
+			}
+		}
+	}
+
+	if err := s.setupAccessLog(); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Webhooks) > 0 {
+		webhooks := make([]events.Webhook, 0, len(cfg.Webhooks))
+		for _, wh := range cfg.Webhooks {
+			for _, name := range wh.Events {
+				if !events.ValidEventName(name) {
+					return nil, fmt.Errorf("unsupported webhook event %q", name)
+				}
+			}
+			webhooks = append(webhooks, events.Webhook{
+				ID:     wh.ID,
+				URL:    wh.URL,
+				Secret: wh.Secret,
+				Events: wh.Events,
+				Prefix: wh.Prefix,
+				Suffix: wh.Suffix,
+			})
+		}
+
+		queueDir, err := s.internalDir("events")
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize event notifications: %w", err)
+		}
+		s.notifier, err = events.NewDispatcher(queueDir, cfg.Region, cfg.Bucket, webhooks)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize event notifications: %w", err)
+		}
+		s.lifecycle.OnDelete(s.publishLifecycleDelete)
+	}
+
+	if len(cfg.Replication) > 0 {
+		queueDir, err := s.internalDir("replication")
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize replication: %w", err)
+		}
+		s.replicator, err = replication.New(queueDir, store, replicationRules(cfg))
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize replication: %w", err)
+		}
+		s.metrics.registerReplication(s.replicator)
+	}
+
+	created = true
+	return s, nil
 }
 
-// Start starts the HTTP server
-func (s *Server) Start() error {
+// internalDir returns the directory of the internal state named name: under the data
+// directory, or a temporary directory removed by Close for backends without one
+func (s *Server) internalDir(name string) (string, error) {
+	if fs, ok := fileStorage(s.storage); ok {
+		return fs.InternalPath(name), nil
+	}
+	if s.tempDir == "" {
+		dir, err := os.MkdirTemp("", "selfhost_s3-")
+		if err != nil {
+			return "", err
+		}
+		s.tempDir = dir
+	}
+	return filepath.Join(s.tempDir, name), nil
+}
+
+// setupAccessLog opens the S3 server access log destinations
+func (s *Server) setupAccessLog() error {
+	var writers []io.Writer
+
+	if s.config.AccessLogFile != "" {
+		file, err := accesslog.OpenRotatingFile(s.config.AccessLogFile, s.config.AccessLogMaxSize, s.config.AccessLogMaxFiles)
+		if err != nil {
+			return fmt.Errorf("failed to initialize access log: %w", err)
+		}
+		s.accessLogFile = file
+		writers = append(writers, file)
+	}
+
+	if s.config.AccessLogPrefix != "" {
+		s.accessLogBucket = accesslog.NewBucketWriter(s.storage, s.config.AccessLogPrefix, s.config.AccessLogInterval)
+		writers = append(writers, s.accessLogBucket)
+	}
+
+	if len(writers) > 0 {
+		s.accessLog = accesslog.NewLogger(writers...)
+	}
+	return nil
+}
+
+// newBackend creates the storage backend selected in the configuration. A bucket on the
+// filesystem is locked until the server is closed.
+func newBackend(cfg *config.Config) (backend storage.Backend, err error) {
+	if cfg.StorageBackend == config.StorageMemory {
+		backend = storage.NewMemory()
+	} else {
+		store, err := storage.NewStorage(cfg.StoragePath, cfg.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.Lock(); err != nil {
+			if errors.Is(err, storage.ErrLocked) {
+				return nil, fmt.Errorf("bucket %s on %s is in use by another server", cfg.Bucket, cfg.StoragePath)
+			}
+			return nil, err
+		}
+		defer func() {
+			if backend == nil {
+				store.Unlock()
+			}
+		}()
+		if cfg.Compression != "" {
+			store.SetCompression(&storage.CompressionPolicy{
+				Algorithm:    cfg.Compression,
+				ContentTypes: cfg.CompressTypes,
+				Prefixes:     cfg.CompressPrefixes,
+			})
+		}
+		if err := store.SetDeduplication(cfg.Dedup); err != nil {
+			return nil, err
+		}
+		if cfg.MetadataIndex {
+			if _, err := store.OpenIndex(); err != nil {
+				return nil, err
+			}
+		}
+		backend = store
+	}
+	if cfg.EncryptionKey != nil {
+		backend.SetMasterKey(cfg.EncryptionKey)
+	}
+	if len(cfg.Quotas) > 0 {
+		quotas := make([]storage.Quota, 0, len(cfg.Quotas))
+		for _, q := range cfg.Quotas {
+			quotas = append(quotas, storage.Quota{Prefix: q.Prefix, MaxBytes: q.MaxBytes, MaxObjects: q.MaxObjects})
+		}
+		if err := backend.SetQuotas(quotas); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.UpstreamEndpoint != "" {
+		opts := storage.CacheOptions{MaxBytes: cfg.CacheMaxSize, WriteThrough: cfg.UpstreamWrites}
+		if fs, ok := backend.(*storage.Storage); ok {
+			opts.StatePath = fs.InternalPath("cache.json")
+		}
+		client := upstream.New(upstream.Options{
+			Endpoint:  cfg.UpstreamEndpoint,
+			Bucket:    cfg.UpstreamBucket,
+			Region:    cfg.UpstreamRegion,
+			AccessKey: cfg.UpstreamAccessKey,
+			SecretKey: cfg.UpstreamSecretKey,
+		})
+		cache, err := storage.NewCache(backend, client, opts)
+		if err != nil {
+			return nil, err
+		}
+		backend = cache
+	}
+	return backend, nil
+}
+
+// fileStorage returns the filesystem storage behind store, if any
+func fileStorage(store storage.Backend) (*storage.Storage, bool) {
+	if cache, ok := store.(*storage.Cache); ok {
+		store = cache.Local()
+	}
+	fs, ok := store.(*storage.Storage)
+	return fs, ok
+}
+
+// Handler returns the HTTP handler serving the S3 API, the health check and the metrics endpoints
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 
 	// Health check endpoint (no auth required)
 	mux.HandleFunc("/health", s.handleHealth)
 
+	// Prometheus metrics (bearer token required when S3_METRICS_TOKEN is set)
+	mux.HandleFunc("/metrics", s.metrics.handler(s.config.MetricsToken))
+
 	// S3 API endpoints - all go through the main handler
-	mux.HandleFunc("/", s.handleRequest)
+	mux.HandleFunc("/", s.instrument(s.handleRequest))
 
-	addr := fmt.Sprintf(":%d", s.config.Port)
-	log.Printf("SelfhostS3 %s starting on %s", Version, addr)
-	log.Printf("Bucket: %s", s.config.Bucket)
-	log.Printf("Storage path: %s", s.config.StoragePath)
+	return s.corsMiddleware(mux)
+}
 
-	return http.ListenAndServe(addr, s.corsMiddleware(mux))
+// StartBackground starts the lifecycle scheduler, event delivery, access log delivery,
+// the collection of deduplicated data and the free disk space monitor
+func (s *Server) StartBackground() {
+	if s.accessLogFile != nil {
+		log.Printf("Access log written to %s", s.config.AccessLogFile)
+	}
+	if s.accessLogBucket != nil {
+		s.accessLogBucket.Start()
+		log.Printf("Access log delivered to %s every %s", s.config.AccessLogPrefix, s.config.AccessLogInterval)
+	}
+
+	if s.config.UpstreamEndpoint != "" {
+		log.Printf("Objects missing locally are fetched from bucket %s at %s (writes upstream: %v)", s.config.UpstreamBucket, s.config.UpstreamEndpoint, s.config.UpstreamWrites)
+	}
+
+	if s.notifier != nil {
+		s.notifier.Start()
+		log.Printf("Event notifications enabled for %d webhook(s)", len(s.config.Webhooks))
+	}
+
+	if s.replicator != nil {
+		s.replicator.Start()
+		log.Printf("Writes and deletes replicated by %d rule(s)", len(s.config.Replication))
+	}
+
+	if s.config.LifecycleInterval > 0 {
+		s.lifecycle.Start()
+		log.Printf("Lifecycle rules applied every %s (dry run: %v)", s.config.LifecycleInterval, s.config.LifecycleDryRun)
+	}
+
+	if s.blobGC != nil {
+		s.blobGC.start()
+		log.Printf("Unreferenced deduplicated data collected every %s", s.config.DedupGCInterval)
+	}
+
+	if s.disk != nil {
+		s.disk.start()
+		log.Printf("Writes rejected while free disk space is below %d bytes", s.config.MinFreeSpace)
+	}
+
+	if s.scrubber != nil {
+		s.scrubber.start()
+		log.Printf("Stored data verified every %s (repair: %v, quarantine: %v)", s.config.ScrubInterval, s.config.ScrubRepair, s.config.ScrubQuarantine)
+	}
+
+	if fs, ok := fileStorage(s.storage); ok && s.config.Watch {
+		s.startWatcher(fs)
+	}
 }
 
-// corsMiddleware adds CORS headers to responses
-func (s *Server) corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-
-		// Check if origin is allowed
-		allowedOrigin := "*"
-		if len(s.config.CORSOrigins) > 0 && s.config.CORSOrigins[0] != "*" {
-			for _, allowed := range s.config.CORSOrigins {
-				if allowed == origin {
-					allowedOrigin = origin
-					break
-				}
-			}
-			if allowedOrigin == "*" {
-				allowedOrigin = s.config.CORSOrigins[0]
-			}
+// Close stops the background tasks started by StartBackground and releases the bucket
+func (s *Server) Close() {
+	s.lifecycle.Stop()
+	if s.blobGC != nil {
+		s.blobGC.stop()
+	}
+	if s.disk != nil {
+		s.disk.stop()
+	}
+	if s.scrubber != nil {
+		s.scrubber.stop()
+	}
+	if s.watcher != nil {
+		_ = s.watcher.Close()
+	}
+	if s.notifier != nil {
+		s.notifier.Stop()
+	}
+	if s.replicator != nil {
+		s.replicator.Stop()
+	}
+	if s.accessLogBucket != nil {
+		s.accessLogBucket.Stop()
+	}
+	if s.accessLogFile != nil {
+		_ = s.accessLogFile.Close()
+	}
+	if s.tempDir != "" {
+		_ = os.RemoveAll(s.tempDir)
+	}
+	if fs, ok := fileStorage(s.storage); ok {
+		fs.Unlock()
+	}
+}
+
+// Start listens on the configured port and serves requests until Shutdown is called
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves requests on listener until Shutdown is called
+func (s *Server) Serve(listener net.Listener) error {
+	log.Printf("SelfhostS3 %s starting on %s", Version, listener.Addr())
+	log.Printf("Bucket: %s", s.config.Bucket)
+	if s.config.StorageBackend == config.StorageMemory {
+		log.Printf("Storage: in memory (data is lost on restart)")
+	} else {
+		log.Printf("Storage path: %s", s.config.StoragePath)
+	}
+
+	s.StartBackground()
+
+	if s.tls == nil {
+		if err := s.http.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			s.Close()
+			return err
 		}
+		return nil
+	}
 
-		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
-		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "*")
-		w.Header().Set("Access-Control-Expose-Headers", "*")
-		w.Header().Set("Access-Control-Max-Age", "86400")
+	s.tls.Start()
+	if len(s.config.ACMEDomains) > 0 {
+		log.Printf("TLS enabled with ACME certificates for %s", strings.Join(s.config.ACMEDomains, ", "))
+	} else {
+		log.Printf("TLS enabled with certificate %s", s.config.TLSCert)
+	}
+	if s.config.TLSClientCA != "" {
+		log.Printf("TLS client certificates required")
+	}
+	if s.acmeHTTP != nil {
+		go func() {
+			log.Printf("ACME HTTP challenges served on %s", s.acmeHTTP.Addr)
+			if err := s.acmeHTTP.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("ACME HTTP server failed: %v", err)
+			}
+		}()
+	}
 
-		// Handle preflight requests
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
+	if err := s.http.ServeTLS(listener, "", ""); !errors.Is(err, http.ErrServerClosed) {
+		if s.acmeHTTP != nil {
+			_ = s.acmeHTTP.Close()
 		}
+		s.tls.Stop()
+		s.Close()
+		return err
+	}
+	return nil
+}
 
-		next.ServeHTTP(w, r)
-	})
+// Shutdown stops accepting connections and waits for in-flight requests to finish.
+// When ctx expires first, the remaining connections are closed, which aborts their uploads.
+// Background tasks are then stopped and the temporary files of unfinished uploads removed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.http.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Shutdown grace period expired, closing remaining connections")
+		_ = s.http.Close()
+	}
+	if s.acmeHTTP != nil {
+		_ = s.acmeHTTP.Close()
+	}
+	if s.tls != nil {
+		s.tls.Stop()
+	}
+
+	s.Close()
+	if closeErr := s.storage.Close(); closeErr != nil {
+		log.Printf("Failed to clean up storage: %v", closeErr)
+	}
+	return err
 }
 
 // handleHealth handles the health check endpoint
@@ -109,9 +506,6 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 
 // handleRequest routes S3 API requests
 func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
-	// Log the request
-	log.Printf("%s %s", r.Method, r.URL.Path)
-
 	// Parse the path: /{bucket}/{key}
 	path := strings.TrimPrefix(r.URL.Path, "/")
 	parts := strings.SplitN(path, "/", 2)
@@ -126,102 +520,301 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 		key = parts[1]
 	}
 
-	// Check if this is a public request (GET/HEAD on public prefix)
-	isPublicRequest := s.config.PublicPrefix != "" &&
-		strings.HasPrefix(key, s.config.PublicPrefix) &&
-		(r.Method == http.MethodGet || r.Method == http.MethodHead)
+	// Check if this is a public request: a plain GET/HEAD of an object on the public prefix.
+	// Subresources such as ?tagging, ?uploadId or ?versionId always require authentication.
+	settings := s.settings.Load()
+	isPublicRequest := settings.publicPrefix != "" &&
+		strings.HasPrefix(key, settings.publicPrefix) &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		publicQuery(r.URL.Query())
 
 	// Validate authentication (skip for public requests)
+	isSigned := false
 	if !isPublicRequest {
-		if err := s.auth.ValidateRequest(r); err != nil {
-			log.Printf("Auth error: %v", err)
-			s.sendError(w, http.StatusForbidden, "AccessDenied", err.Error())
+		if err := settings.auth.ValidateRequest(r); err != nil {
+			s.metrics.authFailures.WithLabelValues(auth.FailureReason(err)).Inc()
+			s.logError(r, slog.LevelWarn, "Authentication failed", err)
+			s.sendAuthError(w, r, err)
 			return
 		}
+		isSigned = true
+	} else if auth.IsSigned(r) {
+		// Public requests may still be signed, which unlocks response header overrides
+		isSigned = settings.auth.ValidateRequest(r) == nil
+	}
+	if info := requestInfoFrom(r.Context()); info != nil && isSigned {
+		info.accessKey = auth.RequestAccessKey(r)
 	}
 
 	// Check bucket matches configured bucket
 	if bucket != s.config.Bucket {
-		s.sendError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		s.sendError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	if s.disk.rejectWrite(r) {
+		s.sendError(w, r, http.StatusServiceUnavailable, "ServiceUnavailable",
+			"The server is read-only because its free disk space is low. Delete objects or free disk space.")
+		return
+	}
+
+	// Requests without a key target the bucket itself
+	if key == "" {
+		s.handleBucketRequest(w, r)
 		return
 	}
 
 	// Route based on method and query parameters
+	query := r.URL.Query()
 	switch r.Method {
 	case http.MethodGet:
-		if r.URL.Query().Has("list-type") {
-			s.handleListObjectsV2(w, r)
-		} else if key == "" {
-			// List objects (legacy)
+		if query.Has("list-type") {
 			s.handleListObjectsV2(w, r)
+		} else if query.Has("tagging") {
+			s.handleGetObjectTagging(w, r, key)
+		} else if query.Has("uploadId") {
+			s.handleListParts(w, r, key)
 		} else {
-			s.handleGetObject(w, r, key, isPublicRequest)
+			s.handleGetObject(w, r, key, isPublicRequest, isSigned)
 		}
 	case http.MethodHead:
 		s.handleHeadObject(w, r, key, isPublicRequest)
 	case http.MethodPut:
-		s.handlePutObject(w, r, key)
+		switch {
+		case query.Has("tagging"):
+			s.handlePutObjectTagging(w, r, key)
+		case query.Has("uploadId"):
+			s.handleUploadPart(w, r, key)
+		case r.Header.Get("x-amz-copy-source") != "":
+			s.handleCopyObject(w, r, key)
+		default:
+			s.handlePutObject(w, r, key)
+		}
+	case http.MethodPost:
+		switch {
+		case query.Has("uploads"):
+			s.handleCreateMultipartUpload(w, r, key)
+		case query.Has("uploadId"):
+			s.handleCompleteMultipartUpload(w, r, key)
+		default:
+			s.sendError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+		}
 	case http.MethodDelete:
-		s.handleDeleteObject(w, r, key)
+		if query.Has("tagging") {
+			s.handleDeleteObjectTagging(w, r, key)
+		} else if query.Has("uploadId") {
+			s.handleAbortMultipartUpload(w, r, key)
+		} else {
+			s.handleDeleteObject(w, r, key)
+		}
 	default:
-		s.sendError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+		s.sendError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
 	}
 }
 
+// publicQuery reports whether query only has parameters allowed on public requests:
+// the download mode, response header overrides (rejected later unless signed) and the
+// parameters of presigned URLs
+func publicQuery(query url.Values) bool {
+	for name := range query {
+		switch {
+		case name == "download", name == "x-id":
+		case strings.HasPrefix(name, "response-"), strings.HasPrefix(name, "X-Amz-"):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// handleBucketRequest routes bucket-level S3 API requests (subresources such as ?versioning)
+func (s *Server) handleBucketRequest(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		switch {
+		case query.Has("versioning"):
+			s.handleGetBucketVersioning(w, r)
+		case query.Has("versions"):
+			s.handleListObjectVersions(w, r)
+		case query.Has("lifecycle"):
+			s.handleGetBucketLifecycle(w, r)
+		case query.Has("cors"):
+			s.handleGetBucketCors(w, r)
+		case query.Has("uploads"):
+			s.handleListMultipartUploads(w, r)
+		case query.Has("snapshot"):
+			s.handleGetBucketSnapshot(w, r)
+		default:
+			// List objects (V2 or legacy)
+			s.handleListObjectsV2(w, r)
+		}
+	case http.MethodHead:
+		// HeadBucket: the bucket always exists once the server is running
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPut:
+		switch {
+		case query.Has("versioning"):
+			s.handlePutBucketVersioning(w, r)
+		case query.Has("lifecycle"):
+			s.handlePutBucketLifecycle(w, r)
+		case query.Has("cors"):
+			s.handlePutBucketCors(w, r)
+		default:
+			s.sendError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+		}
+	case http.MethodDelete:
+		switch {
+		case query.Has("lifecycle"):
+			s.handleDeleteBucketLifecycle(w, r)
+		case query.Has("cors"):
+			s.handleDeleteBucketCors(w, r)
+		default:
+			s.sendError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+		}
+	default:
+		s.sendError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+	}
+}
+
+// responseHeaderOverrides maps GetObject query parameters to the response headers they override
+var responseHeaderOverrides = []struct {
+	param  string
+	header string
+}{
+	{"response-content-type", "Content-Type"},
+	{"response-content-language", "Content-Language"},
+	{"response-expires", "Expires"},
+	{"response-cache-control", "Cache-Control"},
+	{"response-content-disposition", "Content-Disposition"},
+	{"response-content-encoding", "Content-Encoding"},
+}
+
 // handleGetObject handles GET requests for objects
-func (s *Server) handleGetObject(w http.ResponseWriter, r *http.Request, key string, isPublicRequest bool) {
-	obj, reader, err := s.storage.GetObject(key)
-	if err != nil {
-		if err == storage.ErrNotFound {
-			s.sendError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
-			return
+func (s *Server) handleGetObject(w http.ResponseWriter, r *http.Request, key string, isPublicRequest, isSigned bool) {
+	query := r.URL.Query()
+
+	// S3 only honors response header overrides on authenticated requests
+	if !isSigned {
+		for _, o := range responseHeaderOverrides {
+			if query.Has(o.param) {
+				s.sendError(w, r, http.StatusBadRequest, "InvalidRequest",
+					"Request specific response headers cannot be used for anonymous GET requests.")
+				return
+			}
 		}
-		s.sendError(w, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+
+	customerKey, err := parseCustomerKey(r.Header, sseCustomerPrefix)
+	if err != nil {
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+
+	obj, reader, err := s.storage.GetObjectWithOptions(key, storage.GetOptions{
+		VersionID:   query.Get("versionId"),
+		CustomerKey: customerKey,
+	})
+	if err != nil {
+		s.sendStorageError(w, r, err)
 		return
 	}
 	defer func() { _ = reader.Close() }()
 
+	byteRange, err := parseRange(r.Header.Get("Range"), obj.Size)
+	if err != nil {
+		s.sendRangeNotSatisfiable(w, r, obj.Size)
+		return
+	}
+	if byteRange != nil {
+		if err := skipTo(reader, byteRange.start); err != nil {
+			s.sendStorageError(w, r, err)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", obj.ContentType)
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", obj.Size))
+	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("ETag", obj.ETag)
 	w.Header().Set("Last-Modified", obj.LastModified.UTC().Format(http.TimeFormat))
+	if obj.VersionID != "" {
+		w.Header().Set("x-amz-version-id", obj.VersionID)
+	}
+	if len(obj.Tags) > 0 {
+		w.Header().Set("x-amz-tagging-count", strconv.Itoa(len(obj.Tags)))
+	}
+	setEncryptionHeaders(w, obj.Encryption, obj.CustomerKeyMD5)
+	if !query.Has("versionId") {
+		s.setReplicationStatus(w, key)
+	}
 
 	// Add cache header for public files
-	if isPublicRequest && s.config.PublicCacheMaxAge > 0 {
-		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", s.config.PublicCacheMaxAge))
+	if maxAge := s.settings.Load().publicCacheMaxAge; isPublicRequest && maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
 	}
 
 	// Handle download parameter
-	if r.URL.Query().Get("download") == "1" {
-		filename := filepath.Base(key)
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if query.Get("download") == "1" {
+		w.Header().Set("Content-Disposition", attachmentDisposition(filepath.Base(key)))
 	}
 
-	w.WriteHeader(http.StatusOK)
+	// Apply response header overrides (signed requests only, checked above)
+	for _, o := range responseHeaderOverrides {
+		if query.Has(o.param) {
+			w.Header().Set(o.header, query.Get(o.param))
+		}
+	}
+
+	if byteRange == nil {
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.Copy(w, reader)
+		return
+	}
 
-	_, _ = io.Copy(w, reader)
+	w.Header().Set("Content-Range", byteRange.contentRange(obj.Size))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", byteRange.length))
+	w.WriteHeader(http.StatusPartialContent)
+	_, _ = io.CopyN(w, reader, byteRange.length)
 }
 
 // handleHeadObject handles HEAD requests for objects
 func (s *Server) handleHeadObject(w http.ResponseWriter, r *http.Request, key string, isPublicRequest bool) {
-	obj, err := s.storage.HeadObject(key)
+	customerKey, err := parseCustomerKey(r.Header, sseCustomerPrefix)
 	if err != nil {
-		if err == storage.ErrNotFound {
-			s.sendError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
-			return
-		}
-		s.sendError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+
+	obj, err := s.storage.HeadObjectVersion(key, r.URL.Query().Get("versionId"))
+	if err == nil {
+		err = storage.CheckCustomerKey(obj, customerKey)
+	}
+	if err != nil {
+		s.sendStorageError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", obj.ContentType)
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", obj.Size))
+	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("ETag", obj.ETag)
 	w.Header().Set("Last-Modified", obj.LastModified.UTC().Format(http.TimeFormat))
+	if obj.VersionID != "" {
+		w.Header().Set("x-amz-version-id", obj.VersionID)
+	}
+	if len(obj.Tags) > 0 {
+		w.Header().Set("x-amz-tagging-count", strconv.Itoa(len(obj.Tags)))
+	}
+	setEncryptionHeaders(w, obj.Encryption, obj.CustomerKeyMD5)
+	if !r.URL.Query().Has("versionId") {
+		s.setReplicationStatus(w, key)
+	}
 
 	// Add cache header for public files
-	if isPublicRequest && s.config.PublicCacheMaxAge > 0 {
-		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", s.config.PublicCacheMaxAge))
+	if maxAge := s.settings.Load().publicCacheMaxAge; isPublicRequest && maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -231,7 +824,7 @@ func (s *Server) handleHeadObject(w http.ResponseWriter, r *http.Request, key st
 func (s *Server) handlePutObject(w http.ResponseWriter, r *http.Request, key string) {
 	// Check file size
 	if r.ContentLength > s.config.MaxFileSize {
-		s.sendError(w, http.StatusRequestEntityTooLarge, "EntityTooLarge",
+		s.sendError(w, r, http.StatusBadRequest, "EntityTooLarge",
 			fmt.Sprintf("Your proposed upload exceeds the maximum allowed size of %d bytes", s.config.MaxFileSize))
 		return
 	}
@@ -241,60 +834,159 @@ func (s *Server) handlePutObject(w http.ResponseWriter, r *http.Request, key str
 		contentType = "application/octet-stream"
 	}
 
-	// Limit reader to max file size
-	limitedReader := io.LimitReader(r.Body, s.config.MaxFileSize+1)
+	tags, err := parseTaggingHeader(r.Header.Get("x-amz-tagging"))
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
 
-	obj, err := s.storage.PutObject(key, contentType, limitedReader)
+	opts, err := parseEncryptionHeaders(r.Header)
 	if err != nil {
-		if err == storage.ErrInvalidPath {
-			s.sendError(w, http.StatusBadRequest, "InvalidArgument", "Invalid key")
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	opts.ContentType = contentType
+	opts.Tags = tags
+
+	// Reject uploads over quota before receiving them; quotas are checked again on commit
+	if r.ContentLength >= 0 {
+		if err := s.storage.CheckQuota(key, r.ContentLength); err != nil {
+			s.sendStorageError(w, r, err)
 			return
 		}
-		s.sendError(w, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+
+	// Limit reader to max file size
+	limitedReader := io.LimitReader(r.Body, s.config.MaxFileSize+1)
+
+	obj, err := s.storage.PutObjectWithOptions(key, limitedReader, opts)
+	if err != nil {
+		s.sendStorageError(w, r, err)
 		return
 	}
 
+	s.publish(events.ObjectCreatedPut, events.Object{
+		Key:       obj.Key,
+		Size:      obj.Size,
+		ETag:      obj.ETag,
+		VersionID: obj.VersionID,
+	})
+	s.replicate(obj)
+
 	w.Header().Set("ETag", obj.ETag)
+	if obj.VersionID != "" {
+		w.Header().Set("x-amz-version-id", obj.VersionID)
+	}
+	setEncryptionHeaders(w, obj.Encryption, obj.CustomerKeyMD5)
 	w.WriteHeader(http.StatusOK)
 }
 
 // handleDeleteObject handles DELETE requests
 func (s *Server) handleDeleteObject(w http.ResponseWriter, r *http.Request, key string) {
-	err := s.storage.DeleteObject(key)
+	result, err := s.storage.DeleteObjectVersion(key, r.URL.Query().Get("versionId"))
 	if err != nil {
-		if err == storage.ErrInvalidPath {
-			s.sendError(w, http.StatusBadRequest, "InvalidArgument", "Invalid key")
-			return
-		}
-		s.sendError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		s.sendStorageError(w, r, err)
 		return
 	}
 
+	eventName := events.ObjectRemovedDelete
+	if result.DeleteMarker && !r.URL.Query().Has("versionId") {
+		eventName = events.ObjectRemovedDeleteMarkerCreated
+	}
+	s.publish(eventName, events.Object{Key: key, VersionID: result.VersionID})
+	// Deleting a specific version is not replicated, as in S3
+	if !r.URL.Query().Has("versionId") {
+		s.replicateDelete(key)
+	}
+
+	if result.VersionID != "" {
+		w.Header().Set("x-amz-version-id", result.VersionID)
+	}
+	if result.DeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// handleListObjectsV2 handles ListObjectsV2 requests
+// maxListKeys is the largest page returned by ListObjects, as in S3
+const maxListKeys = 1000
+
+// handleListObjectsV2 handles ListObjectsV2 requests, and legacy ListObjects (V1)
+// requests without list-type=2
 func (s *Server) handleListObjectsV2(w http.ResponseWriter, r *http.Request) {
-	prefix := r.URL.Query().Get("prefix")
+	query := r.URL.Query()
+	v2 := query.Get("list-type") == "2"
+	opts := storage.ListOptions{
+		Prefix:    query.Get("prefix"),
+		Delimiter: query.Get("delimiter"),
+		MaxKeys:   maxListKeys,
+	}
+
+	if value := query.Get("max-keys"); value != "" {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "Provided max-keys not an integer or within integer range")
+			return
+		}
+		opts.MaxKeys = min(maxKeys, maxListKeys)
+	}
+
+	// V2 continues after the key in the continuation token, or else after start-after;
+	// V1 continues after the marker
+	token := query.Get("continuation-token")
+	if v2 {
+		opts.StartAfter = query.Get("start-after")
+		if token != "" {
+			decoded, err := base64.StdEncoding.DecodeString(token)
+			if err != nil {
+				s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+				return
+			}
+			opts.StartAfter = string(decoded)
+		}
+	} else {
+		opts.StartAfter = query.Get("marker")
+	}
 
-	objects, err := s.storage.ListObjects(prefix)
+	// A page of no keys lists nothing, but tells whether there is anything to list
+	pageOpts := opts
+	if opts.MaxKeys == 0 {
+		pageOpts.MaxKeys = 1
+	}
+	page, err := s.storage.ListObjectsPage(pageOpts)
 	if err != nil {
-		s.sendError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		s.sendStorageError(w, r, err)
 		return
 	}
+	if opts.MaxKeys == 0 {
+		page = &storage.ListResult{IsTruncated: len(page.Objects)+len(page.CommonPrefixes) > 0}
+	}
 
 	// Build response
 	response := ListBucketResult{
 		XMLName:     xml.Name{Local: "ListBucketResult"},
 		Xmlns:       "http://s3.amazonaws.com/doc/2006-03-01/",
 		Name:        s.config.Bucket,
-		Prefix:      prefix,
-		KeyCount:    len(objects),
-		MaxKeys:     1000,
-		IsTruncated: false,
+		Prefix:      opts.Prefix,
+		Delimiter:   opts.Delimiter,
+		KeyCount:    len(page.Objects) + len(page.CommonPrefixes),
+		MaxKeys:     opts.MaxKeys,
+		IsTruncated: page.IsTruncated,
+	}
+	if v2 {
+		response.ContinuationToken = token
+		response.StartAfter = query.Get("start-after")
+		if page.IsTruncated && page.NextMarker != "" {
+			response.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(page.NextMarker))
+		}
+	} else {
+		response.Marker = opts.StartAfter
+		if page.IsTruncated {
+			response.NextMarker = page.NextMarker
+		}
 	}
 
-	for _, obj := range objects {
+	for _, obj := range page.Objects {
 		response.Contents = append(response.Contents, Contents{
 			Key:          obj.Key,
 			Size:         obj.Size,
@@ -303,6 +995,9 @@ func (s *Server) handleListObjectsV2(w http.ResponseWriter, r *http.Request) {
 			StorageClass: "STANDARD",
 		})
 	}
+	for _, prefix := range page.CommonPrefixes {
+		response.CommonPrefixes = append(response.CommonPrefixes, CommonPrefix{Prefix: prefix})
+	}
 
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(http.StatusOK)
@@ -312,18 +1007,55 @@ func (s *Server) handleListObjectsV2(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(xmlData)
 }
 
-// sendError sends an S3-style error response
-func (s *Server) sendError(w http.ResponseWriter, statusCode int, code, message string) {
-	w.Header().Set("Content-Type", "application/xml")
-	w.WriteHeader(statusCode)
+// attachmentDisposition builds an attachment Content-Disposition header value.
+// Non-ASCII filenames get an ASCII fallback plus an RFC 5987 encoded filename* parameter.
+func attachmentDisposition(filename string) string {
+	var fallback strings.Builder
+	isASCII := true
+	for _, r := range filename {
+		switch {
+		case r == '"' || r == '\\':
+			fallback.WriteRune('\\')
+			fallback.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fallback.WriteRune('_')
+		case r >= utf8.RuneSelf:
+			isASCII = false
+			fallback.WriteRune('_')
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+
+	disposition := fmt.Sprintf(`attachment; filename="%s"`, fallback.String())
+	if !isASCII {
+		disposition += "; filename*=UTF-8''" + rfc5987Encode(filename)
+	}
+	return disposition
+}
 
-	errorResponse := ErrorResponse{
-		XMLName: xml.Name{Local: "Error"},
-		Code:    code,
-		Message: message,
+// rfc5987Encode percent-encodes a value for use in an RFC 5987 ext-value
+func rfc5987Encode(s string) string {
+	const attrChars = "!#$&+-.^_`|~"
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			strings.IndexByte(attrChars, c) >= 0 {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
 	}
+	return buf.String()
+}
+
+// sendXML writes an XML response body with the given status code
+func (s *Server) sendXML(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
 
-	xmlData, _ := xml.MarshalIndent(errorResponse, "", "  ")
+	xmlData, _ := xml.MarshalIndent(v, "", "  ")
 	_, _ = w.Write([]byte(xml.Header))
 	_, _ = w.Write(xmlData)
 }
@@ -332,16 +1064,26 @@ func (s *Server) sendError(w http.ResponseWriter, statusCode int, code, message
 
 // ListBucketResult is the response for ListObjectsV2
 type ListBucketResult struct {
-	XMLName               xml.Name   `xml:"ListBucketResult"`
-	Xmlns                 string     `xml:"xmlns,attr"`
-	Name                  string     `xml:"Name"`
-	Prefix                string     `xml:"Prefix"`
-	KeyCount              int        `xml:"KeyCount"`
-	MaxKeys               int        `xml:"MaxKeys"`
-	IsTruncated           bool       `xml:"IsTruncated"`
-	Contents              []Contents `xml:"Contents"`
-	ContinuationToken     string     `xml:"ContinuationToken,omitempty"`
-	NextContinuationToken string     `xml:"NextContinuationToken,omitempty"`
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	Marker                string         `xml:"Marker,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []Contents     `xml:"Contents"`
+	CommonPrefixes        []CommonPrefix `xml:"CommonPrefixes"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+}
+
+// CommonPrefix represents keys grouped by the delimiter in the list response
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
 }
 
 // Contents represents an object in the list response
@@ -352,10 +1094,3 @@ type Contents struct {
 	Size         int64  `xml:"Size"`
 	StorageClass string `xml:"StorageClass"`
 }
-
-// ErrorResponse is an S3 error response
-type ErrorResponse struct {
-	XMLName xml.Name `xml:"Error"`
-	Code    string   `xml:"Code"`
-	Message string   `xml:"Message"`
-}
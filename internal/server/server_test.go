@@ -1,15 +1,29 @@
 package server
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/hex"
+	"encoding/pem"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
 	"testing"
@@ -70,7 +84,7 @@ func signRequest(req *http.Request, accessKey, secretKey, region string) {
 	signedHeaders := strings.Join(signedHeadersList, ";")
 
 	// Build canonical request
-	canonicalURI := req.URL.Path
+	canonicalURI := req.URL.EscapedPath()
 	if canonicalURI == "" {
 		canonicalURI = "/"
 	}
@@ -131,8 +145,8 @@ func buildCanonicalQueryString(query url.Values) string {
 		sort.Strings(values)
 		for _, v := range values {
 			pairs = append(pairs, fmt.Sprintf("%s=%s",
-				url.QueryEscape(k),
-				url.QueryEscape(v),
+				strings.ReplaceAll(url.QueryEscape(k), "+", "%20"),
+				strings.ReplaceAll(url.QueryEscape(v), "+", "%20"),
 			))
 		}
 	}
@@ -198,6 +212,8 @@ func TestCORSMiddleware(t *testing.T) {
 	// Test preflight request
 	req := httptest.NewRequest(http.MethodOptions, "/test-bucket/key", nil)
 	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	req.Header.Set("Access-Control-Request-Headers", "content-type, x-amz-date")
 	w := httptest.NewRecorder()
 
 	wrapped.ServeHTTP(w, req)
@@ -582,6 +598,85 @@ func TestListObjectsV2_WithPrefix(t *testing.T) {
 	}
 }
 
+func TestListObjects_DelimiterAndPagination(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MetadataIndex = true
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer func() { _ = srv.storage.Close() }()
+
+	for _, key := range []string{"a.txt", "docs/1.md", "docs/2.md", "z.txt"} {
+		if resp := doRequest(t, srv, http.MethodPut, "/test-bucket/"+key, "content"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT %s failed with status %d", key, resp.StatusCode)
+		}
+	}
+
+	list := func(query string) ListBucketResult {
+		t.Helper()
+		resp := doRequest(t, srv, http.MethodGet, "/test-bucket?"+query, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("LIST ?%s failed with status %d", query, resp.StatusCode)
+		}
+		var result ListBucketResult
+		if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode list response: %v", err)
+		}
+		return result
+	}
+	entries := func(result ListBucketResult) []string {
+		var names []string
+		for _, c := range result.Contents {
+			names = append(names, c.Key)
+		}
+		for _, p := range result.CommonPrefixes {
+			names = append(names, p.Prefix)
+		}
+		return names
+	}
+
+	// ListObjectsV2 follows continuation tokens
+	var names []string
+	query := "list-type=2&delimiter=/&max-keys=2"
+	for pages := 1; ; pages++ {
+		result := list(query)
+		if result.KeyCount != len(entries(result)) || result.MaxKeys != 2 || result.Delimiter != "/" {
+			t.Errorf("page %d: unexpected result %+v", pages, result)
+		}
+		names = append(names, entries(result)...)
+		if !result.IsTruncated {
+			if pages != 2 {
+				t.Errorf("listed in %d pages, expected 2", pages)
+			}
+			break
+		}
+		if result.NextContinuationToken == "" || pages > 2 {
+			t.Fatalf("page %d: truncated without continuation token", pages)
+		}
+		query = "list-type=2&delimiter=/&max-keys=2&continuation-token=" + url.QueryEscape(result.NextContinuationToken)
+	}
+	sort.Strings(names)
+	if expected := []string{"a.txt", "docs/", "public/", "z.txt"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("listed %v, expected %v", names, expected)
+	}
+
+	// ListObjects (V1) continues after the marker
+	result := list("marker=docs/1.md&prefix=docs/")
+	if names := entries(result); !reflect.DeepEqual(names, []string{"docs/2.md"}) || result.Marker != "docs/1.md" {
+		t.Errorf("listing after the marker = %v", names)
+	}
+	result = list("prefix=docs/&max-keys=1")
+	if !result.IsTruncated || result.NextMarker != "docs/" {
+		t.Errorf("truncated V1 listing = %+v", result)
+	}
+
+	resp := doRequest(t, srv, http.MethodGet, "/test-bucket?list-type=2&max-keys=many", "")
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, resp) != "InvalidArgument" {
+		t.Errorf("invalid max-keys accepted with status %d", resp.StatusCode)
+	}
+}
+
 func TestGetObject_NotFound(t *testing.T) {
 	cfg := testConfig(t)
 	srv, err := NewServer(cfg)
@@ -635,8 +730,8 @@ func TestPutObject_TooLarge(t *testing.T) {
 	resp := w.Result()
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode != http.StatusRequestEntityTooLarge {
-		t.Errorf("expected status 413, got %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", resp.StatusCode)
 	}
 
 	var errResp ErrorResponse
@@ -649,6 +744,44 @@ func TestPutObject_TooLarge(t *testing.T) {
 	}
 }
 
+func TestPutObject_QuotaExceeded(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Quotas = []config.Quota{{Prefix: "uploads/", MaxBytes: 10}}
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	resp := doRequest(t, srv, http.MethodPut, "/test-bucket/uploads/a.txt", "123456")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	// Rejected from its Content-Length, before the body is stored
+	resp = doRequest(t, srv, http.MethodPut, "/test-bucket/uploads/b.txt", "123456")
+	if resp.StatusCode != http.StatusForbidden || errorCode(t, resp) != "QuotaExceeded" {
+		t.Errorf("expected QuotaExceeded, got %d", resp.StatusCode)
+	}
+
+	// Without a Content-Length, the quota is checked once the body is received
+	req := httptest.NewRequest(http.MethodPut, "/test-bucket/uploads/c.txt", strings.NewReader("123456"))
+	req.Host = "localhost:9000"
+	req.ContentLength = -1
+	signRequest(req, cfg.AccessKey, cfg.SecretKey, cfg.Region)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if resp := w.Result(); resp.StatusCode != http.StatusForbidden || errorCode(t, resp) != "QuotaExceeded" {
+		t.Errorf("expected QuotaExceeded, got %d", resp.StatusCode)
+	}
+
+	// Other prefixes are not limited
+	resp = doRequest(t, srv, http.MethodPut, "/test-bucket/other.txt", "123456")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+}
+
 func TestMethodNotAllowed(t *testing.T) {
 	cfg := testConfig(t)
 	srv, err := NewServer(cfg)
@@ -918,6 +1051,39 @@ func TestPublicAccess_NonPublicPath_RequiresAuth(t *testing.T) {
 	}
 }
 
+func TestPublicAccess_SubresourcesRequireAuth(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	doRequest(t, srv, http.MethodPut, "/test-bucket/public/logo.png", "data")
+	upload := doRequest(t, srv, http.MethodPost, "/test-bucket/public/big.bin?uploads", "")
+	var created InitiateMultipartUploadResult
+	if err := xml.NewDecoder(upload.Body).Decode(&created); err != nil {
+		t.Fatalf("failed to decode CreateMultipartUpload response: %v", err)
+	}
+
+	for _, target := range []string{
+		"/test-bucket/public/big.bin?uploadId=" + created.UploadID,
+		"/test-bucket/public/logo.png?tagging",
+		"/test-bucket/public/logo.png?versionId=null",
+		"/test-bucket/public/logo.png?list-type=2",
+		"/test-bucket/public/logo.png?acl",
+	} {
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("anonymous GET %s = %d, expected 403", target, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, httptest.NewRequest(http.MethodGet, "/test-bucket/public/logo.png?download=1", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("anonymous download = %d, expected 200", w.Code)
+	}
+}
+
 func TestPublicAccess_CacheControlHeader(t *testing.T) {
 	cfg := testConfig(t)
 	cfg.PublicCacheMaxAge = 3600 // 1 hour for test
@@ -1255,3 +1421,455 @@ func TestListObjectsV2_LegacyWithoutListType(t *testing.T) {
 		t.Errorf("expected bucket name 'test-bucket', got %q", result.Name)
 	}
 }
+
+func TestGetObject_ResponseHeaderOverrides(t *testing.T) {
+	cfg := testConfig(t)
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	putReq := httptest.NewRequest(http.MethodPut, "/test-bucket/exports/report.bin", strings.NewReader("report"))
+	putReq.Host = "localhost:9000"
+	signRequest(putReq, cfg.AccessKey, cfg.SecretKey, cfg.Region)
+	srv.handleRequest(httptest.NewRecorder(), putReq)
+
+	query := url.Values{}
+	query.Set("response-content-type", "application/pdf")
+	query.Set("response-content-disposition", `attachment; filename="Q3 Report.pdf"`)
+	query.Set("response-cache-control", "no-store")
+	query.Set("response-content-language", "fr")
+	query.Set("response-expires", "Wed, 21 Oct 2015 07:28:00 GMT")
+
+	getReq := httptest.NewRequest(http.MethodGet, "/test-bucket/exports/report.bin?"+query.Encode(), nil)
+	getReq.Host = "localhost:9000"
+	signRequest(getReq, cfg.AccessKey, cfg.SecretKey, cfg.Region)
+
+	getW := httptest.NewRecorder()
+	srv.handleRequest(getW, getReq)
+
+	getResp := getW.Result()
+	defer func() { _ = getResp.Body.Close() }()
+
+	if getResp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(getResp.Body)
+		t.Fatalf("GET failed with status %d: %s", getResp.StatusCode, string(body))
+	}
+
+	expected := map[string]string{
+		"Content-Type":        "application/pdf",
+		"Content-Disposition": `attachment; filename="Q3 Report.pdf"`,
+		"Cache-Control":       "no-store",
+		"Content-Language":    "fr",
+		"Expires":             "Wed, 21 Oct 2015 07:28:00 GMT",
+	}
+	for header, want := range expected {
+		if got := getResp.Header.Get(header); got != want {
+			t.Errorf("expected %s %q, got %q", header, want, got)
+		}
+	}
+}
+
+func TestGetObject_ResponseHeaderOverrides_OverrideDownloadParam(t *testing.T) {
+	cfg := testConfig(t)
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	putReq := httptest.NewRequest(http.MethodPut, "/test-bucket/public/logo.png", strings.NewReader("png"))
+	putReq.Host = "localhost:9000"
+	signRequest(putReq, cfg.AccessKey, cfg.SecretKey, cfg.Region)
+	srv.handleRequest(httptest.NewRecorder(), putReq)
+
+	// A signed request to a public key may use overrides, which win over download=1
+	getReq := httptest.NewRequest(http.MethodGet,
+		"/test-bucket/public/logo.png?download=1&response-content-disposition=inline", nil)
+	getReq.Host = "localhost:9000"
+	signRequest(getReq, cfg.AccessKey, cfg.SecretKey, cfg.Region)
+
+	getW := httptest.NewRecorder()
+	srv.handleRequest(getW, getReq)
+
+	getResp := getW.Result()
+	defer func() { _ = getResp.Body.Close() }()
+
+	if getResp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", getResp.StatusCode)
+	}
+	if got := getResp.Header.Get("Content-Disposition"); got != "inline" {
+		t.Errorf("expected Content-Disposition inline, got %q", got)
+	}
+}
+
+func TestGetObject_ResponseHeaderOverrides_AnonymousRejected(t *testing.T) {
+	cfg := testConfig(t)
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	putReq := httptest.NewRequest(http.MethodPut, "/test-bucket/public/page.html", strings.NewReader("<html></html>"))
+	putReq.Host = "localhost:9000"
+	signRequest(putReq, cfg.AccessKey, cfg.SecretKey, cfg.Region)
+	srv.handleRequest(httptest.NewRecorder(), putReq)
+
+	getReq := httptest.NewRequest(http.MethodGet, "/test-bucket/public/page.html?response-content-type=text/plain", nil)
+	getReq.Host = "localhost:9000"
+
+	getW := httptest.NewRecorder()
+	srv.handleRequest(getW, getReq)
+
+	getResp := getW.Result()
+	defer func() { _ = getResp.Body.Close() }()
+
+	if getResp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", getResp.StatusCode)
+	}
+
+	var errResp ErrorResponse
+	if err := xml.NewDecoder(getResp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if errResp.Code != "InvalidRequest" {
+		t.Errorf("expected error code 'InvalidRequest', got %q", errResp.Code)
+	}
+}
+
+func TestPublicAccess_DownloadParam_NonASCIIFilename(t *testing.T) {
+	cfg := testConfig(t)
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	putReq := httptest.NewRequest(http.MethodPut, "/test-bucket/public/r%C3%A9sum%C3%A9.pdf", strings.NewReader("cv"))
+	putReq.Host = "localhost:9000"
+	signRequest(putReq, cfg.AccessKey, cfg.SecretKey, cfg.Region)
+	srv.handleRequest(httptest.NewRecorder(), putReq)
+
+	getReq := httptest.NewRequest(http.MethodGet, "/test-bucket/public/r%C3%A9sum%C3%A9.pdf?download=1", nil)
+	getReq.Host = "localhost:9000"
+
+	getW := httptest.NewRecorder()
+	srv.handleRequest(getW, getReq)
+
+	getResp := getW.Result()
+	defer func() { _ = getResp.Body.Close() }()
+
+	expected := `attachment; filename="r_sum_.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`
+	if got := getResp.Header.Get("Content-Disposition"); got != expected {
+		t.Errorf("expected Content-Disposition %q, got %q", expected, got)
+	}
+}
+
+func TestAttachmentDisposition(t *testing.T) {
+	tests := []struct {
+		filename string
+		expected string
+	}{
+		{"report.pdf", `attachment; filename="report.pdf"`},
+		{"my report.pdf", `attachment; filename="my report.pdf"`},
+		{`say "hi".txt`, `attachment; filename="say \"hi\".txt"`},
+		{"日本語.txt", `attachment; filename="___.txt"; filename*=UTF-8''%E6%97%A5%E6%9C%AC%E8%AA%9E.txt`},
+		{"naïve file.txt", `attachment; filename="na_ve file.txt"; filename*=UTF-8''na%C3%AFve%20file.txt`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			if got := attachmentDisposition(tt.filename); got != tt.expected {
+				t.Errorf("attachmentDisposition(%q) = %q, expected %q", tt.filename, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewServer_LocksBucket(t *testing.T) {
+	cfg := testConfig(t)
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	if _, err := NewServer(cfg); err == nil || !strings.Contains(err.Error(), "in use by another server") {
+		t.Fatalf("expected the bucket to be in use, got %v", err)
+	}
+
+	srv.Close()
+	other, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("expected the bucket to be released on Close, got %v", err)
+	}
+	other.Close()
+}
+
+func TestNewServer_Timeouts(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.ReadHeaderTimeout = 5 * time.Second
+	cfg.IdleTimeout = time.Minute
+	cfg.WriteTimeout = 10 * time.Minute
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	if srv.http.ReadHeaderTimeout != 5*time.Second || srv.http.IdleTimeout != time.Minute ||
+		srv.http.WriteTimeout != 10*time.Minute || srv.http.ReadTimeout != 0 {
+		t.Errorf("unexpected http.Server timeouts: %+v", srv.http)
+	}
+}
+
+// startSlowUpload serves srv on a random port and starts a PUT whose body is
+// written through the returned pipe. The response is sent on the returned channel.
+func startSlowUpload(t *testing.T, srv *Server, key string) (*io.PipeWriter, <-chan *http.Response, <-chan error) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(listener) }()
+
+	body, pw := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPut, "http://"+listener.Addr().String()+"/test-bucket/"+key, body)
+	signRequest(req, srv.config.AccessKey, srv.config.SecretKey, srv.config.Region)
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			responses <- nil
+			return
+		}
+		_ = resp.Body.Close()
+		responses <- resp
+	}()
+
+	if _, err := pw.Write([]byte("first chunk ")); err != nil {
+		t.Fatalf("failed to start upload: %v", err)
+	}
+
+	// Wait until the handler is writing the upload
+	tmpDir := filepath.Join(srv.config.StoragePath, ".selfhost_s3", "test-bucket", "tmp")
+	waitFor(t, func() bool {
+		entries, _ := os.ReadDir(tmpDir)
+		return len(entries) > 0
+	})
+	return pw, responses, served
+}
+
+// waitFor polls cond until it holds or the test times out after a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 1s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer_ShutdownDrainsInFlightUploads(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	pw, responses, served := startSlowUpload(t, srv, "slow.txt")
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
+	// The upload completes after shutdown started
+	time.Sleep(50 * time.Millisecond)
+	_, _ = pw.Write([]byte("second chunk"))
+	_ = pw.Close()
+
+	resp := <-responses
+	if resp == nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected in-flight upload to succeed, got %+v", resp)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("expected clean shutdown, got %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("expected Serve to return nil after shutdown, got %v", err)
+	}
+
+	_, reader, err := srv.storage.GetObject("slow.txt")
+	if err != nil {
+		t.Fatalf("expected object to be stored: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+	if data, _ := io.ReadAll(reader); string(data) != "first chunk second chunk" {
+		t.Errorf("unexpected content %q", data)
+	}
+}
+
+func TestServer_ShutdownAbortsUploadsAfterGracePeriod(t *testing.T) {
+	cfg := testConfig(t)
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	pw, responses, _ := startSlowUpload(t, srv, "stuck.txt")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected grace period to expire, got %v", err)
+	}
+
+	// The client gives up on its side too
+	_ = pw.CloseWithError(io.ErrUnexpectedEOF)
+	if resp := <-responses; resp != nil && resp.StatusCode == http.StatusOK {
+		t.Error("expected the stuck upload to fail")
+	}
+
+	// No partial upload is left behind or published
+	tmpDir := filepath.Join(cfg.StoragePath, ".selfhost_s3", "test-bucket", "tmp")
+	waitFor(t, func() bool {
+		entries, _ := os.ReadDir(tmpDir)
+		return len(entries) == 0
+	})
+	if _, err := srv.storage.HeadObject("stuck.txt"); err == nil {
+		t.Error("expected the aborted upload not to be stored")
+	}
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1, usable both as
+// server certificate and as client CA, and returns the file paths and the certificate
+func writeTestCert(t *testing.T) (certFile, keyFile string, cert tls.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "selfhost_s3 test"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("failed to load certificate: %v", err)
+	}
+	return certFile, keyFile, cert
+}
+
+// serveTLS serves srv on a random port and returns its base URL and a client
+// trusting the test certificate. The server is shut down when the test ends.
+func serveTLS(t *testing.T, srv *Server, cert tls.Certificate, clientCerts ...tls.Certificate) (string, *http.Client) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Shutdown(context.Background()) })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: clientCerts},
+			ForceAttemptHTTP2: true,
+		},
+	}
+	return "https://" + listener.Addr().String(), client
+}
+
+func TestServer_TLSWithHTTP2(t *testing.T) {
+	certFile, keyFile, cert := writeTestCert(t)
+	cfg := testConfig(t)
+	cfg.TLSCert = certFile
+	cfg.TLSKey = keyFile
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	baseURL, client := serveTLS(t, srv, cert)
+
+	req, _ := http.NewRequest(http.MethodPut, baseURL+"/test-bucket/secure.txt", strings.NewReader("over tls"))
+	signRequest(req, cfg.AccessKey, cfg.SecretKey, cfg.Region)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("PUT over TLS failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	if resp.Proto != "HTTP/2.0" {
+		t.Errorf("expected HTTP/2, got %s", resp.Proto)
+	}
+}
+
+func TestServer_TLSClientCertificates(t *testing.T) {
+	certFile, keyFile, cert := writeTestCert(t)
+	cfg := testConfig(t)
+	cfg.TLSCert = certFile
+	cfg.TLSKey = keyFile
+	cfg.TLSClientCA = certFile
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	baseURL, client := serveTLS(t, srv, cert)
+
+	if resp, err := client.Get(baseURL + "/health"); err == nil {
+		_ = resp.Body.Close()
+		t.Fatal("expected handshake to fail without a client certificate")
+	}
+
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{cert}
+	resp, err := client.Get(baseURL + "/health")
+	if err != nil {
+		t.Fatalf("request with client certificate failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+}
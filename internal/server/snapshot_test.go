@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+func TestGetBucketSnapshot(t *testing.T) {
+	cfg := testConfig(t)
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	doRequest(t, srv, http.MethodPut, "/test-bucket/a.txt", "hello")
+	doRequest(t, srv, http.MethodPut, "/test-bucket/docs/b.txt", "world")
+
+	resp := doRequest(t, srv, http.MethodGet, "/test-bucket?snapshot", "")
+	archive, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/zstd" {
+		t.Fatalf("snapshot = %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if disposition := resp.Header.Get("Content-Disposition"); !strings.HasPrefix(disposition, `attachment; filename="test-bucket-`) {
+		t.Errorf("Content-Disposition = %q", disposition)
+	}
+
+	dir := t.TempDir()
+	stats, err := storage.Restore(context.Background(), bytes.NewReader(archive), dir, "test-bucket", storage.RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if stats.Objects != 2 {
+		t.Errorf("restored %d objects", stats.Objects)
+	}
+	restored, _ := storage.Open(dir, "test-bucket")
+	if obj, err := restored.HeadObject("docs/b.txt"); err != nil || obj.Size != 5 {
+		t.Errorf("HeadObject = %+v, %v", obj, err)
+	}
+}
+
+func TestGetBucketSnapshot_MemoryBackend(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.StorageBackend = config.StorageMemory
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	if resp := doRequest(t, srv, http.MethodGet, "/test-bucket?snapshot", ""); resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("expected 501, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/Notifuse/selfhost_s3/internal/events"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// handleGetObjectTagging handles GetObjectTagging requests
+func (s *Server) handleGetObjectTagging(w http.ResponseWriter, r *http.Request, key string) {
+	obj, err := s.storage.HeadObjectVersion(key, r.URL.Query().Get("versionId"))
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	response := Tagging{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		TagSet: []TagEntry{},
+	}
+	keys := make([]string, 0, len(obj.Tags))
+	for k := range obj.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		response.TagSet = append(response.TagSet, TagEntry{Key: k, Value: obj.Tags[k]})
+	}
+
+	if obj.VersionID != "" {
+		w.Header().Set("x-amz-version-id", obj.VersionID)
+	}
+	s.sendXML(w, http.StatusOK, response)
+}
+
+// handlePutObjectTagging handles PutObjectTagging requests
+func (s *Server) handlePutObjectTagging(w http.ResponseWriter, r *http.Request, key string) {
+	var tagging Tagging
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxConfigBodySize))
+	if err != nil || xml.Unmarshal(body, &tagging) != nil {
+		s.sendError(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema")
+		return
+	}
+
+	tags := make(map[string]string, len(tagging.TagSet))
+	for _, tag := range tagging.TagSet {
+		if _, exists := tags[tag.Key]; exists {
+			s.sendError(w, r, http.StatusBadRequest, "InvalidTag", "Cannot provide multiple Tags with the same key")
+			return
+		}
+		tags[tag.Key] = tag.Value
+	}
+
+	versionID, err := s.storage.PutObjectTagging(key, r.URL.Query().Get("versionId"), tags)
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+	s.publish(events.ObjectTaggingPut, events.Object{Key: key, VersionID: versionID})
+
+	if versionID != "" {
+		w.Header().Set("x-amz-version-id", versionID)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleDeleteObjectTagging handles DeleteObjectTagging requests
+func (s *Server) handleDeleteObjectTagging(w http.ResponseWriter, r *http.Request, key string) {
+	versionID, err := s.storage.DeleteObjectTagging(key, r.URL.Query().Get("versionId"))
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+	s.publish(events.ObjectTaggingDelete, events.Object{Key: key, VersionID: versionID})
+
+	if versionID != "" {
+		w.Header().Set("x-amz-version-id", versionID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTaggingHeader parses the URL-encoded x-amz-tagging header sent with PutObject
+// (e.g. "retention=short&customer=123")
+func parseTaggingHeader(header string) (map[string]string, error) {
+	if header == "" {
+		return nil, nil
+	}
+
+	values, err := url.ParseQuery(header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: the x-amz-tagging header is not URL encoded", storage.ErrInvalidTag)
+	}
+
+	tags := make(map[string]string, len(values))
+	for k, v := range values {
+		if len(v) > 1 {
+			return nil, fmt.Errorf("%w: cannot provide multiple tags with the same key", storage.ErrInvalidTag)
+		}
+		tags[k] = v[0]
+	}
+	return tags, nil
+}
+
+// Tagging is the request and response body of the object tagging operations
+type Tagging struct {
+	XMLName xml.Name   `xml:"Tagging"`
+	Xmlns   string     `xml:"xmlns,attr,omitempty"`
+	TagSet  []TagEntry `xml:"TagSet>Tag"`
+}
+
+// TagEntry is a single object tag
+type TagEntry struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestObjectTagging_PutGetDelete(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	resp := doRequest(t, srv, http.MethodPut, "/test-bucket/report.csv", "data")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PutObject failed with status %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, srv, http.MethodPut, "/test-bucket/report.csv?tagging", `<Tagging><TagSet>
+		<Tag><Key>retention</Key><Value>short</Value></Tag>
+		<Tag><Key>customer</Key><Value>123</Value></Tag>
+	</TagSet></Tagging>`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PutObjectTagging failed with status %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, srv, http.MethodGet, "/test-bucket/report.csv?tagging", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GetObjectTagging failed with status %d", resp.StatusCode)
+	}
+	var tagging Tagging
+	if err := xml.NewDecoder(resp.Body).Decode(&tagging); err != nil {
+		t.Fatalf("failed to decode tagging: %v", err)
+	}
+	if len(tagging.TagSet) != 2 || tagging.TagSet[0].Key != "customer" || tagging.TagSet[1].Value != "short" {
+		t.Errorf("unexpected tag set: %+v", tagging.TagSet)
+	}
+
+	resp = doRequest(t, srv, http.MethodHead, "/test-bucket/report.csv", "")
+	if got := resp.Header.Get("x-amz-tagging-count"); got != "2" {
+		t.Errorf("expected x-amz-tagging-count 2 on HEAD, got %q", got)
+	}
+	resp = doRequest(t, srv, http.MethodGet, "/test-bucket/report.csv", "")
+	if got := resp.Header.Get("x-amz-tagging-count"); got != "2" {
+		t.Errorf("expected x-amz-tagging-count 2 on GET, got %q", got)
+	}
+
+	resp = doRequest(t, srv, http.MethodDelete, "/test-bucket/report.csv?tagging", "")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DeleteObjectTagging failed with status %d", resp.StatusCode)
+	}
+
+	// The object itself survives
+	resp = doRequest(t, srv, http.MethodHead, "/test-bucket/report.csv", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected object to still exist, got status %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("x-amz-tagging-count"); got != "" {
+		t.Errorf("expected no x-amz-tagging-count after delete, got %q", got)
+	}
+}
+
+func TestObjectTagging_TaggingHeaderOnPut(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/test-bucket/tagged.txt", strings.NewReader("data"))
+	req.Host = "localhost:9000"
+	req.Header.Set("x-amz-tagging", "retention=short&customer=123")
+	signRequest(req, srv.config.AccessKey, srv.config.SecretKey, srv.config.Region)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PutObject failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	resp := doRequest(t, srv, http.MethodGet, "/test-bucket/tagged.txt?tagging", "")
+	var tagging Tagging
+	if err := xml.NewDecoder(resp.Body).Decode(&tagging); err != nil {
+		t.Fatalf("failed to decode tagging: %v", err)
+	}
+	if len(tagging.TagSet) != 2 {
+		t.Errorf("expected 2 tags, got %+v", tagging.TagSet)
+	}
+}
+
+func TestObjectTagging_InvalidTags(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	doRequest(t, srv, http.MethodPut, "/test-bucket/file.txt", "data")
+
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"malformed", `<Tagging><TagSet>`, "MalformedXML"},
+		{"duplicate keys", `<Tagging><TagSet><Tag><Key>a</Key><Value>1</Value></Tag><Tag><Key>a</Key><Value>2</Value></Tag></TagSet></Tagging>`, "InvalidTag"},
+		{"reserved prefix", `<Tagging><TagSet><Tag><Key>aws:x</Key><Value>1</Value></Tag></TagSet></Tagging>`, "InvalidTag"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(t, srv, http.MethodPut, "/test-bucket/file.txt?tagging", tt.body)
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("expected status 400, got %d", resp.StatusCode)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if !strings.Contains(string(body), tt.code) {
+				t.Errorf("expected %s error, got %s", tt.code, body)
+			}
+		})
+	}
+
+	resp := doRequest(t, srv, http.MethodGet, "/test-bucket/missing.txt?tagging", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 for missing object, got %d", resp.StatusCode)
+	}
+}
+
+func TestObjectTagging_PublicPrefixRequiresAuth(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	doRequest(t, srv, http.MethodPut, "/test-bucket/public/logo.png", "data")
+
+	req := httptest.NewRequest(http.MethodGet, "/test-bucket/public/logo.png?tagging", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected anonymous GetObjectTagging to be rejected, got status %d", w.Code)
+	}
+}
+
+func TestParseTaggingHeader(t *testing.T) {
+	tags, err := parseTaggingHeader("a=1&b=hello%20world")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tags["a"] != "1" || tags["b"] != "hello world" {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+
+	if tags, err := parseTaggingHeader(""); err != nil || tags != nil {
+		t.Errorf("expected no tags for empty header, got %v, %v", tags, err)
+	}
+	if _, err := parseTaggingHeader("a=1&a=2"); err == nil {
+		t.Error("expected error for duplicate keys")
+	}
+}
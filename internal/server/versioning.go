@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// maxConfigBodySize limits the size of XML configuration documents sent by clients
+const maxConfigBodySize = 1024 * 1024
+
+// handleGetBucketVersioning handles GetBucketVersioning requests
+func (s *Server) handleGetBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	status, err := s.storage.GetVersioning()
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	s.sendXML(w, http.StatusOK, VersioningConfiguration{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		Status: status,
+	})
+}
+
+// handlePutBucketVersioning handles PutBucketVersioning requests
+func (s *Server) handlePutBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	var config VersioningConfiguration
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxConfigBodySize))
+	if err != nil || xml.Unmarshal(body, &config) != nil {
+		s.sendError(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema")
+		return
+	}
+
+	if config.Status != storage.VersioningEnabled && config.Status != storage.VersioningSuspended {
+		s.sendError(w, r, http.StatusBadRequest, "IllegalVersioningConfigurationException",
+			"The versioning configuration specified in the request is invalid")
+		return
+	}
+
+	if err := s.storage.SetVersioning(config.Status); err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleListObjectVersions handles ListObjectVersions requests
+func (s *Server) handleListObjectVersions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	opts := storage.VersionListOptions{
+		Prefix:          prefix,
+		KeyMarker:       query.Get("key-marker"),
+		VersionIDMarker: query.Get("version-id-marker"),
+		MaxKeys:         maxListKeys,
+	}
+
+	if value := query.Get("max-keys"); value != "" {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "Provided max-keys not an integer or within integer range")
+			return
+		}
+		opts.MaxKeys = min(maxKeys, maxListKeys)
+	}
+	if opts.VersionIDMarker != "" && opts.KeyMarker == "" {
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "A version-id marker cannot be specified without a key marker.")
+		return
+	}
+
+	// A page of no keys lists nothing, but tells whether there is anything to list
+	pageOpts := opts
+	if opts.MaxKeys == 0 {
+		pageOpts.MaxKeys = 1
+	}
+	page, err := s.storage.ListObjectVersionsPage(pageOpts)
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+	if opts.MaxKeys == 0 {
+		page = &storage.VersionListResult{IsTruncated: len(page.Versions) > 0}
+	}
+
+	response := ListVersionsResult{
+		Xmlns:               "http://s3.amazonaws.com/doc/2006-03-01/",
+		Name:                s.config.Bucket,
+		Prefix:              prefix,
+		KeyMarker:           opts.KeyMarker,
+		VersionIDMarker:     opts.VersionIDMarker,
+		MaxKeys:             opts.MaxKeys,
+		IsTruncated:         page.IsTruncated,
+		NextKeyMarker:       page.NextKeyMarker,
+		NextVersionIDMarker: page.NextVersionIDMarker,
+	}
+
+	for _, v := range page.Versions {
+		if v.IsDeleteMarker {
+			response.DeleteMarkers = append(response.DeleteMarkers, DeleteMarkerEntry{
+				Key:          v.Key,
+				VersionID:    v.VersionID,
+				IsLatest:     v.IsLatest,
+				LastModified: v.LastModified.UTC().Format(time.RFC3339),
+			})
+			continue
+		}
+		response.Versions = append(response.Versions, ObjectVersion{
+			Key:          v.Key,
+			VersionID:    v.VersionID,
+			IsLatest:     v.IsLatest,
+			LastModified: v.LastModified.UTC().Format(time.RFC3339),
+			ETag:         v.ETag,
+			Size:         v.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+
+	s.sendXML(w, http.StatusOK, response)
+}
+
+// VersioningConfiguration is the request and response body for bucket versioning
+type VersioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+// ListVersionsResult is the response for ListObjectVersions
+type ListVersionsResult struct {
+	XMLName             xml.Name            `xml:"ListVersionsResult"`
+	Xmlns               string              `xml:"xmlns,attr"`
+	Name                string              `xml:"Name"`
+	Prefix              string              `xml:"Prefix"`
+	KeyMarker           string              `xml:"KeyMarker"`
+	VersionIDMarker     string              `xml:"VersionIdMarker"`
+	MaxKeys             int                 `xml:"MaxKeys"`
+	IsTruncated         bool                `xml:"IsTruncated"`
+	NextKeyMarker       string              `xml:"NextKeyMarker,omitempty"`
+	NextVersionIDMarker string              `xml:"NextVersionIdMarker,omitempty"`
+	Versions            []ObjectVersion     `xml:"Version"`
+	DeleteMarkers       []DeleteMarkerEntry `xml:"DeleteMarker"`
+}
+
+// ObjectVersion represents an object version in the ListObjectVersions response
+type ObjectVersion struct {
+	Key          string `xml:"Key"`
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// DeleteMarkerEntry represents a delete marker in the ListObjectVersions response
+type DeleteMarkerEntry struct {
+	Key          string `xml:"Key"`
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// doRequest sends a signed request to the server and returns the recorded response
+func doRequest(t *testing.T, srv *Server, method, target string, body string) *http.Response {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	req.Host = "localhost:9000"
+	signRequest(req, srv.config.AccessKey, srv.config.SecretKey, srv.config.Region)
+
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	return w.Result()
+}
+
+// enableVersioning turns on versioning for the test bucket
+func enableVersioning(t *testing.T, srv *Server) {
+	t.Helper()
+
+	resp := doRequest(t, srv, http.MethodPut, "/test-bucket?versioning",
+		`<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PutBucketVersioning failed with status %d", resp.StatusCode)
+	}
+}
+
+func TestBucketVersioning_GetAndPut(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	resp := doRequest(t, srv, http.MethodGet, "/test-bucket?versioning", "")
+	var config VersioningConfiguration
+	if err := xml.NewDecoder(resp.Body).Decode(&config); err != nil {
+		t.Fatalf("failed to decode versioning configuration: %v", err)
+	}
+	if config.Status != "" {
+		t.Errorf("expected no status for unversioned bucket, got %q", config.Status)
+	}
+
+	enableVersioning(t, srv)
+
+	resp = doRequest(t, srv, http.MethodGet, "/test-bucket?versioning", "")
+	if err := xml.NewDecoder(resp.Body).Decode(&config); err != nil {
+		t.Fatalf("failed to decode versioning configuration: %v", err)
+	}
+	if config.Status != "Enabled" {
+		t.Errorf("expected status Enabled, got %q", config.Status)
+	}
+}
+
+func TestBucketVersioning_InvalidConfiguration(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"malformed xml", "<VersioningConfiguration>", "MalformedXML"},
+		{"invalid status", "<VersioningConfiguration><Status>Off</Status></VersioningConfiguration>", "IllegalVersioningConfigurationException"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(t, srv, http.MethodPut, "/test-bucket?versioning", tt.body)
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", resp.StatusCode)
+			}
+
+			var errResp ErrorResponse
+			_ = xml.NewDecoder(resp.Body).Decode(&errResp)
+			if errResp.Code != tt.code {
+				t.Errorf("expected error code %q, got %q", tt.code, errResp.Code)
+			}
+		})
+	}
+}
+
+func TestVersioning_PutGetDeleteByVersionID(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	enableVersioning(t, srv)
+
+	put1 := doRequest(t, srv, http.MethodPut, "/test-bucket/invoice.txt", "draft")
+	v1 := put1.Header.Get("x-amz-version-id")
+	put2 := doRequest(t, srv, http.MethodPut, "/test-bucket/invoice.txt", "final")
+	v2 := put2.Header.Get("x-amz-version-id")
+
+	if v1 == "" || v2 == "" || v1 == v2 {
+		t.Fatalf("expected distinct x-amz-version-id headers, got %q and %q", v1, v2)
+	}
+
+	// GET by version ID returns the overwritten content
+	get := doRequest(t, srv, http.MethodGet, "/test-bucket/invoice.txt?versionId="+v1, "")
+	body, _ := io.ReadAll(get.Body)
+	if string(body) != "draft" {
+		t.Errorf("expected v1 content 'draft', got %q", string(body))
+	}
+	if get.Header.Get("x-amz-version-id") != v1 {
+		t.Errorf("expected x-amz-version-id %q, got %q", v1, get.Header.Get("x-amz-version-id"))
+	}
+
+	// HEAD by version ID
+	head := doRequest(t, srv, http.MethodHead, "/test-bucket/invoice.txt?versionId="+v1, "")
+	if head.StatusCode != http.StatusOK || head.Header.Get("Content-Length") != "5" {
+		t.Errorf("unexpected HEAD response: status %d, length %q", head.StatusCode, head.Header.Get("Content-Length"))
+	}
+
+	// DELETE without version ID creates a delete marker
+	del := doRequest(t, srv, http.MethodDelete, "/test-bucket/invoice.txt", "")
+	if del.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", del.StatusCode)
+	}
+	if del.Header.Get("x-amz-delete-marker") != "true" {
+		t.Error("expected x-amz-delete-marker header")
+	}
+	markerID := del.Header.Get("x-amz-version-id")
+
+	if resp := doRequest(t, srv, http.MethodGet, "/test-bucket/invoice.txt", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %d", resp.StatusCode)
+	}
+	if resp := doRequest(t, srv, http.MethodGet, "/test-bucket/invoice.txt?versionId="+markerID, ""); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for delete marker, got %d", resp.StatusCode)
+	}
+
+	// Removing the delete marker restores the latest version
+	doRequest(t, srv, http.MethodDelete, "/test-bucket/invoice.txt?versionId="+markerID, "")
+	get = doRequest(t, srv, http.MethodGet, "/test-bucket/invoice.txt", "")
+	body, _ = io.ReadAll(get.Body)
+	if string(body) != "final" {
+		t.Errorf("expected restored content 'final', got %q", string(body))
+	}
+}
+
+func TestVersioning_NoSuchVersion(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	enableVersioning(t, srv)
+	doRequest(t, srv, http.MethodPut, "/test-bucket/a.txt", "a")
+
+	resp := doRequest(t, srv, http.MethodGet, "/test-bucket/a.txt?versionId=0000000000000000ffffffffffffffff", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", resp.StatusCode)
+	}
+	var errResp ErrorResponse
+	_ = xml.NewDecoder(resp.Body).Decode(&errResp)
+	if errResp.Code != "NoSuchVersion" {
+		t.Errorf("expected NoSuchVersion, got %q", errResp.Code)
+	}
+
+	resp = doRequest(t, srv, http.MethodGet, "/test-bucket/a.txt?versionId=../../secret", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400 for malformed version ID, got %d", resp.StatusCode)
+	}
+}
+
+func TestVersioning_PublicPrefixVersionsRequireAuth(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	enableVersioning(t, srv)
+
+	put := doRequest(t, srv, http.MethodPut, "/test-bucket/public/logo.png", "old logo")
+	versionID := put.Header.Get("x-amz-version-id")
+	doRequest(t, srv, http.MethodDelete, "/test-bucket/public/logo.png", "")
+
+	// The object is hidden behind a delete marker, and its version is not public
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, httptest.NewRequest(http.MethodGet, "/test-bucket/public/logo.png", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("anonymous GET of the deleted object = %d, expected 404", w.Code)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, httptest.NewRequest(method, "/test-bucket/public/logo.png?versionId="+versionID, nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("anonymous %s of the version = %d, expected 403", method, w.Code)
+		}
+	}
+
+	resp := doRequest(t, srv, http.MethodGet, "/test-bucket/public/logo.png?versionId="+versionID, "")
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "old logo" {
+		t.Errorf("signed GET of the version = %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestListObjectVersions(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	enableVersioning(t, srv)
+
+	doRequest(t, srv, http.MethodPut, "/test-bucket/docs/a.txt", "1")
+	doRequest(t, srv, http.MethodPut, "/test-bucket/docs/a.txt", "2")
+	doRequest(t, srv, http.MethodPut, "/test-bucket/docs/b.txt", "b")
+	doRequest(t, srv, http.MethodDelete, "/test-bucket/docs/b.txt", "")
+	doRequest(t, srv, http.MethodPut, "/test-bucket/other.txt", "o")
+
+	resp := doRequest(t, srv, http.MethodGet, "/test-bucket?versions&prefix=docs/", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("ListObjectVersions failed with status %d", resp.StatusCode)
+	}
+
+	var result ListVersionsResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	// Directories are listed as folder markers, like in ListObjectsV2
+	if len(result.Versions) != 4 {
+		t.Errorf("expected 4 versions (docs/ marker, 2x a.txt, b.txt), got %d", len(result.Versions))
+	}
+	if len(result.DeleteMarkers) != 1 || result.DeleteMarkers[0].Key != "docs/b.txt" || !result.DeleteMarkers[0].IsLatest {
+		t.Errorf("expected latest delete marker for docs/b.txt, got %+v", result.DeleteMarkers)
+	}
+
+	latest := map[string]int{}
+	for _, v := range result.Versions {
+		if v.IsLatest {
+			latest[v.Key]++
+		}
+	}
+	if latest["docs/a.txt"] != 1 || latest["docs/b.txt"] != 0 {
+		t.Errorf("unexpected latest flags: %v", latest)
+	}
+}
+
+func TestListObjectVersions_Pagination(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	enableVersioning(t, srv)
+
+	for _, body := range []string{"1", "2", "3"} {
+		doRequest(t, srv, http.MethodPut, "/test-bucket/docs/a.txt", body)
+	}
+	doRequest(t, srv, http.MethodPut, "/test-bucket/docs/b.txt", "b")
+
+	list := func(query string) ListVersionsResult {
+		t.Helper()
+		resp := doRequest(t, srv, http.MethodGet, "/test-bucket?versions&prefix=docs/"+query, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("ListObjectVersions%s failed with status %d", query, resp.StatusCode)
+		}
+		var result ListVersionsResult
+		if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return result
+	}
+
+	var all []string
+	for _, v := range list("").Versions {
+		all = append(all, v.Key+"@"+v.VersionID)
+	}
+
+	// Pages of two follow the markers of the previous page, splitting the versions of a.txt
+	var paged []string
+	query := "&max-keys=2"
+	for pages := 0; ; pages++ {
+		if pages == len(all) {
+			t.Fatal("pagination does not end")
+		}
+		result := list(query)
+		if result.MaxKeys != 2 || len(result.Versions) > 2 {
+			t.Fatalf("page of %d versions with MaxKeys %d", len(result.Versions), result.MaxKeys)
+		}
+		for _, v := range result.Versions {
+			paged = append(paged, v.Key+"@"+v.VersionID)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query = "&max-keys=2&key-marker=" + url.QueryEscape(result.NextKeyMarker) +
+			"&version-id-marker=" + url.QueryEscape(result.NextVersionIDMarker)
+	}
+	if strings.Join(paged, ",") != strings.Join(all, ",") {
+		t.Errorf("paged listing = %v, expected %v", paged, all)
+	}
+
+	// A key marker alone lists later keys only
+	if result := list("&key-marker=docs/a.txt"); len(result.Versions) != 1 || result.Versions[0].Key != "docs/b.txt" {
+		t.Errorf("listing after docs/a.txt = %+v", result.Versions)
+	}
+	if result := list("&max-keys=0"); len(result.Versions) != 0 || !result.IsTruncated {
+		t.Errorf("max-keys=0 = %d versions, truncated %v", len(result.Versions), result.IsTruncated)
+	}
+	if resp := doRequest(t, srv, http.MethodGet, "/test-bucket?versions&version-id-marker=abc", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("version-id-marker without key-marker = %d, expected 400", resp.StatusCode)
+	}
+}
+
+func TestHeadBucket(t *testing.T) {
+	srv, err := NewServer(testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	if resp := doRequest(t, srv, http.MethodHead, "/test-bucket", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/events"
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// watchSettle is how long files must stop changing before they are picked up, so that
+// files still being copied are not described halfway
+const watchSettle = time.Second
+
+// startWatcher starts picking up the files added, replaced or removed directly in the
+// bucket directory. Failing to watch is logged; the server runs without it.
+func (s *Server) startWatcher(store *storage.Storage) {
+	watcher, err := store.Watch(watchSettle, s.publishDiskChanges)
+	if err != nil {
+		log.Printf("Watch: %v", err)
+		return
+	}
+	s.watcher = watcher
+	log.Printf("Files changed directly in the bucket directory are picked up")
+}
+
+// publishDiskChanges logs the objects changed directly on disk and publishes their events
+func (s *Server) publishDiskChanges(changes []storage.Change, err error) {
+	for _, change := range changes {
+		if change.Object == nil {
+			log.Printf("Watch: %s removed on disk", change.Key)
+			s.publish(events.ObjectRemovedDelete, events.Object{Key: change.Key})
+			s.replicateDelete(change.Key)
+			continue
+		}
+		log.Printf("Watch: %s added on disk (%d bytes)", change.Key, change.Object.Size)
+		s.publish(events.ObjectCreatedPut, events.Object{
+			Key:       change.Key,
+			Size:      change.Object.Size,
+			ETag:      change.Object.ETag,
+			VersionID: change.Object.VersionID,
+		})
+		s.replicate(change.Object)
+	}
+	if err != nil {
+		log.Printf("Watch: %v", err)
+	}
+}
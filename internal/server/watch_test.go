@@ -0,0 +1,84 @@
+//go:build linux
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+	"github.com/Notifuse/selfhost_s3/internal/events"
+)
+
+func TestWatcher_PublishesDiskChanges(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var notification events.Notification
+		_ = json.Unmarshal(body, &notification)
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, record := range notification.Records {
+			received = append(received, record.EventName+" "+record.S3.Object.Key)
+		}
+	}))
+	defer hook.Close()
+
+	cfg := testConfig(t)
+	cfg.Watch = true
+	cfg.LifecycleInterval = 0
+	cfg.Webhooks = []config.Webhook{{URL: hook.URL, Events: []string{"s3:ObjectCreated:*", "s3:ObjectRemoved:*"}}}
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	doRequest(t, srv, http.MethodPut, "/test-bucket/old.txt", "old")
+	srv.StartBackground()
+	defer srv.Close()
+	if srv.watcher == nil {
+		t.Fatal("bucket directory not watched")
+	}
+
+	bucketDir := filepath.Join(cfg.StoragePath, "test-bucket")
+	if err := os.MkdirAll(filepath.Join(bucketDir, "synced"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bucketDir, "synced", "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(bucketDir, "old.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"ObjectCreated:Put old.txt", "ObjectCreated:Put synced%2Fnew.txt", "ObjectRemoved:Delete old.txt"}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mu.Lock()
+		got := append([]string(nil), received...)
+		mu.Unlock()
+		sort.Strings(got)
+		if reflect.DeepEqual(got, expected) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("events = %v, expected %v", got, expected)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if resp := doRequest(t, srv, http.MethodGet, "/test-bucket/synced/new.txt", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the new file to be served, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"io"
+	"strings"
+	"time"
+)
+
+// Backend is the object store behind the S3 API. Storage is the filesystem
+// implementation; Memory keeps everything in memory for tests and ephemeral setups.
+type Backend interface {
+	// Objects
+	GetObject(key string) (*Object, io.ReadCloser, error)
+	GetObjectVersion(key, versionID string) (*Object, io.ReadCloser, error)
+	GetObjectWithOptions(key string, opts GetOptions) (*Object, io.ReadCloser, error)
+	HeadObject(key string) (*Object, error)
+	HeadObjectVersion(key, versionID string) (*Object, error)
+	PutObject(key string, contentType string, body io.Reader) (*Object, error)
+	PutObjectWithOptions(key string, body io.Reader, opts PutOptions) (*Object, error)
+	CopyObject(srcKey string, src GetOptions, dstKey string, opts *PutOptions) (*Object, error)
+	DeleteObject(key string) error
+	DeleteObjectVersion(key, versionID string) (*DeleteResult, error)
+	ListObjects(prefix string) ([]Object, error)
+	ListObjectsPage(opts ListOptions) (*ListResult, error)
+	ListObjectVersions(prefix string) ([]Object, error)
+	ListObjectVersionsPage(opts VersionListOptions) (*VersionListResult, error)
+
+	// Multipart uploads
+	CreateMultipartUpload(key string, opts PutOptions) (*Upload, error)
+	UploadPart(key, uploadID string, partNumber int, body io.Reader, customerKey []byte) (*Part, error)
+	ListParts(key, uploadID string) ([]Part, error)
+	CompleteMultipartUpload(key, uploadID string, parts []CompletedPart) (*Object, error)
+	AbortMultipartUpload(key, uploadID string) error
+	ListMultipartUploads(prefix string) ([]Upload, error)
+
+	// Object tags
+	PutObjectTagging(key, versionID string, tags map[string]string) (string, error)
+	DeleteObjectTagging(key, versionID string) (string, error)
+
+	// Bucket settings
+	GetVersioning() (string, error)
+	SetVersioning(status string) error
+	GetBucketConfig(name string) ([]byte, error)
+	PutBucketConfig(name string, data []byte) error
+	DeleteBucketConfig(name string) error
+	EnsurePublicDir(prefix string) error
+
+	// SetMasterKey sets the key of SSE-S3 encryption, applied by default once set.
+	// It must be called before the backend is used.
+	SetMasterKey(key []byte)
+
+	// Usage reports the number of objects and the bytes stored
+	Usage() (*Usage, error)
+
+	// SetQuotas sets the quotas enforced when objects are written, counting the objects
+	// stored now. It must be called before the backend is used.
+	SetQuotas(quotas []Quota) error
+	// CheckQuota returns an error wrapping ErrQuotaExceeded when storing size bytes at
+	// key would exceed a quota
+	CheckQuota(key string, size int64) error
+	// QuotaUsage reports the usage of every quota
+	QuotaUsage() []QuotaUsage
+
+	// Close releases resources once the server no longer uses the backend
+	Close() error
+}
+
+// Compile-time checks that both backends implement Backend
+var (
+	_ Backend = (*Storage)(nil)
+	_ Backend = (*Memory)(nil)
+)
+
+// Upload is a multipart upload that has been created but not completed or aborted
+type Upload struct {
+	ID        string
+	Key       string
+	Initiated time.Time
+
+	// Server-side encryption applied to the parts, as in Object
+	Encryption     string
+	CustomerKeyMD5 string
+}
+
+// Part is an uploaded part of a multipart upload
+type Part struct {
+	PartNumber   int
+	Size         int64
+	ETag         string
+	LastModified time.Time
+
+	compression *compressionInfo // frames of a part compressed by Storage
+	salt        []byte           // derives the key of a part encrypted by Storage
+}
+
+// CompletedPart identifies a part to assemble in CompleteMultipartUpload
+type CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// Multipart limits, as enforced by S3
+const (
+	MaxPartNumber = 10000
+)
+
+// minPartSize is the minimum size of every part but the last one.
+// It is a variable so tests can lower it.
+var minPartSize int64 = 5 * 1024 * 1024
+
+// copyObject implements CopyObject on top of the other Backend operations.
+// A nil opts copies the tags of the source and encrypts the copy with the default
+// mode; otherwise opts replaces them.
+func copyObject(b Backend, srcKey string, srcOpts GetOptions, dstKey string, opts *PutOptions) (*Object, error) {
+	if strings.HasSuffix(srcKey, "/") || strings.HasSuffix(dstKey, "/") {
+		return nil, ErrInvalidPath
+	}
+
+	src, reader, err := b.GetObjectWithOptions(srcKey, srcOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = reader.Close() }()
+
+	if opts == nil {
+		opts = &PutOptions{ContentType: src.ContentType, Tags: src.Tags}
+	}
+	return b.PutObjectWithOptions(dstKey, reader, *opts)
+}
+
+// validatePartOrder checks the part list of CompleteMultipartUpload
+func validatePartOrder(parts []CompletedPart) error {
+	if len(parts) == 0 {
+		return ErrInvalidPart
+	}
+	for i, part := range parts {
+		if part.PartNumber < 1 || part.PartNumber > MaxPartNumber {
+			return ErrInvalidPart
+		}
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return ErrInvalidPartOrder
+		}
+	}
+	return nil
+}
+
+// validKey reports whether key is safe to use as an object key.
+// Keys must not escape the bucket through ".." segments.
+func validKey(key string) bool {
+	return key != "" && !strings.Contains("/"+key+"/", "/../")
+}
+
+// sameETag compares ETags ignoring surrounding quotes
+func sameETag(a, b string) bool {
+	return strings.Trim(a, `"`) == strings.Trim(b, `"`)
+}
@@ -0,0 +1,502 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// backends returns a fresh instance of every Backend implementation
+func backends(t *testing.T) map[string]Backend {
+	t.Helper()
+
+	fs, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	indexed, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if _, err := indexed.OpenIndex(); err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+	t.Cleanup(func() { _ = indexed.CloseIndex() })
+	return map[string]Backend{
+		"filesystem": fs,
+		"indexed":    indexed,
+		"memory":     NewMemory(),
+	}
+}
+
+// forEachBackend runs a test against every Backend implementation
+func forEachBackend(t *testing.T, test func(t *testing.T, b Backend)) {
+	for name, b := range backends(t) {
+		t.Run(name, func(t *testing.T) { test(t, b) })
+	}
+}
+
+// readAll reads a version of key from a backend
+func readAll(t *testing.T, b Backend, key, versionID string) string {
+	t.Helper()
+
+	_, reader, err := b.GetObjectVersion(key, versionID)
+	if err != nil {
+		t.Fatalf("GetObjectVersion(%q, %q) failed: %v", key, versionID, err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	data, _ := io.ReadAll(reader)
+	return string(data)
+}
+
+// listKeys returns the keys listed under prefix
+func listKeys(t *testing.T, b Backend, prefix string) []string {
+	t.Helper()
+
+	objects, err := b.ListObjects(prefix)
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	keys := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	return keys
+}
+
+func TestBackend_ObjectLifecycle(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		obj, err := b.PutObject("docs/readme.txt", "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		if obj.Size != 5 || obj.ETag == "" || obj.VersionID != "" {
+			t.Errorf("unexpected object: %+v", obj)
+		}
+
+		if got := readAll(t, b, "docs/readme.txt", ""); got != "hello" {
+			t.Errorf("expected content 'hello', got %q", got)
+		}
+
+		head, err := b.HeadObject("docs/readme.txt")
+		if err != nil || head.Size != 5 || head.ContentType != "text/plain; charset=utf-8" {
+			t.Errorf("unexpected HeadObject result: %+v, %v", head, err)
+		}
+
+		keys := listKeys(t, b, "")
+		if strings.Join(keys, ",") != "docs/,docs/readme.txt" {
+			t.Errorf("unexpected listing: %v", keys)
+		}
+
+		if err := b.DeleteObject("docs/readme.txt"); err != nil {
+			t.Fatalf("DeleteObject failed: %v", err)
+		}
+		if _, err := b.HeadObject("docs/readme.txt"); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound after delete, got %v", err)
+		}
+
+		// The folder persists after its last file is deleted
+		if keys := listKeys(t, b, ""); strings.Join(keys, ",") != "docs/" {
+			t.Errorf("expected empty folder to persist, got %v", keys)
+		}
+
+		// Deleting a missing object succeeds
+		if err := b.DeleteObject("missing.txt"); err != nil {
+			t.Errorf("expected deleting a missing object to succeed, got %v", err)
+		}
+	})
+}
+
+func TestBackend_FolderMarkers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		if _, err := b.PutObject("a/b/", "", strings.NewReader("")); err != nil {
+			t.Fatalf("PutObject folder failed: %v", err)
+		}
+		if _, err := b.PutObject("a/b/file.txt", "", strings.NewReader("x")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+
+		// A folder with content is kept
+		_ = b.DeleteObject("a/b/")
+		if keys := listKeys(t, b, "a/"); strings.Join(keys, ",") != "a/,a/b/,a/b/file.txt" {
+			t.Errorf("unexpected listing: %v", keys)
+		}
+
+		_ = b.DeleteObject("a/b/file.txt")
+		_ = b.DeleteObject("a/b/")
+		if keys := listKeys(t, b, "a/"); strings.Join(keys, ",") != "a/" {
+			t.Errorf("expected empty folder to be deleted, got %v", keys)
+		}
+
+		if err := b.EnsurePublicDir("public/"); err != nil {
+			t.Fatalf("EnsurePublicDir failed: %v", err)
+		}
+		if keys := listKeys(t, b, "public"); strings.Join(keys, ",") != "public/" {
+			t.Errorf("expected public folder, got %v", keys)
+		}
+	})
+}
+
+func TestBackend_InvalidKeys(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		if _, err := b.PutObject("../escape.txt", "", strings.NewReader("x")); err != ErrInvalidPath {
+			t.Errorf("expected ErrInvalidPath, got %v", err)
+		}
+		if _, err := b.HeadObject("../../etc/passwd"); err != ErrInvalidPath {
+			t.Errorf("expected ErrInvalidPath, got %v", err)
+		}
+	})
+}
+
+func TestBackend_CopyObject(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		if _, err := b.CopyObject("missing.txt", GetOptions{}, "dst.txt", nil); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound for missing source, got %v", err)
+		}
+
+		_, err := b.PutObjectWithOptions("src.txt", strings.NewReader("original"), PutOptions{
+			Tags: map[string]string{"team": "a"},
+		})
+		if err != nil {
+			t.Fatalf("PutObjectWithOptions failed: %v", err)
+		}
+
+		copied, err := b.CopyObject("src.txt", GetOptions{}, "dst.txt", nil)
+		if err != nil {
+			t.Fatalf("CopyObject failed: %v", err)
+		}
+		if copied.Key != "dst.txt" || copied.Size != 8 {
+			t.Errorf("unexpected copy: %+v", copied)
+		}
+		if got := readAll(t, b, "dst.txt", ""); got != "original" {
+			t.Errorf("expected copied content, got %q", got)
+		}
+		if head, _ := b.HeadObject("dst.txt"); head.Tags["team"] != "a" {
+			t.Errorf("expected tags to be copied, got %v", head.Tags)
+		}
+
+		// Replacing tags
+		if _, err := b.CopyObject("src.txt", GetOptions{}, "dst2.txt", &PutOptions{Tags: map[string]string{"team": "b"}}); err != nil {
+			t.Fatalf("CopyObject failed: %v", err)
+		}
+		if head, _ := b.HeadObject("dst2.txt"); head.Tags["team"] != "b" {
+			t.Errorf("expected tags to be replaced, got %v", head.Tags)
+		}
+
+		// Copying an object onto itself is allowed
+		if _, err := b.CopyObject("src.txt", GetOptions{}, "src.txt", nil); err != nil {
+			t.Fatalf("CopyObject onto itself failed: %v", err)
+		}
+		if got := readAll(t, b, "src.txt", ""); got != "original" {
+			t.Errorf("expected content to survive self-copy, got %q", got)
+		}
+	})
+}
+
+func TestBackend_CopyObjectVersion(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		_ = b.SetVersioning(VersioningEnabled)
+
+		v1, _ := b.PutObject("doc.txt", "", strings.NewReader("v1"))
+		_, _ = b.PutObject("doc.txt", "", strings.NewReader("v2"))
+
+		if _, err := b.CopyObject("doc.txt", GetOptions{VersionID: v1.VersionID}, "restored.txt", nil); err != nil {
+			t.Fatalf("CopyObject failed: %v", err)
+		}
+		if got := readAll(t, b, "restored.txt", ""); got != "v1" {
+			t.Errorf("expected the old version to be copied, got %q", got)
+		}
+	})
+}
+
+func TestBackend_Versioning(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		_, _ = b.PutObject("doc.txt", "", strings.NewReader("unversioned"))
+		if err := b.SetVersioning(VersioningEnabled); err != nil {
+			t.Fatalf("SetVersioning failed: %v", err)
+		}
+
+		v1, _ := b.PutObject("doc.txt", "", strings.NewReader("v1"))
+		if v1.VersionID == "" || v1.VersionID == nullVersionID {
+			t.Fatalf("expected a generated version ID, got %q", v1.VersionID)
+		}
+
+		result, err := b.DeleteObjectVersion("doc.txt", "")
+		if err != nil || !result.DeleteMarker {
+			t.Fatalf("expected a delete marker, got %+v, %v", result, err)
+		}
+		if _, err := b.HeadObject("doc.txt"); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound behind delete marker, got %v", err)
+		}
+		if _, err := b.HeadObjectVersion("doc.txt", result.VersionID); err != ErrDeleteMarker {
+			t.Errorf("expected ErrDeleteMarker, got %v", err)
+		}
+
+		versions, _ := b.ListObjectVersions("doc.txt")
+		if len(versions) != 3 || !versions[0].IsDeleteMarker || !versions[0].IsLatest ||
+			versions[1].VersionID != v1.VersionID || versions[2].VersionID != nullVersionID {
+			t.Fatalf("unexpected versions: %+v", versions)
+		}
+
+		// Removing the delete marker restores v1
+		if _, err := b.DeleteObjectVersion("doc.txt", result.VersionID); err != nil {
+			t.Fatalf("DeleteObjectVersion failed: %v", err)
+		}
+		if got := readAll(t, b, "doc.txt", ""); got != "v1" {
+			t.Errorf("expected v1 to be restored, got %q", got)
+		}
+		if got := readAll(t, b, "doc.txt", nullVersionID); got != "unversioned" {
+			t.Errorf("expected null version content, got %q", got)
+		}
+
+		if _, err := b.HeadObjectVersion("doc.txt", "0123456789abcdef0123456789abcdef"); err != ErrNoSuchVersion {
+			t.Errorf("expected ErrNoSuchVersion, got %v", err)
+		}
+		if _, err := b.HeadObjectVersion("doc.txt", "bogus"); err != ErrInvalidVersionID {
+			t.Errorf("expected ErrInvalidVersionID, got %v", err)
+		}
+
+		// Suspended buckets replace the null version in place
+		_ = b.SetVersioning(VersioningSuspended)
+		obj, _ := b.PutObject("doc.txt", "", strings.NewReader("suspended"))
+		if obj.VersionID != nullVersionID {
+			t.Errorf("expected null version ID while suspended, got %q", obj.VersionID)
+		}
+		versions, _ = b.ListObjectVersions("doc.txt")
+		if len(versions) != 2 {
+			t.Errorf("expected the null version to be replaced, got %+v", versions)
+		}
+	})
+}
+
+func TestBackend_Tagging(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		_, _ = b.PutObject("file.txt", "", strings.NewReader("x"))
+
+		if _, err := b.PutObjectTagging("file.txt", "", map[string]string{"a": "1"}); err != nil {
+			t.Fatalf("PutObjectTagging failed: %v", err)
+		}
+		if head, _ := b.HeadObject("file.txt"); head.Tags["a"] != "1" {
+			t.Errorf("expected tag a=1, got %v", head.Tags)
+		}
+		if _, err := b.DeleteObjectTagging("file.txt", ""); err != nil {
+			t.Fatalf("DeleteObjectTagging failed: %v", err)
+		}
+		if head, _ := b.HeadObject("file.txt"); head.Tags != nil {
+			t.Errorf("expected no tags, got %v", head.Tags)
+		}
+		if _, err := b.PutObjectTagging("missing.txt", "", nil); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestBackend_BucketConfig(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		if _, err := b.GetBucketConfig("lifecycle"); err != ErrNoSuchConfig {
+			t.Errorf("expected ErrNoSuchConfig, got %v", err)
+		}
+		if err := b.PutBucketConfig("lifecycle", []byte("<xml/>")); err != nil {
+			t.Fatalf("PutBucketConfig failed: %v", err)
+		}
+		if data, _ := b.GetBucketConfig("lifecycle"); string(data) != "<xml/>" {
+			t.Errorf("unexpected configuration %q", data)
+		}
+		if err := b.DeleteBucketConfig("lifecycle"); err != nil {
+			t.Fatalf("DeleteBucketConfig failed: %v", err)
+		}
+		if err := b.PutBucketConfig("../escape", nil); err == nil {
+			t.Error("expected invalid configuration name to be rejected")
+		}
+	})
+}
+
+func TestBackend_MultipartUpload(t *testing.T) {
+	defer func(size int64) { minPartSize = size }(minPartSize)
+	minPartSize = 4
+
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		upload, err := b.CreateMultipartUpload("big.bin", PutOptions{Tags: map[string]string{"kind": "big"}})
+		if err != nil {
+			t.Fatalf("CreateMultipartUpload failed: %v", err)
+		}
+
+		uploads, _ := b.ListMultipartUploads("")
+		if len(uploads) != 1 || uploads[0].ID != upload.ID || uploads[0].Key != "big.bin" {
+			t.Fatalf("unexpected uploads: %+v", uploads)
+		}
+
+		// Parts can arrive out of order and be replaced
+		p2, err := b.UploadPart("big.bin", upload.ID, 2, strings.NewReader("-world"), nil)
+		if err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
+		_, _ = b.UploadPart("big.bin", upload.ID, 1, strings.NewReader("oops"), nil)
+		p1, _ := b.UploadPart("big.bin", upload.ID, 1, strings.NewReader("hello"), nil)
+
+		parts, err := b.ListParts("big.bin", upload.ID)
+		if err != nil || len(parts) != 2 || parts[0].PartNumber != 1 || parts[0].Size != 5 || parts[0].ETag != p1.ETag {
+			t.Fatalf("unexpected parts: %+v, %v", parts, err)
+		}
+
+		// The object does not exist until the upload completes
+		if _, err := b.HeadObject("big.bin"); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound before completion, got %v", err)
+		}
+
+		if _, err := b.CompleteMultipartUpload("big.bin", upload.ID, []CompletedPart{{2, p2.ETag}, {1, p1.ETag}}); err != ErrInvalidPartOrder {
+			t.Errorf("expected ErrInvalidPartOrder, got %v", err)
+		}
+		if _, err := b.CompleteMultipartUpload("big.bin", upload.ID, []CompletedPart{{1, `"wrong"`}, {2, p2.ETag}}); err != ErrInvalidPart {
+			t.Errorf("expected ErrInvalidPart, got %v", err)
+		}
+
+		obj, err := b.CompleteMultipartUpload("big.bin", upload.ID, []CompletedPart{{1, p1.ETag}, {2, p2.ETag}})
+		if err != nil {
+			t.Fatalf("CompleteMultipartUpload failed: %v", err)
+		}
+		if obj.Size != 11 {
+			t.Errorf("expected size 11, got %d", obj.Size)
+		}
+		if got := readAll(t, b, "big.bin", ""); got != "hello-world" {
+			t.Errorf("unexpected content %q", got)
+		}
+		if head, _ := b.HeadObject("big.bin"); head.Tags["kind"] != "big" {
+			t.Errorf("expected upload tags on the object, got %v", head.Tags)
+		}
+
+		if uploads, _ := b.ListMultipartUploads(""); len(uploads) != 0 {
+			t.Errorf("expected no uploads after completion, got %+v", uploads)
+		}
+		if _, err := b.UploadPart("big.bin", upload.ID, 3, strings.NewReader("x"), nil); err != ErrNoSuchUpload {
+			t.Errorf("expected ErrNoSuchUpload after completion, got %v", err)
+		}
+	})
+}
+
+func TestBackend_Usage(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		usage, err := b.Usage()
+		if err != nil {
+			t.Fatalf("Usage failed: %v", err)
+		}
+		if usage.Objects != 0 || usage.Bytes != 0 {
+			t.Errorf("expected empty usage, got %+v", usage)
+		}
+
+		_, _ = b.PutObject("docs/a.txt", "text/plain", strings.NewReader("hello"))
+		_, _ = b.PutObject("b.txt", "text/plain", strings.NewReader("world!"))
+		_, _ = b.PutObject("empty/", "", strings.NewReader(""))
+		upload, _ := b.CreateMultipartUpload("big.bin", PutOptions{})
+		_, _ = b.UploadPart("big.bin", upload.ID, 1, strings.NewReader("part"), nil)
+
+		usage, err = b.Usage()
+		if err != nil {
+			t.Fatalf("Usage failed: %v", err)
+		}
+		if usage.Objects != 2 {
+			t.Errorf("expected 2 objects, got %d", usage.Objects)
+		}
+		// The filesystem backend also counts its metadata files
+		if usage.Bytes < 15 {
+			t.Errorf("expected at least 15 bytes, got %d", usage.Bytes)
+		}
+
+		if err := b.SetVersioning(VersioningEnabled); err != nil {
+			t.Fatalf("SetVersioning failed: %v", err)
+		}
+		_ = b.DeleteObject("b.txt")
+
+		after, _ := b.Usage()
+		if after.Objects != 1 {
+			t.Errorf("expected 1 object after delete, got %d", after.Objects)
+		}
+		if after.Bytes < usage.Bytes {
+			t.Errorf("expected the non-current version to still use space, got %d < %d", after.Bytes, usage.Bytes)
+		}
+	})
+}
+
+func TestBackend_MultipartUploadLimits(t *testing.T) {
+	defer func(size int64) { minPartSize = size }(minPartSize)
+	minPartSize = 4
+
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		upload, _ := b.CreateMultipartUpload("file.bin", PutOptions{})
+
+		p1, _ := b.UploadPart("file.bin", upload.ID, 1, strings.NewReader("ab"), nil)
+		p2, _ := b.UploadPart("file.bin", upload.ID, 2, strings.NewReader("cd"), nil)
+		if _, err := b.CompleteMultipartUpload("file.bin", upload.ID, []CompletedPart{{1, p1.ETag}, {2, p2.ETag}}); err != ErrEntityTooSmall {
+			t.Errorf("expected ErrEntityTooSmall, got %v", err)
+		}
+
+		if _, err := b.UploadPart("file.bin", upload.ID, 0, strings.NewReader("x"), nil); err != ErrInvalidPart {
+			t.Errorf("expected ErrInvalidPart for part 0, got %v", err)
+		}
+		if _, err := b.UploadPart("other.bin", upload.ID, 1, strings.NewReader("x"), nil); err != ErrNoSuchUpload {
+			t.Errorf("expected ErrNoSuchUpload for another key, got %v", err)
+		}
+		if _, err := b.ListParts("file.bin", "unknown"); err != ErrNoSuchUpload {
+			t.Errorf("expected ErrNoSuchUpload, got %v", err)
+		}
+
+		if err := b.AbortMultipartUpload("file.bin", upload.ID); err != nil {
+			t.Fatalf("AbortMultipartUpload failed: %v", err)
+		}
+		if err := b.AbortMultipartUpload("file.bin", upload.ID); err != ErrNoSuchUpload {
+			t.Errorf("expected ErrNoSuchUpload after abort, got %v", err)
+		}
+		if _, err := b.HeadObject("file.bin"); err != ErrNotFound {
+			t.Errorf("expected no object after abort, got %v", err)
+		}
+	})
+}
+
+func TestStorage_CloseRemovesTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStorage(dir, "test-bucket")
+	if err != nil {
+		t.Fatalf("NewStorage failed: %v", err)
+	}
+
+	// Simulate an upload still being written
+	tmpPath, _, err := store.writeTempFile(strings.NewReader("partial"), nil, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("writeTempFile failed: %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Errorf("expected partial upload to be removed, got %v", err)
+	}
+
+	// Storage stays usable
+	if _, err := store.PutObject("after.txt", "", strings.NewReader("x")); err != nil {
+		t.Errorf("PutObject after Close failed: %v", err)
+	}
+}
+
+func TestNewStorage_RemovesInterruptedUploads(t *testing.T) {
+	dir := t.TempDir()
+	tmpDir := filepath.Join(dir, metaDirName, "test-bucket", "tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "upload-123"), bytes.Repeat([]byte("x"), 10), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewStorage(dir, "test-bucket"); err != nil {
+		t.Fatalf("NewStorage failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpDir, "upload-123")); !os.IsNotExist(err) {
+		t.Errorf("expected interrupted upload to be removed, got %v", err)
+	}
+}
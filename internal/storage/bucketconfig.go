@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// bucketConfigNameRegex restricts configuration names to safe file names
+var bucketConfigNameRegex = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// GetBucketConfig returns a stored bucket configuration document (e.g. "versioning")
+func (s *Storage) GetBucketConfig(name string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.getBucketConfig(name)
+}
+
+// PutBucketConfig stores a bucket configuration document, replacing any previous one
+func (s *Storage) PutBucketConfig(name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !bucketConfigNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid bucket configuration name: %q", name)
+	}
+
+	return WriteFileAtomic(s.metaPath("config", name), data)
+}
+
+// DeleteBucketConfig removes a bucket configuration document.
+// Deleting a configuration that does not exist is not an error.
+func (s *Storage) DeleteBucketConfig(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !bucketConfigNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid bucket configuration name: %q", name)
+	}
+
+	if err := os.Remove(s.metaPath("config", name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete bucket configuration: %w", err)
+	}
+	return nil
+}
+
+// getBucketConfig reads a configuration document; the caller must hold the lock
+func (s *Storage) getBucketConfig(name string) ([]byte, error) {
+	if !bucketConfigNameRegex.MatchString(name) {
+		return nil, fmt.Errorf("invalid bucket configuration name: %q", name)
+	}
+
+	data, err := os.ReadFile(s.metaPath("config", name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoSuchConfig
+		}
+		return nil, fmt.Errorf("failed to read bucket configuration: %w", err)
+	}
+	return data, nil
+}
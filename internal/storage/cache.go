@@ -0,0 +1,435 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// cacheSaveInterval is how often the cache state is saved while objects are fetched
+const cacheSaveInterval = 10 * time.Second
+
+// ErrCacheVersioning is returned when versioning is enabled on a bucket in cache mode:
+// removing an object of a versioned bucket would only add a delete marker, or make an
+// older version current again
+var ErrCacheVersioning = errors.New("versioning cannot be used with an upstream bucket")
+
+// Upstream is the S3-compatible bucket a Cache reads through. Missing objects are
+// reported with ErrNotFound.
+type Upstream interface {
+	GetObject(ctx context.Context, key string) (*Object, io.ReadCloser, error)
+	PutObject(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	DeleteObject(ctx context.Context, key string) error
+}
+
+// CacheOptions configures a Cache
+type CacheOptions struct {
+	// MaxBytes is the size of the objects kept locally that can be fetched again,
+	// 0 for no limit. The least recently used ones are removed first.
+	MaxBytes int64
+	// WriteThrough makes writes and deletes upstream too. Objects written locally can
+	// then be removed like fetched ones; otherwise they are kept.
+	WriteThrough bool
+	// StatePath is the file recording the objects that can be removed and the keys
+	// deleted locally, "" to keep them in memory only
+	StatePath string
+}
+
+// CacheStats describes the objects a Cache can remove
+type CacheStats struct {
+	Objects int
+	Bytes   int64
+}
+
+// Cache is a Backend serving the current objects missing locally from an upstream
+// bucket: they are fetched on first read and stored in the local backend. Listings,
+// versions, tags and bucket settings are those of the local backend.
+type Cache struct {
+	Backend
+	upstream Upstream
+	opts     CacheOptions
+	fetches  singleflight.Group // fetches in progress by key
+
+	mu      sync.Mutex
+	lru     *list.List               // *cacheEntry, least recently used first
+	entries map[string]*list.Element // entries of lru by key
+	bytes   int64                    // size of the entries
+	deleted map[string]bool          // keys deleted locally without WriteThrough, not fetched again
+	dirty   bool                     // state changed since it was saved
+	saved   time.Time
+}
+
+// cacheEntry is an object of the local backend that can be removed and fetched again
+type cacheEntry struct {
+	Key  string    `json:"key"`
+	Size int64     `json:"size"`
+	Used time.Time `json:"used"`
+}
+
+// cacheState is the persisted state of a Cache
+type cacheState struct {
+	Entries []cacheEntry `json:"entries"`
+	Deleted []string     `json:"deleted,omitempty"`
+}
+
+// NewCache returns a Backend reading through local to upstream. The state saved by a
+// previous Cache at opts.StatePath is loaded, forgetting objects no longer stored.
+// Versioning must never have been enabled on local.
+func NewCache(local Backend, upstream Upstream, opts CacheOptions) (*Cache, error) {
+	status, err := local.GetVersioning()
+	if err != nil {
+		return nil, err
+	}
+	if status != "" {
+		return nil, ErrCacheVersioning
+	}
+
+	c := &Cache{
+		Backend:  local,
+		upstream: upstream,
+		opts:     opts,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		deleted:  make(map[string]bool),
+		saved:    time.Now(),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict("")
+	return c, nil
+}
+
+// Local returns the backend storing the objects
+func (c *Cache) Local() Backend {
+	return c.Backend
+}
+
+// Stats reports the objects that can be removed
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Objects: c.lru.Len(), Bytes: c.bytes}
+}
+
+// GetObject reads the current object at key, fetching it from upstream when missing
+func (c *Cache) GetObject(key string) (*Object, io.ReadCloser, error) {
+	return c.GetObjectWithOptions(key, GetOptions{})
+}
+
+// GetObjectVersion reads a version of an object, fetching the current one from upstream
+// when missing
+func (c *Cache) GetObjectVersion(key, versionID string) (*Object, io.ReadCloser, error) {
+	return c.GetObjectWithOptions(key, GetOptions{VersionID: versionID})
+}
+
+// GetObjectWithOptions reads an object, fetching the current one from upstream when missing
+func (c *Cache) GetObjectWithOptions(key string, opts GetOptions) (*Object, io.ReadCloser, error) {
+	obj, reader, err := c.Backend.GetObjectWithOptions(key, opts)
+	if errors.Is(err, ErrNotFound) && opts.VersionID == "" && opts.CustomerKey == nil {
+		if err := c.fetch(key); err != nil {
+			return nil, nil, err
+		}
+		obj, reader, err = c.Backend.GetObjectWithOptions(key, opts)
+	}
+	if err == nil {
+		c.touch(key)
+	}
+	return obj, reader, err
+}
+
+// HeadObject describes the current object at key, fetching it from upstream when missing
+func (c *Cache) HeadObject(key string) (*Object, error) {
+	return c.HeadObjectVersion(key, "")
+}
+
+// HeadObjectVersion describes a version of an object, fetching the current one from
+// upstream when missing so that it is described like the object a GET then returns
+func (c *Cache) HeadObjectVersion(key, versionID string) (*Object, error) {
+	obj, err := c.Backend.HeadObjectVersion(key, versionID)
+	if errors.Is(err, ErrNotFound) && versionID == "" {
+		if err := c.fetch(key); err != nil {
+			return nil, err
+		}
+		obj, err = c.Backend.HeadObjectVersion(key, versionID)
+	}
+	return obj, err
+}
+
+// PutObject stores an object, and writes it upstream with WriteThrough
+func (c *Cache) PutObject(key string, contentType string, body io.Reader) (*Object, error) {
+	return c.PutObjectWithOptions(key, body, PutOptions{ContentType: contentType})
+}
+
+// PutObjectWithOptions stores an object, and writes it upstream with WriteThrough
+func (c *Cache) PutObjectWithOptions(key string, body io.Reader, opts PutOptions) (*Object, error) {
+	c.written(key)
+	obj, err := c.Backend.PutObjectWithOptions(key, body, opts)
+	if err != nil {
+		return nil, err
+	}
+	return obj, c.writeUpstream(key, obj, opts.CustomerKey)
+}
+
+// CopyObject copies an object, reading the source through the cache
+func (c *Cache) CopyObject(srcKey string, src GetOptions, dstKey string, opts *PutOptions) (*Object, error) {
+	return copyObject(c, srcKey, src, dstKey, opts)
+}
+
+// CompleteMultipartUpload assembles an upload, and writes the object upstream with WriteThrough
+func (c *Cache) CompleteMultipartUpload(key, uploadID string, parts []CompletedPart) (*Object, error) {
+	c.written(key)
+	obj, err := c.Backend.CompleteMultipartUpload(key, uploadID, parts)
+	if err != nil {
+		return nil, err
+	}
+	return obj, c.writeUpstream(key, obj, nil)
+}
+
+// DeleteObject removes the current object at key
+func (c *Cache) DeleteObject(key string) error {
+	_, err := c.DeleteObjectVersion(key, "")
+	return err
+}
+
+// DeleteObjectVersion removes an object or one of its local versions. Deletes of the
+// current object are made upstream with WriteThrough; otherwise the key is no longer
+// fetched from upstream until it is written again.
+func (c *Cache) DeleteObjectVersion(key, versionID string) (*DeleteResult, error) {
+	if versionID == "" {
+		if c.opts.WriteThrough {
+			if err := c.upstream.DeleteObject(context.Background(), key); err != nil {
+				return nil, fmt.Errorf("failed to delete %s upstream: %w", key, err)
+			}
+		}
+		c.mu.Lock()
+		c.forget(key)
+		if !c.opts.WriteThrough {
+			c.deleted[key] = true
+		}
+		c.changed()
+		c.mu.Unlock()
+	}
+	return c.Backend.DeleteObjectVersion(key, versionID)
+}
+
+// SetVersioning refuses to enable versioning, since objects could no longer be removed
+func (c *Cache) SetVersioning(string) error {
+	return ErrCacheVersioning
+}
+
+// Close saves the cache state and closes the local backend
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	err := c.save()
+	c.mu.Unlock()
+	if closeErr := c.Backend.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// fetch copies the object at key from upstream into the local backend. Concurrent
+// fetches of a key share a single download.
+func (c *Cache) fetch(key string) error {
+	_, err, _ := c.fetches.Do(key, func() (any, error) {
+		return nil, c.download(key)
+	})
+	return err
+}
+
+// download copies the object at key from upstream into the local backend
+func (c *Cache) download(key string) error {
+	c.mu.Lock()
+	deleted := c.deleted[key]
+	c.mu.Unlock()
+	if deleted {
+		return ErrNotFound
+	}
+
+	obj, body, err := c.upstream.GetObject(context.Background(), key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to fetch %s from upstream: %w", key, err)
+	}
+	defer func() { _ = body.Close() }()
+
+	stored, err := c.Backend.PutObjectWithOptions(key, body, PutOptions{ContentType: obj.ContentType})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(key, stored.Size)
+	c.evict(key)
+	c.changed()
+	return nil
+}
+
+// writeUpstream records an object written locally, and copies it upstream with WriteThrough
+func (c *Cache) writeUpstream(key string, obj *Object, customerKey []byte) error {
+	if !c.opts.WriteThrough {
+		return nil
+	}
+
+	_, reader, err := c.Backend.GetObjectWithOptions(key, GetOptions{VersionID: obj.VersionID, CustomerKey: customerKey})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = reader.Close() }()
+	if err := c.upstream.PutObject(context.Background(), key, reader, obj.Size, obj.ContentType); err != nil {
+		return fmt.Errorf("failed to write %s upstream: %w", key, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(key, obj.Size)
+	c.evict(key)
+	c.changed()
+	return nil
+}
+
+// written is called before key is written locally. Without WriteThrough, the object is
+// only stored locally and must be kept; it is taken out of the entries before the write
+// so that it cannot be removed once written.
+func (c *Cache) written(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.opts.WriteThrough {
+		c.forget(key)
+	}
+	delete(c.deleted, key)
+	c.changed()
+}
+
+// touch marks the object at key as used
+func (c *Cache) touch(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheEntry).Used = time.Now()
+		c.lru.MoveToBack(elem)
+		c.changed()
+	}
+}
+
+// add records the object at key as one that can be removed; the caller must hold c.mu
+func (c *Cache) add(key string, size int64) {
+	c.forget(key)
+	c.entries[key] = c.lru.PushBack(&cacheEntry{Key: key, Size: size, Used: time.Now()})
+	c.bytes += size
+}
+
+// forget removes key from the entries; the caller must hold c.mu
+func (c *Cache) forget(key string) {
+	if elem, ok := c.entries[key]; ok {
+		c.bytes -= elem.Value.(*cacheEntry).Size
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// evict removes the least recently used objects until they fit in MaxBytes, keeping the
+// object at keep, which is being read. The caller must hold c.mu, which keeps writes of
+// the objects removed from starting meanwhile.
+func (c *Cache) evict(keep string) {
+	if c.opts.MaxBytes <= 0 {
+		return
+	}
+	for elem := c.lru.Front(); elem != nil && c.bytes > c.opts.MaxBytes; {
+		next := elem.Next()
+		entry := elem.Value.(*cacheEntry)
+		if entry.Key != keep {
+			if _, err := c.Backend.DeleteObjectVersion(entry.Key, ""); err == nil {
+				c.forget(entry.Key)
+				c.dirty = true
+			}
+		}
+		elem = next
+	}
+}
+
+// changed records a change of the state and saves it from time to time; the caller
+// must hold c.mu
+func (c *Cache) changed() {
+	c.dirty = true
+	if time.Since(c.saved) >= cacheSaveInterval {
+		_ = c.save()
+	}
+}
+
+// save writes the state to StatePath when it changed; the caller must hold c.mu
+func (c *Cache) save() error {
+	if c.opts.StatePath == "" || !c.dirty {
+		return nil
+	}
+
+	state := cacheState{Entries: make([]cacheEntry, 0, c.lru.Len())}
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		state.Entries = append(state.Entries, *elem.Value.(*cacheEntry))
+	}
+	for key := range c.deleted {
+		state.Deleted = append(state.Deleted, key)
+	}
+	sort.Strings(state.Deleted)
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(c.opts.StatePath, data); err != nil {
+		return fmt.Errorf("failed to save the cache state: %w", err)
+	}
+	c.dirty = false
+	c.saved = time.Now()
+	return nil
+}
+
+// load reads the state saved at StatePath, if any
+func (c *Cache) load() error {
+	if c.opts.StatePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.opts.StatePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the cache state: %w", err)
+	}
+	var state cacheState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to read the cache state: %w", err)
+	}
+
+	sort.SliceStable(state.Entries, func(i, j int) bool { return state.Entries[i].Used.Before(state.Entries[j].Used) })
+	for _, entry := range state.Entries {
+		obj, err := c.Backend.HeadObject(entry.Key)
+		if err != nil {
+			continue // removed since
+		}
+		entry.Size = obj.Size
+		c.entries[entry.Key] = c.lru.PushBack(&entry)
+		c.bytes += entry.Size
+	}
+	for _, key := range state.Deleted {
+		c.deleted[key] = true
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memoryUpstream is an Upstream keeping its objects in memory
+type memoryUpstream struct {
+	objects *Memory
+	types   map[string]string // content types written, which Memory does not keep
+	gets    int
+}
+
+func newMemoryUpstream(t *testing.T, objects map[string]string) *memoryUpstream {
+	t.Helper()
+
+	up := &memoryUpstream{objects: NewMemory(), types: make(map[string]string)}
+	for key, data := range objects {
+		if err := up.PutObject(context.Background(), key, strings.NewReader(data), int64(len(data)), "text/markdown"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return up
+}
+
+func (u *memoryUpstream) GetObject(_ context.Context, key string) (*Object, io.ReadCloser, error) {
+	u.gets++
+	obj, reader, err := u.objects.GetObject(key)
+	if err == nil && u.types[key] != "" {
+		obj.ContentType = u.types[key]
+	}
+	return obj, reader, err
+}
+
+func (u *memoryUpstream) PutObject(_ context.Context, key string, body io.Reader, _ int64, contentType string) error {
+	u.types[key] = contentType
+	_, err := u.objects.PutObject(key, contentType, body)
+	return err
+}
+
+func (u *memoryUpstream) DeleteObject(_ context.Context, key string) error {
+	return u.objects.DeleteObject(key)
+}
+
+// newTestCache returns a Cache storing the objects of up on disk
+func newTestCache(t *testing.T, up Upstream, opts CacheOptions) (*Cache, *Storage) {
+	t.Helper()
+
+	local, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewCache(local, up, opts)
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	return cache, local
+}
+
+func TestCache_ReadThrough(t *testing.T) {
+	up := newMemoryUpstream(t, map[string]string{"a.txt": "from upstream"})
+	cache, local := newTestCache(t, up, CacheOptions{})
+
+	obj, err := cache.HeadObject("a.txt")
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if obj.Size != int64(len("from upstream")) {
+		t.Errorf("fetched object = %+v", obj)
+	}
+	if got := readAll(t, cache, "a.txt", ""); got != "from upstream" {
+		t.Errorf("read %q", got)
+	}
+	if got := readAll(t, local, "a.txt", ""); got != "from upstream" {
+		t.Errorf("stored locally: %q", got)
+	}
+	if up.gets != 1 {
+		t.Errorf("expected a single fetch, got %d", up.gets)
+	}
+	if stats := cache.Stats(); stats.Objects != 1 || stats.Bytes != obj.Size {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	if _, _, err := cache.GetObject("missing.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a key missing upstream, got %v", err)
+	}
+	if got := listKeys(t, cache, ""); !reflect.DeepEqual(got, []string{"a.txt"}) {
+		t.Errorf("listing = %v", got)
+	}
+}
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	up := newMemoryUpstream(t, map[string]string{
+		"a.txt": strings.Repeat("a", 40),
+		"b.txt": strings.Repeat("b", 40),
+		"c.txt": strings.Repeat("c", 40),
+	})
+	cache, local := newTestCache(t, up, CacheOptions{MaxBytes: 100})
+
+	readAll(t, cache, "a.txt", "")
+	readAll(t, cache, "b.txt", "")
+	readAll(t, cache, "a.txt", "") // b.txt is now the least recently used
+	readAll(t, cache, "c.txt", "")
+
+	if got := listKeys(t, local, ""); !reflect.DeepEqual(got, []string{"a.txt", "c.txt"}) {
+		t.Errorf("kept locally: %v", got)
+	}
+	if stats := cache.Stats(); stats.Objects != 2 || stats.Bytes != 80 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// Evicted objects are fetched again
+	if got := readAll(t, cache, "b.txt", ""); got != strings.Repeat("b", 40) {
+		t.Errorf("read %q", got)
+	}
+	if up.gets != 4 {
+		t.Errorf("expected 4 fetches, got %d", up.gets)
+	}
+
+	// An object larger than the cache is served, then removed by the next fetch
+	if err := up.PutObject(context.Background(), "big.txt", strings.NewReader(strings.Repeat("x", 150)), 150, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, cache, "big.txt", ""); len(got) != 150 {
+		t.Errorf("read %d bytes", len(got))
+	}
+	readAll(t, cache, "a.txt", "")
+	if got := listKeys(t, local, ""); !reflect.DeepEqual(got, []string{"a.txt"}) {
+		t.Errorf("kept locally: %v", got)
+	}
+}
+
+// gatedUpstream holds the reads of an upstream until release is closed
+type gatedUpstream struct {
+	*memoryUpstream
+	release chan struct{}
+	gets    atomic.Int32
+}
+
+func (u *gatedUpstream) GetObject(ctx context.Context, key string) (*Object, io.ReadCloser, error) {
+	u.gets.Add(1)
+	<-u.release
+	return u.objects.GetObject(key)
+}
+
+func TestCache_ConcurrentMissesFetchOnce(t *testing.T) {
+	up := &gatedUpstream{memoryUpstream: newMemoryUpstream(t, map[string]string{"a.txt": "hello"}), release: make(chan struct{})}
+	cache, _ := newTestCache(t, up, CacheOptions{})
+
+	var wg sync.WaitGroup
+	reads := make([]string, 8)
+	for i := range reads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reads[i] = readAll(t, cache, "a.txt", "")
+		}()
+	}
+	time.Sleep(50 * time.Millisecond) // let every reader miss
+	close(up.release)
+	wg.Wait()
+
+	for _, got := range reads {
+		if got != "hello" {
+			t.Errorf("read %q", got)
+		}
+	}
+	if n := up.gets.Load(); n != 1 {
+		t.Errorf("expected a single download, got %d", n)
+	}
+}
+
+func TestCache_RefusesVersioning(t *testing.T) {
+	up := newMemoryUpstream(t, nil)
+	cache, _ := newTestCache(t, up, CacheOptions{})
+	if err := cache.SetVersioning(VersioningEnabled); !errors.Is(err, ErrCacheVersioning) {
+		t.Errorf("SetVersioning = %v, expected ErrCacheVersioning", err)
+	}
+
+	// Evicting from a versioned bucket would only add delete markers
+	local, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := local.SetVersioning(VersioningSuspended); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCache(local, up, CacheOptions{MaxBytes: 100}); !errors.Is(err, ErrCacheVersioning) {
+		t.Errorf("NewCache on a versioned bucket = %v, expected ErrCacheVersioning", err)
+	}
+}
+
+func TestCache_LocalWritesAreKept(t *testing.T) {
+	up := newMemoryUpstream(t, map[string]string{"a.txt": strings.Repeat("a", 60), "b.txt": strings.Repeat("b", 60)})
+	cache, local := newTestCache(t, up, CacheOptions{MaxBytes: 50})
+
+	readAll(t, cache, "a.txt", "")
+	if _, err := cache.PutObject("a.txt", "", strings.NewReader("changed locally")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.PutObject("local.txt", "", strings.NewReader(strings.Repeat("l", 80))); err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, cache, "b.txt", "")
+	readAll(t, cache, "b.txt", "")
+
+	if got := listKeys(t, local, ""); !reflect.DeepEqual(got, []string{"a.txt", "b.txt", "local.txt"}) {
+		t.Errorf("kept locally: %v", got)
+	}
+	if got := readAll(t, cache, "a.txt", ""); got != "changed locally" {
+		t.Errorf("read %q", got)
+	}
+	if got := readAll(t, up.objects, "a.txt", ""); got != strings.Repeat("a", 60) {
+		t.Errorf("upstream changed without write-through: %q", got)
+	}
+	if _, err := up.objects.HeadObject("local.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("local write made upstream: %v", err)
+	}
+
+	// Deleted objects are not fetched again until written
+	if err := cache.DeleteObject("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.HeadObject("b.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the deleted object to stay deleted, got %v", err)
+	}
+	if _, err := cache.PutObject("b.txt", "", strings.NewReader("new")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, cache, "b.txt", ""); got != "new" {
+		t.Errorf("read %q", got)
+	}
+}
+
+func TestCache_WriteThrough(t *testing.T) {
+	up := newMemoryUpstream(t, map[string]string{"old.txt": "old"})
+	cache, local := newTestCache(t, up, CacheOptions{MaxBytes: 10, WriteThrough: true})
+
+	if _, err := cache.PutObject("new.txt", "text/csv", strings.NewReader("a,b,c")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if got := readAll(t, up.objects, "new.txt", ""); got != "a,b,c" || up.types["new.txt"] != "text/csv" {
+		t.Errorf("written upstream: %q as %q", got, up.types["new.txt"])
+	}
+
+	upload, err := cache.CreateMultipartUpload("multi.txt", PutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := cache.UploadPart("multi.txt", upload.ID, 1, strings.NewReader("assembled"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.CompleteMultipartUpload("multi.txt", upload.ID, []CompletedPart{{PartNumber: 1, ETag: part.ETag}}); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+	if got := readAll(t, up.objects, "multi.txt", ""); got != "assembled" {
+		t.Errorf("assembled upstream: %q", got)
+	}
+
+	// Written objects can be evicted since they are upstream
+	if got := listKeys(t, local, ""); !reflect.DeepEqual(got, []string{"multi.txt"}) {
+		t.Errorf("kept locally: %v", got)
+	}
+	if got := readAll(t, cache, "new.txt", ""); got != "a,b,c" {
+		t.Errorf("read %q", got)
+	}
+
+	if err := cache.DeleteObject("old.txt"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if _, err := up.objects.HeadObject("old.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the delete to be made upstream, got %v", err)
+	}
+}
+
+func TestCache_StateSurvivesRestart(t *testing.T) {
+	up := newMemoryUpstream(t, map[string]string{"a.txt": strings.Repeat("a", 40), "b.txt": strings.Repeat("b", 40)})
+	statePath := filepath.Join(t.TempDir(), "cache.json")
+	local, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewCache(local, up, CacheOptions{StatePath: statePath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, cache, "a.txt", "")
+	readAll(t, cache, "b.txt", "")
+	if err := cache.DeleteObject("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Reopened with a smaller size, the fetched objects are evicted but the delete is kept
+	cache, err = NewCache(local, up, CacheOptions{StatePath: statePath, MaxBytes: 10})
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	if got := listKeys(t, local, ""); len(got) != 0 {
+		t.Errorf("kept locally: %v", got)
+	}
+	if _, err := cache.HeadObject("a.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the deleted object to stay deleted, got %v", err)
+	}
+}
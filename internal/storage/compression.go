@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Compression algorithms
+const (
+	CompressionGzip = "gzip"
+)
+
+// Compressed data is a sequence of frames, each compressed independently, so that
+// ranges only decompress the frames they cover. With gzip, every frame is a gzip
+// member and the file is a valid multi-member gzip stream.
+const compFrameSize = 1024 * 1024
+
+// CompressionPolicy selects the objects compressed on disk
+type CompressionPolicy struct {
+	Algorithm    string   // CompressionGzip
+	ContentTypes []string // e.g. "application/json" or "text/*"; empty compresses every type
+	Prefixes     []string // key prefixes; empty applies to every key
+}
+
+// matches reports whether an object is compressed. The content type sent by the client
+// and the one guessed from the key are both considered, since clients often send none.
+func (p *CompressionPolicy) matches(key, contentType string) bool {
+	if len(p.Prefixes) > 0 && !hasAnyPrefix(key, p.Prefixes) {
+		return false
+	}
+	if len(p.ContentTypes) == 0 {
+		return true
+	}
+	for _, candidate := range []string{contentType, guessContentType(key)} {
+		for _, pattern := range p.ContentTypes {
+			if matchContentType(pattern, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasAnyPrefix reports whether key starts with one of prefixes
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchContentType matches a content type, ignoring its parameters, against a
+// pattern that is either a full type or "type/*"
+func matchContentType(pattern, contentType string) bool {
+	contentType, _, _ = strings.Cut(contentType, ";")
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	pattern = strings.ToLower(pattern)
+	if major, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(contentType, major+"/")
+	}
+	return contentType == pattern
+}
+
+// compressionInfo is persisted in the metadata of a compressed object or part
+type compressionInfo struct {
+	Algorithm string  `json:"algorithm"`
+	Size      int64   `json:"size,omitempty"` // uncompressed size
+	Frames    []frame `json:"frames,omitempty"`
+}
+
+// frame is an independently compressed run of data
+type frame struct {
+	Size   int64 `json:"size"`   // uncompressed
+	Stored int64 `json:"stored"` // compressed
+}
+
+// newCompression returns the compression of a new object, nil when it is stored as is
+func newCompression(policy *CompressionPolicy, key, contentType string) *compressionInfo {
+	if policy == nil || !policy.matches(key, contentType) {
+		return nil
+	}
+	return &compressionInfo{Algorithm: policy.Algorithm}
+}
+
+// storedSize returns the size of the compressed data
+func (c *compressionInfo) storedSize() int64 {
+	var size int64
+	for _, f := range c.Frames {
+		size += f.Stored
+	}
+	return size
+}
+
+// append adds the frames of a part to the object
+func (c *compressionInfo) append(part *compressionInfo) {
+	c.Frames = append(c.Frames, part.Frames...)
+	c.Size += part.Size
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// compressingWriter compresses data written to it into frames, recorded in info.
+// Close compresses the last frame; it does not close the underlying writer.
+type compressingWriter struct {
+	w    io.Writer
+	info *compressionInfo
+	buf  []byte
+	gz   *gzip.Writer
+}
+
+// newCompressingWriter returns a writer compressing into w
+func newCompressingWriter(w io.Writer, info *compressionInfo) (*compressingWriter, error) {
+	if info.Algorithm != CompressionGzip {
+		return nil, fmt.Errorf("unsupported compression algorithm %q", info.Algorithm)
+	}
+	return &compressingWriter{w: w, info: info, buf: make([]byte, 0, compFrameSize), gz: gzip.NewWriter(io.Discard)}, nil
+}
+
+func (c *compressingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), compFrameSize-len(c.buf))
+		c.buf = append(c.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(c.buf) == compFrameSize {
+			if err := c.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close compresses the buffered data
+func (c *compressingWriter) Close() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	return c.flush()
+}
+
+// flush compresses the buffered data as a frame
+func (c *compressingWriter) flush() error {
+	out := &countingWriter{w: c.w}
+	c.gz.Reset(out)
+	if _, err := c.gz.Write(c.buf); err != nil {
+		return err
+	}
+	if err := c.gz.Close(); err != nil {
+		return err
+	}
+	c.info.Frames = append(c.info.Frames, frame{Size: int64(len(c.buf)), Stored: out.n})
+	c.info.Size += int64(len(c.buf))
+	c.buf = c.buf[:0]
+	return nil
+}
+
+// decompressingReader reads the original data of compressed data, frame by frame.
+// It implements io.Seeker so that ranges only decompress the frames they cover.
+type decompressingReader struct {
+	src    io.ReadSeeker
+	closer io.Closer
+	info   *compressionInfo
+	starts []int64 // uncompressed offset of each frame
+	stored []int64 // compressed offset of each frame
+	pos    int64
+	gz     *gzip.Reader
+
+	// The last decompressed frame
+	frame      []byte
+	frameIndex int // -1 when empty
+}
+
+// newDecompressingReader returns a reader decompressing src; closing it closes closer
+func newDecompressingReader(src io.ReadSeeker, closer io.Closer, info *compressionInfo) (*decompressingReader, error) {
+	if info.Algorithm != CompressionGzip {
+		return nil, fmt.Errorf("unsupported compression algorithm %q", info.Algorithm)
+	}
+	d := &decompressingReader{src: src, closer: closer, info: info, frameIndex: -1}
+	var start, stored int64
+	for _, f := range info.Frames {
+		d.starts = append(d.starts, start)
+		d.stored = append(d.stored, stored)
+		start += f.Size
+		stored += f.Stored
+	}
+	return d, nil
+}
+
+func (d *decompressingReader) Read(p []byte) (int, error) {
+	if d.pos >= d.info.Size {
+		return 0, io.EOF
+	}
+	index := sort.Search(len(d.starts), func(i int) bool { return d.starts[i] > d.pos }) - 1
+	if index != d.frameIndex {
+		if err := d.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.frame[d.pos-d.starts[index]:])
+	d.pos += int64(n)
+	return n, nil
+}
+
+// load decompresses a frame
+func (d *decompressingReader) load(index int) error {
+	f := d.info.Frames[index]
+	if _, err := d.src.Seek(d.stored[index], io.SeekStart); err != nil {
+		return err
+	}
+	compressed := io.LimitReader(d.src, f.Stored)
+
+	var err error
+	if d.gz == nil {
+		d.gz, err = gzip.NewReader(compressed)
+	} else {
+		err = d.gz.Reset(compressed)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decompress object data: %w", err)
+	}
+	d.gz.Multistream(false)
+
+	if int64(cap(d.frame)) < f.Size {
+		d.frame = make([]byte, f.Size)
+	}
+	d.frame = d.frame[:f.Size]
+	if _, err := io.ReadFull(d.gz, d.frame); err != nil {
+		d.frameIndex = -1
+		return fmt.Errorf("failed to decompress object data: %w", err)
+	}
+	// The frame must end where its recorded size says
+	if n, _ := d.gz.Read(make([]byte, 1)); n != 0 {
+		d.frameIndex = -1
+		return errors.New("failed to decompress object data: frame is larger than recorded")
+	}
+	d.frameIndex = index
+	return nil
+}
+
+func (d *decompressingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.pos
+	case io.SeekEnd:
+		offset += d.info.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.pos = offset
+	return offset, nil
+}
+
+func (d *decompressingReader) Close() error {
+	if d.closer == nil {
+		return nil
+	}
+	return d.closer.Close()
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// jsonLines returns compressible data of at least n bytes
+func jsonLines(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < n; i++ {
+		fmt.Fprintf(&buf, "{\"id\":%d,\"event\":\"page_view\",\"path\":\"/articles/%d\"}\n", i, i%97)
+	}
+	return buf.Bytes()
+}
+
+// newCompressedStorage creates a storage instance compressing JSON and text objects
+func newCompressedStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	store.SetCompression(&CompressionPolicy{
+		Algorithm:    CompressionGzip,
+		ContentTypes: []string{"application/json", "text/*"},
+	})
+	return store
+}
+
+func TestCompressionPolicy_Matches(t *testing.T) {
+	policy := &CompressionPolicy{
+		Algorithm:    CompressionGzip,
+		ContentTypes: []string{"application/json", "text/*"},
+		Prefixes:     []string{"exports/", "logs/"},
+	}
+	tests := []struct {
+		key         string
+		contentType string
+		want        bool
+	}{
+		{"exports/users.json", "", true},
+		{"logs/app.log", "text/plain; charset=utf-8", true},
+		{"logs/app", "", false},         // no content type to match
+		{"logs/data", "TEXT/CSV", true}, // matched case-insensitively
+		{"exports/photo.png", "", false},
+		{"exports/photo.png", "image/png", false},
+		{"backups/users.json", "", false}, // outside the prefixes
+	}
+	for _, tt := range tests {
+		if got := policy.matches(tt.key, tt.contentType); got != tt.want {
+			t.Errorf("matches(%q, %q) = %v, want %v", tt.key, tt.contentType, got, tt.want)
+		}
+	}
+
+	all := &CompressionPolicy{Algorithm: CompressionGzip}
+	if !all.matches("any/key.bin", "") {
+		t.Error("a policy without filters should match every object")
+	}
+}
+
+func TestCompression_PutGet(t *testing.T) {
+	store := newCompressedStorage(t)
+	data := jsonLines(2*compFrameSize + 1000)
+
+	obj, err := store.PutObject("export.json", "", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if obj.Size != int64(len(data)) {
+		t.Errorf("expected the original size, got %d", obj.Size)
+	}
+
+	// The file on disk is a smaller, valid gzip stream of the data
+	onDisk, err := os.ReadFile(store.keyToPath("export.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onDisk) >= len(data)/2 {
+		t.Errorf("data is not compressed on disk (%d of %d bytes)", len(onDisk), len(data))
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(onDisk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unzipped, _ := io.ReadAll(gz); !bytes.Equal(unzipped, data) {
+		t.Error("file on disk is not a gzip stream of the data")
+	}
+
+	_, reader, err := store.GetObject("export.json")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+	got, err := io.ReadAll(reader)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("decompressed data does not match (%v)", err)
+	}
+
+	// Ranges within a frame and across frames
+	for _, offset := range []int64{10, compFrameSize - 5, int64(len(data)) - 3} {
+		if _, err := reader.(io.Seeker).Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		chunk := make([]byte, 20)
+		n, _ := io.ReadFull(reader, chunk)
+		if !bytes.Equal(chunk[:n], data[offset:min(offset+20, int64(len(data)))]) {
+			t.Errorf("range at %d does not match", offset)
+		}
+	}
+
+	// Clients see the same size and ETag everywhere
+	head, _ := store.HeadObject("export.json")
+	objects, _ := store.ListObjects("")
+	if head.Size != obj.Size || head.ETag != obj.ETag || len(objects) != 1 || objects[0].Size != obj.Size || objects[0].ETag != obj.ETag {
+		t.Errorf("inconsistent metadata: put %+v, head %+v, list %+v", obj, head, objects)
+	}
+}
+
+func TestCompression_PolicyNotMatching(t *testing.T) {
+	store := newCompressedStorage(t)
+	data := randomData(t, 1000)
+
+	if _, err := store.PutObject("photo.png", "", bytes.NewReader(data)); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	onDisk, _ := os.ReadFile(store.keyToPath("photo.png"))
+	if !bytes.Equal(onDisk, data) {
+		t.Error("objects outside the policy should be stored as is")
+	}
+
+	// Disabling compression keeps compressed objects readable
+	if _, err := store.PutObject("notes.txt", "", strings.NewReader("some notes")); err != nil {
+		t.Fatal(err)
+	}
+	store.SetCompression(nil)
+	if got := readAll(t, store, "notes.txt", ""); got != "some notes" {
+		t.Errorf("GetObject = %q", got)
+	}
+}
+
+func TestCompression_Encrypted(t *testing.T) {
+	store := newCompressedStorage(t)
+	store.SetMasterKey(testKey(t))
+	data := jsonLines(compFrameSize + 100)
+
+	obj, err := store.PutObject("export.json", "", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if obj.Encryption != SSES3 || obj.Size != int64(len(data)) {
+		t.Errorf("unexpected object: encryption %q, size %d", obj.Encryption, obj.Size)
+	}
+
+	// Data is compressed before being encrypted
+	onDisk, _ := os.ReadFile(store.keyToPath("export.json"))
+	if len(onDisk) >= len(data)/2 || bytes.Contains(onDisk, []byte("page_view")) {
+		t.Errorf("data is not compressed and encrypted on disk (%d bytes)", len(onDisk))
+	}
+
+	if got := readAll(t, store, "export.json", ""); got != string(data) {
+		t.Error("data does not match")
+	}
+}
+
+func TestCompression_Multipart(t *testing.T) {
+	defer func(size int64) { minPartSize = size }(minPartSize)
+	minPartSize = 4
+
+	for _, encrypted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypted=%v", encrypted), func(t *testing.T) {
+			store := newCompressedStorage(t)
+			if encrypted {
+				store.SetMasterKey(testKey(t))
+			}
+
+			upload, err := store.CreateMultipartUpload("logs.json", PutOptions{})
+			if err != nil {
+				t.Fatalf("CreateMultipartUpload failed: %v", err)
+			}
+			part1 := jsonLines(compFrameSize + 10)
+			part2 := []byte(`{"id":"last"}`)
+			p1, err := store.UploadPart("logs.json", upload.ID, 1, bytes.NewReader(part1), nil)
+			if err != nil {
+				t.Fatalf("UploadPart failed: %v", err)
+			}
+			p2, _ := store.UploadPart("logs.json", upload.ID, 2, bytes.NewReader(part2), nil)
+			if p1.Size != int64(len(part1)) {
+				t.Errorf("expected the original part size, got %d", p1.Size)
+			}
+
+			obj, err := store.CompleteMultipartUpload("logs.json", upload.ID, []CompletedPart{
+				{PartNumber: 1, ETag: p1.ETag},
+				{PartNumber: 2, ETag: p2.ETag},
+			})
+			if err != nil {
+				t.Fatalf("CompleteMultipartUpload failed: %v", err)
+			}
+			want := append(part1, part2...)
+			if obj.Size != int64(len(want)) {
+				t.Errorf("expected size %d, got %d", len(want), obj.Size)
+			}
+
+			_, reader, err := store.GetObject("logs.json")
+			if err != nil {
+				t.Fatalf("GetObject failed: %v", err)
+			}
+			defer func() { _ = reader.Close() }()
+			if got, err := io.ReadAll(reader); err != nil || !bytes.Equal(got, want) {
+				t.Errorf("assembled data does not match (%v)", err)
+			}
+
+			// A range spanning both parts
+			_, _ = reader.(io.Seeker).Seek(int64(len(part1))-3, io.SeekStart)
+			if tail, _ := io.ReadAll(reader); !bytes.Equal(tail, want[len(part1)-3:]) {
+				t.Error("range across parts does not match")
+			}
+		})
+	}
+}
+
+func TestCompression_Versions(t *testing.T) {
+	store := newVersionedStorage(t)
+	store.SetCompression(&CompressionPolicy{Algorithm: CompressionGzip})
+
+	v1, err := store.PutObject("doc.txt", "", strings.NewReader("first version"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("doc.txt", "", strings.NewReader("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readVersion(t, store, "doc.txt", v1.VersionID); got != "first version" {
+		t.Errorf("non-current version = %q", got)
+	}
+	versions, _ := store.ListObjectVersions("")
+	if len(versions) != 2 || versions[0].Size != 6 || versions[1].Size != 13 || versions[1].ETag != v1.ETag {
+		t.Errorf("unexpected versions: %+v", versions)
+	}
+
+	// Deleting the current version restores the compressed one
+	if _, err := store.DeleteObjectVersion("doc.txt", versions[0].VersionID); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, store, "doc.txt", ""); got != "first version" {
+		t.Errorf("restored version = %q", got)
+	}
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Deduplicated objects share their data with a blob named by the SHA-256 of the data,
+// through hard links. The link count of a blob is therefore its reference count plus one:
+// objects and versions keep referencing the data however they are renamed or archived,
+// and deleting them releases their reference. A blob left with a single link is no longer
+// used and is removed by CollectBlobs.
+//
+// Files are never modified in place, so objects sharing a blob cannot affect each other.
+
+// fileID identifies the data of a file, shared by all its hard links
+type fileID struct {
+	dev, ino uint64
+}
+
+// DedupStats summarizes the blobs of a deduplicated bucket
+type DedupStats struct {
+	Blobs             int64 // blobs referenced by objects or versions
+	References        int64 // objects and versions stored in blobs
+	Bytes             int64 // disk space used by the referenced blobs
+	SavedBytes        int64 // disk space the references would use on top of Bytes without deduplication
+	Unreferenced      int64 // blobs no longer referenced, removed by CollectBlobs
+	UnreferencedBytes int64
+}
+
+// SetDeduplication enables storing identical new objects once. Encrypted objects are never
+// deduplicated since each has its own data key. It must be called before the storage is used.
+func (s *Storage) SetDeduplication(enabled bool) error {
+	if enabled && !linksSupported {
+		return errors.New("deduplication requires hard links, which are not supported on this platform")
+	}
+	s.dedup = enabled
+	return nil
+}
+
+// deduplicates reports whether a new object with the given encryption is deduplicated
+func (s *Storage) deduplicates(enc *encryptionInfo) bool {
+	return s.dedup && enc == nil
+}
+
+// blobPath returns the path of the blob holding the data with the given SHA-256
+func (s *Storage) blobPath(sum string) string {
+	return s.metaPath("blobs", sum[:2], sum)
+}
+
+// shareBlob makes the temporary file at tmpPath share its data with the blob of the same
+// content, creating the blob from the file when there is none. It reports whether the data
+// is shared; when hard links fail, the file is simply stored on its own.
+// The caller must hold the write lock.
+func (s *Storage) shareBlob(tmpPath, sum string) bool {
+	tmpInfo, err := os.Stat(tmpPath)
+	if err != nil {
+		return false
+	}
+	blobPath := s.blobPath(sum)
+
+	if blobInfo, err := os.Stat(blobPath); err == nil && blobInfo.Size() == tmpInfo.Size() {
+		// Replace the temporary file with a link to the blob. A command collecting blobs
+		// may have removed it in the meantime, in which case the blob is created again below.
+		linkPath := tmpPath + ".blob"
+		if err := os.Link(blobPath, linkPath); err == nil {
+			if err := os.Rename(linkPath, tmpPath); err == nil {
+				return true
+			}
+			_ = os.Remove(linkPath)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		return false
+	}
+	return os.Link(tmpPath, blobPath) == nil
+}
+
+// DedupStats reports the blobs of the bucket and the disk space they save
+func (s *Storage) DedupStats() (*DedupStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := &DedupStats{}
+	err := s.walkBlobs(func(path string, info os.FileInfo, links uint64) error {
+		if links <= 1 {
+			stats.Unreferenced++
+			stats.UnreferencedBytes += info.Size()
+			return nil
+		}
+		references := int64(links) - 1
+		stats.Blobs++
+		stats.References += references
+		stats.Bytes += info.Size()
+		stats.SavedBytes += (references - 1) * info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// CollectBlobs removes the blobs no longer referenced by any object or version and
+// returns how many were removed and the disk space freed
+func (s *Storage) CollectBlobs() (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed, freed int64
+	err := s.walkBlobs(func(path string, info os.FileInfo, links uint64) error {
+		if links > 1 {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove blob: %w", err)
+		}
+		removed++
+		freed += info.Size()
+		// Drop the directory once its last blob is gone (fails harmlessly otherwise)
+		_ = os.Remove(filepath.Dir(path))
+		return nil
+	})
+	return removed, freed, err
+}
+
+// walkBlobs calls fn with every blob and its number of links; the caller must hold the lock
+func (s *Storage) walkBlobs(fn func(path string, info os.FileInfo, links uint64) error) error {
+	err := filepath.WalkDir(s.metaPath("blobs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		links, _, ok := fileLinks(info)
+		if !ok {
+			return nil
+		}
+		return fn(path, info, links)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk blobs: %w", err)
+	}
+	return nil
+}
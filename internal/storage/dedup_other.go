@@ -0,0 +1,14 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// linksSupported reports whether files can share their data through hard links
+const linksSupported = false
+
+// fileLinks returns the number of hard links to a file and the identifier of its data,
+// which are not available on this platform
+func fileLinks(info os.FileInfo) (uint64, fileID, bool) {
+	return 0, fileID{}, false
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+// newDedupStorage creates a storage instance deduplicating identical objects
+func newDedupStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.SetDeduplication(true); err != nil {
+		t.Skipf("deduplication is not supported: %v", err)
+	}
+	return store
+}
+
+// dedupStats returns the deduplication statistics of store
+func dedupStats(t *testing.T, store *Storage) DedupStats {
+	t.Helper()
+
+	stats, err := store.DedupStats()
+	if err != nil {
+		t.Fatalf("DedupStats failed: %v", err)
+	}
+	return *stats
+}
+
+// sameFile reports whether two objects share their data on disk
+func sameFile(t *testing.T, store *Storage, key1, key2 string) bool {
+	t.Helper()
+
+	info1, err1 := os.Stat(store.keyToPath(key1))
+	info2, err2 := os.Stat(store.keyToPath(key2))
+	if err1 != nil || err2 != nil {
+		t.Fatalf("failed to stat objects: %v, %v", err1, err2)
+	}
+	return os.SameFile(info1, info2)
+}
+
+func TestDedup_PutObject(t *testing.T) {
+	store := newDedupStorage(t)
+	logo := randomData(t, 4096)
+
+	first, err := store.PutObject("a/logo.png", "", bytes.NewReader(logo))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	second, err := store.PutObject("b/logo.png", "", bytes.NewReader(logo))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if _, err := store.PutObject("other.png", "", bytes.NewReader(randomData(t, 100))); err != nil {
+		t.Fatal(err)
+	}
+
+	if !sameFile(t, store, "a/logo.png", "b/logo.png") || sameFile(t, store, "a/logo.png", "other.png") {
+		t.Error("only identical objects should share their data")
+	}
+	want := DedupStats{Blobs: 2, References: 3, Bytes: 4096 + 100, SavedBytes: 4096}
+	if got := dedupStats(t, store); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+
+	// Each object keeps its own modification time and ETag
+	head, _ := store.HeadObject("b/logo.png")
+	if !head.LastModified.Equal(second.LastModified) || head.ETag != second.ETag || head.ETag == first.ETag {
+		t.Errorf("unexpected metadata: first %+v, second %+v, head %+v", first, second, head)
+	}
+	if got := readAll(t, store, "b/logo.png", ""); got != string(logo) {
+		t.Error("deduplicated data does not match")
+	}
+
+	// Shared data is only counted once
+	usage, err := store.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Objects != 3 || usage.Bytes >= 2*4096 {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+}
+
+func TestDedup_CopyObject(t *testing.T) {
+	store := newDedupStorage(t)
+	if _, err := store.PutObject("report.pdf", "", bytes.NewReader(randomData(t, 1000))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CopyObject("report.pdf", GetOptions{}, "copy.pdf", nil); err != nil {
+		t.Fatalf("CopyObject failed: %v", err)
+	}
+	if !sameFile(t, store, "report.pdf", "copy.pdf") {
+		t.Error("a copy should share the data of its source")
+	}
+}
+
+func TestDedup_CollectBlobs(t *testing.T) {
+	store := newDedupStorage(t)
+	data := randomData(t, 2048)
+	for _, key := range []string{"one.bin", "two.bin"} {
+		if _, err := store.PutObject(key, "", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.DeleteObject("one.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if removed, _, _ := store.CollectBlobs(); removed != 0 {
+		t.Errorf("a referenced blob was removed")
+	}
+	if got := readAll(t, store, "two.bin", ""); got != string(data) {
+		t.Error("remaining object does not match")
+	}
+
+	if err := store.DeleteObject("two.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if got := dedupStats(t, store); got.Unreferenced != 1 || got.UnreferencedBytes != 2048 {
+		t.Errorf("stats = %+v", got)
+	}
+	removed, freed, err := store.CollectBlobs()
+	if err != nil || removed != 1 || freed != 2048 {
+		t.Errorf("CollectBlobs = %d, %d, %v", removed, freed, err)
+	}
+	if got := dedupStats(t, store); got != (DedupStats{}) {
+		t.Errorf("stats after collection = %+v", got)
+	}
+
+	// A new upload of the same data creates the blob again
+	if _, err := store.PutObject("three.bin", "", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if got := dedupStats(t, store); got.Blobs != 1 || got.References != 1 {
+		t.Errorf("stats = %+v", got)
+	}
+}
+
+func TestDedup_Versions(t *testing.T) {
+	store := newVersionedStorage(t)
+	if err := store.SetDeduplication(true); err != nil {
+		t.Skipf("deduplication is not supported: %v", err)
+	}
+	data := randomData(t, 512)
+
+	v1, err := store.PutObject("doc.bin", "", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	v2, err := store.PutObject("doc.bin", "", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The archived version still references the blob, with its own metadata
+	if got := dedupStats(t, store); got.References != 2 {
+		t.Errorf("stats = %+v", got)
+	}
+	old, err := store.HeadObjectVersion("doc.bin", v1.VersionID)
+	if err != nil || old.ETag != v1.ETag || !old.LastModified.Equal(v1.LastModified) {
+		t.Errorf("non-current version = %+v (%v), want %+v", old, err, v1)
+	}
+
+	// Deleting the current version restores the previous one with its metadata
+	if _, err := store.DeleteObjectVersion("doc.bin", v2.VersionID); err != nil {
+		t.Fatal(err)
+	}
+	current, _ := store.HeadObject("doc.bin")
+	if current.VersionID != v1.VersionID || current.ETag != v1.ETag {
+		t.Errorf("restored version = %+v, want %+v", current, v1)
+	}
+}
+
+func TestDedup_EncryptedObjects(t *testing.T) {
+	store := newDedupStorage(t)
+	store.SetMasterKey(testKey(t))
+	data := randomData(t, 100)
+	for _, key := range []string{"one.bin", "two.bin"} {
+		if _, err := store.PutObject(key, "", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sameFile(t, store, "one.bin", "two.bin") || dedupStats(t, store).Blobs != 0 {
+		t.Error("encrypted objects should not be deduplicated")
+	}
+}
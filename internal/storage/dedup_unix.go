@@ -0,0 +1,20 @@
+//go:build unix
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// linksSupported reports whether files can share their data through hard links
+const linksSupported = true
+
+// fileLinks returns the number of hard links to a file and the identifier of its data
+func fileLinks(info os.FileInfo) (uint64, fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fileID{}, false
+	}
+	return uint64(st.Nlink), fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
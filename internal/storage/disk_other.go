@@ -0,0 +1,11 @@
+//go:build !unix
+
+package storage
+
+import "errors"
+
+// FreeSpace returns the disk space available to the storage, which is not supported on
+// this platform
+func (s *Storage) FreeSpace() (int64, error) {
+	return 0, errors.New("free disk space is not available on this platform")
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package storage
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// FreeSpace returns the disk space available to the storage, in bytes
+func (s *Storage) FreeSpace() (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(s.basePath, &st); err != nil {
+		return 0, fmt.Errorf("failed to read free disk space: %w", err)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,426 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Server-side encryption modes
+const (
+	SSES3 = "SSE-S3" // data keys wrapped by the master key
+	SSEC  = "SSE-C"  // data keys wrapped by a key provided with each request
+)
+
+// KeySize is the size of master keys and SSE-C keys (AES-256)
+const KeySize = 32
+
+// Encrypted data is a sequence of segments, one per PutObject or per part of a multipart
+// upload. Each segment is split into chunks sealed independently with AES-256-GCM, so that
+// ranges can be decrypted without reading the object from the start.
+const (
+	encChunkSize = 64 * 1024
+	encTagSize   = 16
+)
+
+// encryptionInfo is persisted in the metadata of an encrypted object or multipart upload
+type encryptionInfo struct {
+	Mode       string    `json:"mode"`
+	WrappedKey []byte    `json:"wrappedKey"`       // data key sealed with the master or customer key
+	KeyMD5     string    `json:"keyMD5,omitempty"` // base64 MD5 of the SSE-C key
+	Size       int64     `json:"size,omitempty"`   // plaintext size, compressed when the object is
+	Segments   []segment `json:"segments,omitempty"`
+}
+
+// segment is an independently encrypted run of chunks
+type segment struct {
+	ID   uint32 `json:"id"` // part number, 0 for single uploads; part of every chunk nonce
+	Size int64  `json:"size"`
+	Salt []byte `json:"salt,omitempty"` // derives the key of a part from the data key, see segmentKey
+}
+
+// GetOptions selects the version of an object to read and the key to decrypt it
+type GetOptions struct {
+	VersionID   string
+	CustomerKey []byte // SSE-C key, required for objects stored with one
+}
+
+// CustomerKeyMD5 returns the base64 MD5 digest S3 uses to identify an SSE-C key
+func CustomerKeyMD5(key []byte) string {
+	sum := md5.Sum(key)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// CheckCustomerKey verifies that customerKey is the key obj was encrypted with, or that
+// none is given for objects not stored with SSE-C
+func CheckCustomerKey(obj *Object, customerKey []byte) error {
+	if obj.Encryption != SSEC {
+		if customerKey != nil {
+			return ErrCustomerKeyNotApplicable
+		}
+		return nil
+	}
+	if customerKey == nil {
+		return ErrCustomerKeyRequired
+	}
+	if subtle.ConstantTimeCompare([]byte(CustomerKeyMD5(customerKey)), []byte(obj.CustomerKeyMD5)) != 1 {
+		return ErrCustomerKeyMismatch
+	}
+	return nil
+}
+
+// encryptionMode returns the mode applied to a new object: SSE-C with a customer key,
+// otherwise SSE-S3 when requested or when a master key is configured
+func encryptionMode(opts PutOptions, masterKey []byte) (string, error) {
+	switch {
+	case opts.CustomerKey != nil:
+		if len(opts.CustomerKey) != KeySize {
+			return "", fmt.Errorf("%w: SSE-C keys must be %d bytes", ErrInvalidEncryption, KeySize)
+		}
+		return SSEC, nil
+	case opts.Encryption == SSES3 && masterKey == nil:
+		return "", ErrEncryptionNotConfigured
+	case opts.Encryption != "" && opts.Encryption != SSES3:
+		return "", fmt.Errorf("%w: unsupported mode %q", ErrInvalidEncryption, opts.Encryption)
+	case masterKey != nil:
+		return SSES3, nil
+	default:
+		return "", nil
+	}
+}
+
+// newEncryption generates the data key of a new object or multipart upload and wraps it.
+// It returns nil when the object is stored in plaintext.
+func newEncryption(opts PutOptions, masterKey []byte) (*encryptionInfo, []byte, error) {
+	mode, err := encryptionMode(opts, masterKey)
+	if err != nil || mode == "" {
+		return nil, nil, err
+	}
+
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	info := &encryptionInfo{Mode: mode}
+	kek := masterKey
+	if mode == SSEC {
+		kek = opts.CustomerKey
+		info.KeyMD5 = CustomerKeyMD5(opts.CustomerKey)
+	}
+	if info.WrappedKey, err = wrapKey(kek, dataKey, mode); err != nil {
+		return nil, nil, err
+	}
+	return info, dataKey, nil
+}
+
+// dataKey unwraps the data key of an encrypted object with the master key or customerKey
+func (e *encryptionInfo) dataKey(masterKey, customerKey []byte) ([]byte, error) {
+	kek := masterKey
+	if e.Mode == SSEC {
+		if customerKey == nil {
+			return nil, ErrCustomerKeyRequired
+		}
+		if subtle.ConstantTimeCompare([]byte(CustomerKeyMD5(customerKey)), []byte(e.KeyMD5)) != 1 {
+			return nil, ErrCustomerKeyMismatch
+		}
+		kek = customerKey
+	} else if masterKey == nil {
+		return nil, fmt.Errorf("object is encrypted with SSE-S3 but no master key is configured")
+	}
+
+	dataKey, err := unwrapKey(kek, e.WrappedKey, e.Mode)
+	if err != nil {
+		if e.Mode == SSEC {
+			return nil, ErrCustomerKeyMismatch
+		}
+		return nil, fmt.Errorf("failed to unwrap data key, was the master key changed? %w", err)
+	}
+	return dataKey, nil
+}
+
+// describe sets the encryption of obj; e may be nil for plaintext objects
+func (e *encryptionInfo) describe(obj *Object) {
+	if e == nil {
+		return
+	}
+	obj.Encryption = e.Mode
+	obj.CustomerKeyMD5 = e.KeyMD5
+}
+
+// segments returns the segments of the object, a single one unless assembled from parts
+func (e *encryptionInfo) segments() []segment {
+	if len(e.Segments) > 0 {
+		return e.Segments
+	}
+	return []segment{{ID: 0, Size: e.Size}}
+}
+
+// wrapKey seals a data key with a key encryption key
+func wrapKey(kek, dataKey []byte, mode string) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(mode)), nil
+}
+
+// unwrapKey opens a data key sealed by wrapKey
+func unwrapKey(kek, wrapped []byte, mode string) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(mode))
+}
+
+// newGCM returns AES-256-GCM with key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("%w: keys must be %d bytes", ErrInvalidEncryption, KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentSaltSize is the size of the random salt of each uploaded part
+const segmentSaltSize = 16
+
+// newSegmentSalt returns a random salt for the key of an uploaded part
+func newSegmentSalt() ([]byte, error) {
+	salt := make([]byte, segmentSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return salt, nil
+}
+
+// segmentKey returns the key sealing a segment: the data key itself without a salt, or a
+// key derived from the data key and the salt. The parts of a multipart upload share its
+// data key and may be uploaded again under the same part number, so each upload of a
+// part gets a salt and its nonces are never used with the same key twice.
+func segmentKey(dataKey, salt []byte) []byte {
+	if len(salt) == 0 {
+		return dataKey
+	}
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write([]byte("selfhost_s3 segment key\x00"))
+	mac.Write(salt)
+	return mac.Sum(nil)
+}
+
+// chunkNonce derives the nonce of a chunk. Data keys are never reused across objects and
+// each upload of a part has a key of its own, so the segment ID and chunk index make
+// every nonce unique for its key.
+func chunkNonce(segmentID uint32, index uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint32(nonce[:4], segmentID)
+	binary.BigEndian.PutUint64(nonce[4:], index)
+	return nonce
+}
+
+// chunkAAD authenticates whether a chunk ends its segment, so truncation is detected
+func chunkAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// chunkCount returns the number of chunks of a segment; empty segments have one empty chunk
+func chunkCount(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + encChunkSize - 1) / encChunkSize
+}
+
+// encryptedSize returns the size of an encrypted segment
+func encryptedSize(size int64) int64 {
+	return size + chunkCount(size)*encTagSize
+}
+
+// encryptingWriter encrypts a segment written to it. Close seals the final chunk.
+type encryptingWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	segment uint32
+	index   uint64
+	buf     []byte
+	size    int64
+}
+
+// newEncryptingWriter returns a writer encrypting a segment into w
+func newEncryptingWriter(w io.Writer, dataKey []byte, segmentID uint32) (*encryptingWriter, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{w: w, aead: aead, segment: segmentID, buf: make([]byte, 0, encChunkSize+encTagSize)}, nil
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data follows, since the last one is marked final
+		if len(e.buf) == encChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := min(len(p), encChunkSize-len(e.buf))
+		e.buf = append(e.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		e.size += int64(n)
+	}
+	return written, nil
+}
+
+// Close seals the final chunk; it does not close the underlying writer
+func (e *encryptingWriter) Close() error {
+	return e.seal(true)
+}
+
+// seal encrypts the buffered chunk and writes it out
+func (e *encryptingWriter) seal(final bool) error {
+	sealed := e.aead.Seal(e.buf[:0], chunkNonce(e.segment, e.index), e.buf, chunkAAD(final))
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.index++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// decryptingReader reads the plaintext of encrypted data, decrypting chunk by chunk.
+// It implements io.Seeker so that ranges only decrypt the chunks they cover.
+type decryptingReader struct {
+	src      io.ReaderAt
+	closer   io.Closer
+	aeads    []cipher.AEAD // by segment
+	segments []segment
+	size     int64
+	pos      int64
+
+	// The last decrypted chunk
+	chunk      []byte
+	chunkStart int64 // plaintext offset of chunk, -1 when empty
+	sealed     []byte
+}
+
+// newDecryptingReader returns a reader decrypting src; closing it closes closer
+func newDecryptingReader(src io.ReaderAt, closer io.Closer, dataKey []byte, segments []segment) (*decryptingReader, error) {
+	var unsalted cipher.AEAD // shared by the segments sealed with the data key itself
+	aeads := make([]cipher.AEAD, len(segments))
+	var size int64
+	for i, seg := range segments {
+		size += seg.Size
+		if len(seg.Salt) == 0 && unsalted != nil {
+			aeads[i] = unsalted
+			continue
+		}
+		aead, err := newGCM(segmentKey(dataKey, seg.Salt))
+		if err != nil {
+			return nil, err
+		}
+		if len(seg.Salt) == 0 {
+			unsalted = aead
+		}
+		aeads[i] = aead
+	}
+	return &decryptingReader{
+		src:        src,
+		closer:     closer,
+		aeads:      aeads,
+		segments:   segments,
+		size:       size,
+		chunkStart: -1,
+		sealed:     make([]byte, encChunkSize+encTagSize),
+	}, nil
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	if d.pos >= d.size {
+		return 0, io.EOF
+	}
+	if d.chunkStart < 0 || d.pos < d.chunkStart || d.pos >= d.chunkStart+int64(len(d.chunk)) {
+		if err := d.load(d.pos); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.chunk[d.pos-d.chunkStart:])
+	d.pos += int64(n)
+	return n, nil
+}
+
+// load decrypts the chunk holding the plaintext offset pos
+func (d *decryptingReader) load(pos int64) error {
+	var plainStart, cipherStart int64
+	for i, seg := range d.segments {
+		if pos >= plainStart+seg.Size {
+			plainStart += seg.Size
+			cipherStart += encryptedSize(seg.Size)
+			continue
+		}
+
+		index := (pos - plainStart) / encChunkSize
+		length := min(encChunkSize, seg.Size-index*encChunkSize)
+		final := index == chunkCount(seg.Size)-1
+		sealed := d.sealed[:length+encTagSize]
+		if _, err := d.src.ReadAt(sealed, cipherStart+index*(encChunkSize+encTagSize)); err != nil {
+			return fmt.Errorf("failed to read encrypted data: %w", err)
+		}
+		chunk, err := d.aeads[i].Open(d.chunk[:0], chunkNonce(seg.ID, uint64(index)), sealed, chunkAAD(final))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt object data: %w", err)
+		}
+		d.chunk = chunk
+		d.chunkStart = plainStart + index*encChunkSize
+		return nil
+	}
+	return io.EOF
+}
+
+func (d *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.pos
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.pos = offset
+	return offset, nil
+}
+
+func (d *decryptingReader) Close() error {
+	if d.closer == nil {
+		return nil
+	}
+	return d.closer.Close()
+}
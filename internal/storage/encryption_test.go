@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testKey returns a random 32-byte key
+func testKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// randomData returns n random bytes
+func randomData(t *testing.T, n int) []byte {
+	t.Helper()
+
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// newEncryptedStorage creates a storage instance with a master key
+func newEncryptedStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	store.SetMasterKey(testKey(t))
+	return store
+}
+
+func TestEncryption_SSES3(t *testing.T) {
+	store := newEncryptedStorage(t)
+	data := randomData(t, 3*encChunkSize+100)
+
+	obj, err := store.PutObject("secret.bin", "", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if obj.Encryption != SSES3 || obj.Size != int64(len(data)) {
+		t.Errorf("unexpected object: encryption %q, size %d", obj.Encryption, obj.Size)
+	}
+
+	// The file on disk is encrypted
+	onDisk, err := os.ReadFile(store.keyToPath("secret.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(onDisk, data[:64]) || int64(len(onDisk)) != encryptedSize(int64(len(data))) {
+		t.Errorf("data is not encrypted on disk (%d bytes)", len(onDisk))
+	}
+
+	_, reader, err := store.GetObject("secret.bin")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	got, _ := io.ReadAll(reader)
+	_ = reader.Close()
+	if !bytes.Equal(got, data) {
+		t.Error("decrypted data does not match")
+	}
+
+	head, _ := store.HeadObject("secret.bin")
+	objects, _ := store.ListObjects("")
+	if head.Size != int64(len(data)) || head.Encryption != SSES3 || len(objects) != 1 || objects[0].Size != int64(len(data)) {
+		t.Errorf("plaintext size not reported: head %+v, list %+v", head, objects)
+	}
+
+	// Another master key cannot read the object
+	store.SetMasterKey(testKey(t))
+	if _, _, err := store.GetObject("secret.bin"); err == nil {
+		t.Error("expected an error with another master key")
+	}
+}
+
+func TestEncryption_Plaintext(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.PutObjectWithOptions("a.txt", strings.NewReader("a"), PutOptions{Encryption: SSES3}); !errors.Is(err, ErrEncryptionNotConfigured) {
+		t.Errorf("expected ErrEncryptionNotConfigured, got %v", err)
+	}
+
+	// Objects written before the master key was set stay readable
+	if _, err := store.PutObject("old.txt", "", strings.NewReader("plain")); err != nil {
+		t.Fatal(err)
+	}
+	store.SetMasterKey(testKey(t))
+	obj, reader, err := store.GetObject("old.txt")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	_ = reader.Close()
+	if string(data) != "plain" || obj.Encryption != "" {
+		t.Errorf("got %q, encryption %q", data, obj.Encryption)
+	}
+}
+
+func TestEncryption_SSEC(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		key := testKey(t)
+
+		obj, err := b.PutObjectWithOptions("private.txt", strings.NewReader("customer data"), PutOptions{CustomerKey: key})
+		if err != nil {
+			t.Fatalf("PutObjectWithOptions failed: %v", err)
+		}
+		if obj.Encryption != SSEC || obj.CustomerKeyMD5 != CustomerKeyMD5(key) {
+			t.Errorf("unexpected encryption %q %q", obj.Encryption, obj.CustomerKeyMD5)
+		}
+
+		if _, _, err := b.GetObject("private.txt"); !errors.Is(err, ErrCustomerKeyRequired) {
+			t.Errorf("expected ErrCustomerKeyRequired, got %v", err)
+		}
+		if _, _, err := b.GetObjectWithOptions("private.txt", GetOptions{CustomerKey: testKey(t)}); !errors.Is(err, ErrCustomerKeyMismatch) {
+			t.Errorf("expected ErrCustomerKeyMismatch, got %v", err)
+		}
+
+		_, reader, err := b.GetObjectWithOptions("private.txt", GetOptions{CustomerKey: key})
+		if err != nil {
+			t.Fatalf("GetObjectWithOptions failed: %v", err)
+		}
+		data, _ := io.ReadAll(reader)
+		_ = reader.Close()
+		if string(data) != "customer data" {
+			t.Errorf("got %q", data)
+		}
+
+		// A key for an object that is not encrypted with one is rejected
+		_, _ = b.PutObject("plain.txt", "", strings.NewReader("plain"))
+		if _, _, err := b.GetObjectWithOptions("plain.txt", GetOptions{CustomerKey: key}); !errors.Is(err, ErrCustomerKeyNotApplicable) {
+			t.Errorf("expected ErrCustomerKeyNotApplicable, got %v", err)
+		}
+
+		// Copies are re-encrypted with the destination options
+		copied, err := b.CopyObject("private.txt", GetOptions{CustomerKey: key}, "copy.txt", nil)
+		if err != nil {
+			t.Fatalf("CopyObject failed: %v", err)
+		}
+		if copied.Encryption != "" || readAll(t, b, "copy.txt", "") != "customer data" {
+			t.Errorf("unexpected copy: encryption %q", copied.Encryption)
+		}
+	})
+}
+
+func TestEncryption_Ranges(t *testing.T) {
+	store := newEncryptedStorage(t)
+	data := randomData(t, 2*encChunkSize+10)
+	if _, err := store.PutObject("data.bin", "", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []struct{ start, length int64 }{
+		{0, 10},
+		{encChunkSize - 5, 10}, // across a chunk boundary
+		{encChunkSize, encChunkSize},
+		{2*encChunkSize + 5, 5},    // within the last chunk
+		{100, 2*encChunkSize - 90}, // to the end
+	} {
+		_, reader, err := store.GetObject("data.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reader.(io.Seeker).Seek(r.start, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+		got := make([]byte, r.length)
+		if _, err := io.ReadFull(reader, got); err != nil {
+			t.Fatalf("range %d+%d: %v", r.start, r.length, err)
+		}
+		_ = reader.Close()
+		if !bytes.Equal(got, data[r.start:r.start+r.length]) {
+			t.Errorf("range %d+%d does not match", r.start, r.length)
+		}
+	}
+}
+
+func TestEncryption_Tampering(t *testing.T) {
+	store := newEncryptedStorage(t)
+	if _, err := store.PutObject("data.bin", "", bytes.NewReader(randomData(t, 2*encChunkSize))); err != nil {
+		t.Fatal(err)
+	}
+
+	path := store.keyToPath("data.bin")
+	onDisk, _ := os.ReadFile(path)
+
+	// Truncating the last chunk is detected, even though the remaining chunks are valid
+	if err := os.WriteFile(path, onDisk[:encChunkSize+encTagSize], 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, reader, err := store.GetObject("data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(reader); err == nil {
+		t.Error("expected an error reading truncated data")
+	}
+	_ = reader.Close()
+
+	onDisk[10] ^= 1
+	if err := os.WriteFile(path, onDisk, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, reader, _ = store.GetObject("data.bin")
+	if _, err := io.ReadAll(reader); err == nil {
+		t.Error("expected an error reading modified data")
+	}
+	_ = reader.Close()
+}
+
+func TestEncryption_Multipart(t *testing.T) {
+	defer func(size int64) { minPartSize = size }(minPartSize)
+	minPartSize = 4
+
+	store := newEncryptedStorage(t)
+	key := testKey(t)
+
+	upload, err := store.CreateMultipartUpload("big.bin", PutOptions{CustomerKey: key})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	if upload.Encryption != SSEC {
+		t.Errorf("expected SSE-C upload, got %q", upload.Encryption)
+	}
+
+	part1 := randomData(t, encChunkSize+1)
+	part2 := randomData(t, 7)
+	if _, err := store.UploadPart("big.bin", upload.ID, 1, bytes.NewReader(part1), nil); !errors.Is(err, ErrCustomerKeyRequired) {
+		t.Errorf("expected ErrCustomerKeyRequired, got %v", err)
+	}
+	p1, err := store.UploadPart("big.bin", upload.ID, 1, bytes.NewReader(part1), key)
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+	p3, _ := store.UploadPart("big.bin", upload.ID, 3, bytes.NewReader(part2), key)
+	if p1.Size != int64(len(part1)) {
+		t.Errorf("expected plaintext part size, got %d", p1.Size)
+	}
+
+	obj, err := store.CompleteMultipartUpload("big.bin", upload.ID, []CompletedPart{
+		{PartNumber: 1, ETag: p1.ETag},
+		{PartNumber: 3, ETag: p3.ETag},
+	})
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+	want := append(part1, part2...)
+	if obj.Size != int64(len(want)) || obj.Encryption != SSEC {
+		t.Errorf("unexpected object: size %d, encryption %q", obj.Size, obj.Encryption)
+	}
+
+	_, reader, err := store.GetObjectWithOptions("big.bin", GetOptions{CustomerKey: key})
+	if err != nil {
+		t.Fatalf("GetObjectWithOptions failed: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+	got, err := io.ReadAll(reader)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("assembled data does not match (%v)", err)
+	}
+
+	// Ranges spanning both parts
+	_, _ = reader.(io.Seeker).Seek(int64(len(part1))-3, io.SeekStart)
+	tail, _ := io.ReadAll(reader)
+	if !bytes.Equal(tail, want[len(part1)-3:]) {
+		t.Error("range across parts does not match")
+	}
+}
+
+func TestEncryption_PartUploadedTwice(t *testing.T) {
+	store := newEncryptedStorage(t)
+
+	upload, err := store.CreateMultipartUpload("retried.bin", PutOptions{})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+
+	// A retried part must not reuse the key and nonces of the previous attempt
+	data := randomData(t, encChunkSize+1)
+	var ciphertexts [][]byte
+	var last *Part
+	for range 2 {
+		if last, err = store.UploadPart("retried.bin", upload.ID, 1, bytes.NewReader(data), nil); err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
+		stored, err := os.ReadFile(store.partPath(upload.ID, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertexts = append(ciphertexts, stored)
+	}
+	if bytes.Equal(ciphertexts[0], ciphertexts[1]) {
+		t.Fatal("expected each upload of a part to be encrypted differently")
+	}
+
+	if _, err := store.CompleteMultipartUpload("retried.bin", upload.ID, []CompletedPart{{PartNumber: 1, ETag: last.ETag}}); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+	_, reader, err := store.GetObject("retried.bin")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+	if got, err := io.ReadAll(reader); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("object does not match the last upload of the part (%v)", err)
+	}
+}
+
+func TestEncryption_Versions(t *testing.T) {
+	store := newVersionedStorage(t)
+	store.SetMasterKey(testKey(t))
+
+	v1, err := store.PutObject("doc.txt", "", strings.NewReader("first version"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("doc.txt", "", strings.NewReader("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readVersion(t, store, "doc.txt", v1.VersionID); got != "first version" {
+		t.Errorf("non-current version = %q", got)
+	}
+	versions, _ := store.ListObjectVersions("")
+	for _, v := range versions {
+		if v.VersionID == v1.VersionID && v.Size != int64(len("first version")) {
+			t.Errorf("non-current version size = %d", v.Size)
+		}
+	}
+
+	// Deleting the current version restores the encrypted previous one
+	current, _ := store.HeadObject("doc.txt")
+	if _, err := store.DeleteObjectVersion("doc.txt", current.VersionID); err != nil {
+		t.Fatal(err)
+	}
+	if got := readVersion(t, store, "doc.txt", ""); got != "first version" {
+		t.Errorf("restored version = %q", got)
+	}
+}
+
+func TestCheckCustomerKey(t *testing.T) {
+	key := testKey(t)
+	sseC := &Object{Encryption: SSEC, CustomerKeyMD5: CustomerKeyMD5(key)}
+
+	tests := []struct {
+		name string
+		obj  *Object
+		key  []byte
+		want error
+	}{
+		{"plaintext without key", &Object{}, nil, nil},
+		{"SSE-S3 without key", &Object{Encryption: SSES3}, nil, nil},
+		{"plaintext with key", &Object{}, key, ErrCustomerKeyNotApplicable},
+		{"SSE-C without key", sseC, nil, ErrCustomerKeyRequired},
+		{"SSE-C with other key", sseC, testKey(t), ErrCustomerKeyMismatch},
+		{"SSE-C with key", sseC, key, nil},
+	}
+	for _, tt := range tests {
+		if err := CheckCustomerKey(tt.obj, tt.key); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
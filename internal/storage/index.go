@@ -0,0 +1,467 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+// The metadata index is a bbolt database of the current objects and folders of the
+// bucket, sorted by key, so that listings never walk the bucket. It is updated after
+// every write and delete made through the Storage that opened it.
+//
+// Only one process can open the index. Other processes writing to the bucket, such as
+// the command line while the server runs, mark it stale instead, and it is reconciled
+// with the filesystem on the next listing. An index that was not closed cleanly is
+// rebuilt when it is opened, so index updates do not need to be synced to disk.
+//
+// Rebuilds write the keys found on disk into a second bucket, compare it with the
+// current one and then switch to it, so that memory use does not grow with the bucket.
+
+// indexVersion is the format of the index; an index in another format is rebuilt
+const indexVersion = "1"
+
+var (
+	indexStateBucket = []byte("state")
+	indexKeyBuckets  = [2][]byte{[]byte("keys-0"), []byte("keys-1")} // key -> JSON indexEntry
+
+	indexVersionKey = []byte("version")
+	indexCleanKey   = []byte("clean")  // "1" once closed cleanly
+	indexActiveKey  = []byte("active") // name of the key bucket in use
+)
+
+// indexBatchSize is the number of keys written per transaction while rebuilding
+const indexBatchSize = 10000
+
+// ErrIndexInUse is returned when the metadata index is open in another process
+var ErrIndexInUse = errors.New("metadata index is in use by another process")
+
+// indexEntry is what the index records of an object or folder
+type indexEntry struct {
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"modified"`
+	ETag         string    `json:"etag"`
+}
+
+// IndexStats reports what a rebuild of the metadata index changed
+type IndexStats struct {
+	Keys    int64 // objects and folders in the index
+	Added   int64
+	Updated int64
+	Removed int64
+}
+
+// metadataIndex is an open metadata index
+type metadataIndex struct {
+	db      *bbolt.DB
+	rebuild sync.Mutex // serializes rebuilds
+}
+
+// indexPath returns the path of the index database
+func (s *Storage) indexPath() string {
+	return s.metaPath("index.db")
+}
+
+// staleIndexPath returns the path of the file marking the index stale
+func (s *Storage) staleIndexPath() string {
+	return s.metaPath("index.stale")
+}
+
+// OpenIndex opens the metadata index, creating it from the bucket when it does not exist,
+// and uses it for listings. An index that is stale, was not closed cleanly or cannot be
+// read is rebuilt, and the changes found are returned; the stats are nil otherwise.
+// It returns ErrIndexInUse when another process has the index open.
+func (s *Storage) OpenIndex() (*IndexStats, error) {
+	if err := os.MkdirAll(s.metaPath(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+
+	db, err := bbolt.Open(s.indexPath(), 0644, &bbolt.Options{Timeout: time.Second, NoSync: true})
+	if errors.Is(err, bolterrors.ErrTimeout) {
+		return nil, ErrIndexInUse
+	}
+	if err != nil {
+		// The index only holds what the filesystem has, so a damaged one is recreated
+		if err := os.Remove(s.indexPath()); err != nil {
+			return nil, fmt.Errorf("failed to remove damaged index: %w", err)
+		}
+		if db, err = bbolt.Open(s.indexPath(), 0644, &bbolt.Options{Timeout: time.Second, NoSync: true}); err != nil {
+			return nil, fmt.Errorf("failed to open index: %w", err)
+		}
+	}
+
+	var usable bool
+	err = db.Update(func(tx *bbolt.Tx) error {
+		state, err := tx.CreateBucketIfNotExists(indexStateBucket)
+		if err != nil {
+			return err
+		}
+		usable = string(state.Get(indexVersionKey)) == indexVersion && string(state.Get(indexCleanKey)) == "1" &&
+			activeKeys(tx) != nil
+		if !usable {
+			// Start over, so that a damaged index is never read
+			for _, name := range indexKeyBuckets {
+				if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolterrors.ErrBucketNotFound) {
+					return err
+				}
+			}
+			if _, err := tx.CreateBucket(indexKeyBuckets[0]); err != nil {
+				return err
+			}
+			if err := state.Put(indexActiveKey, indexKeyBuckets[0]); err != nil {
+				return err
+			}
+			if err := state.Put(indexVersionKey, []byte(indexVersion)); err != nil {
+				return err
+			}
+		}
+		// Marked clean again by CloseIndex
+		return state.Put(indexCleanKey, []byte("0"))
+	})
+	if err == nil {
+		err = db.Sync()
+	}
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+
+	s.mu.Lock()
+	s.index = &metadataIndex{db: db}
+	s.mu.Unlock()
+	if usable {
+		if _, err := os.Stat(s.staleIndexPath()); err != nil {
+			return nil, nil
+		}
+	}
+	stats, err := s.RebuildIndex()
+	if err != nil {
+		_ = s.CloseIndex()
+		return nil, err
+	}
+	return stats, nil
+}
+
+// CloseIndex marks the metadata index clean and closes it. Listings walk the bucket again.
+func (s *Storage) CloseIndex() error {
+	s.mu.Lock()
+	index := s.index
+	s.index = nil
+	s.mu.Unlock()
+
+	if index == nil {
+		return nil
+	}
+	err := index.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(indexStateBucket).Put(indexCleanKey, []byte("1"))
+	})
+	if err == nil {
+		err = index.db.Sync()
+	}
+	if closeErr := index.db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to close index: %w", err)
+	}
+	return nil
+}
+
+// MarkIndexStale makes the process holding the metadata index reconcile it with the
+// filesystem before its next listing
+func (s *Storage) MarkIndexStale() error {
+	if err := os.MkdirAll(s.metaPath(), 0755); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+	if err := os.WriteFile(s.staleIndexPath(), nil, 0644); err != nil {
+		return fmt.Errorf("failed to mark index stale: %w", err)
+	}
+	return nil
+}
+
+// RebuildIndex reconciles the metadata index with the objects and folders of the bucket.
+// Writes wait for the rebuild; reads and listings do not.
+func (s *Storage) RebuildIndex() (*IndexStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	index := s.index
+	if index == nil {
+		return nil, errors.New("metadata index is not open")
+	}
+	index.rebuild.Lock()
+	defer index.rebuild.Unlock()
+
+	// Writes marking the index stale from now on are caught by the next rebuild
+	if err := os.Remove(s.staleIndexPath()); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to rebuild index: %w", err)
+	}
+
+	// The keys found on disk are written in batches into the unused key bucket
+	var active, next []byte
+	err := index.db.Update(func(tx *bbolt.Tx) error {
+		// Values are only valid during the transaction
+		active = bytes.Clone(tx.Bucket(indexStateBucket).Get(indexActiveKey))
+		next = indexKeyBuckets[0]
+		if bytes.Equal(active, next) {
+			next = indexKeyBuckets[1]
+		}
+		if err := tx.DeleteBucket(next); err != nil && !errors.Is(err, bolterrors.ErrBucketNotFound) {
+			return err
+		}
+		_, err := tx.CreateBucket(next)
+		return err
+	})
+	batch := make(map[string][]byte, indexBatchSize)
+	flush := func() error {
+		err := index.db.Update(func(tx *bbolt.Tx) error {
+			keys := tx.Bucket(next)
+			for key, value := range batch {
+				if err := keys.Put([]byte(key), value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		clear(batch)
+		return err
+	}
+	if err == nil {
+		err = s.walkObjects("", func(obj Object) error {
+			value, err := json.Marshal(indexEntry{Size: obj.Size, LastModified: obj.LastModified.UTC(), ETag: obj.ETag})
+			if err != nil {
+				return err
+			}
+			batch[obj.Key] = value
+			if len(batch) >= indexBatchSize {
+				return flush()
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		err = flush()
+	}
+
+	// Both buckets are sorted, so they are compared in a single pass before switching
+	stats := &IndexStats{}
+	if err == nil {
+		err = index.db.Update(func(tx *bbolt.Tx) error {
+			oldKeys, newKeys := tx.Bucket(active).Cursor(), tx.Bucket(next).Cursor()
+			ok, ov := oldKeys.First()
+			nk, nv := newKeys.First()
+			for ok != nil || nk != nil {
+				switch c := compareKeys(ok, nk); {
+				case c < 0:
+					stats.Removed++
+					ok, ov = oldKeys.Next()
+				case c > 0:
+					stats.Added++
+					stats.Keys++
+					nk, nv = newKeys.Next()
+				default:
+					if !bytes.Equal(ov, nv) {
+						stats.Updated++
+					}
+					stats.Keys++
+					ok, ov = oldKeys.Next()
+					nk, nv = newKeys.Next()
+				}
+			}
+			if err := tx.Bucket(indexStateBucket).Put(indexActiveKey, next); err != nil {
+				return err
+			}
+			return tx.DeleteBucket(active)
+		})
+	}
+	if err != nil {
+		_ = s.MarkIndexStale()
+		return nil, fmt.Errorf("failed to rebuild index: %w", err)
+	}
+	return stats, nil
+}
+
+// compareKeys orders two keys of the buckets compared by RebuildIndex, where nil
+// stands for the end of a bucket and sorts last
+func compareKeys(a, b []byte) int {
+	switch {
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	default:
+		return bytes.Compare(a, b)
+	}
+}
+
+// activeKeys returns the key bucket in use, nil when there is none
+func activeKeys(tx *bbolt.Tx) *bbolt.Bucket {
+	state := tx.Bucket(indexStateBucket)
+	if state == nil {
+		return nil
+	}
+	name := state.Get(indexActiveKey)
+	if name == nil {
+		return nil
+	}
+	return tx.Bucket(name)
+}
+
+// refreshIndex rebuilds the index when another process marked it stale
+func (s *Storage) refreshIndex() error {
+	if _, err := os.Stat(s.staleIndexPath()); err != nil {
+		return nil
+	}
+	_, err := s.RebuildIndex()
+	return err
+}
+
+// indexKey updates the index after key changed, along with the folders containing it,
+// whose modification times changed too. Without an open index, an index left on disk
+// is marked stale. Failures mark the index stale rather than failing the write, which
+// already happened. The caller must hold the write lock.
+func (s *Storage) indexKey(key string) {
+	if s.index == nil {
+		if _, err := os.Stat(s.indexPath()); err == nil {
+			_ = s.MarkIndexStale()
+		}
+		return
+	}
+
+	err := s.index.db.Update(func(tx *bbolt.Tx) error {
+		keys := activeKeys(tx)
+		for k := key; k != ""; k = parentKey(k) {
+			entry := s.describeKey(k)
+			if entry == nil {
+				if err := keys.Delete([]byte(k)); err != nil {
+					return err
+				}
+				continue
+			}
+			value, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := keys.Put([]byte(k), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = s.MarkIndexStale()
+	}
+}
+
+// describeKey returns the index entry of the object or folder at key, nil when there is
+// none; the caller must hold the lock
+func (s *Storage) describeKey(key string) *indexEntry {
+	info, err := os.Stat(s.keyToPath(key))
+	if err != nil || info.IsDir() != strings.HasSuffix(key, "/") {
+		return nil
+	}
+	if info.IsDir() {
+		return &indexEntry{LastModified: info.ModTime().UTC(), ETag: generateETag(info)}
+	}
+
+	meta, err := s.readSidecar(key)
+	if err != nil {
+		return nil
+	}
+	obj := &Object{}
+	meta.describe(obj, info)
+	return &indexEntry{Size: obj.Size, LastModified: obj.LastModified.UTC(), ETag: obj.ETag}
+}
+
+// parentKey returns the key of the folder containing key, "" at the root of the bucket
+func parentKey(key string) string {
+	i := strings.LastIndex(strings.TrimSuffix(key, "/"), "/")
+	if i < 0 {
+		return ""
+	}
+	return key[:i+1]
+}
+
+// listIndex lists the objects and folders of the index under opts.Prefix, in key order
+func (s *Storage) listIndex(opts ListOptions) (*ListResult, error) {
+	if err := s.refreshIndex(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	index := s.index
+	s.mu.RUnlock()
+	if index == nil {
+		return nil, errors.New("metadata index is not open")
+	}
+
+	page := newPageBuilder(opts)
+	err := index.db.View(func(tx *bbolt.Tx) error {
+		c := activeKeys(tx).Cursor()
+		start := opts.Prefix
+		if opts.StartAfter > start {
+			start = opts.StartAfter
+		}
+		for k, v := c.Seek([]byte(start)); k != nil && bytes.HasPrefix(k, []byte(opts.Prefix)); {
+			key := string(k)
+			if key <= opts.StartAfter {
+				k, v = c.Next()
+				continue
+			}
+
+			// Keys sharing a common prefix are skipped at once
+			if commonPrefix, ok := page.commonPrefix(key); ok {
+				if !page.addPrefix(commonPrefix) {
+					return nil
+				}
+				end := prefixEnd(commonPrefix)
+				if end == "" {
+					return nil
+				}
+				k, v = c.Seek([]byte(end))
+				continue
+			}
+
+			var entry indexEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("invalid index entry for %s: %w", key, err)
+			}
+			obj := Object{
+				Key:          key,
+				Size:         entry.Size,
+				LastModified: entry.LastModified,
+				ContentType:  guessContentType(key),
+				ETag:         entry.ETag,
+			}
+			if !page.addObject(obj) {
+				return nil
+			}
+			k, v = c.Next()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list index: %w", err)
+	}
+	return page.result(), nil
+}
+
+// prefixEnd returns the smallest key greater than every key starting with prefix
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return "" // only reached for prefixes of 0xff bytes, which are not valid keys
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newIndexedStorage returns a Storage listing objects from its metadata index
+func newIndexedStorage(t *testing.T, dir string) *Storage {
+	t.Helper()
+
+	store, err := NewStorage(dir, "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if _, err := store.OpenIndex(); err != nil {
+		t.Fatalf("OpenIndex failed: %v", err)
+	}
+	t.Cleanup(func() { _ = store.CloseIndex() })
+	return store
+}
+
+// walkedKeys returns the keys found by walking the bucket, in key order
+func walkedKeys(t *testing.T, store *Storage) []string {
+	t.Helper()
+
+	walker, err := Open(store.basePath, store.bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := walker.ListObjectsPage(ListOptions{})
+	if err != nil {
+		t.Fatalf("ListObjectsPage failed: %v", err)
+	}
+	var keys []string
+	for _, obj := range page.Objects {
+		keys = append(keys, obj.Key)
+	}
+	return keys
+}
+
+func TestIndex_MatchesWalk(t *testing.T) {
+	store := newIndexedStorage(t, t.TempDir())
+	_ = store.SetVersioning(VersioningEnabled)
+
+	for _, key := range []string{"a.txt", "docs/2024/report.pdf", "docs/readme.md", "empty/", "logs/app.log"} {
+		if _, err := store.PutObject(key, "", strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.PutObject("docs/readme.md", "", strings.NewReader("longer data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteObject("logs/app.log"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteObject("empty/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnsurePublicDir("public/"); err != nil {
+		t.Fatal(err)
+	}
+
+	indexed := listKeys(t, store, "")
+	if walked := walkedKeys(t, store); !reflect.DeepEqual(indexed, walked) {
+		t.Errorf("index lists %v, the bucket holds %v", indexed, walked)
+	}
+	obj, err := store.HeadObject("docs/readme.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, _ := store.ListObjects("docs/readme.md")
+	if len(objects) != 1 || objects[0].Size != obj.Size || objects[0].ETag != obj.ETag {
+		t.Errorf("indexed entry %+v does not match %+v", objects, obj)
+	}
+
+	// Stats report the index as it is: nothing to change
+	stats, err := store.RebuildIndex()
+	if err != nil {
+		t.Fatalf("RebuildIndex failed: %v", err)
+	}
+	if *stats != (IndexStats{Keys: int64(len(indexed))}) {
+		t.Errorf("rebuild of an up to date index = %+v", stats)
+	}
+}
+
+func TestIndex_OtherProcessWrites(t *testing.T) {
+	dir := t.TempDir()
+	store := newIndexedStorage(t, dir)
+	if _, err := store.PutObject("old.txt", "", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another Storage on the same directory, as used by the command line
+	other, err := Open(dir, "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.OpenIndex(); !errors.Is(err, ErrIndexInUse) {
+		t.Fatalf("OpenIndex of an index in use = %v", err)
+	}
+	if _, err := other.PutObject("new.txt", "", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.DeleteObject("old.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.staleIndexPath()); err != nil {
+		t.Fatalf("index not marked stale: %v", err)
+	}
+
+	if got := listKeys(t, store, ""); !reflect.DeepEqual(got, []string{"new.txt"}) {
+		t.Errorf("listing after writes of another process = %v", got)
+	}
+	if _, err := os.Stat(store.staleIndexPath()); !os.IsNotExist(err) {
+		t.Errorf("stale marker not removed: %v", err)
+	}
+}
+
+func TestIndex_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	store := newIndexedStorage(t, dir)
+	for _, key := range []string{"a.txt", "b.txt"} {
+		if _, err := store.PutObject(key, "", strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.CloseIndex(); err != nil {
+		t.Fatalf("CloseIndex failed: %v", err)
+	}
+
+	// A cleanly closed index is used as is
+	stats, err := store.OpenIndex()
+	if err != nil || stats != nil {
+		t.Fatalf("OpenIndex of a clean index = %+v, %v", stats, err)
+	}
+
+	// An index not closed cleanly is rebuilt, as after a crash
+	if _, err := store.PutObject("c.txt", "", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	_ = store.index.db.Close()
+	store.index = nil
+	if err := os.Remove(store.keyToPath("a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = store.OpenIndex()
+	if err != nil {
+		t.Fatalf("OpenIndex failed: %v", err)
+	}
+	if stats == nil || *stats != (IndexStats{Keys: 2, Added: 2}) {
+		t.Errorf("rebuild after an unclean shutdown = %+v", stats)
+	}
+	if got := listKeys(t, store, ""); !reflect.DeepEqual(got, []string{"b.txt", "c.txt"}) {
+		t.Errorf("listing after rebuild = %v", got)
+	}
+}
+
+func TestIndex_RebuildStats(t *testing.T) {
+	dir := t.TempDir()
+	store := newIndexedStorage(t, dir)
+	for _, key := range []string{"keep.txt", "change.txt", "remove.txt"} {
+		if _, err := store.PutObject(key, "", strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Files changed behind the index
+	if err := os.WriteFile(store.keyToPath("change.txt"), []byte("changed data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(store.keyToPath("remove.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(store.keyToPath("added.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := store.RebuildIndex()
+	if err != nil {
+		t.Fatalf("RebuildIndex failed: %v", err)
+	}
+	if *stats != (IndexStats{Keys: 3, Added: 1, Updated: 1, Removed: 1}) {
+		t.Errorf("stats = %+v", stats)
+	}
+	objects, _ := store.ListObjects("change.txt")
+	if len(objects) != 1 || objects[0].Size != int64(len("changed data")) {
+		t.Errorf("changed entry = %+v", objects)
+	}
+}
+
+func TestIndex_DamagedFile(t *testing.T) {
+	dir := t.TempDir()
+	store := newIndexedStorage(t, dir)
+	if _, err := store.PutObject("a.txt", "", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CloseIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(store.indexPath(), []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.OpenIndex(); err != nil {
+		t.Fatalf("OpenIndex of a damaged index failed: %v", err)
+	}
+	if got := listKeys(t, store, ""); !reflect.DeepEqual(got, []string{"a.txt"}) {
+		t.Errorf("listing after recreating the index = %v", got)
+	}
+}
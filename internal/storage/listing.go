@@ -0,0 +1,166 @@
+package storage
+
+import "strings"
+
+// ListOptions selects a page of a listing, as in the S3 ListObjects APIs
+type ListOptions struct {
+	Prefix     string
+	Delimiter  string // keys containing it after the prefix are grouped into common prefixes
+	StartAfter string // only keys and common prefixes after it are listed
+	MaxKeys    int    // objects and common prefixes per page, 0 for no limit
+}
+
+// ListResult is a page of a listing, in key order
+type ListResult struct {
+	Objects        []Object
+	CommonPrefixes []string
+	IsTruncated    bool
+	NextMarker     string // last key or common prefix of a truncated page
+}
+
+// pageBuilder collects the entries of a listing page from keys visited in order
+type pageBuilder struct {
+	opts ListOptions
+	page ListResult
+	last string
+}
+
+// newPageBuilder starts a page of the listing described by opts
+func newPageBuilder(opts ListOptions) *pageBuilder {
+	return &pageBuilder{opts: opts}
+}
+
+// commonPrefix returns the common prefix grouping key, if any
+func (p *pageBuilder) commonPrefix(key string) (string, bool) {
+	if p.opts.Delimiter == "" {
+		return "", false
+	}
+	i := strings.Index(key[len(p.opts.Prefix):], p.opts.Delimiter)
+	if i < 0 {
+		return "", false
+	}
+	return key[:len(p.opts.Prefix)+i+len(p.opts.Delimiter)], true
+}
+
+// addPrefix adds a common prefix unless it was already listed, on this page or an
+// earlier one. It returns false once the page is full.
+func (p *pageBuilder) addPrefix(prefix string) bool {
+	if prefix <= p.opts.StartAfter || prefix == p.last {
+		return true
+	}
+	if p.full() {
+		return false
+	}
+	p.page.CommonPrefixes = append(p.page.CommonPrefixes, prefix)
+	p.last = prefix
+	return true
+}
+
+// addObject adds an object, returning false once the page is full
+func (p *pageBuilder) addObject(obj Object) bool {
+	if p.full() {
+		return false
+	}
+	p.page.Objects = append(p.page.Objects, obj)
+	p.last = obj.Key
+	return true
+}
+
+// full reports whether the page is full, marking it truncated since another entry follows
+func (p *pageBuilder) full() bool {
+	if p.opts.MaxKeys <= 0 || len(p.page.Objects)+len(p.page.CommonPrefixes) < p.opts.MaxKeys {
+		return false
+	}
+	p.page.IsTruncated = true
+	p.page.NextMarker = p.last
+	return true
+}
+
+// result returns the page
+func (p *pageBuilder) result() *ListResult {
+	return &p.page
+}
+
+// listPage returns the page of a listing described by opts from objects sorted by key
+func listPage(objects []Object, opts ListOptions) *ListResult {
+	page := newPageBuilder(opts)
+	for _, obj := range objects {
+		if !strings.HasPrefix(obj.Key, opts.Prefix) || obj.Key <= opts.StartAfter {
+			continue
+		}
+		added := true
+		if prefix, ok := page.commonPrefix(obj.Key); ok {
+			added = page.addPrefix(prefix)
+		} else {
+			added = page.addObject(obj)
+		}
+		if !added {
+			break
+		}
+	}
+	return page.result()
+}
+
+// VersionListOptions selects a page of a version listing, as in the S3 ListObjectVersions API
+type VersionListOptions struct {
+	Prefix          string // only versions of keys starting with this prefix are listed
+	KeyMarker       string // only versions of later keys are listed, unless VersionIDMarker is set
+	VersionIDMarker string // versions of KeyMarker older than this one are listed too
+	MaxKeys         int    // versions and delete markers per page, 0 for no limit
+}
+
+// VersionListResult is a page of a version listing
+type VersionListResult struct {
+	Versions            []Object
+	IsTruncated         bool
+	NextKeyMarker       string // key and version ID of the last entry of a truncated page
+	NextVersionIDMarker string
+}
+
+// versionPager builds a page of a version listing from versions added in listing order
+type versionPager struct {
+	opts     VersionListOptions
+	page     *VersionListResult
+	skipping bool
+}
+
+func newVersionPager(opts VersionListOptions) *versionPager {
+	return &versionPager{opts: opts, page: &VersionListResult{}, skipping: opts.VersionIDMarker != ""}
+}
+
+// add adds v to the page if it belongs there, and reports whether the page is complete
+func (p *versionPager) add(v Object) bool {
+	if v.Key < p.opts.KeyMarker || !strings.HasPrefix(v.Key, p.opts.Prefix) {
+		return false
+	}
+	if v.Key == p.opts.KeyMarker {
+		// Versions of the marker key are newest first: list those after the marker
+		if p.opts.VersionIDMarker == "" {
+			return false
+		}
+		if p.skipping {
+			p.skipping = v.VersionID != p.opts.VersionIDMarker
+			return false
+		}
+	}
+	if p.opts.MaxKeys > 0 && len(p.page.Versions) == p.opts.MaxKeys {
+		last := p.page.Versions[len(p.page.Versions)-1]
+		p.page.IsTruncated = true
+		p.page.NextKeyMarker = last.Key
+		p.page.NextVersionIDMarker = last.VersionID
+		return true
+	}
+	p.page.Versions = append(p.page.Versions, v)
+	return false
+}
+
+// versionsPage returns the page described by opts from versions listed by ListObjectVersions
+func versionsPage(versions []Object, opts VersionListOptions) *VersionListResult {
+	pager := newVersionPager(opts)
+	for _, v := range versions {
+		if pager.add(v) {
+			break
+		}
+	}
+	return pager.page
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pageKeys returns the keys and common prefixes of a listing page
+func pageKeys(page *ListResult) ([]string, []string) {
+	var keys []string
+	for _, obj := range page.Objects {
+		keys = append(keys, obj.Key)
+	}
+	return keys, page.CommonPrefixes
+}
+
+func TestListObjectsPage_Delimiter(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		for _, key := range []string{"a.txt", "docs/2024/report.pdf", "docs/readme.md", "docs-old.txt", "logs/app.log", "z.txt"} {
+			if _, err := b.PutObject(key, "", strings.NewReader("data")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		page, err := b.ListObjectsPage(ListOptions{Delimiter: "/"})
+		if err != nil {
+			t.Fatalf("ListObjectsPage failed: %v", err)
+		}
+		keys, prefixes := pageKeys(page)
+		if !reflect.DeepEqual(keys, []string{"a.txt", "docs-old.txt", "z.txt"}) || !reflect.DeepEqual(prefixes, []string{"docs/", "logs/"}) {
+			t.Errorf("root listing = %v %v", keys, prefixes)
+		}
+		if page.IsTruncated {
+			t.Error("complete listing reported as truncated")
+		}
+
+		page, _ = b.ListObjectsPage(ListOptions{Prefix: "docs/", Delimiter: "/"})
+		keys, prefixes = pageKeys(page)
+		if !reflect.DeepEqual(keys, []string{"docs/", "docs/readme.md"}) || !reflect.DeepEqual(prefixes, []string{"docs/2024/"}) {
+			t.Errorf("docs/ listing = %v %v", keys, prefixes)
+		}
+	})
+}
+
+func TestListObjectsPage_Pagination(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		for _, key := range []string{"a.txt", "b/1.txt", "b/2.txt", "c.txt", "d/1.txt"} {
+			if _, err := b.PutObject(key, "", strings.NewReader("data")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Common prefixes count towards the page size and are not listed twice
+		var keys, prefixes []string
+		opts := ListOptions{Delimiter: "/", MaxKeys: 2}
+		for pages := 0; ; pages++ {
+			if pages > 5 {
+				t.Fatal("listing does not end")
+			}
+			page, err := b.ListObjectsPage(opts)
+			if err != nil {
+				t.Fatalf("ListObjectsPage failed: %v", err)
+			}
+			if n := len(page.Objects) + len(page.CommonPrefixes); n > 2 {
+				t.Errorf("page of %d entries", n)
+			}
+			k, p := pageKeys(page)
+			keys, prefixes = append(keys, k...), append(prefixes, p...)
+			if !page.IsTruncated {
+				break
+			}
+			opts.StartAfter = page.NextMarker
+		}
+		if !reflect.DeepEqual(keys, []string{"a.txt", "c.txt"}) || !reflect.DeepEqual(prefixes, []string{"b/", "d/"}) {
+			t.Errorf("paginated listing = %v %v", keys, prefixes)
+		}
+
+		// Without a delimiter, every key is listed
+		page, _ := b.ListObjectsPage(ListOptions{Prefix: "b/", StartAfter: "b/1.txt", MaxKeys: 1})
+		if keys, _ := pageKeys(page); !reflect.DeepEqual(keys, []string{"b/2.txt"}) || page.IsTruncated {
+			t.Errorf("page after b/1.txt = %v, truncated %v", keys, page.IsTruncated)
+		}
+	})
+}
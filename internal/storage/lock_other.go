@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// lockFile takes an exclusive lock on f, which is not supported on this platform
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,827 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is a Backend keeping every object in memory. It behaves like the
+// filesystem backend (versioning, tags, folder markers, multipart uploads)
+// and is meant for tests and ephemeral environments: all data is lost on exit.
+// Server-side encryption is tracked (modes and SSE-C key checks) but the data
+// itself is kept in plaintext, since it never leaves the process.
+type Memory struct {
+	mu         sync.RWMutex
+	objects    map[string][]*memVersion // versions of each key, newest first
+	folders    map[string]time.Time     // folder markers, keys ending with "/"
+	versioning string
+	configs    map[string][]byte
+	uploads    map[string]*memUpload
+	masterKey  []byte
+	quotas     *quotaTracker
+}
+
+// memVersion is a version of an object (or a delete marker)
+type memVersion struct {
+	id           string // "" for objects written before versioning was ever enabled
+	deleteMarker bool
+	data         []byte
+	etag         string
+	lastModified time.Time
+	tags         map[string]string
+	encryption   string // SSES3, SSEC or ""
+	keyMD5       string // SSE-C key digest
+}
+
+// memUpload is an in-progress multipart upload
+type memUpload struct {
+	key       string
+	initiated time.Time
+	opts      PutOptions // CustomerKey is replaced by keyMD5
+	mode      string
+	keyMD5    string
+	parts     map[int]*memPart
+}
+
+// memPart is an uploaded part of a multipart upload
+type memPart struct {
+	data         []byte
+	etag         string
+	lastModified time.Time
+}
+
+// NewMemory creates an empty in-memory backend
+func NewMemory() *Memory {
+	return &Memory{
+		objects: make(map[string][]*memVersion),
+		folders: make(map[string]time.Time),
+		configs: make(map[string][]byte),
+		uploads: make(map[string]*memUpload),
+	}
+}
+
+// SetMasterKey implements Backend. Once set, new objects are recorded as SSE-S3 by default.
+func (m *Memory) SetMasterKey(key []byte) {
+	m.masterKey = key
+}
+
+// Close implements Backend. Memory holds no external resources.
+func (m *Memory) Close() error {
+	return nil
+}
+
+// versionID returns the ID a version is listed and addressed with
+func (v *memVersion) versionID() string {
+	if v.id == "" {
+		return nullVersionID
+	}
+	return v.id
+}
+
+// object converts a version to an Object
+func (v *memVersion) object(key string, latest bool) *Object {
+	versionID := v.versionID()
+	if latest {
+		// Like the filesystem backend, current objects written before versioning
+		// was ever enabled report no version ID
+		versionID = v.id
+	}
+	return &Object{
+		Key:            key,
+		Size:           int64(len(v.data)),
+		LastModified:   v.lastModified,
+		ContentType:    guessContentType(key),
+		ETag:           v.etag,
+		VersionID:      versionID,
+		IsLatest:       latest,
+		IsDeleteMarker: v.deleteMarker,
+		Tags:           v.tags,
+		Encryption:     v.encryption,
+		CustomerKeyMD5: v.keyMD5,
+	}
+}
+
+// GetObject retrieves an object
+func (m *Memory) GetObject(key string) (*Object, io.ReadCloser, error) {
+	return m.GetObjectVersion(key, "")
+}
+
+// GetObjectVersion retrieves a specific version of an object.
+// An empty versionID returns the current version.
+func (m *Memory) GetObjectVersion(key, versionID string) (*Object, io.ReadCloser, error) {
+	return m.GetObjectWithOptions(key, GetOptions{VersionID: versionID})
+}
+
+// GetObjectWithOptions retrieves a version of an object, checking the customer key
+// of the options when it was stored with SSE-C. The reader implements io.Seeker.
+func (m *Memory) GetObjectWithOptions(key string, opts GetOptions) (*Object, io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	v, latest, err := m.resolve(key, opts.VersionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	obj := v.object(key, latest)
+	if err := CheckCustomerKey(obj, opts.CustomerKey); err != nil {
+		return nil, nil, err
+	}
+	// Version data is never modified after it is written, so it can be read without the lock
+	return obj, readSeekNopCloser{bytes.NewReader(v.data)}, nil
+}
+
+// readSeekNopCloser adds a no-op Close to a bytes.Reader, keeping it seekable
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error {
+	return nil
+}
+
+// HeadObject retrieves object metadata without the body
+func (m *Memory) HeadObject(key string) (*Object, error) {
+	return m.HeadObjectVersion(key, "")
+}
+
+// HeadObjectVersion retrieves metadata of a specific version of an object
+func (m *Memory) HeadObjectVersion(key, versionID string) (*Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	v, latest, err := m.resolve(key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	return v.object(key, latest), nil
+}
+
+// resolve locates a version of key; the caller must hold the lock
+func (m *Memory) resolve(key, versionID string) (*memVersion, bool, error) {
+	if !validKey(key) {
+		return nil, false, ErrInvalidPath
+	}
+	if versionID != "" && !validVersionID(versionID) {
+		return nil, false, ErrInvalidVersionID
+	}
+
+	versions := m.objects[key]
+	if versionID == "" {
+		if len(versions) == 0 || versions[0].deleteMarker {
+			return nil, false, ErrNotFound
+		}
+		return versions[0], true, nil
+	}
+
+	for i, v := range versions {
+		if v.versionID() == versionID {
+			if v.deleteMarker {
+				return nil, false, ErrDeleteMarker
+			}
+			return v, i == 0, nil
+		}
+	}
+	return nil, false, ErrNoSuchVersion
+}
+
+// PutObject stores an object
+func (m *Memory) PutObject(key string, contentType string, body io.Reader) (*Object, error) {
+	return m.PutObjectWithOptions(key, body, PutOptions{ContentType: contentType})
+}
+
+// PutObjectWithOptions stores an object along with optional attributes such as tags
+func (m *Memory) PutObjectWithOptions(key string, body io.Reader, opts PutOptions) (*Object, error) {
+	if strings.HasSuffix(key, "/") {
+		return m.createFolderMarker(key)
+	}
+	if !validKey(key) {
+		return nil, ErrInvalidPath
+	}
+	if err := ValidateTags(opts.Tags); err != nil {
+		return nil, err
+	}
+	mode, err := encryptionMode(opts, m.masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.commit(key, data, opts, mode, customerKeyMD5(opts.CustomerKey))
+}
+
+// customerKeyMD5 returns the digest of an optional SSE-C key
+func customerKeyMD5(key []byte) string {
+	if key == nil {
+		return ""
+	}
+	return CustomerKeyMD5(key)
+}
+
+// commit stores data as the new current version of key, recording its encryption mode
+// and SSE-C key digest. The caller must hold the write lock.
+func (m *Memory) commit(key string, data []byte, opts PutOptions, mode, keyMD5 string) (*Object, error) {
+	before, after := m.currentSize(key), currentSize{size: int64(len(data)), exists: true}
+	if err := m.quotas.check(key, before, after); err != nil {
+		return nil, err
+	}
+	m.quotas.apply(key, before, after)
+
+	sum := md5.Sum(data)
+	v := &memVersion{
+		id:           m.prepareOverwrite(key),
+		data:         data,
+		etag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+		lastModified: time.Now(),
+		tags:         copyTags(opts.Tags),
+		encryption:   mode,
+		keyMD5:       keyMD5,
+	}
+	m.objects[key] = append([]*memVersion{v}, m.objects[key]...)
+	m.addParentFolders(key)
+
+	obj := v.object(key, true)
+	if opts.ContentType != "" {
+		obj.ContentType = opts.ContentType
+	}
+	return obj, nil
+}
+
+// prepareOverwrite makes room for a new current version of key according to the
+// versioning status and returns its version ID. The caller must hold the write lock.
+func (m *Memory) prepareOverwrite(key string) string {
+	switch m.versioning {
+	case VersioningEnabled:
+		return newVersionID()
+	case VersioningSuspended:
+		// A suspended bucket keeps a single "null" version that is replaced in place
+		m.removeVersion(key, nullVersionID)
+		return nullVersionID
+	default:
+		delete(m.objects, key)
+		return ""
+	}
+}
+
+// removeVersion drops a version of key, reporting whether it existed.
+// The caller must hold the write lock.
+func (m *Memory) removeVersion(key, versionID string) (*memVersion, bool) {
+	versions := m.objects[key]
+	for i, v := range versions {
+		if v.versionID() != versionID {
+			continue
+		}
+		versions = append(versions[:i:i], versions[i+1:]...)
+		if len(versions) == 0 {
+			delete(m.objects, key)
+		} else {
+			m.objects[key] = versions
+		}
+		return v, true
+	}
+	return nil, false
+}
+
+// createFolderMarker records a folder marker (key ending with "/")
+func (m *Memory) createFolderMarker(key string) (*Object, error) {
+	if !validKey(strings.TrimSuffix(key, "/")) {
+		return nil, ErrInvalidPath
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.addParentFolders(key)
+	return &Object{
+		Key:          key,
+		LastModified: m.folders[key],
+		ContentType:  "application/x-directory",
+		ETag:         `"` + hex.EncodeToString(md5.New().Sum(nil)) + `"`,
+	}, nil
+}
+
+// addParentFolders records every folder containing key (and key itself when it is a folder),
+// mirroring the directories the filesystem backend creates. The caller must hold the write lock.
+func (m *Memory) addParentFolders(key string) {
+	now := time.Now()
+	for i := 0; i < len(key); i++ {
+		if key[i] != '/' {
+			continue
+		}
+		if _, ok := m.folders[key[:i+1]]; !ok {
+			m.folders[key[:i+1]] = now
+		}
+	}
+}
+
+// CopyObject copies a version of an object (the current one when src has no version ID)
+// to dstKey. A nil opts keeps the source tags; otherwise opts replaces them.
+func (m *Memory) CopyObject(srcKey string, src GetOptions, dstKey string, opts *PutOptions) (*Object, error) {
+	return copyObject(m, srcKey, src, dstKey, opts)
+}
+
+// DeleteObject removes an object
+func (m *Memory) DeleteObject(key string) error {
+	_, err := m.DeleteObjectVersion(key, "")
+	return err
+}
+
+// DeleteObjectVersion deletes an object according to the bucket versioning status.
+// Without a version ID, versioned buckets keep the data and add a delete marker;
+// with a version ID, that version (or delete marker) is permanently removed.
+func (m *Memory) DeleteObjectVersion(key, versionID string) (*DeleteResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if strings.HasSuffix(key, "/") {
+		m.deleteFolderMarker(key)
+		return &DeleteResult{}, nil
+	}
+	if !validKey(key) {
+		return nil, ErrInvalidPath
+	}
+
+	before := m.currentSize(key)
+	defer func() { m.quotas.apply(key, before, m.currentSize(key)) }()
+
+	if versionID != "" {
+		if !validVersionID(versionID) {
+			return nil, ErrInvalidVersionID
+		}
+		// S3 treats deleting a version that does not exist as a success
+		removed, _ := m.removeVersion(key, versionID)
+		return &DeleteResult{VersionID: versionID, DeleteMarker: removed != nil && removed.deleteMarker}, nil
+	}
+
+	switch m.versioning {
+	case VersioningEnabled:
+		marker := &memVersion{id: newVersionID(), deleteMarker: true, lastModified: time.Now()}
+		m.objects[key] = append([]*memVersion{marker}, m.objects[key]...)
+		return &DeleteResult{VersionID: marker.id, DeleteMarker: true}, nil
+	case VersioningSuspended:
+		// The null version is replaced by a null delete marker; other versions are kept
+		m.removeVersion(key, nullVersionID)
+		marker := &memVersion{id: nullVersionID, deleteMarker: true, lastModified: time.Now()}
+		m.objects[key] = append([]*memVersion{marker}, m.objects[key]...)
+		return &DeleteResult{VersionID: nullVersionID, DeleteMarker: true}, nil
+	default:
+		delete(m.objects, key)
+		return &DeleteResult{}, nil
+	}
+}
+
+// deleteFolderMarker removes a folder marker unless it still contains objects or folders,
+// like removing a non-empty directory fails. The caller must hold the write lock.
+func (m *Memory) deleteFolderMarker(key string) {
+	for k, versions := range m.objects {
+		if strings.HasPrefix(k, key) && len(versions) > 0 && !versions[0].deleteMarker {
+			return
+		}
+	}
+	for k := range m.folders {
+		if k != key && strings.HasPrefix(k, key) {
+			return
+		}
+	}
+	delete(m.folders, key)
+}
+
+// ListObjects returns the current objects and folder markers, sorted by key
+func (m *Memory) ListObjects(prefix string) ([]Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var objects []Object
+	for key, versions := range m.objects {
+		if !strings.HasPrefix(key, prefix) || versions[0].deleteMarker {
+			continue
+		}
+		obj := versions[0].object(key, true)
+		objects = append(objects, Object{
+			Key:          obj.Key,
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+			ContentType:  obj.ContentType,
+			ETag:         obj.ETag,
+		})
+	}
+	for key, modified := range m.folders {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, *m.folderObject(key, modified))
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// ListObjectsPage returns a page of the objects under opts.Prefix in key order, grouping
+// keys by opts.Delimiter
+func (m *Memory) ListObjectsPage(opts ListOptions) (*ListResult, error) {
+	objects, err := m.ListObjects(opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return listPage(objects, opts), nil
+}
+
+// Usage counts the current objects and the bytes held by every version and upload part
+func (m *Memory) Usage() (*Usage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	usage := &Usage{}
+	for _, versions := range m.objects {
+		if !versions[0].deleteMarker {
+			usage.Objects++
+		}
+		for _, v := range versions {
+			usage.Bytes += int64(len(v.data))
+		}
+	}
+	for _, upload := range m.uploads {
+		for _, part := range upload.parts {
+			usage.Bytes += int64(len(part.data))
+		}
+	}
+	return usage, nil
+}
+
+// SetQuotas implements Backend
+func (m *Memory) SetQuotas(quotas []Quota) error {
+	objects, err := m.ListObjects("")
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.quotas = newQuotaTracker(quotas, objects)
+	return nil
+}
+
+// CheckQuota implements Backend
+func (m *Memory) CheckQuota(key string, size int64) error {
+	if strings.HasSuffix(key, "/") {
+		return nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.quotas.check(key, m.currentSize(key), currentSize{size: size, exists: true})
+}
+
+// QuotaUsage implements Backend
+func (m *Memory) QuotaUsage() []QuotaUsage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.quotas.snapshot()
+}
+
+// currentSize describes the current object at key; the caller must hold the lock
+func (m *Memory) currentSize(key string) currentSize {
+	versions := m.objects[key]
+	if len(versions) == 0 || versions[0].deleteMarker {
+		return currentSize{}
+	}
+	return currentSize{size: int64(len(versions[0].data)), exists: true}
+}
+
+// folderObject describes a folder marker
+func (m *Memory) folderObject(key string, modified time.Time) *Object {
+	return &Object{
+		Key:          key,
+		LastModified: modified,
+		ContentType:  guessContentType(key),
+		ETag:         `"` + hex.EncodeToString(md5.New().Sum(nil)) + `"`,
+	}
+}
+
+// ListObjectVersions returns every version and delete marker in the bucket,
+// sorted by key and then newest first
+func (m *Memory) ListObjectVersions(prefix string) ([]Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var versions []Object
+	for key, modified := range m.folders {
+		if strings.HasPrefix(key, prefix) {
+			obj := m.folderObject(key, modified)
+			obj.VersionID = nullVersionID
+			obj.IsLatest = true
+			versions = append(versions, *obj)
+		}
+	}
+	for key, entries := range m.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for i, v := range entries {
+			obj := v.object(key, i == 0)
+			obj.VersionID = v.versionID()
+			versions = append(versions, *obj)
+		}
+	}
+
+	// Keep the per-key newest-first order while sorting keys
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].Key < versions[j].Key })
+	return versions, nil
+}
+
+// ListObjectVersionsPage returns the page of versions and delete markers described by opts
+func (m *Memory) ListObjectVersionsPage(opts VersionListOptions) (*VersionListResult, error) {
+	versions, err := m.ListObjectVersions(opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return versionsPage(versions, opts), nil
+}
+
+// CreateMultipartUpload starts a multipart upload for key
+func (m *Memory) CreateMultipartUpload(key string, opts PutOptions) (*Upload, error) {
+	if strings.HasSuffix(key, "/") || !validKey(key) {
+		return nil, ErrInvalidPath
+	}
+	if err := ValidateTags(opts.Tags); err != nil {
+		return nil, err
+	}
+	mode, err := encryptionMode(opts, m.masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload := &Upload{
+		ID:             newVersionID(),
+		Key:            key,
+		Initiated:      time.Now(),
+		Encryption:     mode,
+		CustomerKeyMD5: customerKeyMD5(opts.CustomerKey),
+	}
+	m.uploads[upload.ID] = &memUpload{
+		key:       key,
+		initiated: upload.Initiated,
+		opts:      PutOptions{ContentType: opts.ContentType, Tags: copyTags(opts.Tags)},
+		mode:      mode,
+		keyMD5:    upload.CustomerKeyMD5,
+		parts:     make(map[int]*memPart),
+	}
+	return upload, nil
+}
+
+// UploadPart stores one part of a multipart upload, replacing a previous part with the same number.
+// Parts of uploads created with an SSE-C key must be sent with the same customerKey.
+func (m *Memory) UploadPart(key, uploadID string, partNumber int, body io.Reader, customerKey []byte) (*Part, error) {
+	if partNumber < 1 || partNumber > MaxPartNumber {
+		return nil, ErrInvalidPart
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, err := m.upload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckCustomerKey(&Object{Encryption: upload.mode, CustomerKeyMD5: upload.keyMD5}, customerKey); err != nil {
+		return nil, err
+	}
+
+	sum := md5.Sum(data)
+	part := &memPart{data: data, etag: `"` + hex.EncodeToString(sum[:]) + `"`, lastModified: time.Now()}
+	upload.parts[partNumber] = part
+
+	return &Part{PartNumber: partNumber, Size: int64(len(data)), ETag: part.etag, LastModified: part.lastModified}, nil
+}
+
+// ListParts returns the uploaded parts of a multipart upload, sorted by part number
+func (m *Memory) ListParts(key, uploadID string) ([]Part, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	upload, err := m.upload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]Part, 0, len(upload.parts))
+	for number, part := range upload.parts {
+		parts = append(parts, Part{
+			PartNumber:   number,
+			Size:         int64(len(part.data)),
+			ETag:         part.etag,
+			LastModified: part.lastModified,
+		})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+// CompleteMultipartUpload assembles the given parts into the final object
+func (m *Memory) CompleteMultipartUpload(key, uploadID string, parts []CompletedPart) (*Object, error) {
+	if err := validatePartOrder(parts); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, err := m.upload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	var data bytes.Buffer
+	for i, requested := range parts {
+		part, ok := upload.parts[requested.PartNumber]
+		if !ok || !sameETag(part.etag, requested.ETag) {
+			return nil, ErrInvalidPart
+		}
+		if i < len(parts)-1 && int64(len(part.data)) < minPartSize {
+			return nil, ErrEntityTooSmall
+		}
+		data.Write(part.data)
+	}
+
+	obj, err := m.commit(key, data.Bytes(), upload.opts, upload.mode, upload.keyMD5)
+	if err != nil {
+		return nil, err
+	}
+	delete(m.uploads, uploadID)
+	return obj, nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (m *Memory) AbortMultipartUpload(key, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.upload(key, uploadID); err != nil {
+		return err
+	}
+	delete(m.uploads, uploadID)
+	return nil
+}
+
+// ListMultipartUploads returns the in-progress multipart uploads, sorted by key and initiation time
+func (m *Memory) ListMultipartUploads(prefix string) ([]Upload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var uploads []Upload
+	for id, upload := range m.uploads {
+		if strings.HasPrefix(upload.key, prefix) {
+			uploads = append(uploads, Upload{ID: id, Key: upload.key, Initiated: upload.initiated, Encryption: upload.mode, CustomerKeyMD5: upload.keyMD5})
+		}
+	}
+	sortUploads(uploads)
+	return uploads, nil
+}
+
+// upload returns an in-progress upload of key; the caller must hold the lock
+func (m *Memory) upload(key, uploadID string) (*memUpload, error) {
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.key != key {
+		return nil, ErrNoSuchUpload
+	}
+	return upload, nil
+}
+
+// PutObjectTagging replaces the tags of a version of an object (the current
+// version when versionID is empty) and returns the version ID that was tagged
+func (m *Memory) PutObjectTagging(key, versionID string, tags map[string]string) (string, error) {
+	if err := ValidateTags(tags); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.setTags(key, versionID, tags)
+}
+
+// DeleteObjectTagging removes all tags from a version of an object
+// and returns the version ID that was updated
+func (m *Memory) DeleteObjectTagging(key, versionID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.setTags(key, versionID, nil)
+}
+
+// setTags stores the tags of a version of key; the caller must hold the write lock
+func (m *Memory) setTags(key, versionID string, tags map[string]string) (string, error) {
+	v, latest, err := m.resolve(key, versionID)
+	if err != nil {
+		return "", err
+	}
+	v.tags = copyTags(tags)
+	return v.object(key, latest).VersionID, nil
+}
+
+// GetVersioning returns the bucket versioning status ("" if it was never enabled)
+func (m *Memory) GetVersioning() (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.versioning, nil
+}
+
+// SetVersioning enables or suspends versioning
+func (m *Memory) SetVersioning(status string) error {
+	if status != VersioningEnabled && status != VersioningSuspended {
+		return fmt.Errorf("invalid versioning status: %q", status)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.versioning = status
+	return nil
+}
+
+// GetBucketConfig returns a stored bucket configuration document
+func (m *Memory) GetBucketConfig(name string) ([]byte, error) {
+	if !bucketConfigNameRegex.MatchString(name) {
+		return nil, fmt.Errorf("invalid bucket configuration name: %q", name)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, ok := m.configs[name]
+	if !ok {
+		return nil, ErrNoSuchConfig
+	}
+	return bytes.Clone(data), nil
+}
+
+// PutBucketConfig stores a bucket configuration document, replacing any previous one
+func (m *Memory) PutBucketConfig(name string, data []byte) error {
+	if !bucketConfigNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid bucket configuration name: %q", name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.configs[name] = bytes.Clone(data)
+	return nil
+}
+
+// DeleteBucketConfig removes a bucket configuration document
+func (m *Memory) DeleteBucketConfig(name string) error {
+	if !bucketConfigNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid bucket configuration name: %q", name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.configs, name)
+	return nil
+}
+
+// EnsurePublicDir creates the folder marker of the public prefix
+func (m *Memory) EnsurePublicDir(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	_, err := m.createFolderMarker(strings.TrimSuffix(prefix, "/") + "/")
+	return err
+}
+
+// copyTags returns a copy of tags, or nil when there are none
+func copyTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	return copied
+}
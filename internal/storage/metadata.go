@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// metaDirName is the hidden directory (next to the bucket directories) that
+// holds sidecar metadata, non-current versions and bucket configuration.
+// Leading dots are not valid in S3 bucket names, so it can never clash with a bucket.
+const metaDirName = ".selfhost_s3"
+
+// objectMeta is the sidecar metadata persisted alongside an object or version
+type objectMeta struct {
+	Key            string    `json:"key"`
+	VersionID      string    `json:"versionId,omitempty"`
+	IsDeleteMarker bool      `json:"deleteMarker,omitempty"`
+	Size           int64     `json:"size,omitempty"`
+	ETag           string    `json:"etag,omitempty"`
+	LastModified   time.Time `json:"lastModified,omitzero"`
+
+	Tags map[string]string `json:"tags,omitempty"`
+
+	Encryption  *encryptionInfo  `json:"encryption,omitempty"`  // nil for plaintext data
+	Compression *compressionInfo `json:"compression,omitempty"` // nil for data stored as is
+	Blob        string           `json:"blob,omitempty"`        // SHA-256 of deduplicated data
+	Checksum    string           `json:"checksum,omitempty"`    // SHA-256 of the stored data, verified by Scrub
+
+	isCurrent bool // set for current objects when listing versions
+}
+
+// dataSize returns the size of the object as seen by clients, given the size of its file
+func (m *objectMeta) dataSize(fileSize int64) int64 {
+	switch {
+	case m.Compression != nil:
+		return m.Compression.Size
+	case m.Encryption != nil:
+		return m.Encryption.Size
+	default:
+		return fileSize
+	}
+}
+
+// modTime returns the modification time of the object stored in the file described by
+// info. Files sharing a blob have the time of the first object, so each records its own.
+func (m *objectMeta) modTime(info os.FileInfo) time.Time {
+	if !m.LastModified.IsZero() {
+		return m.LastModified
+	}
+	return info.ModTime()
+}
+
+// describe sets the size, modification time, ETag and encryption of obj, the current
+// object stored in the file described by info. Sizes and ETags are those of the original
+// data, so they do not depend on how the data is stored.
+func (m *objectMeta) describe(obj *Object, info os.FileInfo) {
+	obj.Size = m.dataSize(info.Size())
+	obj.LastModified = m.modTime(info)
+	obj.ETag = objectETag(obj.LastModified, obj.Size)
+	m.Encryption.describe(obj)
+}
+
+// metaPath returns the hidden metadata directory for the bucket
+func (s *Storage) metaPath(elem ...string) string {
+	return filepath.Join(append([]string{s.basePath, metaDirName, s.bucket}, elem...)...)
+}
+
+// InternalPath returns a path inside the bucket's hidden metadata directory,
+// for components that persist their own state next to the data (e.g. the event queue)
+func (s *Storage) InternalPath(elem ...string) string {
+	return s.metaPath(elem...)
+}
+
+// keyHash returns a filesystem-safe identifier for a key.
+// Hashing avoids clashes between keys like "a" and "a/b" in the metadata tree.
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// sidecarPath returns the path of the sidecar metadata file for the current version of key
+func (s *Storage) sidecarPath(key string) string {
+	h := keyHash(key)
+	return s.metaPath("objects", h[:2], h+".json")
+}
+
+// readSidecar loads the sidecar metadata of the current version of key.
+// Objects written before sidecars existed (or placed on disk directly) have none,
+// in which case an empty objectMeta is returned.
+func (s *Storage) readSidecar(key string) (*objectMeta, error) {
+	meta, err := readMetaFile(s.sidecarPath(key))
+	if os.IsNotExist(err) {
+		return &objectMeta{Key: key}, nil
+	}
+	return meta, err
+}
+
+// writeSidecar persists the sidecar metadata of the current version of key
+func (s *Storage) writeSidecar(meta *objectMeta) error {
+	return writeMetaFile(s.sidecarPath(meta.Key), meta)
+}
+
+// removeSidecar deletes the sidecar metadata of key, ignoring missing files
+func (s *Storage) removeSidecar(key string) error {
+	if err := os.Remove(s.sidecarPath(key)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove metadata: %w", err)
+	}
+	return nil
+}
+
+// readMetaFile decodes a metadata JSON file
+func readMetaFile(path string) (*objectMeta, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta objectMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata %s: %w", path, err)
+	}
+	return &meta, nil
+}
+
+// writeMetaFile encodes a metadata JSON file, creating parent directories as needed
+func writeMetaFile(path string, meta *objectMeta) error {
+	return writeJSONFile(path, meta)
+}
+
+// WriteFileAtomic writes data to a temporary file and renames it into place,
+// so readers never observe a partially written file. Parent directories are created.
+func WriteFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	return nil
+}
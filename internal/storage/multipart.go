@@ -0,0 +1,391 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uploadIDRegex matches upload IDs generated by CreateMultipartUpload
+var uploadIDRegex = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// uploadMeta is persisted in the directory of a multipart upload
+type uploadMeta struct {
+	Key         string            `json:"key"`
+	Initiated   time.Time         `json:"initiated"`
+	ContentType string            `json:"contentType,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+
+	Encryption  *encryptionInfo  `json:"encryption,omitempty"`  // data key shared by all parts
+	Compression *compressionInfo `json:"compression,omitempty"` // set when the parts are compressed
+}
+
+// partMeta is persisted next to the data of an uploaded part
+type partMeta struct {
+	Size         int64     `json:"size"` // original size of encrypted or compressed parts
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"lastModified"`
+
+	Compression *compressionInfo `json:"compression,omitempty"` // frames of a compressed part
+	Salt        []byte           `json:"salt,omitempty"`        // derives the key of an encrypted part
+}
+
+// uploadDir returns the directory holding the parts of a multipart upload
+func (s *Storage) uploadDir(uploadID string) string {
+	return s.metaPath("multipart", uploadID)
+}
+
+// partPath returns the data path of a part; zero-padding keeps parts sorted by name
+func (s *Storage) partPath(uploadID string, partNumber int) string {
+	return filepath.Join(s.uploadDir(uploadID), fmt.Sprintf("%05d", partNumber))
+}
+
+// CreateMultipartUpload starts a multipart upload for key
+func (s *Storage) CreateMultipartUpload(key string, opts PutOptions) (*Upload, error) {
+	if strings.HasSuffix(key, "/") {
+		return nil, ErrInvalidPath
+	}
+	if err := s.validatePath(s.keyToPath(key)); err != nil {
+		return nil, err
+	}
+	if err := ValidateTags(opts.Tags); err != nil {
+		return nil, err
+	}
+
+	enc, _, err := newEncryption(opts, s.masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &Upload{ID: newVersionID(), Key: key, Initiated: time.Now()}
+	if enc != nil {
+		upload.Encryption, upload.CustomerKeyMD5 = enc.Mode, enc.KeyMD5
+	}
+	meta := uploadMeta{
+		Key:         key,
+		Initiated:   upload.Initiated,
+		ContentType: opts.ContentType,
+		Tags:        opts.Tags,
+		Encryption:  enc,
+		Compression: newCompression(s.compression, key, opts.ContentType),
+	}
+	if err := writeJSONFile(filepath.Join(s.uploadDir(upload.ID), "upload.json"), meta); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// UploadPart stores one part of a multipart upload, replacing a previous part with the same number.
+// Parts of uploads created with an SSE-C key must be sent with the same customerKey.
+func (s *Storage) UploadPart(key, uploadID string, partNumber int, body io.Reader, customerKey []byte) (*Part, error) {
+	if partNumber < 1 || partNumber > MaxPartNumber {
+		return nil, ErrInvalidPart
+	}
+	meta, err := s.readUpload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each upload of a part is sealed with its own key, since parts may be uploaded again
+	var dataKey, salt []byte
+	if meta.Encryption != nil {
+		if dataKey, err = meta.Encryption.dataKey(s.masterKey, customerKey); err != nil {
+			return nil, err
+		}
+		if salt, err = newSegmentSalt(); err != nil {
+			return nil, err
+		}
+		dataKey = segmentKey(dataKey, salt)
+	} else if customerKey != nil {
+		return nil, ErrCustomerKeyNotApplicable
+	}
+
+	// Each part is compressed into its own frames, which the completed object concatenates
+	var comp *compressionInfo
+	if meta.Compression != nil {
+		comp = &compressionInfo{Algorithm: meta.Compression.Algorithm}
+	}
+
+	hash := md5.New()
+	tmpPath, size, err := s.writeTempFile(io.TeeReader(body, hash), dataKey, uint32(partNumber), comp, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The upload may have been completed or aborted while the part was uploading
+	if _, err := s.readUpload(key, uploadID); err != nil {
+		return nil, err
+	}
+
+	path := s.partPath(uploadID, partNumber)
+	if err := os.Rename(tmpPath, path); err != nil {
+		return nil, fmt.Errorf("failed to store part: %w", err)
+	}
+
+	part := &Part{
+		PartNumber:   partNumber,
+		Size:         size,
+		ETag:         `"` + hex.EncodeToString(hash.Sum(nil)) + `"`,
+		LastModified: time.Now(),
+	}
+	if err := writeJSONFile(path+".json", partMeta{Size: part.Size, ETag: part.ETag, LastModified: part.LastModified, Compression: comp, Salt: salt}); err != nil {
+		return nil, err
+	}
+	return part, nil
+}
+
+// ListParts returns the uploaded parts of a multipart upload, sorted by part number
+func (s *Storage) ListParts(key, uploadID string) ([]Part, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.readUpload(key, uploadID); err != nil {
+		return nil, err
+	}
+	return s.listParts(uploadID)
+}
+
+// listParts reads the part metadata of an upload; the caller must hold the lock
+func (s *Storage) listParts(uploadID string) ([]Part, error) {
+	files, err := filepath.Glob(filepath.Join(s.uploadDir(uploadID), "[0-9]*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parts: %w", err)
+	}
+
+	parts := make([]Part, 0, len(files))
+	for _, file := range files {
+		number, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			continue
+		}
+		var meta partMeta
+		if err := readJSONFile(file, &meta); err != nil {
+			return nil, err
+		}
+		parts = append(parts, Part{PartNumber: number, Size: meta.Size, ETag: meta.ETag, LastModified: meta.LastModified, compression: meta.Compression, salt: meta.Salt})
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+// CompleteMultipartUpload assembles the given parts into the final object
+func (s *Storage) CompleteMultipartUpload(key, uploadID string, parts []CompletedPart) (*Object, error) {
+	if err := validatePartOrder(parts); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	meta, err := s.readUpload(key, uploadID)
+	var uploaded map[int]Part
+	if err == nil {
+		uploaded, err = s.checkParts(uploadID, parts)
+	}
+	if err == nil && s.quotas != nil {
+		// Fail before assembling the parts; the quotas are checked again on commit
+		var total int64
+		for _, part := range parts {
+			total += uploaded[part.PartNumber].Size
+		}
+		err = s.quotas.check(key, s.currentSize(key), currentSize{size: total, exists: true})
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Concatenate the parts without holding the lock
+	readers := make([]io.Reader, 0, len(parts))
+	for _, part := range parts {
+		file, err := os.Open(s.partPath(uploadID, part.PartNumber))
+		if err != nil {
+			return nil, ErrInvalidPart
+		}
+		defer func() { _ = file.Close() }()
+		readers = append(readers, file)
+	}
+
+	// Encrypted and compressed parts are concatenated as they are, each part being
+	// a segment of the encrypted object and a run of frames of the compressed one
+	sum := sha256.New()
+	tmpPath, size, err := s.writeTempFile(io.MultiReader(readers...), nil, 0, nil, sum)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	stored := &objectMeta{Encryption: meta.Encryption, Compression: meta.Compression, Checksum: hashString(sum)}
+	var expected int64
+	for _, part := range parts {
+		uploadedPart := uploaded[part.PartNumber]
+		partSize := uploadedPart.Size
+		if comp := stored.Compression; comp != nil {
+			if uploadedPart.compression == nil {
+				return nil, ErrInvalidPart
+			}
+			comp.append(uploadedPart.compression)
+			partSize = uploadedPart.compression.storedSize()
+		}
+		if enc := stored.Encryption; enc != nil {
+			enc.Segments = append(enc.Segments, segment{ID: uint32(part.PartNumber), Size: partSize, Salt: uploadedPart.salt})
+			enc.Size += partSize
+			partSize = encryptedSize(partSize)
+		}
+		expected += partSize
+	}
+	if expected != size {
+		return nil, ErrInvalidPart // a part was replaced while the parts were assembled
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.readUpload(key, uploadID); err != nil {
+		return nil, err
+	}
+
+	obj, err := s.commitFile(key, tmpPath, PutOptions{ContentType: meta.ContentType, Tags: meta.Tags}, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.RemoveAll(s.uploadDir(uploadID)); err != nil {
+		return nil, fmt.Errorf("failed to clean up upload: %w", err)
+	}
+	return obj, nil
+}
+
+// checkParts verifies that the requested parts exist with matching ETags and
+// that all but the last meet the minimum part size, and returns the uploaded parts by
+// number. The caller must hold the lock.
+func (s *Storage) checkParts(uploadID string, parts []CompletedPart) (map[int]Part, error) {
+	uploaded, err := s.listParts(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	byNumber := make(map[int]Part, len(uploaded))
+	for _, part := range uploaded {
+		byNumber[part.PartNumber] = part
+	}
+
+	for i, requested := range parts {
+		part, ok := byNumber[requested.PartNumber]
+		if !ok || !sameETag(part.ETag, requested.ETag) {
+			return nil, ErrInvalidPart
+		}
+		if i < len(parts)-1 && part.Size < minPartSize {
+			return nil, ErrEntityTooSmall
+		}
+	}
+	return byNumber, nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (s *Storage) AbortMultipartUpload(key, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.readUpload(key, uploadID); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.uploadDir(uploadID)); err != nil {
+		return fmt.Errorf("failed to abort upload: %w", err)
+	}
+	return nil
+}
+
+// ListMultipartUploads returns the in-progress multipart uploads, sorted by key and initiation time
+func (s *Storage) ListMultipartUploads(prefix string) ([]Upload, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries, err := os.ReadDir(s.metaPath("multipart"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list uploads: %w", err)
+	}
+
+	var uploads []Upload
+	for _, entry := range entries {
+		if !entry.IsDir() || !uploadIDRegex.MatchString(entry.Name()) {
+			continue
+		}
+		var meta uploadMeta
+		if err := readJSONFile(filepath.Join(s.uploadDir(entry.Name()), "upload.json"), &meta); err != nil {
+			continue // being created or removed concurrently
+		}
+		if !strings.HasPrefix(meta.Key, prefix) {
+			continue
+		}
+		uploads = append(uploads, Upload{ID: entry.Name(), Key: meta.Key, Initiated: meta.Initiated})
+	}
+
+	sortUploads(uploads)
+	return uploads, nil
+}
+
+// readUpload loads the metadata of a multipart upload and checks it belongs to key
+func (s *Storage) readUpload(key, uploadID string) (*uploadMeta, error) {
+	if !uploadIDRegex.MatchString(uploadID) {
+		return nil, ErrNoSuchUpload
+	}
+
+	var meta uploadMeta
+	if err := readJSONFile(filepath.Join(s.uploadDir(uploadID), "upload.json"), &meta); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoSuchUpload
+		}
+		return nil, err
+	}
+	if meta.Key != key {
+		return nil, ErrNoSuchUpload
+	}
+	return &meta, nil
+}
+
+// sortUploads orders uploads by key, then oldest first
+func sortUploads(uploads []Upload) {
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].Initiated.Before(uploads[j].Initiated)
+	})
+}
+
+// readJSONFile decodes a JSON file into v
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSONFile atomically writes v as JSON, creating parent directories as needed
+func writeJSONFile(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	return WriteFileAtomic(path, data)
+}
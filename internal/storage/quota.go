@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Quota limits the current objects stored under a key prefix. Non-current versions,
+// delete markers, folder markers and incomplete uploads are not counted.
+type Quota struct {
+	Prefix     string // "" for the whole bucket
+	MaxBytes   int64  // 0 for no limit
+	MaxObjects int64  // 0 for no limit
+}
+
+// QuotaUsage is the usage of a quota
+type QuotaUsage struct {
+	Quota
+	Bytes   int64
+	Objects int64
+}
+
+// currentSize describes the current object at a key, before or after a write
+type currentSize struct {
+	size   int64
+	exists bool
+}
+
+// quotaTracker keeps the usage of each quota up to date as objects are written and
+// deleted, so that quotas are enforced without walking the bucket. The backend
+// holding it protects it with its lock; a nil tracker enforces nothing.
+type quotaTracker struct {
+	usage []QuotaUsage
+}
+
+// newQuotaTracker tracks quotas from the current objects of the bucket, or returns nil
+// without quotas
+func newQuotaTracker(quotas []Quota, objects []Object) *quotaTracker {
+	if len(quotas) == 0 {
+		return nil
+	}
+	q := &quotaTracker{}
+	for _, quota := range quotas {
+		q.usage = append(q.usage, QuotaUsage{Quota: quota})
+	}
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, "/") {
+			continue // folder marker
+		}
+		q.apply(obj.Key, currentSize{}, currentSize{size: obj.Size, exists: true})
+	}
+	return q
+}
+
+// check returns an error wrapping ErrQuotaExceeded when replacing the current object at
+// key as described would exceed a quota. Writes that do not increase the usage are
+// always allowed, so that a bucket over quota can still be cleaned up.
+func (q *quotaTracker) check(key string, before, after currentSize) error {
+	if q == nil {
+		return nil
+	}
+	bytes, objects := after.size-before.size, count(after)-count(before)
+	for _, u := range q.usage {
+		if !strings.HasPrefix(key, u.Prefix) {
+			continue
+		}
+		if u.MaxBytes > 0 && bytes > 0 && u.Bytes+bytes > u.MaxBytes {
+			return fmt.Errorf("%w: %s is limited to %d bytes", ErrQuotaExceeded, u.describe(), u.MaxBytes)
+		}
+		if u.MaxObjects > 0 && objects > 0 && u.Objects+objects > u.MaxObjects {
+			return fmt.Errorf("%w: %s is limited to %d objects", ErrQuotaExceeded, u.describe(), u.MaxObjects)
+		}
+	}
+	return nil
+}
+
+// apply records that the current object at key changed as described
+func (q *quotaTracker) apply(key string, before, after currentSize) {
+	if q == nil {
+		return
+	}
+	for i := range q.usage {
+		u := &q.usage[i]
+		if strings.HasPrefix(key, u.Prefix) {
+			u.Bytes += after.size - before.size
+			u.Objects += count(after) - count(before)
+		}
+	}
+}
+
+// snapshot returns a copy of the usage of every quota
+func (q *quotaTracker) snapshot() []QuotaUsage {
+	if q == nil {
+		return nil
+	}
+	usage := make([]QuotaUsage, len(q.usage))
+	copy(usage, q.usage)
+	return usage
+}
+
+// describe names the scope of a quota in errors
+func (u *QuotaUsage) describe() string {
+	if u.Prefix == "" {
+		return "the bucket"
+	}
+	return fmt.Sprintf("prefix %q", u.Prefix)
+}
+
+// count returns the number of objects c stands for
+func count(c currentSize) int64 {
+	if c.exists {
+		return 1
+	}
+	return 0
+}
+
+// SetQuotas sets the quotas enforced on new objects, counting the objects stored now.
+// Usage is then kept up to date as objects are written and deleted through this Storage.
+// It must be called before the storage is used.
+func (s *Storage) SetQuotas(quotas []Quota) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var objects []Object
+	if len(quotas) > 0 {
+		var err error
+		if objects, err = s.listObjects(""); err != nil {
+			return err
+		}
+	}
+	s.quotas = newQuotaTracker(quotas, objects)
+	return nil
+}
+
+// recountQuotas counts the usage of every quota again from the current objects, after
+// files were changed directly in the bucket directory. The caller must not hold the lock.
+func (s *Storage) recountQuotas() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.quotas == nil {
+		return nil
+	}
+	objects, err := s.listObjects("")
+	if err != nil {
+		return err
+	}
+	quotas := make([]Quota, 0, len(s.quotas.usage))
+	for _, usage := range s.quotas.usage {
+		quotas = append(quotas, usage.Quota)
+	}
+	s.quotas = newQuotaTracker(quotas, objects)
+	return nil
+}
+
+// CheckQuota reports whether storing size bytes at key would exceed a quota, so that
+// uploads can be rejected before their data is received
+func (s *Storage) CheckQuota(key string, size int64) error {
+	if strings.HasSuffix(key, "/") {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.quotas.check(key, s.currentSize(key), currentSize{size: size, exists: true})
+}
+
+// QuotaUsage returns the usage of every quota
+func (s *Storage) QuotaUsage() []QuotaUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.quotas.snapshot()
+}
+
+// currentSize describes the current object at key; the caller must hold the lock
+func (s *Storage) currentSize(key string) currentSize {
+	info, err := os.Stat(s.keyToPath(key))
+	if err != nil || info.IsDir() {
+		return currentSize{}
+	}
+	meta, err := s.readSidecar(key)
+	if err != nil {
+		return currentSize{size: info.Size(), exists: true}
+	}
+	return currentSize{size: meta.dataSize(info.Size()), exists: true}
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// quotaUsage returns the usage of the quota of prefix
+func quotaUsage(t *testing.T, b Backend, prefix string) QuotaUsage {
+	t.Helper()
+
+	for _, usage := range b.QuotaUsage() {
+		if usage.Prefix == prefix {
+			return usage
+		}
+	}
+	t.Fatalf("no quota for prefix %q", prefix)
+	return QuotaUsage{}
+}
+
+func TestQuota_Bytes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		if err := b.SetQuotas([]Quota{{MaxBytes: 10}}); err != nil {
+			t.Fatalf("SetQuotas failed: %v", err)
+		}
+
+		if _, err := b.PutObject("a.txt", "", strings.NewReader("123456")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		_, err := b.PutObject("b.txt", "", strings.NewReader("12345"))
+		if !errors.Is(err, ErrQuotaExceeded) || !strings.Contains(err.Error(), "the bucket is limited to 10 bytes") {
+			t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+		}
+		if _, err := b.HeadObject("b.txt"); err != ErrNotFound {
+			t.Errorf("rejected object was stored: %v", err)
+		}
+		if err := b.CheckQuota("b.txt", 5); !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("CheckQuota = %v, expected ErrQuotaExceeded", err)
+		}
+
+		// Overwriting counts the difference with the replaced object
+		if _, err := b.PutObject("a.txt", "", strings.NewReader("1234567890")); err != nil {
+			t.Errorf("overwrite within quota failed: %v", err)
+		}
+		if _, err := b.PutObject("a.txt", "", strings.NewReader("1")); err != nil {
+			t.Errorf("shrinking overwrite failed: %v", err)
+		}
+		if _, err := b.PutObject("b.txt", "", strings.NewReader("12345")); err != nil {
+			t.Errorf("PutObject after shrinking failed: %v", err)
+		}
+		if got := quotaUsage(t, b, ""); got.Bytes != 6 || got.Objects != 2 {
+			t.Errorf("usage = %+v", got)
+		}
+
+		if err := b.DeleteObject("b.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if got := quotaUsage(t, b, ""); got.Bytes != 1 || got.Objects != 1 {
+			t.Errorf("usage after delete = %+v", got)
+		}
+
+		// Folder markers are not counted
+		if _, err := b.PutObject("folder/", "", strings.NewReader("")); err != nil {
+			t.Errorf("folder marker rejected: %v", err)
+		}
+		if got := quotaUsage(t, b, ""); got.Objects != 1 {
+			t.Errorf("usage after folder marker = %+v", got)
+		}
+	})
+}
+
+func TestQuota_Prefixes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		// Existing objects are counted when quotas are set
+		for _, key := range []string{"logs/1.log", "logs/2.log", "uploads/a.png"} {
+			if _, err := b.PutObject(key, "", strings.NewReader("data")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := b.SetQuotas([]Quota{{MaxObjects: 4}, {Prefix: "logs/", MaxObjects: 2}})
+		if err != nil {
+			t.Fatalf("SetQuotas failed: %v", err)
+		}
+		if got := quotaUsage(t, b, "logs/"); got.Objects != 2 || got.Bytes != 8 {
+			t.Errorf("usage = %+v", got)
+		}
+
+		_, err = b.PutObject("logs/3.log", "", strings.NewReader("data"))
+		if !errors.Is(err, ErrQuotaExceeded) || !strings.Contains(err.Error(), `prefix "logs/" is limited to 2 objects`) {
+			t.Errorf("expected the prefix quota to be exceeded, got %v", err)
+		}
+		if _, err := b.PutObject("logs/1.log", "", strings.NewReader("replaced")); err != nil {
+			t.Errorf("overwrite rejected: %v", err)
+		}
+		if _, err := b.PutObject("uploads/b.png", "", strings.NewReader("data")); err != nil {
+			t.Errorf("PutObject outside the prefix failed: %v", err)
+		}
+		if _, err := b.PutObject("uploads/c.png", "", strings.NewReader("data")); !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("expected the bucket quota to be exceeded, got %v", err)
+		}
+	})
+}
+
+func TestQuota_Versions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		_ = b.SetVersioning(VersioningEnabled)
+		if err := b.SetQuotas([]Quota{{MaxObjects: 1}}); err != nil {
+			t.Fatal(err)
+		}
+
+		v1, _ := b.PutObject("doc.txt", "", strings.NewReader("v1"))
+		if _, err := b.PutObject("doc.txt", "", strings.NewReader("v2-longer")); err != nil {
+			t.Fatalf("new version rejected: %v", err)
+		}
+		if got := quotaUsage(t, b, ""); got.Objects != 1 || got.Bytes != 9 {
+			t.Errorf("usage = %+v", got)
+		}
+
+		// Only current objects count: a delete marker frees the quota
+		result, err := b.DeleteObjectVersion("doc.txt", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := quotaUsage(t, b, ""); got.Objects != 0 || got.Bytes != 0 {
+			t.Errorf("usage behind delete marker = %+v", got)
+		}
+
+		// Removing the marker makes the last version current again
+		if _, err := b.DeleteObjectVersion("doc.txt", result.VersionID); err != nil {
+			t.Fatal(err)
+		}
+		if got := quotaUsage(t, b, ""); got.Objects != 1 || got.Bytes != 9 {
+			t.Errorf("usage after removing the marker = %+v", got)
+		}
+		if _, err := b.DeleteObjectVersion("doc.txt", v1.VersionID); err != nil {
+			t.Fatal(err)
+		}
+		if got := quotaUsage(t, b, ""); got.Bytes != 9 {
+			t.Errorf("removing a non-current version changed the usage: %+v", got)
+		}
+	})
+}
+
+func TestQuota_MultipartUpload(t *testing.T) {
+	defer func(size int64) { minPartSize = size }(minPartSize)
+	minPartSize = 4
+
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		if err := b.SetQuotas([]Quota{{Prefix: "big/", MaxBytes: 8}}); err != nil {
+			t.Fatal(err)
+		}
+
+		upload, err := b.CreateMultipartUpload("big/file.bin", PutOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p1, _ := b.UploadPart("big/file.bin", upload.ID, 1, strings.NewReader("hello"), nil)
+		p2, _ := b.UploadPart("big/file.bin", upload.ID, 2, strings.NewReader("-world"), nil)
+		parts := []CompletedPart{{PartNumber: 1, ETag: p1.ETag}, {PartNumber: 2, ETag: p2.ETag}}
+
+		if _, err := b.CompleteMultipartUpload("big/file.bin", upload.ID, parts); !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+		}
+		// The upload is kept, so it can be completed once space is freed
+		if _, err := b.ListParts("big/file.bin", upload.ID); err != nil {
+			t.Errorf("upload was discarded: %v", err)
+		}
+		if got := quotaUsage(t, b, "big/"); got.Bytes != 0 || got.Objects != 0 {
+			t.Errorf("usage = %+v", got)
+		}
+	})
+}
+
+func TestQuota_Disabled(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b Backend) {
+		if err := b.CheckQuota("any.bin", 1<<40); err != nil {
+			t.Errorf("CheckQuota without quotas = %v", err)
+		}
+		if usage := b.QuotaUsage(); usage != nil {
+			t.Errorf("QuotaUsage without quotas = %+v", usage)
+		}
+	})
+}
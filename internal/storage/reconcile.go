@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Change is an object added, replaced or removed directly in the bucket directory,
+// found by Reconcile
+type Change struct {
+	Key    string
+	Object *Object // the object now stored, nil when it was removed
+}
+
+// KeyForPath returns the key of the object stored at path, or the key prefix of a
+// directory, "" for the bucket directory itself. It returns false when path is outside
+// the bucket directory.
+func (s *Storage) KeyForPath(path string) (string, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	bucketPath, err := filepath.Abs(filepath.Join(s.basePath, s.bucket))
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(bucketPath, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if rel == "." {
+		return "", true
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// Reconcile brings the metadata of the objects at keys in line with their files after
+// they were added, replaced or removed directly in the bucket directory, by rsync for
+// instance. Files without metadata, or changed since their metadata was written, are
+// hashed and described as new objects, stored as they are: they are not encrypted,
+// compressed or deduplicated. The metadata of removed files is dropped. Keys written
+// through Storage are left as they are, so Reconcile can be given every key changed on
+// disk. The metadata index and quotas are updated, and the changes made are returned.
+func (s *Storage) Reconcile(keys []string) ([]Change, error) {
+	var changes []Change
+	for _, key := range keys {
+		change, err := s.reconcileKey(key)
+		if err != nil {
+			return changes, fmt.Errorf("failed to reconcile %s: %w", key, err)
+		}
+		if change != nil {
+			changes = append(changes, *change)
+		}
+	}
+	if len(changes) > 0 {
+		if err := s.recountQuotas(); err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+// reconcileKey reconciles the metadata of key with its file, returning nil when it
+// was up to date
+func (s *Storage) reconcileKey(key string) (*Change, error) {
+	path := s.keyToPath(key)
+	if strings.HasSuffix(key, "/") || s.validatePath(path) != nil {
+		return nil, nil
+	}
+
+	s.mu.RLock()
+	info, statErr := os.Stat(path)
+	meta, metaErr := s.readSidecar(key)
+	metaInfo, _ := os.Stat(s.sidecarPath(key))
+	s.mu.RUnlock()
+	if metaErr != nil {
+		// Unreadable metadata is replaced
+		meta = &objectMeta{}
+	}
+
+	if statErr != nil || !info.Mode().IsRegular() {
+		if metaInfo == nil || meta.IsDeleteMarker {
+			return nil, nil
+		}
+		return s.dropRemoved(key)
+	}
+
+	// Data written through Storage is in place before its metadata is written
+	if metaInfo != nil && !changeTime(info).After(metaInfo.ModTime()) {
+		if meta.Checksum != "" {
+			return nil, nil
+		}
+		// Metadata written before checksums existed only lacks the checksum
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		current, err := s.readSidecar(key)
+		if err != nil || current.Checksum != "" || !unchangedFile(path, info) {
+			return nil, nil
+		}
+		current.Key = key
+		current.Checksum = sum
+		return nil, s.writeSidecar(current)
+	}
+
+	sum, err := fileChecksum(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil // removed since; its own event follows
+		}
+		return nil, err
+	}
+	if sum == meta.Checksum {
+		return nil, nil // attributes changed, not the data
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The object must not have been written or changed again while it was read
+	current, err := s.readSidecar(key)
+	if err != nil {
+		current = &objectMeta{}
+	}
+	if current.Checksum != meta.Checksum || current.VersionID != meta.VersionID || !unchangedFile(path, info) {
+		return nil, nil
+	}
+
+	versionID, err := s.adoptedVersionID(key)
+	if err != nil {
+		return nil, err
+	}
+	adopted := &objectMeta{Key: key, VersionID: versionID, Checksum: sum}
+	if err := s.writeSidecar(adopted); err != nil {
+		return nil, err
+	}
+	obj := &Object{Key: key, ContentType: guessContentType(key), VersionID: versionID, IsLatest: true}
+	adopted.describe(obj, info)
+	s.indexKey(key)
+	return &Change{Key: key, Object: obj}, nil
+}
+
+// dropRemoved drops the metadata of the object at key once its file is gone.
+// Non-current versions are kept.
+func (s *Storage) dropRemoved(key string) (*Change, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(s.keyToPath(key)); err == nil {
+		return nil, nil // written again since
+	}
+	if _, err := os.Stat(s.sidecarPath(key)); err != nil {
+		return nil, nil
+	}
+	if err := s.removeSidecar(key); err != nil {
+		return nil, err
+	}
+	s.indexKey(key)
+	return &Change{Key: key}, nil
+}
+
+// adoptedVersionID returns the version ID of an object found on disk, from the
+// versioning status of the bucket. The caller must hold the write lock.
+func (s *Storage) adoptedVersionID(key string) (string, error) {
+	status, err := s.versioningStatus()
+	if err != nil {
+		return "", err
+	}
+	switch status {
+	case VersioningEnabled:
+		return newVersionID(), nil
+	case VersioningSuspended:
+		// It replaces the single "null" version
+		return nullVersionID, s.removeVersion(key, nullVersionID)
+	default:
+		return "", nil
+	}
+}
+
+// fileChecksum returns the SHA-256 of the file at path
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, file); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hashString(sum), nil
+}
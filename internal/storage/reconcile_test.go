@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeOnDisk writes a file in the bucket directory behind the storage's back
+func writeOnDisk(t *testing.T, store *Storage, key, data string) {
+	t.Helper()
+
+	path := store.keyToPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// changedKeys returns the keys of changes, with a "-" prefix for removals
+func changedKeys(changes []Change) []string {
+	var keys []string
+	for _, change := range changes {
+		if change.Object == nil {
+			keys = append(keys, "-"+change.Key)
+		} else {
+			keys = append(keys, change.Key)
+		}
+	}
+	return keys
+}
+
+func TestReconcile_AddedFiles(t *testing.T) {
+	store := newVersionedStorage(t)
+	if _, err := store.PutObject("written.txt", "", strings.NewReader("through storage")); err != nil {
+		t.Fatal(err)
+	}
+	writeOnDisk(t, store, "docs/copied.pdf", "copied by rsync")
+
+	changes, err := store.Reconcile([]string{"written.txt", "docs/copied.pdf", "missing.txt"})
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if got := changedKeys(changes); !reflect.DeepEqual(got, []string{"docs/copied.pdf"}) {
+		t.Fatalf("changes = %v", got)
+	}
+	obj := changes[0].Object
+	if obj.Size != int64(len("copied by rsync")) || obj.VersionID == "" || obj.ContentType != "application/pdf" || obj.ETag == "" {
+		t.Errorf("adopted object = %+v", obj)
+	}
+	if head, err := store.HeadObject("docs/copied.pdf"); err != nil || head.VersionID != obj.VersionID {
+		t.Errorf("HeadObject = %+v, %v", head, err)
+	}
+
+	// Adopted files have a checksum, and are not adopted twice
+	if report := scrub(t, store, ScrubOptions{}); report.Unverified != 0 || len(report.Issues) != 0 {
+		t.Errorf("scrub after reconcile = %+v", report)
+	}
+	if changes, _ := store.Reconcile([]string{"docs/copied.pdf"}); len(changes) != 0 {
+		t.Errorf("second reconcile = %v", changedKeys(changes))
+	}
+}
+
+func TestReconcile_ReplacedAndRemovedFiles(t *testing.T) {
+	store := newVersionedStorage(t)
+	old, _ := store.PutObjectWithOptions("report.txt", strings.NewReader("old report"), PutOptions{Tags: map[string]string{"team": "ops"}})
+	if _, err := store.PutObject("gone.txt", "", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	writeOnDisk(t, store, "report.txt", "new report")
+	if err := os.Remove(store.keyToPath("gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := store.Reconcile([]string{"gone.txt", "report.txt"})
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if got := changedKeys(changes); !reflect.DeepEqual(got, []string{"-gone.txt", "report.txt"}) {
+		t.Fatalf("changes = %v", got)
+	}
+
+	// A replaced file is a new object, without the tags of the one it replaced
+	obj, err := store.HeadObject("report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.VersionID == old.VersionID || len(obj.Tags) != 0 || obj.Size != int64(len("new report")) {
+		t.Errorf("replaced object = %+v", obj)
+	}
+	if _, err := os.Stat(store.sidecarPath("gone.txt")); !os.IsNotExist(err) {
+		t.Errorf("metadata of the removed file kept: %v", err)
+	}
+	if got := listKeys(t, store, ""); !reflect.DeepEqual(got, []string{"report.txt"}) {
+		t.Errorf("listing = %v", got)
+	}
+}
+
+func TestReconcile_IndexAndQuotas(t *testing.T) {
+	store := newIndexedStorage(t, t.TempDir())
+	if err := store.SetQuotas([]Quota{{MaxBytes: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("a.txt", "", strings.NewReader("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	writeOnDisk(t, store, "b.txt", strings.Repeat("x", 50))
+
+	if _, err := store.Reconcile([]string{"b.txt"}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if got := listKeys(t, store, ""); !reflect.DeepEqual(got, []string{"a.txt", "b.txt"}) {
+		t.Errorf("indexed listing = %v", got)
+	}
+	if usage := store.QuotaUsage(); usage[0].Bytes != 60 || usage[0].Objects != 2 {
+		t.Errorf("quota usage = %+v", usage)
+	}
+}
+
+func TestStorage_KeyForPath(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStorage(dir, "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		key  string
+		ok   bool
+	}{
+		{filepath.Join(dir, "test-bucket", "docs", "a.txt"), "docs/a.txt", true},
+		{filepath.Join(dir, "test-bucket"), "", true},
+		{filepath.Join(dir, "other-bucket", "a.txt"), "", false},
+		{filepath.Join(dir, "test-bucket-old", "a.txt"), "", false},
+	}
+	for _, tt := range tests {
+		if key, ok := store.KeyForPath(tt.path); key != tt.key || ok != tt.ok {
+			t.Errorf("KeyForPath(%q) = %q, %v, expected %q, %v", tt.path, key, ok, tt.key, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,602 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Problems found by Scrub
+const (
+	ScrubCorrupt          = "corrupt"           // data that does not match its checksum
+	ScrubModified         = "modified"          // data changed on disk after it was written, outside of the server
+	ScrubCorruptMetadata  = "corrupt-metadata"  // metadata that cannot be decoded
+	ScrubOrphanedMetadata = "orphaned-metadata" // metadata of an object or version whose data is missing
+	ScrubOrphanedData     = "orphaned-data"     // data of a version whose metadata is missing
+	ScrubStaleTemp        = "stale-temp"        // temporary file left behind by an interrupted upload
+)
+
+// Actions taken by Scrub
+const (
+	ScrubQuarantined     = "quarantined"
+	ScrubRemoved         = "removed"
+	ScrubChecksumUpdated = "checksum updated"
+)
+
+// staleTempAge is the age after which a temporary file no longer belongs to an upload in progress
+const staleTempAge = 24 * time.Hour
+
+// ScrubOptions selects what Scrub fixes and how fast it reads
+type ScrubOptions struct {
+	// Repair removes orphaned metadata and stale temporary files, records the checksum of
+	// objects written before checksums existed and accepts plaintext objects modified on disk
+	Repair bool
+	// Quarantine moves corrupt objects and orphaned data to the quarantine directory
+	Quarantine bool
+	// Rate limits the bytes read per second, 0 for no limit
+	Rate int64
+}
+
+// ScrubIssue is a problem found by Scrub
+type ScrubIssue struct {
+	Problem   string
+	Key       string // "" for temporary files
+	VersionID string // set for non-current versions
+	Path      string
+	Detail    string
+	Action    string // what was done about it, "" when nothing
+}
+
+// ScrubReport summarizes a scrub
+type ScrubReport struct {
+	Objects    int64 // current objects checked
+	Versions   int64 // non-current versions checked
+	Bytes      int64 // bytes read
+	Unverified int64 // objects and versions without a checksum to verify
+	Recorded   int64 // checksums recorded by Repair
+	Issues     []ScrubIssue
+}
+
+// Unresolved returns the number of issues left as they were
+func (r *ScrubReport) Unresolved() int {
+	n := 0
+	for _, issue := range r.Issues {
+		if issue.Action == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// scrubber is a scrub in progress
+type scrubber struct {
+	s          *Storage
+	ctx        context.Context
+	opts       ScrubOptions
+	report     *ScrubReport
+	reader     *throttledReader
+	quarantine string // directory of this scrub in the quarantine area
+}
+
+// Scrub walks the bucket and its metadata, verifies the data of every object and version
+// against the checksum recorded when it was written, and reports corrupt data, files
+// modified on disk, orphaned metadata and data, and stale temporary files. Objects are
+// locked only while they are fixed, so the storage stays usable during a scrub, which
+// stops early when ctx is done. Scrub never deletes object data: with opts.Quarantine,
+// corrupt objects are moved under .selfhost_s3/{bucket}/quarantine/.
+func (s *Storage) Scrub(ctx context.Context, opts ScrubOptions) (*ScrubReport, error) {
+	sc := &scrubber{
+		s:          s,
+		ctx:        ctx,
+		opts:       opts,
+		report:     &ScrubReport{},
+		reader:     &throttledReader{ctx: ctx, rate: opts.Rate, start: time.Now()},
+		quarantine: s.metaPath("quarantine", time.Now().UTC().Format("20060102T150405Z")),
+	}
+	for _, step := range []func() error{sc.objects, sc.sidecars, sc.versions, sc.tempFiles} {
+		if err := step(); err != nil {
+			return sc.report, fmt.Errorf("scrub failed: %w", err)
+		}
+	}
+	return sc.report, nil
+}
+
+// issue records a problem
+func (sc *scrubber) issue(issue ScrubIssue) {
+	sc.report.Issues = append(sc.report.Issues, issue)
+}
+
+// objects verifies the current objects
+func (sc *scrubber) objects() error {
+	bucketPath := filepath.Join(sc.s.basePath, sc.s.bucket)
+	return filepath.WalkDir(bucketPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // removed during the scrub
+			}
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		if err := sc.ctx.Err(); err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(bucketPath, path)
+		if err != nil {
+			return err
+		}
+		return sc.object(filepath.ToSlash(relPath), path)
+	})
+}
+
+// object verifies the current object at key, stored at path
+func (sc *scrubber) object(key, path string) error {
+	s := sc.s
+	s.mu.RLock()
+	meta, err := s.readSidecar(key)
+	metaInfo, _ := os.Stat(s.sidecarPath(key))
+	var file *os.File
+	if err == nil {
+		file, err = os.Open(path)
+	}
+	s.mu.RUnlock()
+	if os.IsNotExist(err) {
+		return nil // deleted during the scrub
+	}
+	if err != nil {
+		sc.issue(ScrubIssue{Problem: ScrubCorruptMetadata, Key: key, Path: s.sidecarPath(key), Detail: err.Error()})
+		return nil
+	}
+
+	info, sum, err := sc.hashFile(file)
+	if err != nil {
+		return err
+	}
+	sc.report.Objects++
+
+	// The object must not have changed while it was read before anything is fixed
+	unchanged := func() bool {
+		current, err := s.readSidecar(key)
+		return err == nil && current.VersionID == meta.VersionID && current.Checksum == meta.Checksum && unchangedFile(path, info)
+	}
+	problem, action := sc.verify(meta, sum, info, metaInfo)
+	if problem == "" && action == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !unchanged() {
+		return nil
+	}
+	detail := mismatch(meta, sum)
+	switch action {
+	case "":
+	case ScrubChecksumUpdated:
+		meta.Checksum = sum
+		if err := s.writeSidecar(meta); err != nil {
+			return err
+		}
+	case ScrubQuarantined:
+		if err := s.quarantineObject(sc.quarantine, key, meta); err != nil {
+			return err
+		}
+	}
+	if problem != "" {
+		sc.issue(ScrubIssue{Problem: problem, Key: key, Path: path, Detail: detail, Action: action})
+	}
+	return nil
+}
+
+// verify compares the checksum of data with its metadata, and returns the problem found
+// along with the action to take. An action without problem records a missing checksum.
+func (sc *scrubber) verify(meta *objectMeta, sum string, info, metaInfo os.FileInfo) (string, string) {
+	expected := meta.Checksum
+	if expected == "" {
+		// Deduplicated objects written before checksums existed are named by theirs
+		expected = meta.Blob
+	}
+	if expected == "" {
+		sc.report.Unverified++
+		if sc.opts.Repair {
+			sc.report.Recorded++
+			return "", ScrubChecksumUpdated
+		}
+		return "", ""
+	}
+	if expected == sum {
+		return "", ""
+	}
+
+	// Data written by the server is older than its metadata; data written to afterwards
+	// was modified outside of it. Only plaintext objects of their own can be accepted as is.
+	if metaInfo != nil && info.ModTime().After(metaInfo.ModTime()) {
+		if sc.opts.Repair && meta.Encryption == nil && meta.Compression == nil && meta.Blob == "" {
+			return ScrubModified, ScrubChecksumUpdated
+		}
+		if !sc.opts.Quarantine {
+			return ScrubModified, ""
+		}
+		return ScrubModified, ScrubQuarantined
+	}
+	if sc.opts.Quarantine {
+		return ScrubCorrupt, ScrubQuarantined
+	}
+	return ScrubCorrupt, ""
+}
+
+// mismatch describes a checksum mismatch
+func mismatch(meta *objectMeta, sum string) string {
+	expected := meta.Checksum
+	if expected == "" {
+		expected = meta.Blob
+	}
+	return fmt.Sprintf("SHA-256 %s, expected %s", sum, expected)
+}
+
+// sidecars reports the sidecar metadata of objects that no longer exist
+func (sc *scrubber) sidecars() error {
+	s := sc.s
+	return filepath.WalkDir(s.metaPath("objects"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		if err := sc.ctx.Err(); err != nil {
+			return err
+		}
+
+		meta, err := readMetaFile(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			sc.issue(ScrubIssue{Problem: ScrubCorruptMetadata, Path: path, Detail: err.Error()})
+			return nil
+		}
+
+		orphaned := func() bool {
+			if s.sidecarPath(meta.Key) != path {
+				return true
+			}
+			info, err := os.Stat(s.keyToPath(meta.Key))
+			return err != nil || info.IsDir()
+		}
+		s.mu.RLock()
+		found := orphaned()
+		s.mu.RUnlock()
+		if !found {
+			return nil
+		}
+
+		issue := ScrubIssue{Problem: ScrubOrphanedMetadata, Key: meta.Key, Path: path, Detail: "the object does not exist"}
+		if sc.opts.Repair {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if !orphaned() {
+				return nil // written during the scrub
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			issue.Action = ScrubRemoved
+		}
+		sc.issue(issue)
+		return nil
+	})
+}
+
+// versions verifies the non-current versions, and reports the metadata and data of
+// versions missing the other half
+func (sc *scrubber) versions() error {
+	root := sc.s.metaPath("versions")
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// Each key has a directory of versions among the folders of its key
+		if !d.IsDir() || path == root {
+			return nil
+		}
+		if err := sc.ctx.Err(); err != nil {
+			return err
+		}
+		return sc.versionDir(path)
+	})
+}
+
+// versionDir checks the versions of one key stored in dir
+func (sc *scrubber) versionDir(dir string) error {
+	s := sc.s
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	metas := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok {
+			metas[name] = true
+		}
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue // a folder of keys
+		}
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		if strings.HasSuffix(name, ".json") {
+			if err := sc.version(path); err != nil {
+				return err
+			}
+			continue
+		}
+		if metas[name] {
+			continue
+		}
+
+		// Data without metadata cannot be served; it is set aside rather than deleted
+		issue := ScrubIssue{Problem: ScrubOrphanedData, VersionID: name, Path: path, Detail: "the version has no metadata"}
+		if sc.opts.Quarantine {
+			s.mu.Lock()
+			if _, err := os.Stat(path + ".json"); os.IsNotExist(err) {
+				dest := filepath.Join(sc.quarantine, "orphaned", filepath.Base(dir), name)
+				if err := moveFile(path, dest); err != nil {
+					s.mu.Unlock()
+					return err
+				}
+				issue.Action = ScrubQuarantined
+			}
+			s.mu.Unlock()
+		}
+		sc.issue(issue)
+	}
+	_ = os.Remove(dir) // once emptied by the quarantine
+	return nil
+}
+
+// version verifies the non-current version whose metadata is at path
+func (sc *scrubber) version(path string) error {
+	s := sc.s
+	meta, err := readMetaFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		sc.issue(ScrubIssue{Problem: ScrubCorruptMetadata, Path: path, Detail: err.Error()})
+		return nil
+	}
+	if meta.IsDeleteMarker {
+		return nil
+	}
+	dataPath := s.versionDataPath(meta.Key, meta.VersionID)
+
+	s.mu.RLock()
+	metaInfo, metaErr := os.Stat(path)
+	file, err := os.Open(dataPath)
+	s.mu.RUnlock()
+	if os.IsNotExist(metaErr) {
+		return nil // removed during the scrub
+	}
+	if os.IsNotExist(err) {
+		issue := ScrubIssue{Problem: ScrubOrphanedMetadata, Key: meta.Key, VersionID: meta.VersionID, Path: path, Detail: "the version has no data"}
+		if sc.opts.Repair {
+			s.mu.Lock()
+			if _, err := os.Stat(dataPath); os.IsNotExist(err) {
+				if err := s.removeVersion(meta.Key, meta.VersionID); err != nil {
+					s.mu.Unlock()
+					return err
+				}
+				issue.Action = ScrubRemoved
+			}
+			s.mu.Unlock()
+		}
+		sc.issue(issue)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	info, sum, err := sc.hashFile(file)
+	if err != nil {
+		return err
+	}
+	sc.report.Versions++
+
+	problem, action := sc.verify(meta, sum, info, metaInfo)
+	if problem == "" && action == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !unchangedFile(dataPath, info) {
+		return nil // removed or replaced during the scrub
+	}
+	detail := mismatch(meta, sum)
+	switch action {
+	case "":
+	case ScrubChecksumUpdated:
+		meta.Checksum = sum
+		if err := writeMetaFile(path, meta); err != nil {
+			return err
+		}
+	case ScrubQuarantined:
+		if err := s.quarantineVersion(sc.quarantine, meta); err != nil {
+			return err
+		}
+	}
+	if problem != "" {
+		sc.issue(ScrubIssue{Problem: problem, Key: meta.Key, VersionID: meta.VersionID, Path: dataPath, Detail: detail, Action: action})
+	}
+	return nil
+}
+
+// tempFiles reports the temporary files of uploads interrupted long ago
+func (sc *scrubber) tempFiles() error {
+	entries, err := os.ReadDir(sc.s.metaPath("tmp"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < staleTempAge {
+			continue
+		}
+		path := filepath.Join(sc.s.metaPath("tmp"), entry.Name())
+		issue := ScrubIssue{Problem: ScrubStaleTemp, Path: path, Detail: fmt.Sprintf("last written %s", info.ModTime().UTC().Format(time.RFC3339))}
+		if sc.opts.Repair {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			issue.Action = ScrubRemoved
+		}
+		sc.issue(issue)
+	}
+	return nil
+}
+
+// hashFile returns the info and SHA-256 of an open file, which it closes
+func (sc *scrubber) hashFile(file *os.File) (os.FileInfo, string, error) {
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.New()
+	sc.reader.r = file
+	n, err := io.Copy(sum, sc.reader)
+	sc.report.Bytes += n
+	if err != nil {
+		return nil, "", err
+	}
+	return info, hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// quarantineObject moves the current object at key and its metadata under dir, making the
+// latest non-current version current again. The caller must hold the write lock.
+func (s *Storage) quarantineObject(dir, key string, meta *objectMeta) error {
+	path := s.keyToPath(key)
+	dest := filepath.Join(dir, "objects", filepath.FromSlash(key))
+
+	before := s.currentSize(key)
+	if err := moveFile(path, dest); err != nil {
+		return err
+	}
+	if err := writeMetaFile(filepath.Join(dir, "metadata", filepath.FromSlash(key)+".json"), meta); err != nil {
+		return err
+	}
+	s.dropBlob(meta, dest)
+	if err := s.removeSidecar(key); err != nil {
+		return err
+	}
+	if err := s.promoteLatest(key); err != nil {
+		return err
+	}
+	s.quotas.apply(key, before, s.currentSize(key))
+	s.indexKey(key)
+	return nil
+}
+
+// quarantineVersion moves a non-current version and its metadata under dir.
+// The caller must hold the write lock.
+func (s *Storage) quarantineVersion(dir string, meta *objectMeta) error {
+	dest := filepath.Join(dir, "versions", filepath.FromSlash(meta.Key), meta.VersionID)
+	if err := moveFile(s.versionDataPath(meta.Key, meta.VersionID), dest); err != nil {
+		return err
+	}
+	if err := writeMetaFile(dest+".json", meta); err != nil {
+		return err
+	}
+	s.dropBlob(meta, dest)
+	return s.removeVersion(meta.Key, meta.VersionID)
+}
+
+// dropBlob removes the blob of corrupt deduplicated data moved to path, so that new
+// objects are not linked to it. The caller must hold the write lock.
+func (s *Storage) dropBlob(meta *objectMeta, path string) {
+	if meta.Blob == "" {
+		return
+	}
+	if info, err := os.Stat(path); err == nil && unchangedFile(s.blobPath(meta.Blob), info) {
+		_ = os.Remove(s.blobPath(meta.Blob))
+	}
+}
+
+// unchangedFile reports whether path is still the file described by info, unmodified
+func unchangedFile(path string, info os.FileInfo) bool {
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(current, info) && current.Size() == info.Size() && current.ModTime().Equal(info.ModTime())
+}
+
+// moveFile renames a file, creating the parent directories of dest
+func moveFile(path, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return fmt.Errorf("failed to move %s: %w", path, err)
+	}
+	return nil
+}
+
+// throttledReader reads at most rate bytes per second on average since start, and stops
+// once ctx is done
+type throttledReader struct {
+	ctx   context.Context
+	r     io.Reader
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if t.rate > 0 && int64(len(p)) > t.rate {
+		p = p[:t.rate]
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+	if t.rate > 0 {
+		due := t.start.Add(time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second)))
+		if wait := time.Until(due); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-t.ctx.Done():
+				return n, t.ctx.Err()
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// corruptFile flips a byte of a file without changing its modification time, as bit rot does
+func corruptFile(t *testing.T, path string) {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(path, data, info.Mode()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// scrub runs Scrub and fails the test on error
+func scrub(t *testing.T, store *Storage, opts ScrubOptions) *ScrubReport {
+	t.Helper()
+
+	report, err := store.Scrub(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	return report
+}
+
+// problems returns the problems of a report with their keys, and their actions
+func problems(report *ScrubReport) []string {
+	var found []string
+	for _, issue := range report.Issues {
+		entry := issue.Problem + " " + issue.Key
+		if issue.Action != "" {
+			entry += " (" + issue.Action + ")"
+		}
+		found = append(found, entry)
+	}
+	return found
+}
+
+func TestScrub_Clean(t *testing.T) {
+	store := newVersionedStorage(t)
+	for _, body := range []string{"v1", "v2"} {
+		if _, err := store.PutObject("doc.txt", "", strings.NewReader(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.PutObject("folder/", "", strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+
+	report := scrub(t, store, ScrubOptions{})
+	if report.Objects != 1 || report.Versions != 1 || report.Bytes != 4 || report.Unverified != 0 || len(report.Issues) != 0 {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+func TestScrub_Corrupt(t *testing.T) {
+	store := newVersionedStorage(t)
+	if _, err := store.PutObject("doc.txt", "", strings.NewReader("first version")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("doc.txt", "", strings.NewReader("second version")); err != nil {
+		t.Fatal(err)
+	}
+	corruptFile(t, store.keyToPath("doc.txt"))
+
+	report := scrub(t, store, ScrubOptions{})
+	if got := problems(report); len(got) != 1 || got[0] != "corrupt doc.txt" || report.Unresolved() != 1 {
+		t.Fatalf("problems = %v", got)
+	}
+	if !strings.Contains(report.Issues[0].Detail, "expected") {
+		t.Errorf("detail = %q", report.Issues[0].Detail)
+	}
+
+	// The corrupt object is set aside and the previous version is current again
+	report = scrub(t, store, ScrubOptions{Quarantine: true})
+	if got := problems(report); len(got) != 1 || got[0] != "corrupt doc.txt (quarantined)" {
+		t.Fatalf("problems = %v", got)
+	}
+	if got := readAll(t, store, "doc.txt", ""); got != "first version" {
+		t.Errorf("current object after quarantine = %q", got)
+	}
+	moved, _ := filepath.Glob(store.metaPath("quarantine", "*", "objects", "doc.txt"))
+	if len(moved) != 1 {
+		t.Errorf("quarantined files = %v", moved)
+	}
+	if report := scrub(t, store, ScrubOptions{}); len(report.Issues) != 0 {
+		t.Errorf("problems after quarantine = %v", problems(report))
+	}
+}
+
+func TestScrub_CorruptVersion(t *testing.T) {
+	store := newVersionedStorage(t)
+	v1, _ := store.PutObject("doc.txt", "", strings.NewReader("first version"))
+	if _, err := store.PutObject("doc.txt", "", strings.NewReader("second version")); err != nil {
+		t.Fatal(err)
+	}
+	corruptFile(t, store.versionDataPath("doc.txt", v1.VersionID))
+
+	report := scrub(t, store, ScrubOptions{Quarantine: true})
+	if got := problems(report); len(got) != 1 || got[0] != "corrupt doc.txt (quarantined)" || report.Issues[0].VersionID != v1.VersionID {
+		t.Fatalf("problems = %v", got)
+	}
+	versions, _ := store.ListObjectVersions("doc.txt")
+	if len(versions) != 1 || !versions[0].IsLatest {
+		t.Errorf("versions after quarantine = %+v", versions)
+	}
+}
+
+func TestScrub_Modified(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("notes.txt", "", strings.NewReader("original")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Edited in place after the server wrote it
+	path := store.keyToPath("notes.txt")
+	if err := os.WriteFile(path, []byte("edited by hand"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := problems(scrub(t, store, ScrubOptions{})); len(got) != 1 || got[0] != "modified notes.txt" {
+		t.Fatalf("problems = %v", got)
+	}
+	if got := problems(scrub(t, store, ScrubOptions{Repair: true})); len(got) != 1 || got[0] != "modified notes.txt (checksum updated)" {
+		t.Fatalf("problems with repair = %v", got)
+	}
+	if report := scrub(t, store, ScrubOptions{}); len(report.Issues) != 0 || report.Objects != 1 {
+		t.Errorf("report after accepting the change = %+v", report)
+	}
+	if got := readAll(t, store, "notes.txt", ""); got != "edited by hand" {
+		t.Errorf("object = %q", got)
+	}
+}
+
+func TestScrub_Orphans(t *testing.T) {
+	store := newVersionedStorage(t)
+	v1, _ := store.PutObject("doc.txt", "", strings.NewReader("v1"))
+	v2, _ := store.PutObject("doc.txt", "", strings.NewReader("v2"))
+	if _, err := store.PutObject("doc.txt", "", strings.NewReader("v3")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sidecar of a missing object, version data without metadata and the reverse
+	if err := store.writeSidecar(&objectMeta{Key: "gone.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(store.versionMetaPath("doc.txt", v1.VersionID)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(store.versionDataPath("doc.txt", v2.VersionID)); err != nil {
+		t.Fatal(err)
+	}
+	tmp := store.metaPath("tmp", "upload-123")
+	if err := os.MkdirAll(filepath.Dir(tmp), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tmp, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(tmp, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	report := scrub(t, store, ScrubOptions{})
+	expected := []string{"orphaned-metadata gone.txt", "orphaned-data ", "orphaned-metadata doc.txt", "stale-temp "}
+	if got := problems(report); strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Fatalf("problems = %q, expected %q", got, expected)
+	}
+
+	report = scrub(t, store, ScrubOptions{Repair: true, Quarantine: true})
+	if report.Unresolved() != 0 || len(report.Issues) != 4 {
+		t.Errorf("problems with repair = %v", problems(report))
+	}
+	if report := scrub(t, store, ScrubOptions{}); len(report.Issues) != 0 {
+		t.Errorf("problems after repair = %v", problems(report))
+	}
+	if got := readAll(t, store, "doc.txt", ""); got != "v3" {
+		t.Errorf("current object = %q", got)
+	}
+}
+
+func TestScrub_RecordsMissingChecksums(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Placed on disk directly, without metadata
+	if err := os.WriteFile(store.keyToPath("legacy.txt"), []byte("legacy data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if report := scrub(t, store, ScrubOptions{}); report.Unverified != 1 || report.Recorded != 0 || len(report.Issues) != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	if report := scrub(t, store, ScrubOptions{Repair: true}); report.Recorded != 1 {
+		t.Errorf("report with repair = %+v", report)
+	}
+
+	corruptFile(t, store.keyToPath("legacy.txt"))
+	report := scrub(t, store, ScrubOptions{})
+	if got := problems(report); report.Unverified != 0 || len(got) != 1 || got[0] != "corrupt legacy.txt" {
+		t.Errorf("problems once the checksum is recorded = %v", got)
+	}
+}
+
+func TestScrub_DedupBlob(t *testing.T) {
+	store := newDedupStorage(t)
+	for _, key := range []string{"a.png", "b.png"} {
+		if _, err := store.PutObject(key, "", strings.NewReader("same image")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	corruptFile(t, store.keyToPath("a.png"))
+
+	report := scrub(t, store, ScrubOptions{Quarantine: true})
+	if got := problems(report); len(got) != 2 || got[0] != "corrupt a.png (quarantined)" || got[1] != "corrupt b.png (quarantined)" {
+		t.Fatalf("problems = %v", got)
+	}
+	// New uploads of the same content get a sound blob
+	if _, err := store.PutObject("c.png", "", strings.NewReader("same image")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, store, "c.png", ""); got != "same image" {
+		t.Errorf("object stored after the quarantine = %q", got)
+	}
+}
+
+func TestScrub_RateAndCancel(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("data.bin", "", strings.NewReader(strings.Repeat("x", 20000))); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	scrub(t, store, ScrubOptions{Rate: 50000})
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("20000 bytes at 50000 bytes/s read in %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := store.Scrub(ctx, ScrubOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Scrub with a canceled context = %v", err)
+	}
+}
@@ -0,0 +1,515 @@
+package storage
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// A snapshot is a zstd-compressed tar archive of the objects of a bucket and their
+// metadata. It starts with a manifest, followed by the bucket directory under data/ and
+// the sidecars, versions, bucket configuration and deduplicated blobs under meta/. Files
+// sharing their data are archived once and hard linked, and modification times are kept
+// to the nanosecond since ETags derive from them. Uploads in progress, the metadata index
+// and the queues of the server are left out: the index is rebuilt from the files.
+
+const (
+	snapshotManifestName = "snapshot.json"
+	snapshotFormat       = 1
+	snapshotDataDir      = "data"
+	snapshotMetaDir      = "meta"
+)
+
+// snapshotMetaDirs are the metadata directories archived along with the objects
+var snapshotMetaDirs = []string{"objects", "versions", "config", "blobs"}
+
+var (
+	// ErrInvalidSnapshot is returned when restoring an archive that is not a valid snapshot
+	ErrInvalidSnapshot = errors.New("invalid snapshot archive")
+	// ErrBucketNotEmpty is returned when restoring into a bucket that has objects
+	ErrBucketNotEmpty = errors.New("the bucket already has objects")
+)
+
+// snapshotManifest is the first entry of a snapshot archive
+type snapshotManifest struct {
+	Format  int       `json:"format"`
+	Bucket  string    `json:"bucket"`
+	Created time.Time `json:"created"`
+}
+
+// Snapshot is a consistent view of the bucket, ready to be archived with WriteTo
+type Snapshot struct {
+	Bucket  string
+	Created time.Time
+
+	roots   []snapshotRoot
+	staging string // directory to remove on Close, empty when the storage itself is archived
+	release func() // releases the lock held until Close, nil once released
+}
+
+// snapshotRoot is a directory archived under name
+type snapshotRoot struct {
+	dir, name string
+}
+
+// snapshotRoots returns the directories archived from a bucket directory and its
+// metadata directory
+func snapshotRoots(bucketDir, metaDir string) []snapshotRoot {
+	roots := []snapshotRoot{{bucketDir, snapshotDataDir}}
+	for _, dir := range snapshotMetaDirs {
+		roots = append(roots, snapshotRoot{filepath.Join(metaDir, dir), path.Join(snapshotMetaDir, dir)})
+	}
+	return roots
+}
+
+// Snapshot captures the objects and metadata of the bucket. Writes wait while the files
+// are hard linked into a staging directory, then go on while the snapshot is archived;
+// reads never wait. Without hard links, writes wait until the snapshot is closed.
+// The snapshot must be closed.
+func (s *Storage) Snapshot() (*Snapshot, error) {
+	snap := &Snapshot{Bucket: s.bucket, Created: time.Now().UTC()}
+	roots := snapshotRoots(filepath.Join(s.basePath, s.bucket), s.metaPath())
+
+	s.mu.RLock()
+	if !linksSupported {
+		snap.roots = roots
+		snap.release = s.mu.RUnlock
+		return snap, nil
+	}
+	defer s.mu.RUnlock()
+
+	if err := os.MkdirAll(s.metaPath("tmp"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	staging, err := os.MkdirTemp(s.metaPath("tmp"), "snapshot-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	snap.staging = staging
+	snap.roots = snapshotRoots(filepath.Join(staging, snapshotDataDir), filepath.Join(staging, snapshotMetaDir))
+
+	// Files are never modified in place, so links keep their content as it is now
+	err = walkSnapshot(roots, func(name, src string, d fs.DirEntry) error {
+		dst := filepath.Join(staging, filepath.FromSlash(name))
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		return os.Link(src, dst)
+	})
+	if err != nil {
+		_ = os.RemoveAll(staging)
+		return nil, fmt.Errorf("failed to stage snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+// walkSnapshot calls fn with the archive name and path of every directory and regular
+// file under roots, parents first. Missing roots are skipped.
+func walkSnapshot(roots []snapshotRoot, fn func(name, src string, d fs.DirEntry) error) error {
+	for _, root := range roots {
+		err := filepath.WalkDir(root.dir, func(src string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && src == root.dir {
+					return nil
+				}
+				return err
+			}
+			if !d.IsDir() && !d.Type().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(root.dir, src)
+			if err != nil {
+				return err
+			}
+			return fn(path.Join(root.name, filepath.ToSlash(rel)), src, d)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTo writes the snapshot archive to w and returns the number of bytes written
+func (snap *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	zw, err := zstd.NewWriter(counter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	tw := tar.NewWriter(zw)
+
+	manifest, err := json.Marshal(snapshotManifest{Format: snapshotFormat, Bucket: snap.Bucket, Created: snap.Created})
+	if err != nil {
+		return counter.n, err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     snapshotManifestName,
+		Mode:     0644,
+		Size:     int64(len(manifest)),
+		ModTime:  snap.Created,
+		Format:   tar.FormatPAX,
+	})
+	if err == nil {
+		_, err = tw.Write(manifest)
+	}
+	if err != nil {
+		return counter.n, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	archived := make(map[fileID]string) // names of the files archived, by data
+	err = walkSnapshot(snap.roots, func(name, src string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: info.ModTime(),
+			Format:  tar.FormatPAX,
+		}
+		if d.IsDir() {
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+			return tw.WriteHeader(hdr)
+		}
+
+		// Staged files have one more link than the data they share
+		if links, id, ok := fileLinks(info); ok && links > 1 {
+			if target, ok := archived[id]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = target
+				return tw.WriteHeader(hdr)
+			}
+			archived[id] = name
+		}
+
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = info.Size()
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		return counter.n, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return counter.n, nil
+}
+
+// Close removes the staging directory of the snapshot, or lets writes go on
+func (snap *Snapshot) Close() error {
+	if snap.release != nil {
+		snap.release()
+		snap.release = nil
+	}
+	if snap.staging == "" {
+		return nil
+	}
+	return os.RemoveAll(snap.staging)
+}
+
+// RestoreOptions controls how a snapshot is restored
+type RestoreOptions struct {
+	// Replace replaces the objects and metadata of a bucket that has objects, instead of
+	// failing with ErrBucketNotEmpty
+	Replace bool
+}
+
+// RestoreStats describes a restored snapshot
+type RestoreStats struct {
+	Bucket   string    // bucket the snapshot was taken of
+	Created  time.Time // when the snapshot was taken
+	Objects  int64     // current objects verified
+	Versions int64     // non-current versions verified
+	Bytes    int64
+}
+
+// Restore loads a snapshot archive into bucket under basePath, which may differ from the
+// bucket the snapshot was taken of. The archive is extracted next to the bucket and its
+// data verified against its checksums before it takes the place of the bucket, so a
+// damaged archive leaves the bucket as it was. It fails with ErrLocked while a server
+// is running on the bucket.
+func Restore(ctx context.Context, r io.Reader, basePath, bucket string, opts RestoreOptions) (*RestoreStats, error) {
+	// A server using the bucket would keep serving the files moved aside
+	target := &Storage{basePath: basePath, bucket: bucket}
+	if err := target.Lock(); err != nil {
+		return nil, err
+	}
+	defer target.Unlock()
+
+	bucketDir := filepath.Join(basePath, bucket)
+	if !opts.Replace {
+		if err := checkNoObjects(bucketDir); err != nil {
+			return nil, err
+		}
+	}
+
+	workDir := filepath.Join(basePath, metaDirName)
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+	// A leading dot keeps the staging directory apart from the metadata of buckets
+	staging, err := os.MkdirTemp(workDir, ".restore-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(staging) }()
+
+	manifest, err := extractSnapshot(r, staging, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	staged := &Storage{basePath: staging, bucket: bucket}
+	report, err := staged.Scrub(ctx, ScrubOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, issue := range report.Issues {
+		switch issue.Problem {
+		case ScrubCorrupt, ScrubModified, ScrubCorruptMetadata:
+			subject := issue.Key
+			if subject == "" {
+				subject = issue.Path
+			}
+			return nil, fmt.Errorf("%w: %s: %s: %s", ErrInvalidSnapshot, issue.Problem, subject, issue.Detail)
+		}
+	}
+
+	// Move the bucket and its metadata aside, removed with the staging directory
+	previous := filepath.Join(staging, "previous")
+	moves := []restoreMove{
+		{filepath.Join(staging, bucket), bucketDir, filepath.Join(previous, bucket)},
+		{staged.metaPath(), filepath.Join(workDir, bucket), filepath.Join(previous, metaDirName, bucket)},
+	}
+	if err := os.MkdirAll(filepath.Join(previous, metaDirName), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	for i, move := range moves {
+		if err := os.Rename(move.live, move.previous); err != nil && !os.IsNotExist(err) {
+			undoRestore(moves[:i])
+			return nil, fmt.Errorf("failed to move the bucket aside: %w", err)
+		}
+	}
+	for i, move := range moves {
+		if err := os.Rename(move.staged, move.live); err != nil {
+			for _, done := range moves[:i] {
+				_ = os.Rename(done.live, done.staged)
+			}
+			undoRestore(moves)
+			return nil, fmt.Errorf("failed to move the restored bucket into place: %w", err)
+		}
+	}
+
+	return &RestoreStats{
+		Bucket:   manifest.Bucket,
+		Created:  manifest.Created,
+		Objects:  report.Objects,
+		Versions: report.Versions,
+		Bytes:    report.Bytes,
+	}, nil
+}
+
+// restoreMove is a directory put in place by Restore
+type restoreMove struct {
+	staged, live, previous string
+}
+
+// undoRestore moves back the directories moved aside by Restore
+func undoRestore(moves []restoreMove) {
+	for _, move := range moves {
+		if _, err := os.Stat(move.previous); err == nil {
+			_ = os.Rename(move.previous, move.live)
+		}
+	}
+}
+
+// checkNoObjects returns ErrBucketNotEmpty when the bucket directory has files. Empty
+// directories, such as the public prefix created on startup, do not count.
+func checkNoObjects(bucketDir string) error {
+	errFound := errors.New("found")
+	err := filepath.WalkDir(bucketDir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return errFound
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errFound):
+		return ErrBucketNotEmpty
+	case err != nil && !os.IsNotExist(err):
+		return fmt.Errorf("failed to read bucket directory: %w", err)
+	}
+	return nil
+}
+
+// extractSnapshot extracts a snapshot archive into dir, laid out like a storage path
+// holding bucket, and returns its manifest
+func extractSnapshot(r io.Reader, dir, bucket string) (*snapshotManifest, error) {
+	zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != snapshotManifestName {
+		return nil, fmt.Errorf("%w: no %s manifest", ErrInvalidSnapshot, snapshotManifestName)
+	}
+	var manifest snapshotManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode manifest: %v", ErrInvalidSnapshot, err)
+	}
+	if manifest.Format != snapshotFormat {
+		return nil, fmt.Errorf("%w: unsupported format %d", ErrInvalidSnapshot, manifest.Format)
+	}
+
+	// localPath maps the name of an entry to its path, rejecting names outside the snapshot
+	localPath := func(name string) (string, error) {
+		name = strings.TrimSuffix(name, "/")
+		if !filepath.IsLocal(name) || path.Clean(name) != name {
+			return "", fmt.Errorf("%w: unexpected entry %q", ErrInvalidSnapshot, name)
+		}
+		top, rest, _ := strings.Cut(name, "/")
+		switch {
+		case top == snapshotDataDir:
+			return filepath.Join(dir, bucket, filepath.FromSlash(rest)), nil
+		case top == snapshotMetaDir && rest != "" && slices.Contains(snapshotMetaDirs, strings.Split(rest, "/")[0]):
+			return filepath.Join(dir, metaDirName, bucket, filepath.FromSlash(rest)), nil
+		}
+		return "", fmt.Errorf("%w: unexpected entry %q", ErrInvalidSnapshot, name)
+	}
+
+	type dirTime struct {
+		path    string
+		modTime time.Time
+	}
+	var dirs []dirTime
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+		dst, err := localPath(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create directory: %w", err)
+			}
+			dirs = append(dirs, dirTime{dst, hdr.ModTime})
+		case tar.TypeReg:
+			if err := extractFile(tr, dst, hdr); err != nil {
+				return nil, err
+			}
+		case tar.TypeLink:
+			src, err := localPath(hdr.Linkname)
+			if err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return nil, fmt.Errorf("failed to create directory: %w", err)
+			}
+			if err := linkOrCopy(src, dst); err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrInvalidSnapshot, hdr.Name, err)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unexpected entry type of %q", ErrInvalidSnapshot, hdr.Name)
+		}
+	}
+	// Reading to the end verifies the zstd checksum
+	if _, err := io.Copy(io.Discard, zr); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+
+	// Folders get their times back once their content is written, deepest first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Chtimes(dirs[i].path, dirs[i].modTime, dirs[i].modTime)
+	}
+	return &manifest, nil
+}
+
+// extractFile writes the content of the current archive entry to path
+func extractFile(r io.Reader, dst string, hdr *tar.Header) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.FileMode(hdr.Mode).Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("%w: %s: %v", ErrInvalidSnapshot, hdr.Name, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return os.Chtimes(dst, hdr.ModTime, hdr.ModTime)
+}
+
+// linkOrCopy makes dst share the data of src, or copies it where hard links are not supported
+func linkOrCopy(src, dst string) error {
+	if linksSupported {
+		return os.Link(src, dst)
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
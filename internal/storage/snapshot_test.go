@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// snapshotArchive returns a snapshot archive of store
+func snapshotArchive(t *testing.T, store *Storage) []byte {
+	t.Helper()
+
+	snap, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	defer func() { _ = snap.Close() }()
+
+	var buf bytes.Buffer
+	n, err := snap.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d bytes, wrote %d", n, buf.Len())
+	}
+	return buf.Bytes()
+}
+
+func TestSnapshot_RestoreKeepsObjectsAndMetadata(t *testing.T) {
+	store := newDedupStorage(t)
+	if err := store.SetVersioning(VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	logo := randomData(t, 4096)
+	if _, err := store.PutObject("a/logo.png", "", bytes.NewReader(logo)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("b/logo.png", "", bytes.NewReader(logo)); err != nil {
+		t.Fatal(err)
+	}
+	first, err := store.PutObjectWithOptions("doc.txt", strings.NewReader("v1"), PutOptions{Tags: map[string]string{"team": "docs"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := store.PutObject("doc.txt", "", strings.NewReader("v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("empty/", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutBucketConfig("cors", []byte("<CORSConfiguration/>")); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := snapshotArchive(t, store)
+	if entries, _ := os.ReadDir(store.metaPath("tmp")); len(entries) != 0 {
+		t.Errorf("expected the staging directory to be removed, found %d entries", len(entries))
+	}
+
+	// Restored under another name into an empty storage path
+	dir := t.TempDir()
+	stats, err := Restore(context.Background(), bytes.NewReader(archive), dir, "restored", RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if stats.Bucket != "test-bucket" || stats.Objects != 3 || stats.Versions != 1 {
+		t.Errorf("Restore = %+v", stats)
+	}
+
+	restored, err := Open(dir, "restored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := restored.HeadObject("doc.txt")
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if obj.ETag != current.ETag || !obj.LastModified.Equal(current.LastModified) || obj.VersionID != current.VersionID {
+		t.Errorf("restored object = %+v, expected %+v", obj, current)
+	}
+	if got := readVersion(t, restored, "doc.txt", first.VersionID); got != "v1" {
+		t.Errorf("previous version = %q", got)
+	}
+	if version, err := restored.HeadObjectVersion("doc.txt", first.VersionID); err != nil || version.Tags["team"] != "docs" {
+		t.Errorf("previous version = %+v, %v", version, err)
+	}
+	if !sameFile(t, restored, "a/logo.png", "b/logo.png") {
+		t.Error("expected deduplicated objects to share their data after a restore")
+	}
+	if info, err := os.Stat(restored.keyToPath("empty")); err != nil || !info.IsDir() {
+		t.Errorf("expected the folder marker to be restored: %v", err)
+	}
+	if data, err := restored.GetBucketConfig("cors"); err != nil || string(data) != "<CORSConfiguration/>" {
+		t.Errorf("bucket config = %q, %v", data, err)
+	}
+}
+
+func TestSnapshot_WritesAfterSnapshotAreLeftOut(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("before.txt", "", strings.NewReader("before")); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	defer func() { _ = snap.Close() }()
+	if linksSupported {
+		// Writes only wait while the snapshot is staged
+		if _, err := store.PutObject("before.txt", "", strings.NewReader("changed")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.PutObject("after.txt", "", strings.NewReader("after")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := snap.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	if _, err := Restore(context.Background(), &buf, dir, "test-bucket", RestoreOptions{}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	restored, _ := Open(dir, "test-bucket")
+	if _, reader, err := restored.GetObject("before.txt"); err != nil {
+		t.Errorf("GetObject failed: %v", err)
+	} else {
+		defer func() { _ = reader.Close() }()
+		var data bytes.Buffer
+		_, _ = data.ReadFrom(reader)
+		if data.String() != "before" {
+			t.Errorf("restored %q", data.String())
+		}
+	}
+	if _, err := restored.HeadObject("after.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the object written after the snapshot to be left out, got %v", err)
+	}
+}
+
+func TestRestore_ExistingBucket(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("a.txt", "", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	archive := snapshotArchive(t, store)
+	if _, err := store.PutObject("b.txt", "", strings.NewReader("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Restore(context.Background(), bytes.NewReader(archive), store.basePath, "test-bucket", RestoreOptions{})
+	if !errors.Is(err, ErrBucketNotEmpty) {
+		t.Fatalf("expected ErrBucketNotEmpty, got %v", err)
+	}
+	if _, err := Restore(context.Background(), bytes.NewReader(archive), store.basePath, "test-bucket", RestoreOptions{Replace: true}); err != nil {
+		t.Fatalf("Restore with Replace failed: %v", err)
+	}
+	if _, err := store.HeadObject("a.txt"); err != nil {
+		t.Errorf("HeadObject(a.txt) = %v", err)
+	}
+	if _, err := store.HeadObject("b.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the object missing from the snapshot to be gone, got %v", err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(store.basePath, metaDirName))
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, ",") != "test-bucket,test-bucket.lock" {
+		t.Errorf("expected no restore directory left behind, found %v", names)
+	}
+
+	// Empty directories, like the public prefix created on startup, are not objects
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "test-bucket", "public"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(context.Background(), bytes.NewReader(archive), dir, "test-bucket", RestoreOptions{}); err != nil {
+		t.Errorf("Restore into a bucket without objects failed: %v", err)
+	}
+}
+
+func TestRestore_LockedBucket(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("a.txt", "", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	archive := snapshotArchive(t, store)
+
+	if err := store.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	_, err = Restore(context.Background(), bytes.NewReader(archive), store.basePath, "test-bucket", RestoreOptions{Replace: true})
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	store.Unlock()
+	before, err := os.Stat(store.lockPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(context.Background(), bytes.NewReader(archive), store.basePath, "test-bucket", RestoreOptions{Replace: true}); err != nil {
+		t.Errorf("Restore after Unlock failed: %v", err)
+	}
+
+	// The lock file is not swapped with the metadata, so the lock was held throughout
+	after, err := os.Stat(store.lockPath())
+	if err != nil || !os.SameFile(before, after) {
+		t.Errorf("expected the lock file to be kept by Restore (%v)", err)
+	}
+}
+
+func TestRestore_RejectsInvalidArchives(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("a.txt", "", strings.NewReader("original")); err != nil {
+		t.Fatal(err)
+	}
+	// Bit rot in the source is caught before anything is restored
+	if err := os.WriteFile(store.keyToPath("a.txt"), []byte("rotten!!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rotten := snapshotArchive(t, store)
+
+	var escaping bytes.Buffer
+	zw, _ := zstd.NewWriter(&escaping)
+	tw := tar.NewWriter(zw)
+	manifest := []byte(`{"format":1,"bucket":"test-bucket"}`)
+	_ = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: snapshotManifestName, Size: int64(len(manifest)), Mode: 0644})
+	_, _ = tw.Write(manifest)
+	_ = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "data/../../evil.txt", Mode: 0644})
+	_ = tw.Close()
+	_ = zw.Close()
+
+	truncated := snapshotArchive(t, store)
+	truncated = truncated[:len(truncated)/2]
+
+	for name, archive := range map[string][]byte{
+		"corrupt data": rotten,
+		"escaping":     escaping.Bytes(),
+		"truncated":    truncated,
+		"not zstd":     []byte("hello"),
+	} {
+		dir := t.TempDir()
+		_, err := Restore(context.Background(), bytes.NewReader(archive), dir, "test-bucket", RestoreOptions{})
+		if !errors.Is(err, ErrInvalidSnapshot) {
+			t.Errorf("%s: expected ErrInvalidSnapshot, got %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "test-bucket")); !os.IsNotExist(err) {
+			t.Errorf("%s: expected nothing restored", name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "evil.txt")); !os.IsNotExist(err) {
+			t.Errorf("%s: file written outside the storage path", name)
+		}
+	}
+}
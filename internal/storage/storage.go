@@ -1,11 +1,15 @@
 package storage
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"mime"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,13 +22,41 @@ type Object struct {
 	LastModified time.Time
 	ContentType  string
 	ETag         string
+
+	// Versioning information ("" when the bucket has never been versioned)
+	VersionID      string
+	IsLatest       bool
+	IsDeleteMarker bool
+
+	Tags map[string]string // object tags, nil when untagged
+
+	// Server-side encryption: SSES3, SSEC or "" for plaintext
+	Encryption     string
+	CustomerKeyMD5 string // identifies the SSE-C key
+}
+
+// PutOptions holds optional attributes of an uploaded object
+type PutOptions struct {
+	ContentType string
+	Tags        map[string]string
+
+	// Encryption requests SSES3; "" applies SSE-S3 when a master key is configured.
+	// A CustomerKey selects SSE-C instead.
+	Encryption  string
+	CustomerKey []byte
 }
 
 // Storage handles file operations on the local filesystem
 type Storage struct {
-	basePath string
-	bucket   string
-	mu       sync.RWMutex
+	basePath    string
+	bucket      string
+	masterKey   []byte             // wraps the data keys of SSE-S3 objects, nil to store new objects in plaintext
+	compression *CompressionPolicy // objects compressed on disk, nil to store objects as is
+	dedup       bool               // store identical objects once, see SetDeduplication
+	quotas      *quotaTracker      // usage of the quotas set with SetQuotas, nil without quotas
+	index       *metadataIndex     // listings come from the index once opened with OpenIndex
+	lock        *os.File           // lock file held between Lock and Unlock
+	mu          sync.RWMutex
 }
 
 // NewStorage creates a new storage instance
@@ -35,93 +67,84 @@ func NewStorage(basePath, bucket string) (*Storage, error) {
 		return nil, fmt.Errorf("failed to create bucket directory: %w", err)
 	}
 
-	return &Storage{
+	s := &Storage{
 		basePath: basePath,
 		bucket:   bucket,
-	}, nil
-}
-
-// GetObject retrieves an object from storage
-func (s *Storage) GetObject(key string) (*Object, io.ReadCloser, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	path := s.keyToPath(key)
-
-	// Check for path traversal
-	if err := s.validatePath(path); err != nil {
-		return nil, nil, err
 	}
 
-	info, err := os.Stat(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil, ErrNotFound
-		}
-		return nil, nil, fmt.Errorf("failed to stat file: %w", err)
+	// Temporary files left behind by uploads interrupted by a crash can never complete
+	if err := os.RemoveAll(s.metaPath("tmp")); err != nil {
+		return nil, fmt.Errorf("failed to clean up temporary files: %w", err)
 	}
 
-	if info.IsDir() {
-		return nil, nil, ErrNotFound
-	}
+	return s, nil
+}
 
-	file, err := os.Open(path)
+// Open opens the storage of an existing bucket without the cleanup of NewStorage, so that
+// tools can use it while a server is running on the same directory. Such a Storage must
+// not be closed, since Close removes the temporary files of the server's uploads.
+func Open(basePath, bucket string) (*Storage, error) {
+	info, err := os.Stat(filepath.Join(basePath, bucket))
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+		return nil, fmt.Errorf("failed to open bucket directory: %w", err)
 	}
-
-	obj := &Object{
-		Key:          key,
-		Size:         info.Size(),
-		LastModified: info.ModTime(),
-		ContentType:  guessContentType(key),
-		ETag:         generateETag(info),
+	if !info.IsDir() {
+		return nil, fmt.Errorf("failed to open bucket directory: %s is not a directory", filepath.Join(basePath, bucket))
 	}
-
-	return obj, file, nil
+	return &Storage{basePath: basePath, bucket: bucket}, nil
 }
 
-// HeadObject retrieves object metadata without the body
-func (s *Storage) HeadObject(key string) (*Object, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// SetMasterKey sets the key wrapping the data keys of SSE-S3 objects. Once set, new
+// objects are encrypted by default. It must be called before the storage is used.
+func (s *Storage) SetMasterKey(key []byte) {
+	s.masterKey = key
+}
 
-	path := s.keyToPath(key)
+// SetCompression sets the policy selecting the new objects compressed on disk, nil to
+// disable compression. Existing objects are read back whatever the policy. It must be
+// called before the storage is used.
+func (s *Storage) SetCompression(policy *CompressionPolicy) {
+	s.compression = policy
+}
 
-	if err := s.validatePath(path); err != nil {
-		return nil, err
+// Close removes the temporary files of uploads that were still in progress and closes
+// the metadata index. Storage remains usable afterwards.
+func (s *Storage) Close() error {
+	if err := s.CloseIndex(); err != nil {
+		return err
 	}
+	return os.RemoveAll(s.metaPath("tmp"))
+}
 
-	info, err := os.Stat(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, ErrNotFound
-		}
-		return nil, fmt.Errorf("failed to stat file: %w", err)
-	}
+// CopyObject copies a version of an object (the current one when src has no version ID)
+// to dstKey. A nil opts keeps the source tags; otherwise opts replaces them.
+func (s *Storage) CopyObject(srcKey string, src GetOptions, dstKey string, opts *PutOptions) (*Object, error) {
+	return copyObject(s, srcKey, src, dstKey, opts)
+}
 
-	if info.IsDir() {
-		return nil, ErrNotFound
-	}
+// GetObject retrieves an object from storage
+func (s *Storage) GetObject(key string) (*Object, io.ReadCloser, error) {
+	return s.GetObjectVersion(key, "")
+}
 
-	return &Object{
-		Key:          key,
-		Size:         info.Size(),
-		LastModified: info.ModTime(),
-		ContentType:  guessContentType(key),
-		ETag:         generateETag(info),
-	}, nil
+// HeadObject retrieves object metadata without the body
+func (s *Storage) HeadObject(key string) (*Object, error) {
+	return s.HeadObjectVersion(key, "")
 }
 
 // PutObject stores an object
 func (s *Storage) PutObject(key string, contentType string, body io.Reader) (*Object, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.PutObjectWithOptions(key, body, PutOptions{ContentType: contentType})
+}
 
+// PutObjectWithOptions stores an object along with optional attributes such as tags
+func (s *Storage) PutObjectWithOptions(key string, body io.Reader, opts PutOptions) (*Object, error) {
 	// Handle folder markers (keys ending with /)
 	// S3 clients create these as 0-byte objects to represent "folders"
 	// We store them as actual directories on the filesystem
 	if strings.HasSuffix(key, "/") {
+		s.mu.Lock()
+		defer s.mu.Unlock()
 		return s.createFolderMarker(key)
 	}
 
@@ -131,44 +154,178 @@ func (s *Storage) PutObject(key string, contentType string, body io.Reader) (*Ob
 		return nil, err
 	}
 
+	if err := ValidateTags(opts.Tags); err != nil {
+		return nil, err
+	}
+
+	enc, dataKey, err := newEncryption(opts, s.masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	comp := newCompression(s.compression, key, opts.ContentType)
+
+	// Write the upload to a temporary file first, without holding the lock,
+	// so the previous version stays intact and readable until the upload completes
+	sum := sha256.New()
+	tmpPath, size, err := s.writeTempFile(body, dataKey, 0, comp, sum)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(tmpPath) }() // no-op once renamed into place
+	if enc != nil {
+		// Compressed data is encrypted after compression
+		enc.Size = size
+		if comp != nil {
+			enc.Size = comp.storedSize()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.commitFile(key, tmpPath, opts, &objectMeta{Encryption: enc, Compression: comp, Checksum: hashString(sum)})
+}
+
+// commitFile moves a fully written temporary file into place as the new current
+// version of key. stored holds the encryption, compression and checksum of the data.
+// The caller must hold the write lock.
+func (s *Storage) commitFile(key, tmpPath string, opts PutOptions, stored *objectMeta) (*Object, error) {
+	path := s.keyToPath(key)
+
 	// Create parent directories
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create directories: %w", err)
 	}
 
-	// Create the file
-	file, err := os.Create(path)
+	// Quotas are checked against the size of the original data
+	var before currentSize
+	if s.quotas != nil {
+		tmpInfo, err := os.Stat(tmpPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat file: %w", err)
+		}
+		before = s.currentSize(key)
+		if err := s.quotas.check(key, before, currentSize{size: stored.dataSize(tmpInfo.Size()), exists: true}); err != nil {
+			return nil, err
+		}
+	}
+
+	meta := &objectMeta{Key: key, Tags: opts.Tags, Encryption: stored.Encryption, Compression: stored.Compression, Checksum: stored.Checksum}
+
+	// Deduplicated data is shared through the blob named by its checksum. A shared blob
+	// keeps the modification time of its first object, so the time of this one is
+	// recorded in its metadata.
+	if s.deduplicates(stored.Encryption) && stored.Checksum != "" {
+		if tmpInfo, err := os.Stat(tmpPath); err == nil && s.shareBlob(tmpPath, stored.Checksum) {
+			meta.Blob = stored.Checksum
+			meta.LastModified = tmpInfo.ModTime()
+		}
+	}
+
+	versionID, err := s.prepareOverwrite(key)
 	if err != nil {
+		return nil, err
+	}
+	meta.VersionID = versionID
+
+	if err := os.Rename(tmpPath, path); err != nil {
 		return nil, fmt.Errorf("failed to create file: %w", err)
 	}
-	defer func() { _ = file.Close() }()
 
-	// Copy content
-	size, err := io.Copy(file, body)
-	if err != nil {
-		_ = os.Remove(path) // Clean up on error
-		return nil, fmt.Errorf("failed to write file: %w", err)
+	if err := s.writeSidecar(meta); err != nil {
+		return nil, err
 	}
 
 	// Get file info for response
-	info, err := file.Stat()
+	info, err := os.Stat(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to stat file: %w", err)
 	}
 
 	// Determine content type
+	contentType := opts.ContentType
 	if contentType == "" {
 		contentType = guessContentType(key)
 	}
 
-	return &Object{
-		Key:          key,
-		Size:         size,
-		LastModified: info.ModTime(),
-		ContentType:  contentType,
-		ETag:         generateETag(info),
-	}, nil
+	obj := &Object{
+		Key:         key,
+		ContentType: contentType,
+		VersionID:   versionID,
+		IsLatest:    true,
+		Tags:        opts.Tags,
+	}
+	meta.describe(obj, info)
+	s.quotas.apply(key, before, currentSize{size: obj.Size, exists: true})
+	s.indexKey(key)
+	return obj, nil
+}
+
+// writeTempFile copies body into a new file in the bucket's temporary directory and
+// returns its path and the size of body. With comp, body is compressed and its frames
+// are recorded in comp. With a data key, the data is then encrypted as the segment segmentID.
+// With sum, the data written to the file is also hashed.
+func (s *Storage) writeTempFile(body io.Reader, dataKey []byte, segmentID uint32, comp *compressionInfo, sum hash.Hash) (string, int64, error) {
+	tmpDir := s.metaPath("tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return "", 0, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(tmpDir, "upload-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	var w io.Writer = file
+	if sum != nil {
+		w = io.MultiWriter(file, sum)
+	}
+	size, err := writeData(w, body, dataKey, segmentID, comp)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name()) // Clean up on error
+		return "", 0, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return file.Name(), size, nil
+}
+
+// writeData copies body into w, compressing it with comp and then encrypting it with
+// dataKey when they are set, and returns the size of body
+func writeData(w io.Writer, body io.Reader, dataKey []byte, segmentID uint32, comp *compressionInfo) (int64, error) {
+	var closers []io.Closer // innermost writer first
+	if dataKey != nil {
+		enc, err := newEncryptingWriter(w, dataKey, segmentID)
+		if err != nil {
+			return 0, err
+		}
+		w = enc
+		closers = append(closers, enc)
+	}
+	if comp != nil {
+		compressor, err := newCompressingWriter(w, comp)
+		if err != nil {
+			return 0, err
+		}
+		w = compressor
+		closers = append(closers, compressor)
+	}
+
+	size, err := io.Copy(w, body)
+	if err != nil {
+		return 0, err
+	}
+	// The outermost writer flushes into the next one, so close them from the outside in
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
 }
 
 // createFolderMarker creates a directory for folder marker keys (ending with /)
@@ -191,6 +348,7 @@ func (s *Storage) createFolderMarker(key string) (*Object, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to stat directory: %w", err)
 	}
+	s.indexKey(key)
 
 	return &Object{
 		Key:          key,
@@ -203,20 +361,14 @@ func (s *Storage) createFolderMarker(key string) (*Object, error) {
 
 // DeleteObject removes an object from storage
 func (s *Storage) DeleteObject(key string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Handle folder marker deletion (keys ending with /)
-	if strings.HasSuffix(key, "/") {
-		return s.deleteFolderMarker(key)
-	}
+	_, err := s.DeleteObjectVersion(key, "")
+	return err
+}
 
+// deleteCurrent removes the current object at key; the caller must hold the write lock
+func (s *Storage) deleteCurrent(key string) error {
 	path := s.keyToPath(key)
 
-	if err := s.validatePath(path); err != nil {
-		return err
-	}
-
 	// Check if it exists
 	_, err := os.Stat(path)
 	if err != nil {
@@ -236,7 +388,7 @@ func (s *Storage) DeleteObject(key string) error {
 	// persist even when empty. Users who want to delete a folder must explicitly
 	// delete the folder marker key (e.g., DELETE myfolder/).
 
-	return nil
+	return s.removeSidecar(key)
 }
 
 // deleteFolderMarker removes a directory that was created as a folder marker
@@ -274,14 +426,73 @@ func (s *Storage) deleteFolderMarker(key string) error {
 
 // ListObjects returns all objects in the bucket
 func (s *Storage) ListObjects(prefix string) ([]Object, error) {
+	if s.indexed() {
+		result, err := s.listIndex(ListOptions{Prefix: prefix})
+		if err != nil {
+			return nil, err
+		}
+		return result.Objects, nil
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	bucketPath := filepath.Join(s.basePath, s.bucket)
+	return s.listObjects(prefix)
+}
+
+// ListObjectsPage returns a page of the objects under opts.Prefix in key order, grouping
+// keys by opts.Delimiter. With the metadata index open, the bucket is not walked.
+func (s *Storage) ListObjectsPage(opts ListOptions) (*ListResult, error) {
+	if s.indexed() {
+		return s.listIndex(opts)
+	}
+
+	s.mu.RLock()
+	objects, err := s.listObjects(opts.Prefix)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return listPage(objects, opts), nil
+}
+
+// indexed reports whether listings come from the metadata index
+func (s *Storage) indexed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.index != nil
+}
+
+// listObjects walks the bucket directory; the caller must hold the lock
+func (s *Storage) listObjects(prefix string) ([]Object, error) {
 	var objects []Object
+	err := s.walkObjects(prefix, func(obj Object) error {
+		objects = append(objects, obj)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// walkObjects calls fn with every object and folder under prefix, starting from the
+// deepest directory containing them; the caller must hold the lock
+func (s *Storage) walkObjects(prefix string, fn func(Object) error) error {
+	bucketPath := filepath.Join(s.basePath, s.bucket)
+	root := bucketPath
+	if i := strings.LastIndex(prefix, "/"); i >= 0 && validKey(prefix[:i+1]) {
+		root = s.keyToPath(prefix[:i])
+	}
 
-	err := filepath.Walk(bucketPath, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
+			// A prefix without objects has no directory
+			if path == root && root != bucketPath && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
 			return err
 		}
 
@@ -309,28 +520,46 @@ func (s *Storage) ListObjects(prefix string) ([]Object, error) {
 			return nil
 		}
 
-		// Directories report size 0 (S3 folder marker convention)
-		size := info.Size()
-		if info.IsDir() {
-			size = 0
+		obj, err := s.describeEntry(key, info)
+		if err != nil {
+			return err
 		}
-
-		objects = append(objects, Object{
-			Key:          key,
-			Size:         size,
-			LastModified: info.ModTime(),
-			ContentType:  guessContentType(key),
-			ETag:         generateETag(info),
-		})
-
-		return nil
+		return fn(obj)
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to list objects: %w", err)
+		return fmt.Errorf("failed to list objects: %w", err)
 	}
 
-	return objects, nil
+	return nil
+}
+
+// describeEntry returns the listing of the object or folder at key from its file info;
+// the caller must hold the lock
+func (s *Storage) describeEntry(key string, info os.FileInfo) (Object, error) {
+	// Directories report size 0 (S3 folder marker convention)
+	size := info.Size()
+	if info.IsDir() {
+		size = 0
+	}
+
+	obj := Object{
+		Key:          key,
+		Size:         size,
+		LastModified: info.ModTime(),
+		ContentType:  guessContentType(key),
+		ETag:         generateETag(info),
+	}
+
+	// Encrypted and compressed objects report the size of their original data
+	if !info.IsDir() {
+		meta, err := s.readSidecar(key)
+		if err != nil {
+			return Object{}, err
+		}
+		meta.describe(&obj, info)
+	}
+	return obj, nil
 }
 
 // EnsurePublicDir creates the public directory if it doesn't exist
@@ -341,7 +570,15 @@ func (s *Storage) EnsurePublicDir(prefix string) error {
 	// Remove trailing slash for directory creation
 	prefix = strings.TrimSuffix(prefix, "/")
 	publicPath := filepath.Join(s.basePath, s.bucket, prefix)
-	return os.MkdirAll(publicPath, 0755)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(publicPath, 0755); err != nil {
+		return err
+	}
+	s.indexKey(prefix + "/")
+	return nil
 }
 
 // keyToPath converts an S3 key to a filesystem path
@@ -432,13 +669,42 @@ func guessContentType(key string) string {
 
 // generateETag generates a simple ETag based on file modification time and size
 func generateETag(info os.FileInfo) string {
+	return objectETag(info.ModTime(), info.Size())
+}
+
+// hashString returns the hex digest of sum, "" when it is nil
+func hashString(sum hash.Hash) string {
+	if sum == nil {
+		return ""
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// objectETag generates the ETag of an object from its modification time and size
+func objectETag(modTime time.Time, size int64) string {
 	// Simple ETag: use modification time and size
 	// For a proper implementation, you'd compute MD5 of the content
-	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+	return fmt.Sprintf("\"%x-%x\"", modTime.UnixNano(), size)
 }
 
 // Errors
 var (
-	ErrNotFound    = fmt.Errorf("object not found")
-	ErrInvalidPath = fmt.Errorf("invalid path")
+	ErrNotFound         = fmt.Errorf("object not found")
+	ErrInvalidPath      = fmt.Errorf("invalid path")
+	ErrNoSuchVersion    = fmt.Errorf("version not found")
+	ErrInvalidVersionID = fmt.Errorf("invalid version id")
+	ErrDeleteMarker     = fmt.Errorf("version is a delete marker")
+	ErrNoSuchConfig     = fmt.Errorf("bucket configuration not found")
+	ErrInvalidTag       = fmt.Errorf("invalid tag")
+	ErrNoSuchUpload     = fmt.Errorf("multipart upload not found")
+	ErrInvalidPart      = fmt.Errorf("invalid part")
+	ErrInvalidPartOrder = fmt.Errorf("parts are not in ascending order")
+	ErrEntityTooSmall   = fmt.Errorf("part is smaller than the minimum allowed size")
+	ErrQuotaExceeded    = fmt.Errorf("quota exceeded")
+
+	ErrEncryptionNotConfigured  = fmt.Errorf("server-side encryption is not configured")
+	ErrInvalidEncryption        = fmt.Errorf("invalid encryption parameters")
+	ErrCustomerKeyRequired      = fmt.Errorf("object is encrypted with a customer key")
+	ErrCustomerKeyMismatch      = fmt.Errorf("customer key does not match the object")
+	ErrCustomerKeyNotApplicable = fmt.Errorf("object is not encrypted with a customer key")
 )
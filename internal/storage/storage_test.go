@@ -32,6 +32,39 @@ func TestNewStorage(t *testing.T) {
 	}
 }
 
+func TestOpen(t *testing.T) {
+	tempDir := t.TempDir()
+
+	if _, err := Open(tempDir, "test-bucket"); err == nil {
+		t.Fatal("expected error for a missing bucket directory")
+	}
+
+	server, err := NewStorage(tempDir, "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	tmpFile := server.metaPath("tmp", "upload")
+	if err := os.MkdirAll(filepath.Dir(tmpFile), 0755); err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	if err := os.WriteFile(tmpFile, []byte("in progress"), 0644); err != nil {
+		t.Fatalf("failed to write temporary file: %v", err)
+	}
+
+	tool, err := Open(tempDir, "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	if _, err := tool.PutObject("file.txt", "text/plain", strings.NewReader("data")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	// The uploads of the running server are left alone
+	if _, err := os.Stat(tmpFile); err != nil {
+		t.Errorf("expected the temporary file of the server to remain: %v", err)
+	}
+}
+
 func TestPutObject(t *testing.T) {
 	tempDir := t.TempDir()
 	storage, err := NewStorage(tempDir, "test-bucket")
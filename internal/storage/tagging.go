@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// S3 tagging limits
+const (
+	maxTagsPerObject  = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// ValidateTags checks a tag set against the S3 tagging limits
+func ValidateTags(tags map[string]string) error {
+	if len(tags) > maxTagsPerObject {
+		return fmt.Errorf("%w: at most %d tags are allowed per object", ErrInvalidTag, maxTagsPerObject)
+	}
+	for key, value := range tags {
+		if key == "" || utf8.RuneCountInString(key) > maxTagKeyLength || !utf8.ValidString(key) {
+			return fmt.Errorf("%w: tag keys must be 1 to %d characters", ErrInvalidTag, maxTagKeyLength)
+		}
+		if utf8.RuneCountInString(value) > maxTagValueLength || !utf8.ValidString(value) {
+			return fmt.Errorf("%w: tag values must be at most %d characters", ErrInvalidTag, maxTagValueLength)
+		}
+		if strings.HasPrefix(strings.ToLower(key), "aws:") {
+			return fmt.Errorf("%w: the aws: prefix is reserved", ErrInvalidTag)
+		}
+	}
+	return nil
+}
+
+// PutObjectTagging replaces the tags of a version of an object (the current
+// version when versionID is empty) and returns the version ID that was tagged
+func (s *Storage) PutObjectTagging(key, versionID string, tags map[string]string) (string, error) {
+	if err := ValidateTags(tags); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.setTags(key, versionID, tags)
+}
+
+// DeleteObjectTagging removes all tags from a version of an object
+// and returns the version ID that was updated
+func (s *Storage) DeleteObjectTagging(key, versionID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.setTags(key, versionID, nil)
+}
+
+// setTags stores the tags of a version of key; the caller must hold the write lock
+func (s *Storage) setTags(key, versionID string, tags map[string]string) (string, error) {
+	if len(tags) == 0 {
+		tags = nil
+	}
+
+	obj, _, err := s.resolveVersion(key, versionID)
+	if err != nil {
+		return "", err
+	}
+
+	if obj.IsLatest {
+		meta, err := s.readSidecar(key)
+		if err != nil {
+			return "", err
+		}
+		meta.Tags = tags
+		return obj.VersionID, s.writeSidecar(meta)
+	}
+
+	path := s.versionMetaPath(key, obj.VersionID)
+	entry, err := readMetaFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNoSuchVersion
+		}
+		return "", err
+	}
+	entry.Tags = tags
+	return obj.VersionID, writeMetaFile(path, entry)
+}
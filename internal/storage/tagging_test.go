@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTagging_PutWithTags(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	tags := map[string]string{"retention": "short", "customer": "123"}
+	obj, err := store.PutObjectWithOptions("report.csv", strings.NewReader("data"), PutOptions{Tags: tags})
+	if err != nil {
+		t.Fatalf("PutObjectWithOptions failed: %v", err)
+	}
+	if len(obj.Tags) != 2 {
+		t.Errorf("expected 2 tags on the returned object, got %v", obj.Tags)
+	}
+
+	head, err := store.HeadObject("report.csv")
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if head.Tags["retention"] != "short" || head.Tags["customer"] != "123" {
+		t.Errorf("unexpected tags: %v", head.Tags)
+	}
+
+	// Overwriting the object replaces its tags
+	if _, err := store.PutObject("report.csv", "", strings.NewReader("new")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	head, _ = store.HeadObject("report.csv")
+	if len(head.Tags) != 0 {
+		t.Errorf("expected overwrite to clear tags, got %v", head.Tags)
+	}
+}
+
+func TestTagging_PutAndDelete(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	if _, err := store.PutObjectTagging("missing.txt", "", map[string]string{"a": "1"}); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for missing object, got %v", err)
+	}
+
+	if _, err := store.PutObject("file.txt", "", strings.NewReader("data")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	versionID, err := store.PutObjectTagging("file.txt", "", map[string]string{"a": "1"})
+	if err != nil {
+		t.Fatalf("PutObjectTagging failed: %v", err)
+	}
+	if versionID != "" {
+		t.Errorf("expected no version ID for unversioned bucket, got %q", versionID)
+	}
+
+	head, _ := store.HeadObject("file.txt")
+	if head.Tags["a"] != "1" {
+		t.Errorf("expected tag a=1, got %v", head.Tags)
+	}
+
+	if _, err := store.DeleteObjectTagging("file.txt", ""); err != nil {
+		t.Fatalf("DeleteObjectTagging failed: %v", err)
+	}
+	head, _ = store.HeadObject("file.txt")
+	if head.Tags != nil {
+		t.Errorf("expected no tags after delete, got %v", head.Tags)
+	}
+}
+
+func TestTagging_Versions(t *testing.T) {
+	store := newVersionedStorage(t)
+
+	v1, err := store.PutObjectWithOptions("doc.txt", strings.NewReader("v1"), PutOptions{
+		Tags: map[string]string{"version": "one"},
+	})
+	if err != nil {
+		t.Fatalf("PutObjectWithOptions failed: %v", err)
+	}
+	v2, err := store.PutObject("doc.txt", "", strings.NewReader("v2"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	// Tags follow the version into the non-current area
+	old, err := store.HeadObjectVersion("doc.txt", v1.VersionID)
+	if err != nil {
+		t.Fatalf("HeadObjectVersion failed: %v", err)
+	}
+	if old.Tags["version"] != "one" {
+		t.Errorf("expected archived version to keep its tags, got %v", old.Tags)
+	}
+
+	// Tag a non-current version directly
+	if _, err := store.PutObjectTagging("doc.txt", v1.VersionID, map[string]string{"version": "first"}); err != nil {
+		t.Fatalf("PutObjectTagging failed: %v", err)
+	}
+	old, _ = store.HeadObjectVersion("doc.txt", v1.VersionID)
+	if old.Tags["version"] != "first" {
+		t.Errorf("expected updated tags on non-current version, got %v", old.Tags)
+	}
+	current, _ := store.HeadObject("doc.txt")
+	if len(current.Tags) != 0 {
+		t.Errorf("expected current version to stay untagged, got %v", current.Tags)
+	}
+
+	// Deleting the current version promotes v1 along with its tags
+	if _, err := store.DeleteObjectVersion("doc.txt", v2.VersionID); err != nil {
+		t.Fatalf("DeleteObjectVersion failed: %v", err)
+	}
+	current, _ = store.HeadObject("doc.txt")
+	if current.Tags["version"] != "first" {
+		t.Errorf("expected promoted version to keep its tags, got %v", current.Tags)
+	}
+
+	versions, _ := store.ListObjectVersions("doc.txt")
+	if len(versions) != 1 || versions[0].Tags["version"] != "first" {
+		t.Errorf("expected ListObjectVersions to report tags, got %+v", versions)
+	}
+
+	// Delete markers cannot be tagged
+	marker, _ := store.DeleteObjectVersion("doc.txt", "")
+	if _, err := store.PutObjectTagging("doc.txt", marker.VersionID, map[string]string{"a": "b"}); err != ErrDeleteMarker {
+		t.Errorf("expected ErrDeleteMarker, got %v", err)
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	tooMany := make(map[string]string)
+	for i := 0; i < 11; i++ {
+		tooMany[strings.Repeat("k", i+1)] = "v"
+	}
+
+	tests := []struct {
+		name  string
+		tags  map[string]string
+		valid bool
+	}{
+		{"nil", nil, true},
+		{"simple", map[string]string{"retention": "short", "empty": ""}, true},
+		{"too many", tooMany, false},
+		{"empty key", map[string]string{"": "v"}, false},
+		{"long key", map[string]string{strings.Repeat("k", 129): "v"}, false},
+		{"long value", map[string]string{"k": strings.Repeat("v", 257)}, false},
+		{"reserved prefix", map[string]string{"aws:createdBy": "v"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTags(tt.tags)
+			if tt.valid && err != nil {
+				t.Errorf("expected valid tags, got %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidTag) {
+				t.Errorf("expected ErrInvalidTag, got %v", err)
+			}
+		})
+	}
+}
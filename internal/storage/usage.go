@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Usage summarizes the space used by the bucket
+type Usage struct {
+	Objects int64 // current objects, excluding folder markers and delete markers
+	Bytes   int64 // bytes stored, including non-current versions and incomplete uploads
+}
+
+// Usage walks the bucket and its metadata directory. Bytes also counts sidecar
+// metadata and bucket configuration, so it reflects the disk space actually used:
+// deduplicated data shared by several files is counted once. The metadata index is
+// left out, since it is only a cache of the bucket.
+func (s *Storage) Usage() (*Usage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usage := &Usage{}
+	seen := make(map[fileID]bool)
+	addBytes := func(info os.FileInfo) {
+		if links, id, ok := fileLinks(info); ok && links > 1 {
+			if seen[id] {
+				return
+			}
+			seen[id] = true
+		}
+		usage.Bytes += info.Size()
+	}
+	err := filepath.WalkDir(filepath.Join(s.basePath, s.bucket), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		usage.Objects++
+		addBytes(info)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute usage: %w", err)
+	}
+
+	err = filepath.WalkDir(s.metaPath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Uploads may remove temporary files while the walk is in progress
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || path == s.indexPath() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		addBytes(info)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute usage: %w", err)
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,851 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Bucket versioning states, as used by PutBucketVersioning
+const (
+	VersioningEnabled   = "Enabled"
+	VersioningSuspended = "Suspended"
+)
+
+// nullVersionID identifies objects written while versioning was never enabled or suspended
+const nullVersionID = "null"
+
+// versionIDRegex matches version IDs generated by newVersionID
+var versionIDRegex = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// DeleteResult describes the outcome of a versioned delete
+type DeleteResult struct {
+	VersionID    string
+	DeleteMarker bool
+}
+
+// GetVersioning returns the bucket versioning status ("" if it was never enabled)
+func (s *Storage) GetVersioning() (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.versioningStatus()
+}
+
+// SetVersioning enables or suspends versioning for the bucket.
+// As in S3, a bucket that had versioning enabled can only be suspended, never unversioned again.
+func (s *Storage) SetVersioning(status string) error {
+	if status != VersioningEnabled && status != VersioningSuspended {
+		return fmt.Errorf("invalid versioning status: %q", status)
+	}
+	return s.PutBucketConfig("versioning", []byte(status))
+}
+
+// versioningStatus reads the versioning status; the caller must hold the lock
+func (s *Storage) versioningStatus() (string, error) {
+	data, err := s.getBucketConfig("versioning")
+	if err != nil {
+		if err == ErrNoSuchConfig {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(data), nil
+}
+
+// newVersionID generates a version ID that sorts lexically by creation time
+func newVersionID() string {
+	var random [8]byte
+	_, _ = rand.Read(random[:])
+	return fmt.Sprintf("%016x%s", time.Now().UnixNano(), hex.EncodeToString(random[:]))
+}
+
+// validVersionID reports whether id is a well-formed version ID.
+// This also guarantees version IDs are safe to use as file names.
+func validVersionID(id string) bool {
+	return id == nullVersionID || versionIDRegex.MatchString(id)
+}
+
+// versionDir returns the directory holding the non-current versions of key. It sits
+// in the folders of the key, so that the versions under a prefix share a subtree.
+func (s *Storage) versionDir(key string) string {
+	return s.metaPath("versions", versionFolder(key), keyHash(key))
+}
+
+// versionFolder returns the folder of the versions tree holding the versions of key:
+// the folders of the key, or of a folder marker its parent
+func versionFolder(key string) string {
+	key = strings.TrimSuffix(key, "/")
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return ""
+	}
+	return folderPath(key[:i])
+}
+
+// folderPath returns the relative path of slash-separated folders, up to the first
+// empty or "." folder, which the filesystem cannot represent
+func folderPath(folders string) string {
+	names := strings.Split(folders, "/")
+	for i, name := range names {
+		if name == "" || name == "." {
+			names = names[:i]
+			break
+		}
+	}
+	return filepath.Join(names...)
+}
+
+// versionDirKey returns the key whose versions are stored in dir, or "" if it holds none
+func versionDirKey(dir string) (string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to list versions: %w", err)
+	}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" || strings.HasPrefix(file.Name(), ".tmp-") {
+			continue
+		}
+		entry, err := readMetaFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", err
+		}
+		return entry.Key, nil
+	}
+	return "", nil
+}
+
+// versionDataPath returns the path of a non-current version's content
+func (s *Storage) versionDataPath(key, versionID string) string {
+	return filepath.Join(s.versionDir(key), versionID)
+}
+
+// versionMetaPath returns the path of a non-current version's metadata
+func (s *Storage) versionMetaPath(key, versionID string) string {
+	return filepath.Join(s.versionDir(key), versionID+".json")
+}
+
+// currentVersionID returns the version ID of the current object at key,
+// or "" if there is no current object. The caller must hold the lock.
+func (s *Storage) currentVersionID(key string) (string, error) {
+	info, err := os.Stat(s.keyToPath(key))
+	if err != nil || info.IsDir() {
+		return "", nil
+	}
+
+	meta, err := s.readSidecar(key)
+	if err != nil {
+		return "", err
+	}
+	if meta.VersionID == "" {
+		return nullVersionID, nil
+	}
+	return meta.VersionID, nil
+}
+
+// prepareOverwrite makes room for a new current version of key according to
+// the versioning status, returning the version ID the new object should get.
+// The caller must hold the write lock.
+func (s *Storage) prepareOverwrite(key string) (string, error) {
+	status, err := s.versioningStatus()
+	if err != nil {
+		return "", err
+	}
+
+	switch status {
+	case VersioningEnabled:
+		if err := s.archiveCurrent(key); err != nil {
+			return "", err
+		}
+		return newVersionID(), nil
+	case VersioningSuspended:
+		// A suspended bucket keeps a single "null" version that is replaced in place
+		currentID, err := s.currentVersionID(key)
+		if err != nil {
+			return "", err
+		}
+		if currentID != "" && currentID != nullVersionID {
+			if err := s.archiveCurrent(key); err != nil {
+				return "", err
+			}
+		}
+		if err := s.removeVersion(key, nullVersionID); err != nil {
+			return "", err
+		}
+		return nullVersionID, nil
+	default:
+		return "", nil
+	}
+}
+
+// archiveCurrent moves the current object at key into the non-current versions area.
+// The caller must hold the write lock.
+func (s *Storage) archiveCurrent(key string) error {
+	path := s.keyToPath(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.IsDir() {
+		return nil
+	}
+
+	meta, err := s.readSidecar(key)
+	if err != nil {
+		return err
+	}
+	versionID := meta.VersionID
+	if versionID == "" {
+		versionID = nullVersionID
+	}
+
+	if err := os.MkdirAll(s.versionDir(key), 0755); err != nil {
+		return fmt.Errorf("failed to create version directory: %w", err)
+	}
+	if err := os.Rename(path, s.versionDataPath(key, versionID)); err != nil {
+		return fmt.Errorf("failed to archive version: %w", err)
+	}
+
+	size, modTime := meta.dataSize(info.Size()), meta.modTime(info)
+	entry := &objectMeta{
+		Key:          key,
+		VersionID:    versionID,
+		Size:         size,
+		ETag:         objectETag(modTime, size),
+		LastModified: modTime,
+		Tags:         meta.Tags,
+		Encryption:   meta.Encryption,
+		Compression:  meta.Compression,
+		Blob:         meta.Blob,
+		Checksum:     meta.Checksum,
+	}
+	if err := writeMetaFile(s.versionMetaPath(key, versionID), entry); err != nil {
+		return err
+	}
+
+	return s.removeSidecar(key)
+}
+
+// putDeleteMarker records a delete marker as the latest version of key.
+// The caller must hold the write lock.
+func (s *Storage) putDeleteMarker(key, versionID string) error {
+	return writeMetaFile(s.versionMetaPath(key, versionID), &objectMeta{
+		Key:            key,
+		VersionID:      versionID,
+		IsDeleteMarker: true,
+		LastModified:   time.Now(),
+	})
+}
+
+// removeVersion permanently deletes a non-current version (or delete marker) of key.
+// Missing versions are ignored. The caller must hold the write lock.
+func (s *Storage) removeVersion(key, versionID string) error {
+	for _, path := range []string{s.versionDataPath(key, versionID), s.versionMetaPath(key, versionID)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete version: %w", err)
+		}
+	}
+
+	// Drop the version directory and its folders once the last version is gone
+	// (fails harmlessly otherwise)
+	root := s.metaPath("versions")
+	for dir := s.versionDir(key); dir != root; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// versionEntries returns the non-current versions of key, newest first.
+// The caller must hold the lock.
+func (s *Storage) versionEntries(key string) ([]*objectMeta, error) {
+	files, err := filepath.Glob(filepath.Join(s.versionDir(key), "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+
+	entries := make([]*objectMeta, 0, len(files))
+	for _, file := range files {
+		entry, err := readMetaFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	sortVersions(entries)
+	return entries, nil
+}
+
+// sortVersions orders versions by key, then current object first, then newest first
+func sortVersions(entries []*objectMeta) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Key != entries[j].Key {
+			return entries[i].Key < entries[j].Key
+		}
+		if entries[i].isCurrent != entries[j].isCurrent {
+			return entries[i].isCurrent
+		}
+		if !entries[i].LastModified.Equal(entries[j].LastModified) {
+			return entries[i].LastModified.After(entries[j].LastModified)
+		}
+		return entries[i].VersionID > entries[j].VersionID
+	})
+}
+
+// promoteLatest restores the newest non-current version of key as the current
+// object, unless the newest version is a delete marker. The caller must hold the write lock.
+func (s *Storage) promoteLatest(key string) error {
+	entries, err := s.versionEntries(key)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 || entries[0].IsDeleteMarker {
+		return nil
+	}
+
+	latest := entries[0]
+	path := s.keyToPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+	if err := os.Rename(s.versionDataPath(key, latest.VersionID), path); err != nil {
+		return fmt.Errorf("failed to restore version: %w", err)
+	}
+	restored := &objectMeta{
+		Key:         key,
+		VersionID:   latest.VersionID,
+		Tags:        latest.Tags,
+		Encryption:  latest.Encryption,
+		Compression: latest.Compression,
+		Blob:        latest.Blob,
+		Checksum:    latest.Checksum,
+	}
+	if latest.Blob != "" {
+		restored.LastModified = latest.LastModified
+	}
+	if err := s.writeSidecar(restored); err != nil {
+		return err
+	}
+	return s.removeVersion(key, latest.VersionID)
+}
+
+// GetObjectVersion retrieves a specific version of an object.
+// An empty versionID returns the current version.
+func (s *Storage) GetObjectVersion(key, versionID string) (*Object, io.ReadCloser, error) {
+	return s.GetObjectWithOptions(key, GetOptions{VersionID: versionID})
+}
+
+// GetObjectWithOptions retrieves a version of an object, decrypting it with the
+// customer key of the options when it was stored with SSE-C. The reader implements
+// io.Seeker, so that ranges can be read without going through the whole object.
+func (s *Storage) GetObjectWithOptions(key string, opts GetOptions) (*Object, io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, path, meta, err := s.resolveVersionData(key, opts.VersionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := CheckCustomerKey(obj, opts.CustomerKey); err != nil {
+		return nil, nil, err
+	}
+
+	var dataKey []byte
+	if meta.Encryption != nil {
+		if dataKey, err = meta.Encryption.dataKey(s.masterKey, opts.CustomerKey); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	// Data is compressed before being encrypted, so it is decrypted first
+	var reader io.ReadSeekCloser = file
+	if meta.Encryption != nil {
+		if reader, err = newDecryptingReader(file, file, dataKey, meta.Encryption.segments()); err != nil {
+			_ = file.Close()
+			return nil, nil, err
+		}
+	}
+	if meta.Compression != nil {
+		if reader, err = newDecompressingReader(reader, reader, meta.Compression); err != nil {
+			_ = file.Close()
+			return nil, nil, err
+		}
+	}
+	return obj, reader, nil
+}
+
+// HeadObjectVersion retrieves metadata of a specific version of an object.
+// An empty versionID returns the current version.
+func (s *Storage) HeadObjectVersion(key, versionID string) (*Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, _, err := s.resolveVersion(key, versionID)
+	return obj, err
+}
+
+// resolveVersion locates a version of key and returns its metadata and content path.
+// The caller must hold the lock.
+func (s *Storage) resolveVersion(key, versionID string) (*Object, string, error) {
+	obj, path, _, err := s.resolveVersionData(key, versionID)
+	return obj, path, err
+}
+
+// resolveVersionData is resolveVersion also returning the metadata of the version,
+// which describes how the content is stored. The caller must hold the lock.
+func (s *Storage) resolveVersionData(key, versionID string) (*Object, string, *objectMeta, error) {
+	path := s.keyToPath(key)
+
+	if err := s.validatePath(path); err != nil {
+		return nil, "", nil, err
+	}
+
+	if versionID != "" && !validVersionID(versionID) {
+		return nil, "", nil, ErrInvalidVersionID
+	}
+
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, "", nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	// The current object answers requests without a version ID and requests for its own ID
+	if err == nil && !info.IsDir() {
+		meta, err := s.readSidecar(key)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		currentID := meta.VersionID
+		if currentID == "" {
+			currentID = nullVersionID
+		}
+
+		if versionID == "" || versionID == currentID {
+			obj := &Object{
+				Key:         key,
+				ContentType: guessContentType(key),
+				VersionID:   meta.VersionID,
+				IsLatest:    true,
+				Tags:        meta.Tags,
+			}
+			meta.describe(obj, info)
+			return obj, path, meta, nil
+		}
+	}
+
+	if versionID == "" {
+		return nil, "", nil, ErrNotFound
+	}
+
+	entry, err := readMetaFile(s.versionMetaPath(key, versionID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", nil, ErrNoSuchVersion
+		}
+		return nil, "", nil, err
+	}
+	if entry.IsDeleteMarker {
+		return nil, "", nil, ErrDeleteMarker
+	}
+
+	obj := &Object{
+		Key:          key,
+		Size:         entry.Size,
+		LastModified: entry.LastModified,
+		ContentType:  guessContentType(key),
+		ETag:         entry.ETag,
+		VersionID:    entry.VersionID,
+		Tags:         entry.Tags,
+	}
+	entry.Encryption.describe(obj)
+	return obj, s.versionDataPath(key, versionID), entry, nil
+}
+
+// DeleteObjectVersion deletes an object according to the bucket versioning status.
+// Without a version ID, versioned buckets keep the data and add a delete marker;
+// with a version ID, that version (or delete marker) is permanently removed.
+func (s *Storage) DeleteObjectVersion(key, versionID string) (*DeleteResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Folder markers are plain directories and are never versioned
+	if strings.HasSuffix(key, "/") {
+		if err := s.deleteFolderMarker(key); err != nil {
+			return nil, err
+		}
+		s.indexKey(key)
+		return &DeleteResult{}, nil
+	}
+
+	path := s.keyToPath(key)
+
+	if err := s.validatePath(path); err != nil {
+		return nil, err
+	}
+	defer s.indexKey(key)
+
+	// Deleting a version may remove the current object or make an older one current
+	if s.quotas != nil {
+		before := s.currentSize(key)
+		defer func() { s.quotas.apply(key, before, s.currentSize(key)) }()
+	}
+
+	if versionID != "" {
+		if !validVersionID(versionID) {
+			return nil, ErrInvalidVersionID
+		}
+		return s.deleteSpecificVersion(key, versionID)
+	}
+
+	status, err := s.versioningStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case VersioningEnabled:
+		if err := s.archiveCurrent(key); err != nil {
+			return nil, err
+		}
+		markerID := newVersionID()
+		if err := s.putDeleteMarker(key, markerID); err != nil {
+			return nil, err
+		}
+		return &DeleteResult{VersionID: markerID, DeleteMarker: true}, nil
+	case VersioningSuspended:
+		// The null version is replaced by a null delete marker; other versions are kept
+		currentID, err := s.currentVersionID(key)
+		if err != nil {
+			return nil, err
+		}
+		if currentID == nullVersionID {
+			if err := s.deleteCurrent(key); err != nil {
+				return nil, err
+			}
+		} else if err := s.archiveCurrent(key); err != nil {
+			return nil, err
+		}
+		if err := s.removeVersion(key, nullVersionID); err != nil {
+			return nil, err
+		}
+		if err := s.putDeleteMarker(key, nullVersionID); err != nil {
+			return nil, err
+		}
+		return &DeleteResult{VersionID: nullVersionID, DeleteMarker: true}, nil
+	default:
+		return &DeleteResult{}, s.deleteCurrent(key)
+	}
+}
+
+// deleteSpecificVersion permanently removes one version of key.
+// The caller must hold the write lock.
+func (s *Storage) deleteSpecificVersion(key, versionID string) (*DeleteResult, error) {
+	currentID, err := s.currentVersionID(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentID == versionID {
+		if err := s.deleteCurrent(key); err != nil {
+			return nil, err
+		}
+		if err := s.promoteLatest(key); err != nil {
+			return nil, err
+		}
+		return &DeleteResult{VersionID: versionID}, nil
+	}
+
+	entry, err := readMetaFile(s.versionMetaPath(key, versionID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// S3 treats deleting a version that does not exist as a success
+			return &DeleteResult{VersionID: versionID}, nil
+		}
+		return nil, err
+	}
+
+	if err := s.removeVersion(key, versionID); err != nil {
+		return nil, err
+	}
+
+	// Removing a delete marker can make an older version current again
+	if currentID == "" {
+		if err := s.promoteLatest(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &DeleteResult{VersionID: versionID, DeleteMarker: entry.IsDeleteMarker}, nil
+}
+
+// errStopWalk stops a version walk once a page is full
+var errStopWalk = errors.New("stop walk")
+
+// ListObjectVersions returns every version and delete marker in the bucket,
+// sorted by key and then newest first
+func (s *Storage) ListObjectVersions(prefix string) ([]Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var versions []Object
+	err := s.walkVersions(prefix, "", func(v Object) error {
+		versions = append(versions, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// ListObjectVersionsPage returns the page of versions and delete markers described by
+// opts. Only the folders under the prefix are walked, from the key marker on, and the
+// walk stops as soon as the page is full.
+func (s *Storage) ListObjectVersionsPage(opts VersionListOptions) (*VersionListResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pager := newVersionPager(opts)
+	err := s.walkVersions(opts.Prefix, opts.KeyMarker, func(v Object) error {
+		if pager.add(v) {
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return nil, err
+	}
+	return pager.page, nil
+}
+
+// versionWalker walks the current objects and the versions tree side by side, one
+// folder at a time, calling fn with the versions of every key under prefix from
+// marker on, sorted by key and then newest first
+type versionWalker struct {
+	s      *Storage
+	prefix string
+	marker string
+	fn     func(Object) error
+}
+
+// walkVersions calls fn with the versions under prefix of the keys from marker on, in
+// listing order, until fn returns an error; the caller must hold the lock
+func (s *Storage) walkVersions(prefix, marker string, fn func(Object) error) error {
+	w := &versionWalker{s: s, prefix: prefix, marker: marker, fn: fn}
+
+	// The keys under prefix are all in the folder of its last full folder, apart
+	// from the folder itself whose versions are in its parent
+	folder := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		folder = folderPath(prefix[:i])
+		if i == len(prefix)-1 && versionFolder(prefix) != folder && prefix >= marker {
+			var info os.FileInfo
+			if stat, err := os.Lstat(filepath.Join(s.basePath, s.bucket, folder)); err == nil && stat.IsDir() {
+				info = stat
+			}
+			if err := w.key(prefix, info); err != nil {
+				return fmt.Errorf("failed to list versions: %w", err)
+			}
+		}
+	}
+
+	if err := w.folder(folder); err != nil {
+		return fmt.Errorf("failed to list versions: %w", err)
+	}
+	return nil
+}
+
+// folder walks the keys in folder, the path of a folder relative to the bucket
+func (w *versionWalker) folder(folder string) error {
+	keyPrefix := ""
+	if folder != "" {
+		keyPrefix = filepath.ToSlash(folder) + "/"
+	}
+
+	// Current objects and subfolders
+	infos := make(map[string]os.FileInfo)
+	folders := make(map[string]bool)
+	entries, err := os.ReadDir(filepath.Join(w.s.basePath, w.s.bucket, folder))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		key := keyPrefix + entry.Name()
+		if entry.IsDir() {
+			key += "/"
+			folders[key] = true
+		}
+		infos[key] = info
+	}
+
+	// Version directories hold the files of one key; the other directories are folders
+	keys := make(map[string]bool)
+	versionsPath := w.s.metaPath("versions", folder)
+	entries, err = os.ReadDir(versionsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(versionsPath, entry.Name())
+		children, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		hasVersions := false
+		for _, child := range children {
+			if child.IsDir() {
+				folders[keyPrefix+entry.Name()+"/"] = true
+			} else {
+				hasVersions = true
+			}
+		}
+		if hasVersions {
+			key, err := versionDirKey(dir)
+			if err != nil {
+				return err
+			}
+			if key != "" {
+				keys[key] = true
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(infos)+len(keys))
+	for key := range infos {
+		sorted = append(sorted, key)
+	}
+	for key := range folders {
+		if _, ok := infos[key]; !ok {
+			sorted = append(sorted, key)
+		}
+	}
+	for key := range keys {
+		if _, ok := infos[key]; !ok && !folders[key] {
+			sorted = append(sorted, key)
+		}
+	}
+	sort.Strings(sorted)
+
+	// The keys of a subfolder all start with its key, so none of the other keys of
+	// this folder sorts among them: listing each subfolder in place keeps key order
+	for _, key := range sorted {
+		if key >= w.marker && strings.HasPrefix(key, w.prefix) {
+			if err := w.key(key, infos[key]); err != nil {
+				return err
+			}
+		}
+		if !folders[key] {
+			continue
+		}
+		if key < w.marker && !strings.HasPrefix(w.marker, key) {
+			continue
+		}
+		if !strings.HasPrefix(key, w.prefix) && !strings.HasPrefix(w.prefix, key) {
+			continue
+		}
+		if err := w.folder(filepath.Join(folder, key[len(keyPrefix):len(key)-1])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// key lists the versions of key, starting with the current object or folder whose
+// file info is info, if any
+func (w *versionWalker) key(key string, info os.FileInfo) error {
+	var entries []*objectMeta
+	if info != nil {
+		obj, err := w.s.describeEntry(key, info)
+		if err != nil {
+			return err
+		}
+		entry := &objectMeta{
+			Key:          obj.Key,
+			VersionID:    nullVersionID,
+			Size:         obj.Size,
+			ETag:         obj.ETag,
+			LastModified: obj.LastModified,
+			isCurrent:    true,
+		}
+		if !info.IsDir() {
+			if meta, err := w.s.readSidecar(key); err == nil {
+				if meta.VersionID != "" {
+					entry.VersionID = meta.VersionID
+				}
+				entry.Tags = meta.Tags
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	versions, err := w.s.versionEntries(key)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, versions...)
+	sortVersions(entries)
+
+	// The first entry is the latest: the current object if there is one, otherwise
+	// the newest non-current version (typically a delete marker)
+	for i, entry := range entries {
+		err := w.fn(Object{
+			Key:            entry.Key,
+			Size:           entry.Size,
+			LastModified:   entry.LastModified,
+			ContentType:    guessContentType(entry.Key),
+			ETag:           entry.ETag,
+			VersionID:      entry.VersionID,
+			IsLatest:       i == 0,
+			IsDeleteMarker: entry.IsDeleteMarker,
+			Tags:           entry.Tags,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,457 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newVersionedStorage creates a storage instance with versioning enabled
+func newVersionedStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.SetVersioning(VersioningEnabled); err != nil {
+		t.Fatalf("failed to enable versioning: %v", err)
+	}
+	return store
+}
+
+// readVersion returns the content of a specific version of key
+func readVersion(t *testing.T, store *Storage, key, versionID string) string {
+	t.Helper()
+
+	_, reader, err := store.GetObjectVersion(key, versionID)
+	if err != nil {
+		t.Fatalf("GetObjectVersion(%q, %q) failed: %v", key, versionID, err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	data, _ := io.ReadAll(reader)
+	return string(data)
+}
+
+func TestVersioning_Status(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	status, err := store.GetVersioning()
+	if err != nil {
+		t.Fatalf("GetVersioning failed: %v", err)
+	}
+	if status != "" {
+		t.Errorf("expected empty status for new bucket, got %q", status)
+	}
+
+	for _, want := range []string{VersioningEnabled, VersioningSuspended} {
+		if err := store.SetVersioning(want); err != nil {
+			t.Fatalf("SetVersioning(%q) failed: %v", want, err)
+		}
+		status, _ = store.GetVersioning()
+		if status != want {
+			t.Errorf("expected status %q, got %q", want, status)
+		}
+	}
+
+	if err := store.SetVersioning("Disabled"); err == nil {
+		t.Error("expected error for invalid versioning status")
+	}
+}
+
+func TestVersioning_UnversionedHasNoVersionID(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	obj, err := store.PutObject("file.txt", "text/plain", strings.NewReader("v1"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if obj.VersionID != "" {
+		t.Errorf("expected no version ID, got %q", obj.VersionID)
+	}
+
+	// Overwrites replace the object in place
+	if _, err := store.PutObject("file.txt", "text/plain", strings.NewReader("v2")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	versions, err := store.ListObjectVersions("")
+	if err != nil {
+		t.Fatalf("ListObjectVersions failed: %v", err)
+	}
+	if len(versions) != 1 || versions[0].VersionID != "null" {
+		t.Errorf("expected single null version, got %+v", versions)
+	}
+}
+
+func TestVersioning_OverwriteKeepsPreviousVersions(t *testing.T) {
+	store := newVersionedStorage(t)
+
+	v1, err := store.PutObject("doc.txt", "text/plain", strings.NewReader("first"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	v2, err := store.PutObject("doc.txt", "text/plain", strings.NewReader("second"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	if v1.VersionID == "" || v2.VersionID == "" || v1.VersionID == v2.VersionID {
+		t.Fatalf("expected distinct version IDs, got %q and %q", v1.VersionID, v2.VersionID)
+	}
+
+	if got := readVersion(t, store, "doc.txt", ""); got != "second" {
+		t.Errorf("expected current content 'second', got %q", got)
+	}
+	if got := readVersion(t, store, "doc.txt", v1.VersionID); got != "first" {
+		t.Errorf("expected v1 content 'first', got %q", got)
+	}
+	if got := readVersion(t, store, "doc.txt", v2.VersionID); got != "second" {
+		t.Errorf("expected v2 content 'second', got %q", got)
+	}
+
+	head, err := store.HeadObjectVersion("doc.txt", v1.VersionID)
+	if err != nil {
+		t.Fatalf("HeadObjectVersion failed: %v", err)
+	}
+	if head.Size != 5 || head.VersionID != v1.VersionID {
+		t.Errorf("unexpected v1 metadata: %+v", head)
+	}
+}
+
+func TestVersioning_NonCurrentVersionsAreHidden(t *testing.T) {
+	tempDir := t.TempDir()
+	store, err := NewStorage(tempDir, "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	_ = store.SetVersioning(VersioningEnabled)
+
+	_, _ = store.PutObject("a.txt", "text/plain", strings.NewReader("1"))
+	_, _ = store.PutObject("a.txt", "text/plain", strings.NewReader("2"))
+
+	objects, err := store.ListObjects("")
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Key != "a.txt" {
+		t.Errorf("expected only the current object in listing, got %+v", objects)
+	}
+
+	// Non-current versions live outside the bucket directory
+	if _, err := os.Stat(filepath.Join(tempDir, metaDirName, "test-bucket", "versions")); err != nil {
+		t.Errorf("expected versions directory in hidden metadata area: %v", err)
+	}
+}
+
+func TestVersioning_DeleteCreatesDeleteMarker(t *testing.T) {
+	store := newVersionedStorage(t)
+
+	v1, _ := store.PutObject("photo.png", "image/png", strings.NewReader("png"))
+
+	result, err := store.DeleteObjectVersion("photo.png", "")
+	if err != nil {
+		t.Fatalf("DeleteObjectVersion failed: %v", err)
+	}
+	if !result.DeleteMarker || result.VersionID == "" {
+		t.Errorf("expected delete marker with version ID, got %+v", result)
+	}
+
+	if _, err := store.HeadObject("photo.png"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+
+	// The data is still reachable by version ID
+	if got := readVersion(t, store, "photo.png", v1.VersionID); got != "png" {
+		t.Errorf("expected old version content 'png', got %q", got)
+	}
+
+	// Reading the delete marker itself is not allowed
+	if _, err := store.HeadObjectVersion("photo.png", result.VersionID); err != ErrDeleteMarker {
+		t.Errorf("expected ErrDeleteMarker, got %v", err)
+	}
+
+	versions, err := store.ListObjectVersions("")
+	if err != nil {
+		t.Fatalf("ListObjectVersions failed: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 versions, got %d", len(versions))
+	}
+	if !versions[0].IsDeleteMarker || !versions[0].IsLatest {
+		t.Errorf("expected latest entry to be the delete marker, got %+v", versions[0])
+	}
+	if versions[1].VersionID != v1.VersionID || versions[1].IsLatest {
+		t.Errorf("expected non-current v1 entry, got %+v", versions[1])
+	}
+}
+
+func TestVersioning_DeleteMarkerRemovalRestoresObject(t *testing.T) {
+	store := newVersionedStorage(t)
+
+	v1, _ := store.PutObject("restore.txt", "text/plain", strings.NewReader("keep me"))
+	marker, _ := store.DeleteObjectVersion("restore.txt", "")
+
+	result, err := store.DeleteObjectVersion("restore.txt", marker.VersionID)
+	if err != nil {
+		t.Fatalf("DeleteObjectVersion failed: %v", err)
+	}
+	if !result.DeleteMarker {
+		t.Error("expected result to report a removed delete marker")
+	}
+
+	obj, err := store.HeadObject("restore.txt")
+	if err != nil {
+		t.Fatalf("expected object to be restored, got %v", err)
+	}
+	if obj.VersionID != v1.VersionID {
+		t.Errorf("expected restored version %q, got %q", v1.VersionID, obj.VersionID)
+	}
+}
+
+func TestVersioning_DeleteCurrentVersionPromotesPrevious(t *testing.T) {
+	store := newVersionedStorage(t)
+
+	v1, _ := store.PutObject("report.csv", "text/csv", strings.NewReader("old"))
+	v2, _ := store.PutObject("report.csv", "text/csv", strings.NewReader("new"))
+
+	if _, err := store.DeleteObjectVersion("report.csv", v2.VersionID); err != nil {
+		t.Fatalf("DeleteObjectVersion failed: %v", err)
+	}
+
+	if got := readVersion(t, store, "report.csv", ""); got != "old" {
+		t.Errorf("expected previous version to become current, got %q", got)
+	}
+	if _, err := store.HeadObjectVersion("report.csv", v2.VersionID); err != ErrNoSuchVersion {
+		t.Errorf("expected ErrNoSuchVersion for deleted version, got %v", err)
+	}
+
+	if _, err := store.DeleteObjectVersion("report.csv", v1.VersionID); err != nil {
+		t.Fatalf("DeleteObjectVersion failed: %v", err)
+	}
+	if _, err := store.HeadObject("report.csv"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound once all versions are deleted, got %v", err)
+	}
+
+	versions, _ := store.ListObjectVersions("report.csv")
+	if len(versions) != 0 {
+		t.Errorf("expected no versions left, got %+v", versions)
+	}
+}
+
+func TestVersioning_PreExistingObjectBecomesNullVersion(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	_, _ = store.PutObject("legacy.txt", "text/plain", strings.NewReader("legacy"))
+	_ = store.SetVersioning(VersioningEnabled)
+	_, _ = store.PutObject("legacy.txt", "text/plain", strings.NewReader("versioned"))
+
+	if got := readVersion(t, store, "legacy.txt", "null"); got != "legacy" {
+		t.Errorf("expected null version content 'legacy', got %q", got)
+	}
+}
+
+func TestVersioning_Suspended(t *testing.T) {
+	store := newVersionedStorage(t)
+
+	v1, _ := store.PutObject("s.txt", "text/plain", strings.NewReader("enabled"))
+	_ = store.SetVersioning(VersioningSuspended)
+
+	n1, err := store.PutObject("s.txt", "text/plain", strings.NewReader("null-1"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if n1.VersionID != "null" {
+		t.Errorf("expected null version ID in suspended bucket, got %q", n1.VersionID)
+	}
+
+	// A second write replaces the null version instead of keeping it
+	_, _ = store.PutObject("s.txt", "text/plain", strings.NewReader("null-2"))
+
+	versions, _ := store.ListObjectVersions("")
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 versions (null + enabled), got %+v", versions)
+	}
+	if got := readVersion(t, store, "s.txt", "null"); got != "null-2" {
+		t.Errorf("expected null version 'null-2', got %q", got)
+	}
+	if got := readVersion(t, store, "s.txt", v1.VersionID); got != "enabled" {
+		t.Errorf("expected enabled-era version to be kept, got %q", got)
+	}
+
+	result, err := store.DeleteObjectVersion("s.txt", "")
+	if err != nil {
+		t.Fatalf("DeleteObjectVersion failed: %v", err)
+	}
+	if result.VersionID != "null" || !result.DeleteMarker {
+		t.Errorf("expected null delete marker, got %+v", result)
+	}
+}
+
+func TestVersioning_InvalidVersionID(t *testing.T) {
+	store := newVersionedStorage(t)
+	_, _ = store.PutObject("x.txt", "text/plain", strings.NewReader("x"))
+
+	for _, id := range []string{"../../etc/passwd", "abc", "NULL"} {
+		if _, _, err := store.GetObjectVersion("x.txt", id); err != ErrInvalidVersionID {
+			t.Errorf("GetObjectVersion(%q): expected ErrInvalidVersionID, got %v", id, err)
+		}
+		if _, err := store.DeleteObjectVersion("x.txt", id); err != ErrInvalidVersionID {
+			t.Errorf("DeleteObjectVersion(%q): expected ErrInvalidVersionID, got %v", id, err)
+		}
+	}
+}
+
+// versionKeys returns the key and version ID of each version
+func versionKeys(versions []Object) []string {
+	keys := make([]string, len(versions))
+	for i, v := range versions {
+		keys[i] = v.Key + "@" + v.VersionID
+	}
+	return keys
+}
+
+func TestListObjectVersionsPage_Pagination(t *testing.T) {
+	store := newVersionedStorage(t)
+
+	// Keys spread over folders, with '-' sorting before the '/' of a folder, and
+	// deleted keys left with versions only
+	if _, err := store.PutObject("a", "text/plain", strings.NewReader("a")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if err := store.DeleteObject("a"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	for _, key := range []string{"a-b", "a/b/c", "a/c", "a0", "b/x", "a/b/c", "a-b"} {
+		if _, err := store.PutObject(key, "text/plain", strings.NewReader(key)); err != nil {
+			t.Fatalf("PutObject(%q) failed: %v", key, err)
+		}
+	}
+	for _, key := range []string{"a-b", "a/c"} {
+		if err := store.DeleteObject(key); err != nil {
+			t.Fatalf("DeleteObject(%q) failed: %v", key, err)
+		}
+	}
+
+	all, err := store.ListObjectVersions("")
+	if err != nil {
+		t.Fatalf("ListObjectVersions failed: %v", err)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i-1].Key > all[i].Key {
+			t.Fatalf("versions not sorted by key: %v", versionKeys(all))
+		}
+		if all[i].IsLatest != (all[i-1].Key != all[i].Key) {
+			t.Fatalf("wrong latest version at %d: %v", i, versionKeys(all))
+		}
+	}
+
+	for _, prefix := range []string{"", "a", "a/", "a/b/", "a/b/c", "b", "c"} {
+		var want []Object
+		for _, v := range all {
+			if strings.HasPrefix(v.Key, prefix) {
+				want = append(want, v)
+			}
+		}
+
+		for _, maxKeys := range []int{1, 2, 3, 100} {
+			opts := VersionListOptions{Prefix: prefix, MaxKeys: maxKeys}
+			var got []Object
+			for {
+				page, err := store.ListObjectVersionsPage(opts)
+				if err != nil {
+					t.Fatalf("ListObjectVersionsPage(%+v) failed: %v", opts, err)
+				}
+				got = append(got, page.Versions...)
+				if !page.IsTruncated {
+					break
+				}
+				opts.KeyMarker, opts.VersionIDMarker = page.NextKeyMarker, page.NextVersionIDMarker
+			}
+
+			if strings.Join(versionKeys(got), ",") != strings.Join(versionKeys(want), ",") {
+				t.Errorf("prefix %q, max-keys %d: expected %v, got %v", prefix, maxKeys, versionKeys(want), versionKeys(got))
+			}
+		}
+	}
+}
+
+func TestListObjectVersionsPage_WalksOnlyThePage(t *testing.T) {
+	store := newVersionedStorage(t)
+
+	for _, key := range []string{"a/1", "a/2", "a/3", "z/1", "z/1"} {
+		if _, err := store.PutObject(key, "text/plain", strings.NewReader(key)); err != nil {
+			t.Fatalf("PutObject(%q) failed: %v", key, err)
+		}
+	}
+
+	// Versions that are never walked cannot fail the listing
+	files, _ := filepath.Glob(filepath.Join(store.versionDir("z/1"), "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("expected one version of z/1, got %v", files)
+	}
+	if err := os.WriteFile(files[0], []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ListObjectVersions(""); err == nil {
+		t.Fatal("expected the corrupt version to fail a full listing")
+	}
+
+	page, err := store.ListObjectVersionsPage(VersionListOptions{Prefix: "a/"})
+	if err != nil {
+		t.Fatalf("ListObjectVersionsPage failed: %v", err)
+	}
+	if len(page.Versions) != 4 || page.IsTruncated {
+		t.Errorf("expected the folder and 3 versions under a/, got %v", versionKeys(page.Versions))
+	}
+
+	page, err = store.ListObjectVersionsPage(VersionListOptions{MaxKeys: 3})
+	if err != nil {
+		t.Fatalf("ListObjectVersionsPage failed: %v", err)
+	}
+	if !page.IsTruncated || page.NextKeyMarker != "a/2" {
+		t.Errorf("expected a page truncated after a/2, got %+v", page)
+	}
+}
+
+func TestBucketConfig(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	if _, err := store.GetBucketConfig("lifecycle"); err != ErrNoSuchConfig {
+		t.Errorf("expected ErrNoSuchConfig, got %v", err)
+	}
+
+	if err := store.PutBucketConfig("lifecycle", []byte("<xml/>")); err != nil {
+		t.Fatalf("PutBucketConfig failed: %v", err)
+	}
+	data, err := store.GetBucketConfig("lifecycle")
+	if err != nil || string(data) != "<xml/>" {
+		t.Errorf("expected stored config, got %q (err %v)", data, err)
+	}
+
+	if err := store.DeleteBucketConfig("lifecycle"); err != nil {
+		t.Fatalf("DeleteBucketConfig failed: %v", err)
+	}
+	if _, err := store.GetBucketConfig("lifecycle"); err != ErrNoSuchConfig {
+		t.Errorf("expected ErrNoSuchConfig after delete, got %v", err)
+	}
+
+	if err := store.PutBucketConfig("../escape", nil); err == nil {
+		t.Error("expected error for invalid config name")
+	}
+}
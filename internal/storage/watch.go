@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrWatchUnsupported is returned by Watch on systems without inotify
+var ErrWatchUnsupported = errors.New("watching the bucket directory is only supported on Linux")
+
+// watchMaxDelay bounds, in settle periods, how long changes wait while files keep changing
+const watchMaxDelay = 10
+
+// Watcher reconciles the objects whose files are added, replaced or removed directly in
+// the bucket directory as they change on disk
+type Watcher struct {
+	s        *Storage
+	settle   time.Duration
+	onChange func([]Change, error)
+	reportMu sync.Mutex // onChange is called from one goroutine at a time
+	keys     chan string
+	inotify  *inotify
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// Watch watches the bucket directory until Close is called. Once files have stopped
+// changing for settle, the keys changed are given to Reconcile and onChange is called
+// with the changes made, or with the error that stopped it. Files changed while nothing
+// watches them are picked up by Reconcile, e.g. through the import command.
+func (s *Storage) Watch(settle time.Duration, onChange func([]Change, error)) (*Watcher, error) {
+	w := &Watcher{
+		s:        s,
+		settle:   settle,
+		onChange: onChange,
+		keys:     make(chan string, 256),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// Close stops watching. Changes not reconciled yet are left to the next Reconcile.
+func (w *Watcher) Close() error {
+	close(w.quit)
+	err := w.close()
+	<-w.done
+	return err
+}
+
+// run collects the keys changed on disk and reconciles them in batches
+func (w *Watcher) run() {
+	defer close(w.done)
+
+	pending := make(map[string]bool)
+	var first time.Time
+	timer := time.NewTimer(w.settle)
+	timer.Stop()
+
+	for {
+		select {
+		case <-w.quit:
+			timer.Stop()
+			return
+		case key := <-w.keys:
+			if len(pending) == 0 {
+				first = time.Now()
+			}
+			pending[key] = true
+			// Busy directories are still reconciled regularly
+			if time.Since(first) < watchMaxDelay*w.settle {
+				timer.Reset(w.settle)
+			}
+		case <-timer.C:
+			keys := make([]string, 0, len(pending))
+			for key := range pending {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			pending = make(map[string]bool)
+
+			changes, err := w.s.Reconcile(keys)
+			if len(changes) > 0 || err != nil {
+				w.report(changes, err)
+			}
+		}
+	}
+}
+
+// report calls onChange
+func (w *Watcher) report(changes []Change, err error) {
+	w.reportMu.Lock()
+	defer w.reportMu.Unlock()
+	w.onChange(changes, err)
+}
+
+// send queues a key changed on disk
+func (w *Watcher) send(key string) {
+	select {
+	case w.keys <- key:
+	case <-w.quit:
+	}
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// watchMask selects the inotify events of the bucket directories: files written,
+// moved in or out and deleted, and directories created
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM |
+	syscall.IN_DELETE | syscall.IN_CREATE | syscall.IN_ONLYDIR
+
+// inotify watches every directory of the bucket
+type inotify struct {
+	fd      int
+	file    *os.File
+	root    string
+	watches map[int32]string // directory of each watch, relative to root
+	done    chan struct{}    // closed once reading stopped
+}
+
+// open starts watching the bucket directory and its subdirectories
+func (w *Watcher) open() error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("failed to watch the bucket directory: %w", err)
+	}
+	// A non-blocking descriptor is read through the runtime poller, so Close interrupts reads
+	w.inotify = &inotify{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		root:    filepath.Join(w.s.basePath, w.s.bucket),
+		watches: make(map[int32]string),
+		done:    make(chan struct{}),
+	}
+	if err := w.inotify.addTree("", nil); err != nil {
+		_ = w.inotify.file.Close()
+		return fmt.Errorf("failed to watch the bucket directory: %w", err)
+	}
+	go w.read()
+	return nil
+}
+
+// close stops watching and waits for the events being handled
+func (w *Watcher) close() error {
+	err := w.inotify.file.Close()
+	<-w.inotify.done
+	return err
+}
+
+// read turns inotify events into the keys changed, until the watcher is closed
+func (w *Watcher) read() {
+	defer close(w.inotify.done)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.inotify.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.report(nil, fmt.Errorf("failed to read bucket directory changes: %w", err))
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			start := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[start:start+nameLen]), "\x00")
+			offset = start + nameLen
+
+			w.handle(wd, mask, name)
+		}
+	}
+}
+
+// handle queues the keys changed by an inotify event
+func (w *Watcher) handle(wd int32, mask uint32, name string) {
+	in := w.inotify
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		// Events were lost: every file is checked again
+		if err := in.addTree("", w.send); err != nil {
+			w.report(nil, fmt.Errorf("failed to rescan the bucket directory: %w", err))
+		}
+		return
+	}
+	dir, ok := in.watches[wd]
+	if !ok {
+		return
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(in.watches, wd)
+		return
+	}
+
+	key := path.Join(dir, name)
+	if mask&syscall.IN_ISDIR == 0 {
+		if mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_MOVED_FROM|syscall.IN_DELETE) != 0 {
+			w.send(key)
+		}
+		return
+	}
+	switch {
+	case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		// Files may have been added before the directory was watched
+		if err := in.addTree(key, w.send); err != nil && !os.IsNotExist(err) {
+			w.report(nil, fmt.Errorf("failed to watch %s: %w", key, err))
+		}
+	case mask&syscall.IN_MOVED_FROM != 0:
+		// The kernel keeps watching a moved directory; it is named again if moved back in
+		for id, watched := range in.watches {
+			if watched == key || strings.HasPrefix(watched, key+"/") {
+				delete(in.watches, id)
+			}
+		}
+	}
+}
+
+// addTree watches the directory dir, relative to the bucket directory, and its
+// subdirectories, calling found with the key of every file in them when it is set
+func (in *inotify) addTree(dir string, found func(string)) error {
+	return filepath.WalkDir(filepath.Join(in.root, filepath.FromSlash(dir)), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(in.root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !d.IsDir() {
+			if found != nil && d.Type().IsRegular() {
+				found(rel)
+			}
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(in.fd, p, watchMask)
+		if err != nil {
+			if errors.Is(err, syscall.ENOENT) {
+				return filepath.SkipDir
+			}
+			return fmt.Errorf("failed to watch %s: %w", p, err)
+		}
+		if rel == "." {
+			rel = ""
+		}
+		in.watches[int32(wd)] = rel
+		return nil
+	})
+}
+
+// changeTime returns the time the file described by info last changed, including
+// renames into place, which preserve the modification time
+func changeTime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec))
+	}
+	return info.ModTime()
+}
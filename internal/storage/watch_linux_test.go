@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// watchChanges watches store and returns a function waiting for the changes reconciled
+func watchChanges(t *testing.T, store *Storage) func(n int) []string {
+	t.Helper()
+
+	var mu sync.Mutex
+	var keys []string
+	w, err := store.Watch(50*time.Millisecond, func(changes []Change, err error) {
+		if err != nil {
+			t.Errorf("watch error: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, changedKeys(changes)...)
+	})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+
+	return func(n int) []string {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mu.Lock()
+			got := append([]string(nil), keys...)
+			mu.Unlock()
+			if len(got) >= n || time.Now().After(deadline) {
+				sort.Strings(got)
+				return got
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestWatch_OutOfBandChanges(t *testing.T) {
+	store, err := NewStorage(t.TempDir(), "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("old.txt", "", strings.NewReader("old")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject("kept.txt", "", strings.NewReader("kept")); err != nil {
+		t.Fatal(err)
+	}
+	wait := watchChanges(t, store)
+
+	// Writes through the storage are not changes
+	if _, err := store.PutObject("api/upload.txt", "", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	writeOnDisk(t, store, "new/dir/file.txt", "added in a new directory")
+	if err := os.Remove(store.keyToPath("old.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaced like rsync -a does, keeping an older modification time
+	writeOnDisk(t, store, "kept.txt", "replaced")
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(store.keyToPath("kept.txt"), past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"-old.txt", "kept.txt", "new/dir/file.txt"}
+	if got := wait(len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("changes = %v, expected %v", got, expected)
+	}
+	if got := readAll(t, store, "kept.txt", ""); got != "replaced" {
+		t.Errorf("replaced object = %q", got)
+	}
+}
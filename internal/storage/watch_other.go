@@ -0,0 +1,26 @@
+//go:build !linux
+
+package storage
+
+import (
+	"os"
+	"time"
+)
+
+// inotify is only available on Linux
+type inotify struct{}
+
+// open reports that the bucket directory cannot be watched
+func (w *Watcher) open() error {
+	return ErrWatchUnsupported
+}
+
+// close has nothing to stop
+func (w *Watcher) close() error {
+	return nil
+}
+
+// changeTime returns the modification time of the file described by info
+func changeTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
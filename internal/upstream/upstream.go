@@ -0,0 +1,107 @@
+// Package upstream reads and writes the objects of an S3-compatible bucket for the
+// pull-through cache mode
+package upstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+// Options locates the upstream bucket and the credentials used to sign requests
+type Options struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// Client is a storage.Upstream signing its requests with SigV4
+type Client struct {
+	s3     *s3.Client
+	bucket string
+}
+
+var _ storage.Upstream = (*Client)(nil)
+
+// New returns a client for the bucket described by opts
+func New(opts Options) *Client {
+	return &Client{
+		s3: s3.New(s3.Options{
+			Region:       opts.Region,
+			Credentials:  credentials.NewStaticCredentialsProvider(opts.AccessKey, opts.SecretKey, ""),
+			BaseEndpoint: aws.String(opts.Endpoint),
+			UsePathStyle: true,
+			// Bodies are streamed from the local backend, so they are neither hashed nor
+			// sent with checksum trailers, which not every S3-compatible server supports
+			RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+			ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+		}),
+		bucket: opts.Bucket,
+	}
+}
+
+// GetObject reads the object at key; the caller must close the reader
+func (c *Client) GetObject(ctx context.Context, key string) (*storage.Object, io.ReadCloser, error) {
+	output, err := c.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, nil, mapError(err)
+	}
+
+	obj := &storage.Object{
+		Key:         key,
+		Size:        aws.ToInt64(output.ContentLength),
+		ContentType: aws.ToString(output.ContentType),
+		ETag:        strings.Trim(aws.ToString(output.ETag), `"`),
+	}
+	if output.LastModified != nil {
+		obj.LastModified = *output.LastModified
+	}
+	return obj, output.Body, nil
+}
+
+// PutObject writes size bytes of body at key
+func (c *Client) PutObject(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(c.bucket),
+		Key:           aws.String(key),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	_, err := c.s3.PutObject(ctx, input, s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware))
+	return mapError(err)
+}
+
+// DeleteObject removes the object at key
+func (c *Client) DeleteObject(ctx context.Context, key string) error {
+	_, err := c.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+	return mapError(err)
+}
+
+// mapError reports missing objects with storage.ErrNotFound
+func mapError(err error) error {
+	var status interface{ HTTPStatusCode() int }
+	if errors.As(err, &status) && status.HTTPStatusCode() == http.StatusNotFound {
+		return storage.ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Notifuse/selfhost_s3/internal/storage"
+)
+
+func TestClient_GetObject(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=upstream-key/") {
+			t.Errorf("request not signed: %q", r.Header.Get("Authorization"))
+		}
+		if r.URL.Path != "/prod-bucket/docs/a.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/markdown")
+		w.Header().Set("ETag", `"abc123"`)
+		w.Header().Set("Content-Length", "5")
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer ts.Close()
+
+	client := New(Options{Endpoint: ts.URL, Bucket: "prod-bucket", Region: "us-east-1", AccessKey: "upstream-key", SecretKey: "upstream-secret"})
+	obj, body, err := client.GetObject(context.Background(), "docs/a.txt")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	defer func() { _ = body.Close() }()
+	data, _ := io.ReadAll(body)
+	if string(data) != "hello" || obj.Size != 5 || obj.ContentType != "text/markdown" || obj.ETag != "abc123" {
+		t.Errorf("GetObject = %+v, %q", obj, data)
+	}
+
+	if _, _, err := client.GetObject(context.Background(), "missing.txt"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClient_PutObjectStreamsUnsignedPayload(t *testing.T) {
+	var received string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Amz-Content-Sha256"); got != "UNSIGNED-PAYLOAD" {
+			t.Errorf("payload hash = %q", got)
+		}
+		if r.ContentLength != 5 || r.Header.Get("Content-Encoding") != "" || r.Header.Get("Content-Type") != "text/csv" {
+			t.Errorf("unexpected request: length %d, headers %v", r.ContentLength, r.Header)
+		}
+		data, _ := io.ReadAll(r.Body)
+		received = string(data)
+	}))
+	defer ts.Close()
+
+	client := New(Options{Endpoint: ts.URL, Bucket: "prod-bucket", Region: "us-east-1", AccessKey: "upstream-key", SecretKey: "upstream-secret"})
+	// A reader that cannot seek, like the object readers of the local backend
+	body := io.MultiReader(strings.NewReader("a,b,c"))
+	if err := client.PutObject(context.Background(), "new.csv", body, 5, "text/csv"); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if received != "a,b,c" {
+		t.Errorf("received %q", received)
+	}
+}
@@ -0,0 +1,98 @@
+// Package s3test starts an in-process selfhost_s3 server for Go tests and
+// returns an aws-sdk-go-v2 S3 client configured to use it.
+//
+//	func TestUpload(t *testing.T) {
+//		srv := s3test.New(t)
+//		_, err := srv.Client.PutObject(ctx, &s3.PutObjectInput{
+//			Bucket: aws.String(srv.Bucket),
+//			Key:    aws.String("hello.txt"),
+//			Body:   strings.NewReader("hello"),
+//		})
+//		...
+//	}
+package s3test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/Notifuse/selfhost_s3/selfhosts3"
+)
+
+// Defaults used by New
+const (
+	Bucket    = "test-bucket"
+	AccessKey = "test-access-key"
+	SecretKey = "test-secret-key"
+	Region    = "us-east-1"
+)
+
+// Server is a running test server
+type Server struct {
+	URL    string // base URL, e.g. http://127.0.0.1:54321
+	Bucket string
+	Config *selfhosts3.Config
+	Client *s3.Client // path-style client signed with the server credentials
+
+	HTTP *httptest.Server
+}
+
+// New starts a server backed by in-memory storage and stops it when the test ends.
+// Options can adjust the configuration before the server starts, e.g. WithFilesystem.
+func New(t testing.TB, opts ...func(*selfhosts3.Config)) *Server {
+	t.Helper()
+
+	cfg := selfhosts3.DefaultConfig()
+	cfg.Bucket = Bucket
+	cfg.AccessKey = AccessKey
+	cfg.SecretKey = SecretKey
+	cfg.Region = Region
+	cfg.StorageBackend = selfhosts3.StorageMemory
+	cfg.LifecycleInterval = 0
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	handler, err := selfhosts3.New(cfg)
+	if err != nil {
+		t.Fatalf("s3test: failed to create server: %v", err)
+	}
+	ts := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		ts.Close()
+		handler.Close()
+	})
+
+	return &Server{
+		URL:    ts.URL,
+		Bucket: cfg.Bucket,
+		Config: cfg,
+		Client: NewClient(ts, cfg),
+		HTTP:   ts,
+	}
+}
+
+// WithFilesystem stores objects in a temporary directory removed when the test ends
+func WithFilesystem(t testing.TB) func(*selfhosts3.Config) {
+	dir := t.TempDir()
+	return func(cfg *selfhosts3.Config) {
+		cfg.StorageBackend = selfhosts3.StorageFilesystem
+		cfg.StoragePath = dir
+	}
+}
+
+// NewClient returns an S3 client for a server started on ts with cfg.
+// It does not read shared AWS configuration or environment variables.
+func NewClient(ts *httptest.Server, cfg *selfhosts3.Config) *s3.Client {
+	return s3.New(s3.Options{
+		Region:       cfg.Region,
+		Credentials:  credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, ""),
+		BaseEndpoint: aws.String(ts.URL),
+		UsePathStyle: true,
+		HTTPClient:   ts.Client(),
+	})
+}
@@ -0,0 +1,180 @@
+package s3test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/Notifuse/selfhost_s3/selfhosts3"
+)
+
+func TestNew_ObjectRoundTrip(t *testing.T) {
+	for name, opts := range map[string][]func(*selfhosts3.Config){
+		"memory":     nil,
+		"filesystem": {WithFilesystem(t)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := New(t, opts...)
+			ctx := context.Background()
+
+			_, err := srv.Client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket:      aws.String(srv.Bucket),
+				Key:         aws.String("docs/hello.txt"),
+				Body:        strings.NewReader("hello"),
+				ContentType: aws.String("text/plain"),
+			})
+			if err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+
+			getOutput, err := srv.Client.GetObject(ctx, &s3.GetObjectInput{
+				Bucket: aws.String(srv.Bucket),
+				Key:    aws.String("docs/hello.txt"),
+			})
+			if err != nil {
+				t.Fatalf("GetObject failed: %v", err)
+			}
+			body, _ := io.ReadAll(getOutput.Body)
+			_ = getOutput.Body.Close()
+			if string(body) != "hello" {
+				t.Errorf("expected 'hello', got %q", body)
+			}
+
+			listOutput, err := srv.Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+				Bucket: aws.String(srv.Bucket),
+				Prefix: aws.String("docs/"),
+			})
+			if err != nil {
+				t.Fatalf("ListObjectsV2 failed: %v", err)
+			}
+			if len(listOutput.Contents) != 2 {
+				t.Errorf("expected folder and file in listing, got %d objects", len(listOutput.Contents))
+			}
+
+			_, err = srv.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(srv.Bucket),
+				Key:    aws.String("docs/hello.txt"),
+			})
+			if err != nil {
+				t.Fatalf("DeleteObject failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestNew_MultipartAndCopy(t *testing.T) {
+	srv := New(t)
+	ctx := context.Background()
+
+	createOutput, err := srv.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(srv.Bucket),
+		Key:    aws.String("big.bin"),
+	})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+
+	parts := [][]byte{bytes.Repeat([]byte("a"), 5*1024*1024), []byte("end")}
+	var completed []types.CompletedPart
+	for i, data := range parts {
+		partOutput, err := srv.Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(srv.Bucket),
+			Key:        aws.String("big.bin"),
+			UploadId:   createOutput.UploadId,
+			PartNumber: aws.Int32(int32(i + 1)),
+			Body:       bytes.NewReader(data),
+		})
+		if err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
+		completed = append(completed, types.CompletedPart{ETag: partOutput.ETag, PartNumber: aws.Int32(int32(i + 1))})
+	}
+
+	_, err = srv.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(srv.Bucket),
+		Key:             aws.String("big.bin"),
+		UploadId:        createOutput.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+
+	_, err = srv.Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(srv.Bucket),
+		Key:        aws.String("copy.bin"),
+		CopySource: aws.String(srv.Bucket + "/big.bin"),
+	})
+	if err != nil {
+		t.Fatalf("CopyObject failed: %v", err)
+	}
+
+	headOutput, err := srv.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(srv.Bucket),
+		Key:    aws.String("copy.bin"),
+	})
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if *headOutput.ContentLength != 5*1024*1024+3 {
+		t.Errorf("unexpected copied size %d", *headOutput.ContentLength)
+	}
+}
+
+func TestNew_PresignedURL(t *testing.T) {
+	srv := New(t)
+	ctx := context.Background()
+
+	_, err := srv.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(srv.Bucket),
+		Key:    aws.String("private/report.csv"),
+		Body:   strings.NewReader("a,b"),
+	})
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	presigned, err := s3.NewPresignClient(srv.Client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(srv.Bucket),
+		Key:    aws.String("private/report.csv"),
+	}, s3.WithPresignExpires(time.Minute))
+	if err != nil {
+		t.Fatalf("PresignGetObject failed: %v", err)
+	}
+
+	resp, err := srv.HTTP.Client().Get(presigned.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestNew_Options(t *testing.T) {
+	srv := New(t, func(cfg *selfhosts3.Config) {
+		cfg.Bucket = "custom"
+		cfg.MaxFileSize = 4
+	})
+
+	if srv.Bucket != "custom" {
+		t.Errorf("expected custom bucket, got %q", srv.Bucket)
+	}
+	_, err := srv.Client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("custom"),
+		Key:    aws.String("too-large.txt"),
+		Body:   strings.NewReader("too large"),
+	})
+	if err == nil {
+		t.Error("expected upload above MaxFileSize to fail")
+	}
+}
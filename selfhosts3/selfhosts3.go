@@ -0,0 +1,60 @@
+// Package selfhosts3 embeds the selfhost_s3 server in another Go program.
+//
+// New returns an http.Handler serving the S3 API for a single bucket, which can be
+// mounted in an existing HTTP server or started on an httptest.Server
+// (see the s3test package).
+package selfhosts3
+
+import (
+	"net/http"
+
+	"github.com/Notifuse/selfhost_s3/internal/config"
+	"github.com/Notifuse/selfhost_s3/internal/server"
+)
+
+// Config is the server configuration. Start from DefaultConfig.
+type Config = config.Config
+
+// Webhook configures an HTTP endpoint receiving S3 event notifications
+type Webhook = config.Webhook
+
+// Storage backends
+const (
+	StorageFilesystem = config.StorageFilesystem
+	StorageMemory     = config.StorageMemory
+)
+
+// DefaultConfig returns the configuration used by the selfhost_s3 binary when no
+// optional environment variable is set. Bucket and credentials must be filled in.
+func DefaultConfig() *Config {
+	return config.Default()
+}
+
+// Handler serves the S3 API. It must be closed to stop its background tasks.
+type Handler struct {
+	srv     *server.Server
+	handler http.Handler
+}
+
+// New creates a handler for cfg and starts its background tasks
+// (lifecycle rules and event delivery). Port is ignored.
+func New(cfg *Config) (*Handler, error) {
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	srv.StartBackground()
+
+	return &Handler{srv: srv, handler: srv.Handler()}, nil
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, r)
+}
+
+// Close stops the background tasks. It does not close the storage, so a
+// filesystem-backed handler can be recreated on the same directory.
+func (h *Handler) Close() {
+	h.srv.Close()
+}
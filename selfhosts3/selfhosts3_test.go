@@ -0,0 +1,43 @@
+package selfhosts3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNew_RequiresCredentials(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Bucket = "test-bucket"
+	cfg.StorageBackend = StorageMemory
+
+	if _, err := New(cfg); err == nil {
+		t.Fatal("expected error without credentials")
+	}
+}
+
+func TestHandler_ServesHealthAndRejectsUnsigned(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Bucket = "test-bucket"
+	cfg.AccessKey = "access"
+	cfg.SecretKey = "secret"
+	cfg.StorageBackend = StorageMemory
+
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer h.Close()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected health check to succeed, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test-bucket/file.txt", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected unsigned request to be rejected, got %d", w.Code)
+	}
+}